@@ -1,6 +1,7 @@
 package qio
 
 import (
+	"io"
 	"net/url"
 	"path/filepath"
 	"time"
@@ -101,6 +102,73 @@ func (me AferoFile) Download() (Content, error) {
 	return me.DownloadP(), nil
 }
 
+func (me AferoFile) UploadP(reader io.Reader, size int64) {
+	if err := me.Upload(reader, size); err != nil {
+		panic(qerr.NewSystemError(err.Error(), err))
+	}
+}
+
+// Upload 先写入同目录下的临时文件再改名，失败时不会留下不完整的文件；新文件的权限为 0644，已存在的文件保留原来的权限
+func (me AferoFile) Upload(reader io.Reader, size int64) error {
+	return WriteFileAtomicFunc(me.afs, me.rawPath, func(f afero.File) error {
+		if _, err := io.Copy(f, uploadReader(reader, size)); err != nil {
+			return errors.Wrapf(err, "upload %s", me.rawPath)
+		}
+		return nil
+	}, AtomicWriteOptions{Perm: 0o644, KeepPerm: true, DirPerm: 0o755})
+}
+
+func (me AferoFile) DeleteP() {
+	if err := me.Delete(); err != nil {
+		panic(qerr.NewSystemError(err.Error(), err))
+	}
+}
+
+// Delete 删除文件或空目录
+func (me AferoFile) Delete() error {
+	if err := me.afs.Remove(me.rawPath); err != nil {
+		return errors.Wrapf(err, "delete %s", me.rawPath)
+	}
+	return nil
+}
+
+func (me AferoFile) StatP() FileInfo {
+	r, err := me.Stat()
+	if err != nil {
+		panic(qerr.NewSystemError(err.Error(), err))
+	}
+	return r
+}
+
+func (me AferoFile) Stat() (FileInfo, error) {
+	info, err := me.afs.Stat(me.rawPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "stat %s", me.rawPath)
+	}
+	return newFileInfo(me.Dir(), info), nil
+}
+
+func (me AferoFile) ListP() []FileInfo {
+	r, err := me.List()
+	if err != nil {
+		panic(qerr.NewSystemError(err.Error(), err))
+	}
+	return r
+}
+
+func (me AferoFile) List() ([]FileInfo, error) {
+	infos, err := afero.ReadDir(me.afs, me.rawPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "list %s", me.rawPath)
+	}
+
+	r := make([]FileInfo, 0, len(infos))
+	for _, info := range infos {
+		r = append(r, newFileInfo(me.rawPath, info))
+	}
+	return r, nil
+}
+
 type AferoBlobT struct {
 	path string
 	afs  afero.Fs
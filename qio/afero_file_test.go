@@ -3,6 +3,7 @@ package qio
 import (
 	"io"
 	"net/url"
+	"os"
 	"path/filepath"
 	"strings"
 	"testing"
@@ -369,3 +370,33 @@ func TestAferoFile_Url_WithHttpProtocol(t *testing.T) {
 		t.Errorf("Expected protocol \"file\", got \"%s\"", af.Protocol())
 	}
 }
+
+func TestAferoFile_store(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	testFileStore(t, "", func(p string) File {
+		return NewAferoFileP(fs, p, nil, 0)
+	}, func(p string) (string, bool) {
+		data, err := afero.ReadFile(fs, p)
+		return string(data), err == nil
+	}, true)
+}
+
+func TestAferoFile_Upload_perm(t *testing.T) {
+	a := require.New(t)
+	fs := afero.NewMemMapFs()
+
+	// 新文件为 0644
+	a.NoError(NewAferoFileP(fs, "/out/new.bin", nil, 0).Upload(strings.NewReader("new"), 3))
+	info, err := fs.Stat("/out/new.bin")
+	a.NoError(err)
+	a.Equal(os.FileMode(0o644), info.Mode().Perm())
+
+	// 覆盖已存在的文件时保留原来的权限
+	WriteFileTextP(fs, "/out/old.bin", "old")
+	a.NoError(fs.Chmod("/out/old.bin", 0o640))
+	a.NoError(NewAferoFileP(fs, "/out/old.bin", nil, 0).Upload(strings.NewReader("updated"), 7))
+	a.Equal("updated", ReadFileTextP(fs, "/out/old.bin"))
+	info, err = fs.Stat("/out/old.bin")
+	a.NoError(err)
+	a.Equal(os.FileMode(0o640), info.Mode().Perm())
+}
@@ -0,0 +1,117 @@
+package qio
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/qiangyt/go-comm/v3/qerr"
+	"github.com/spf13/afero"
+)
+
+// ATOMIC_TEMP_SUFFIX 原子写入时临时文件名的后缀，临时文件名为 "." + 目标文件名 + ".<随机串>" + ATOMIC_TEMP_SUFFIX
+const ATOMIC_TEMP_SUFFIX = ".tmp"
+
+// maxSymlinkDepth 解析符号链接的最大层数，与 Linux 的 MAXSYMLINKS 一致
+const maxSymlinkDepth = 40
+
+// AtomicWriteOptions WriteFileAtomic 的选项，零值表示不创建上级目录、不修改临时文件的权限 0600
+type AtomicWriteOptions struct {
+	// Perm 写入后文件的权限，为 0 时保留临时文件的权限
+	Perm os.FileMode
+	// KeepPerm 目标文件已存在时沿用它的权限
+	KeepPerm bool
+	// DirPerm 不为 0 时先用这个权限创建上级目录
+	DirPerm os.FileMode
+}
+
+func WriteFileAtomicP(fs afero.Fs, path string, content []byte, options AtomicWriteOptions) {
+	if err := WriteFileAtomic(fs, path, content, options); err != nil {
+		panic(qerr.NewSystemError(err.Error(), err))
+	}
+}
+
+// WriteFileAtomic 原子地把 content 写入 path，见 WriteFileAtomicFunc
+func WriteFileAtomic(fs afero.Fs, path string, content []byte, options AtomicWriteOptions) error {
+	return WriteFileAtomicFunc(fs, path, func(f afero.File) error {
+		_, err := f.Write(content)
+		return err
+	}, options)
+}
+
+// WriteFileAtomicFunc 先由 write 写入同目录下的临时文件，成功后再改名为 path，读者不会看到写了一半的文件；
+// 失败时删除临时文件，write 返回的错误原样返回。write 不需要关闭文件。
+// path 是符号链接时（fs 支持 afero.Symlinker，如 OsFs）替换的是链接指向的文件，链接本身保留
+func WriteFileAtomicFunc(fs afero.Fs, path string, write func(f afero.File) error, options AtomicWriteOptions) (err error) {
+	path = ResolveSymlink(fs, path)
+
+	perm := options.Perm
+	if options.KeepPerm {
+		if info, err := fs.Stat(path); err == nil {
+			perm = info.Mode().Perm()
+		}
+	}
+
+	dir := filepath.Dir(path)
+	if options.DirPerm != 0 {
+		if err := fs.MkdirAll(dir, options.DirPerm); err != nil {
+			return errors.Wrapf(err, "create directory: %s", dir)
+		}
+	}
+
+	tmp, err := afero.TempFile(fs, dir, "."+filepath.Base(path)+".*"+ATOMIC_TEMP_SUFFIX)
+	if err != nil {
+		return errors.Wrapf(err, "create temp file in %s", dir)
+	}
+	tmpPath := tmp.Name()
+	closed := false
+	defer func() {
+		if err != nil {
+			if !closed {
+				tmp.Close()
+			}
+			fs.Remove(tmpPath)
+		}
+	}()
+
+	if err = write(tmp); err != nil {
+		return err
+	}
+	closed = true
+	if err = tmp.Close(); err != nil {
+		return errors.Wrapf(err, "close temp file: %s", tmpPath)
+	}
+	if perm != 0 {
+		if err = fs.Chmod(tmpPath, perm); err != nil {
+			return errors.Wrapf(err, "chmod temp file: %s", tmpPath)
+		}
+	}
+	if err = fs.Rename(tmpPath, path); err != nil {
+		return errors.Wrapf(err, "rename %s to %s", tmpPath, path)
+	}
+	return nil
+}
+
+// ResolveSymlink 返回 path 最终指向的路径，链接目标可以不存在；fs 不支持符号链接或 path 不是符号链接时返回 path
+func ResolveSymlink(fs afero.Fs, path string) string {
+	linker, ok := fs.(afero.Symlinker)
+	if !ok {
+		return path
+	}
+
+	for i := 0; i < maxSymlinkDepth; i++ {
+		info, lstatCalled, err := linker.LstatIfPossible(path)
+		if err != nil || !lstatCalled || info.Mode()&os.ModeSymlink == 0 {
+			return path
+		}
+		target, err := linker.ReadlinkIfPossible(path)
+		if err != nil {
+			return path
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		path = target
+	}
+	return path
+}
@@ -0,0 +1,92 @@
+package qio
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_WriteFileAtomic_happy(t *testing.T) {
+	a := require.New(t)
+	fs := afero.NewMemMapFs()
+
+	a.NoError(WriteFileAtomic(fs, "/a/b/file", []byte("v1"), AtomicWriteOptions{Perm: 0o640, DirPerm: 0o700}))
+	a.Equal("v1", ReadFileTextP(fs, "/a/b/file"))
+	info, err := fs.Stat("/a/b/file")
+	a.NoError(err)
+	a.Equal(os.FileMode(0o640), info.Mode().Perm())
+
+	// 已存在的文件保留权限
+	a.NoError(fs.Chmod("/a/b/file", 0o600))
+	a.NoError(WriteFileAtomic(fs, "/a/b/file", []byte("v2"), AtomicWriteOptions{Perm: 0o644, KeepPerm: true}))
+	a.Equal("v2", ReadFileTextP(fs, "/a/b/file"))
+	info, err = fs.Stat("/a/b/file")
+	a.NoError(err)
+	a.Equal(os.FileMode(0o600), info.Mode().Perm())
+}
+
+func Test_WriteFileAtomicFunc_failure(t *testing.T) {
+	a := require.New(t)
+	fs := afero.NewMemMapFs()
+	WriteFileP(fs, "/dir/file", []byte("old"))
+
+	failure := errors.New("write failed")
+	err := WriteFileAtomicFunc(fs, "/dir/file", func(f afero.File) error {
+		f.Write([]byte("partial"))
+		return failure
+	}, AtomicWriteOptions{})
+	a.Equal(failure, err)
+	a.Equal("old", ReadFileTextP(fs, "/dir/file"))
+
+	names, err := afero.ReadDir(fs, "/dir")
+	a.NoError(err)
+	a.Len(names, 1)
+}
+
+func Test_WriteFileAtomic_symlink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("创建符号链接需要特权")
+	}
+	a := require.New(t)
+	fs := afero.NewOsFs()
+
+	dir := t.TempDir()
+	real := filepath.Join(dir, "dotfiles", "config")
+	a.NoError(os.MkdirAll(filepath.Dir(real), 0o755))
+	a.NoError(os.WriteFile(real, []byte("old"), 0o600))
+	link := filepath.Join(dir, "config")
+	a.NoError(os.Symlink(filepath.Join("dotfiles", "config"), link))
+
+	a.Equal(real, ResolveSymlink(fs, link))
+	a.NoError(WriteFileAtomic(fs, link, []byte("new"), AtomicWriteOptions{Perm: 0o644, KeepPerm: true}))
+
+	info, err := os.Lstat(link)
+	a.NoError(err)
+	a.NotZero(info.Mode() & os.ModeSymlink)
+	data, err := os.ReadFile(real)
+	a.NoError(err)
+	a.Equal("new", string(data))
+	info, err = os.Stat(real)
+	a.NoError(err)
+	a.Equal(os.FileMode(0o600), info.Mode().Perm())
+
+	// 悬空的链接写入链接目标
+	dangling := filepath.Join(dir, "dangling")
+	a.NoError(os.Symlink(filepath.Join(dir, "dotfiles", "created"), dangling))
+	a.NoError(WriteFileAtomic(fs, dangling, []byte("created"), AtomicWriteOptions{}))
+	data, err = os.ReadFile(filepath.Join(dir, "dotfiles", "created"))
+	a.NoError(err)
+	a.Equal("created", string(data))
+
+	entries, err := os.ReadDir(filepath.Join(dir, "dotfiles"))
+	a.NoError(err)
+	for _, e := range entries {
+		a.False(strings.HasSuffix(e.Name(), ATOMIC_TEMP_SUFFIX), e.Name())
+	}
+}
@@ -0,0 +1,394 @@
+package qio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/qiangyt/go-comm/v3/qcoll"
+	"github.com/qiangyt/go-comm/v3/qerr"
+	"github.com/qiangyt/go-comm/v3/qlang"
+	"github.com/spf13/afero"
+)
+
+const (
+	DEFAULT_DOWNLOAD_ATTEMPTS    = 5
+	DEFAULT_DOWNLOAD_MIN_BACKOFF = 500 * time.Millisecond
+	DEFAULT_DOWNLOAD_MAX_BACKOFF = 30 * time.Second
+)
+
+// DownloadOptions 下载选项，零值字段使用默认值
+type DownloadOptionsT struct {
+	Credentials Credentials
+	// Timeout 单次尝试的超时，0 表示不限制。超时后从已下载的位置继续
+	Timeout time.Duration
+
+	// Attempts 最多尝试次数，默认 DEFAULT_DOWNLOAD_ATTEMPTS
+	Attempts int
+	// MinBackoff 第一次重试前的等待时间，之后每次翻倍，不超过 MaxBackoff，并加入随机抖动
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// SHA256 和 MD5 期望的十六进制摘要，为空时不校验
+	SHA256 string
+	MD5    string
+
+	// Perm 目标文件的权限，默认 0644
+	Perm os.FileMode
+
+	// OnProgress 进度回调，参数同 NewProgressReader
+	OnProgress func(Transferred, Total int64, speed float64)
+
+	// Client HTTP(S) 下载使用的客户端，默认 http.DefaultClient
+	Client *http.Client
+}
+
+type DownloadOptions = *DownloadOptionsT
+
+func (me DownloadOptions) withDefaults() DownloadOptions {
+	r := DownloadOptionsT{}
+	if me != nil {
+		r = *me
+	}
+
+	if r.Attempts <= 0 {
+		r.Attempts = DEFAULT_DOWNLOAD_ATTEMPTS
+	}
+	if r.MinBackoff <= 0 {
+		r.MinBackoff = DEFAULT_DOWNLOAD_MIN_BACKOFF
+	}
+	if r.MaxBackoff < r.MinBackoff {
+		r.MaxBackoff = max(DEFAULT_DOWNLOAD_MAX_BACKOFF, r.MinBackoff)
+	}
+	if r.Perm == 0 {
+		r.Perm = 0o644
+	}
+	if r.Client == nil {
+		r.Client = http.DefaultClient
+	}
+	return &r
+}
+
+// downloadBackoff 返回第 attempt 次失败后的等待时间，在 [d/2, d] 之间随机
+func downloadBackoff(attempt int, minBackoff, maxBackoff time.Duration) time.Duration {
+	d := minBackoff
+	for i := 1; i < attempt && d < maxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, maxBackoff)
+	return d/2 + rand.N(d/2+1)
+}
+
+// retryableDownloadError 可以重试的下载错误，如网络中断、5xx 和校验失败
+type retryableDownloadError struct {
+	error
+}
+
+func (me retryableDownloadError) Unwrap() error {
+	return me.error
+}
+
+func retryableDownload(err error) error {
+	return retryableDownloadError{err}
+}
+
+func isRetryableDownload(err error) bool {
+	var r retryableDownloadError
+	return errors.As(err, &r)
+}
+
+func DownloadFileP(ctx context.Context, fs afero.Fs, url string, target string, options DownloadOptions) int64 {
+	r, err := DownloadFile(ctx, fs, url, target, options)
+	if err != nil {
+		panic(qerr.NewSystemError(err.Error(), err))
+	}
+	return r
+}
+
+// DownloadFile 流式下载 url 到 fs 中的 target，返回文件大小。
+// 先写入 target 所在目录的临时文件，全部完成并通过摘要校验后再改名为 target，失败时不会留下不完整的文件。
+// HTTP(S) 下载中断后用 Range 请求从已下载的位置继续，服务端内容变化时（If-Range 不匹配）从头开始；
+// 其他协议每次尝试都从头开始。可以重试的错误按指数退避重试
+func DownloadFile(ctx context.Context, fs afero.Fs, url string, target string, options DownloadOptions) (size int64, err error) {
+	options = options.withDefaults()
+
+	var d *downloaderT
+	err = WriteFileAtomicFunc(fs, target, func(tmp afero.File) error {
+		d = &downloaderT{fs: fs, url: url, file: tmp, options: options, total: -1}
+		d.progress = NewProgressReader(d, -1, options.OnProgress)
+
+		for attempt := 1; ; attempt++ {
+			err := d.fetch(ctx)
+			if err == nil {
+				err = d.verify()
+			}
+			if err == nil {
+				break
+			}
+			if !isRetryableDownload(err) || attempt >= options.Attempts || ctx.Err() != nil {
+				return errors.Wrapf(err, "download %s (attempt %d/%d)", url, attempt, options.Attempts)
+			}
+
+			timer := time.NewTimer(downloadBackoff(attempt, options.MinBackoff, options.MaxBackoff))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return errors.Wrapf(ctx.Err(), "download %s", url)
+			case <-timer.C:
+			}
+		}
+		d.progress.Finish()
+		return nil
+	}, AtomicWriteOptions{Perm: options.Perm, DirPerm: 0o755})
+	if err != nil {
+		return 0, err
+	}
+	return d.offset, nil
+}
+
+// downloaderT 一次 DownloadFile 的状态，在多次尝试之间保留
+type downloaderT struct {
+	fs      afero.Fs
+	url     string
+	file    afero.File
+	options DownloadOptions
+
+	// offset 已写入临时文件的字节数
+	offset int64
+	// total 总大小，未知时为 -1
+	total int64
+	// validator 第一次响应的强 ETag 或 Last-Modified，续传时用作 If-Range
+	validator string
+
+	// body 当前尝试的响应体，progress 通过 downloaderT.Read 读取它
+	body     io.Reader
+	progress ProgressReader
+}
+
+func (me *downloaderT) Read(buf []byte) (int, error) {
+	return me.body.Read(buf)
+}
+
+// restart 丢弃已下载的内容
+func (me *downloaderT) restart(total int64) error {
+	if err := me.file.Truncate(0); err != nil {
+		return errors.Wrap(err, "truncate temp file")
+	}
+	if _, err := me.file.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrap(err, "seek temp file")
+	}
+	me.offset, me.total = 0, total
+	me.progress.reset(0, total)
+	return nil
+}
+
+// copy 把 body 追加到临时文件，中断时保留已写入的部分
+func (me *downloaderT) copy(body io.Reader) error {
+	me.body = body
+	n, err := io.Copy(me.file, me.progress)
+	me.offset += n
+	if err != nil {
+		return retryableDownload(errors.Wrapf(err, "read after %d bytes", me.offset))
+	}
+	if me.total >= 0 && me.offset != me.total {
+		return retryableDownload(errors.Errorf("incomplete: got %d of %d bytes", me.offset, me.total))
+	}
+	return nil
+}
+
+func (me *downloaderT) fetch(ctx context.Context) error {
+	if me.options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, me.options.Timeout)
+		defer cancel()
+	}
+
+	lc := strings.ToLower(me.url)
+	if strings.HasPrefix(lc, HTTP) || strings.HasPrefix(lc, HTTPS) {
+		return me.fetchHTTP(ctx)
+	}
+	return me.fetchFile()
+}
+
+func (me *downloaderT) fetchHTTP(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, me.url, nil)
+	if err != nil {
+		return errors.Wrap(err, "new request")
+	}
+	if c := me.options.Credentials; c != nil && (len(c.User) > 0 || len(c.Password) > 0) {
+		req.SetBasicAuth(c.User, c.Password)
+	}
+	if me.offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", me.offset))
+		if len(me.validator) > 0 {
+			req.Header.Set("If-Range", me.validator)
+		}
+	}
+
+	resp, err := me.options.Client.Do(req)
+	if err != nil {
+		return retryableDownload(err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if err := me.restart(resp.ContentLength); err != nil {
+			return err
+		}
+		me.validator = httpValidator(resp)
+	case http.StatusPartialContent:
+		start, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != me.offset {
+			me.restart(-1)
+			return retryableDownload(errors.Errorf("unexpected Content-Range: '%s'", resp.Header.Get("Content-Range")))
+		}
+		me.total = total
+		me.progress.reset(me.offset, total)
+	case http.StatusRequestedRangeNotSatisfiable:
+		// 已下载的部分比服务端的文件还长，从头开始
+		me.restart(-1)
+		return retryableDownload(errors.New(resp.Status))
+	default:
+		err := errors.Errorf("unexpected HTTP status: %s", resp.Status)
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout {
+			return retryableDownload(err)
+		}
+		return err
+	}
+
+	return me.copy(resp.Body)
+}
+
+// fetchFile 下载其他协议的远程文件或读取 fs 中的本地文件，不支持续传
+func (me *downloaderT) fetchFile() error {
+	if !IsRemote(me.url) {
+		return me.fetchLocal()
+	}
+
+	f, err := NewFile(me.fs, me.url, me.options.Credentials, me.options.Timeout)
+	if err != nil {
+		return err
+	}
+
+	c, err := f.Download()
+	if err != nil {
+		return retryableDownload(err)
+	}
+	defer c.Blob.Close()
+
+	if err := me.restart(-1); err != nil {
+		return err
+	}
+	return me.copy(c.Blob)
+}
+
+// fetchLocal 直接打开本地文件，不经过 AferoBlob（它在 Close 时会删除文件）。本地文件的错误重试也不会恢复
+func (me *downloaderT) fetchLocal() error {
+	path := me.url
+	if IsFileProtocol(path) {
+		path = path[len(FILE):]
+	}
+
+	src, err := me.fs.Open(path)
+	if err != nil {
+		return errors.Wrapf(err, "open %s", path)
+	}
+	defer src.Close()
+
+	total := int64(-1)
+	if info, err := src.Stat(); err == nil {
+		total = info.Size()
+	}
+	if err := me.restart(total); err != nil {
+		return err
+	}
+
+	err = me.copy(src)
+	if r, ok := err.(retryableDownloadError); ok {
+		return r.error
+	}
+	return err
+}
+
+// verify 校验期望的摘要，不匹配时丢弃已下载的内容以便重试时从头开始
+func (me *downloaderT) verify() (err error) {
+	if len(me.options.SHA256) == 0 && len(me.options.MD5) == 0 {
+		return nil
+	}
+	defer func() {
+		if e := qlang.RecoverAsError(recover()); e != nil {
+			err = errors.Wrap(e, "verify download")
+		}
+	}()
+
+	hc := qcoll.NewHashCalculator()
+	for _, expected := range []struct {
+		name, value string
+		calc        func(r io.Reader) string
+	}{
+		{"SHA256", me.options.SHA256, hc.CalculateSHA256FromReader},
+		{"MD5", me.options.MD5, hc.CalculateMD5FromReader},
+	} {
+		if len(expected.value) == 0 {
+			continue
+		}
+		if _, err := me.file.Seek(0, io.SeekStart); err != nil {
+			return errors.Wrap(err, "seek temp file")
+		}
+		actual := expected.calc(me.file)
+		if !strings.EqualFold(actual, expected.value) {
+			if err := me.restart(-1); err != nil {
+				return err
+			}
+			return retryableDownload(errors.Errorf("%s mismatch: expected %s, got %s", expected.name, expected.value, actual))
+		}
+	}
+
+	_, err = me.file.Seek(me.offset, io.SeekStart)
+	return err
+}
+
+// httpValidator 返回可以用作 If-Range 的强 ETag 或 Last-Modified
+func httpValidator(resp *http.Response) string {
+	if etag := resp.Header.Get("ETag"); len(etag) > 0 && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return resp.Header.Get("Last-Modified")
+}
+
+// parseContentRange 解析 "bytes 100-199/200"，总大小为 "*" 时返回 -1
+func parseContentRange(s string) (start int64, total int64, ok bool) {
+	spec, found := strings.CutPrefix(s, "bytes ")
+	if !found {
+		return 0, 0, false
+	}
+	rng, size, found := strings.Cut(spec, "/")
+	if !found {
+		return 0, 0, false
+	}
+	first, _, found := strings.Cut(rng, "-")
+	if !found {
+		return 0, 0, false
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	if size == "*" {
+		return start, -1, true
+	}
+	total, err = strconv.ParseInt(size, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return start, total, true
+}
@@ -0,0 +1,290 @@
+package qio
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+// testDownloadServer 提供 content，支持 Range 和 If-Range；前 drops 次请求只发送一半内容就断开连接
+type testDownloadServer struct {
+	mu      sync.Mutex
+	content []byte
+	etag    string
+	drops   int
+	status  []int
+	ranges  []string
+}
+
+func (me *testDownloadServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	me.mu.Lock()
+	me.ranges = append(me.ranges, r.Header.Get("Range"))
+	content, etag := me.content, me.etag
+	status := 0
+	if len(me.status) > 0 {
+		status, me.status = me.status[0], me.status[1:]
+	}
+	drop := me.drops > 0
+	if drop {
+		me.drops--
+	}
+	me.mu.Unlock()
+
+	if status != 0 {
+		w.WriteHeader(status)
+		return
+	}
+	if drop {
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(content[:len(content)/2])
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+
+	w.Header().Set("ETag", etag)
+	http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+}
+
+func (me *testDownloadServer) fail(status ...int) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.status = status
+}
+
+func (me *testDownloadServer) requests() []string {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return append([]string{}, me.ranges...)
+}
+
+func testDownloadContent() []byte {
+	return bytes.Repeat([]byte("0123456789abcdef"), 4096)
+}
+
+func testDownloadOptions() DownloadOptions {
+	return &DownloadOptionsT{MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+}
+
+func TestDownloadFile_resume(t *testing.T) {
+	a := require.New(t)
+
+	content := testDownloadContent()
+	sha := sha256.Sum256(content)
+	sum := md5.Sum(content)
+	server := &testDownloadServer{content: content, etag: `"v1"`, drops: 1}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	var progressed atomic.Int64
+	options := testDownloadOptions()
+	options.SHA256 = hex.EncodeToString(sha[:])
+	options.MD5 = hex.EncodeToString(sum[:])
+	options.Perm = 0o600
+	options.OnProgress = func(transferred, total int64, speed float64) {
+		progressed.Store(transferred)
+		if total != int64(len(content)) {
+			t.Errorf("unexpected total: %d", total)
+		}
+	}
+
+	fs := afero.NewMemMapFs()
+	n, err := DownloadFile(context.Background(), fs, ts.URL+"/file.bin", "/dl/file.bin", options)
+	a.NoError(err)
+	a.Equal(int64(len(content)), n)
+	a.Equal(int64(len(content)), progressed.Load())
+	a.Equal([]string{"", "bytes=" + strconv.Itoa(len(content)/2) + "-"}, server.requests())
+
+	actual, err := afero.ReadFile(fs, "/dl/file.bin")
+	a.NoError(err)
+	a.Equal(content, actual)
+	info, err := fs.Stat("/dl/file.bin")
+	a.NoError(err)
+	a.Equal(os.FileMode(0o600), info.Mode().Perm())
+
+	entries, err := afero.ReadDir(fs, "/dl")
+	a.NoError(err)
+	a.Len(entries, 1)
+}
+
+func TestDownloadFile_changedWhileResuming(t *testing.T) {
+	a := require.New(t)
+
+	server := &testDownloadServer{content: []byte("first version of the content"), etag: `"v1"`, drops: 1}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	options := testDownloadOptions()
+	options.OnProgress = func(transferred, total int64, speed float64) {}
+	options.Client = &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			// 续传前服务端的内容变了
+			if len(r.Header.Get("Range")) > 0 {
+				server.mu.Lock()
+				server.content, server.etag = []byte("second version"), `"v2"`
+				server.mu.Unlock()
+			}
+			return http.DefaultTransport.RoundTrip(r)
+		}),
+	}
+
+	fs := afero.NewMemMapFs()
+	_, err := DownloadFile(context.Background(), fs, ts.URL, "/file.bin", options)
+	a.NoError(err)
+	actual, err := afero.ReadFile(fs, "/file.bin")
+	a.NoError(err)
+	a.Equal("second version", string(actual))
+}
+
+type roundTripFunc func(r *http.Request) (*http.Response, error)
+
+func (me roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return me(r)
+}
+
+func TestDownloadFile_retry(t *testing.T) {
+	a := require.New(t)
+
+	server := &testDownloadServer{content: []byte("hello"), status: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	fs := afero.NewMemMapFs()
+	n := DownloadFileP(context.Background(), fs, ts.URL, "/file.txt", testDownloadOptions())
+	a.Equal(int64(5), n)
+	a.Len(server.requests(), 3)
+
+	// 4xx 不重试
+	server.fail(http.StatusNotFound)
+	_, err := DownloadFile(context.Background(), fs, ts.URL, "/missing.txt", testDownloadOptions())
+	a.ErrorContains(err, "404")
+	a.Len(server.requests(), 4)
+	_, err = fs.Stat("/missing.txt")
+	a.True(os.IsNotExist(err))
+
+	// 超过尝试次数
+	options := testDownloadOptions()
+	options.Attempts = 2
+	server.fail(http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+	_, err = DownloadFile(context.Background(), fs, ts.URL, "/file.txt", options)
+	a.ErrorContains(err, "attempt 2/2")
+	a.Len(server.requests(), 6)
+
+	// 失败时原有的目标文件不变，也不留下临时文件
+	actual, err := afero.ReadFile(fs, "/file.txt")
+	a.NoError(err)
+	a.Equal("hello", string(actual))
+	entries, err := afero.ReadDir(fs, "/")
+	a.NoError(err)
+	a.Len(entries, 1)
+}
+
+func TestDownloadFile_checksumMismatch(t *testing.T) {
+	a := require.New(t)
+
+	server := &testDownloadServer{content: []byte("hello")}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	options := testDownloadOptions()
+	options.Attempts = 2
+	options.SHA256 = "00"
+	fs := afero.NewMemMapFs()
+	_, err := DownloadFile(context.Background(), fs, ts.URL, "/file.txt", options)
+	a.ErrorContains(err, "SHA256 mismatch")
+	// 校验失败后从头重新下载
+	a.Equal([]string{"", ""}, server.requests())
+
+	entries, err := afero.ReadDir(fs, "/")
+	a.NoError(err)
+	a.Empty(entries)
+}
+
+func TestDownloadFile_canceled(t *testing.T) {
+	a := require.New(t)
+
+	server := &testDownloadServer{status: []int{http.StatusServiceUnavailable}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	options := testDownloadOptions()
+	options.MinBackoff = time.Hour
+	options.MaxBackoff = time.Hour
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	_, err := DownloadFile(ctx, afero.NewMemMapFs(), ts.URL, "/file.txt", options)
+	a.ErrorIs(err, context.Canceled)
+}
+
+func TestDownloadFile_local(t *testing.T) {
+	a := require.New(t)
+
+	fs := afero.NewMemMapFs()
+	a.NoError(afero.WriteFile(fs, "/src/file.txt", []byte("local content"), 0o644))
+
+	n, err := DownloadFile(context.Background(), fs, "/src/file.txt", "/dst/file.txt", testDownloadOptions())
+	a.NoError(err)
+	a.Equal(int64(13), n)
+	actual, err := afero.ReadFile(fs, "/dst/file.txt")
+	a.NoError(err)
+	a.Equal("local content", string(actual))
+	// 源文件保持不变
+	actual, err = afero.ReadFile(fs, "/src/file.txt")
+	a.NoError(err)
+	a.Equal("local content", string(actual))
+
+	_, err = DownloadFile(context.Background(), fs, "file:///src/file.txt", "/dst/copy.txt", nil)
+	a.NoError(err)
+
+	// 本地文件不存在时不重试
+	_, err = DownloadFile(context.Background(), fs, "/src/missing.txt", "/dst/missing.txt", nil)
+	a.Error(err)
+	a.Contains(err.Error(), "attempt 1/")
+}
+
+func TestDownloadBackoff(t *testing.T) {
+	a := require.New(t)
+
+	for i := 0; i < 100; i++ {
+		d := downloadBackoff(1, 100*time.Millisecond, time.Second)
+		a.True(d >= 50*time.Millisecond && d <= 100*time.Millisecond)
+		d = downloadBackoff(3, 100*time.Millisecond, time.Second)
+		a.True(d >= 200*time.Millisecond && d <= 400*time.Millisecond)
+		d = downloadBackoff(20, 100*time.Millisecond, time.Second)
+		a.True(d >= 500*time.Millisecond && d <= time.Second)
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	a := require.New(t)
+
+	start, total, ok := parseContentRange("bytes 100-199/200")
+	a.True(ok)
+	a.Equal(int64(100), start)
+	a.Equal(int64(200), total)
+
+	start, total, ok = parseContentRange("bytes 5-9/*")
+	a.True(ok)
+	a.Equal(int64(5), start)
+	a.Equal(int64(-1), total)
+
+	for _, s := range []string{"", "bytes */200", "items 1-2/3", "bytes 1/2", "bytes 1-2/x"} {
+		_, _, ok = parseContentRange(s)
+		a.False(ok, s)
+	}
+}
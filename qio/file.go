@@ -1,13 +1,16 @@
 package qio
 
 import (
+	"io"
 	"net/url"
+	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/goodsru/go-universal-network-adapter/models"
 	"github.com/goodsru/go-universal-network-adapter/services"
+	"github.com/pkg/errors"
 	"github.com/qiangyt/go-comm/v3/qerr"
 	"github.com/qiangyt/go-comm/v3/qlang"
 	"github.com/spf13/afero"
@@ -33,6 +36,19 @@ type File interface {
 	Timeout() time.Duration
 	DownloadP() Content
 	Download() (Content, error)
+
+	// Upload 把 reader 的内容写入该文件，目录不存在时自动创建。
+	// size 是要上传的字节数，reader 不足 size 字节时报错；size < 0 表示一直读到 EOF
+	UploadP(reader io.Reader, size int64)
+	Upload(reader io.Reader, size int64) error
+	DeleteP()
+	Delete() error
+	// Stat 返回文件信息，文件不存在时返回的错误满足 errors.Is(err, os.ErrNotExist)
+	StatP() FileInfo
+	Stat() (FileInfo, error)
+	// List 把该 URL 当作目录，返回其中的文件和子目录
+	ListP() []FileInfo
+	List() ([]FileInfo, error)
 }
 
 type (
@@ -45,6 +61,16 @@ type (
 	Content  = *ContentT
 )
 
+// FileInfo 文件信息，Path 是文件所在的目录
+type (
+	FileInfoT = models.RemoteFile
+	FileInfo  = *FileInfoT
+)
+
+func newFileInfo(dir string, info os.FileInfo) FileInfo {
+	return &FileInfoT{Name: info.Name(), Path: dir, Size: info.Size(), Lastmod: info.ModTime(), IsDir: info.IsDir()}
+}
+
 func NewFileP(afs afero.Fs, url string, credentials Credentials, timeout time.Duration) File {
 	r, err := NewFile(afs, url, credentials, timeout)
 	if err != nil {
@@ -122,38 +148,78 @@ func DownloadBytesP(logger qlang.Logger, fallbackDir string, fs afero.Fs, url st
 	return r
 }
 
-func DownloadBytes(logger qlang.Logger, fallbackDir string, fs afero.Fs, url string, credentials Credentials, timeout time.Duration) (result []byte, err error) {
-	result, err = downloadBytes(fs, url, credentials, timeout)
+func DownloadBytes(logger qlang.Logger, fallbackDir string, fs afero.Fs, url string, credentials Credentials, timeout time.Duration) ([]byte, error) {
+	return DownloadBytesWithFallback(logger, fallbackDir, fs, url, credentials, timeout, 0)
+}
 
-	if len(fallbackDir) > 0 {
-		if err == nil {
-			if logger != nil {
-				logger.Info().Str("fallbackDir", fallbackDir).Str("url", url).Msg("save download files to fallback dir")
-			}
-			fallbackFilePath, fallbackErr := WriteFallbackFile(fallbackDir, fs, url, result)
-			if fallbackErr != nil {
-				if logger != nil {
-					logger.Warn().Err(fallbackErr).Str("url", url).Str("fallbackFilePath", fallbackFilePath).Msg("save fallback file failed")
-				}
-			}
-			return result, err
-		} else {
+func DownloadBytesWithFallbackP(logger qlang.Logger, fallbackDir string, fs afero.Fs, url string, credentials Credentials, timeout time.Duration, maxStale time.Duration) []byte {
+	r, err := DownloadBytesWithFallback(logger, fallbackDir, fs, url, credentials, timeout, maxStale)
+	if err != nil {
+		panic(qerr.NewSystemError(err.Error(), err))
+	}
+	return r
+}
+
+// DownloadBytesWithFallback 下载 url 的内容，fallbackDir 不为空时把内容和元数据保存到 fallbackDir，下载失败时使用保存的副本。
+// HTTP(S) 下载时带上次的 ETag 和 Last-Modified 发送条件请求，服务端返回 304 时直接使用保存的副本。
+// maxStale > 0 时，下载失败只使用 maxStale 以内下载的副本
+func DownloadBytesWithFallback(logger qlang.Logger, fallbackDir string, fs afero.Fs, url string, credentials Credentials, timeout time.Duration, maxStale time.Duration) ([]byte, error) {
+	if len(fallbackDir) == 0 {
+		return downloadBytes(fs, url, credentials, timeout)
+	}
+
+	fallback, meta, fallbackErr := readFallback(fallbackDir, fs, url)
+	if fallbackErr != nil && logger != nil {
+		logger.Warn().Err(fallbackErr).Str("url", url).Str("fallbackDir", fallbackDir).Msg("read fallback file failed")
+	}
+
+	var result []byte
+	var newMeta FallbackMeta
+	var err error
+	if isHttpUrl(url) {
+		var notModified bool
+		result, newMeta, notModified, err = fetchConditional(url, credentials, timeout, meta)
+		if notModified {
 			if logger != nil {
-				logger.Warn().Err(err).Str("url", url).Msg("fallbacking due to failed to download the file")
+				logger.Info().Str("fallbackDir", fallbackDir).Str("url", url).Msg("not modified, use fallback file")
 			}
-			fallbackFilePath, r, fallbackErr := ReadFallbackFile(fallbackDir, fs, url)
-			if fallbackErr != nil {
-				if logger != nil {
-					logger.Warn().Err(fallbackErr).Str("url", url).Str("fallbackFilePath", fallbackFilePath).Msg("get fallbacked file failed too")
-				}
-			} else {
-				result = r
-				err = nil
+			if metaPath, metaErr := WriteFallbackMeta(fallbackDir, fs, newMeta); metaErr != nil && logger != nil {
+				logger.Warn().Err(metaErr).Str("url", url).Str("metaPath", metaPath).Msg("save fallback meta file failed")
 			}
+			return fallback, nil
 		}
+	} else {
+		result, err = downloadBytes(fs, url, credentials, timeout)
+		newMeta = &FallbackMetaT{Url: url, FetchedAt: time.Now()}
 	}
 
-	return result, err
+	if err == nil {
+		if logger != nil {
+			logger.Info().Str("fallbackDir", fallbackDir).Str("url", url).Msg("save download files to fallback dir")
+		}
+		fallbackFilePath, fallbackErr := WriteFallbackFile(fallbackDir, fs, url, result)
+		if fallbackErr == nil {
+			fallbackFilePath, fallbackErr = WriteFallbackMeta(fallbackDir, fs, newMeta)
+		}
+		if fallbackErr != nil && logger != nil {
+			logger.Warn().Err(fallbackErr).Str("url", url).Str("fallbackFilePath", fallbackFilePath).Msg("save fallback file failed")
+		}
+		return result, nil
+	}
+
+	if meta == nil {
+		return nil, err
+	}
+	if fallbackStale(meta, maxStale) {
+		if logger != nil {
+			logger.Warn().Err(err).Str("url", url).Time("fetchedAt", meta.FetchedAt).Msg("fallback file is too stale to use")
+		}
+		return nil, err
+	}
+	if logger != nil {
+		logger.Warn().Err(err).Str("url", url).Time("fetchedAt", meta.FetchedAt).Msg("fallbacking due to failed to download the file")
+	}
+	return fallback, nil
 }
 
 func downloadBytes(fs afero.Fs, url string, credentials Credentials, timeout time.Duration) ([]byte, error) {
@@ -188,3 +254,43 @@ func DownloadText(logger qlang.Logger, fallbackDir string, fs afero.Fs, url stri
 	}
 	return string(bytes), nil
 }
+
+func DownloadTextWithFallbackP(logger qlang.Logger, fallbackDir string, fs afero.Fs, url string, credentials Credentials, timeout time.Duration, maxStale time.Duration) string {
+	r, err := DownloadTextWithFallback(logger, fallbackDir, fs, url, credentials, timeout, maxStale)
+	if err != nil {
+		panic(qerr.NewSystemError(err.Error(), err))
+	}
+	return r
+}
+
+func DownloadTextWithFallback(logger qlang.Logger, fallbackDir string, fs afero.Fs, url string, credentials Credentials, timeout time.Duration, maxStale time.Duration) (string, error) {
+	bytes, err := DownloadBytesWithFallback(logger, fallbackDir, fs, url, credentials, timeout, maxStale)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes), nil
+}
+
+// uploadReaderT 从 reader 读取恰好 size 字节，提前 EOF 时报错。
+// 不使用 io.ErrUnexpectedEOF，有的客户端（如 sftp.File.ReadFrom）把它当作正常结束
+type uploadReaderT struct {
+	reader    io.Reader
+	remaining int64
+}
+
+// uploadReader size < 0 时原样返回 reader
+func uploadReader(reader io.Reader, size int64) io.Reader {
+	if size < 0 {
+		return reader
+	}
+	return &uploadReaderT{reader: io.LimitReader(reader, size), remaining: size}
+}
+
+func (me *uploadReaderT) Read(p []byte) (int, error) {
+	n, err := me.reader.Read(p)
+	me.remaining -= int64(n)
+	if err == io.EOF && me.remaining > 0 {
+		return n, errors.Errorf("unexpected EOF: %d bytes short", me.remaining)
+	}
+	return n, err
+}
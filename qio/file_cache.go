@@ -1,39 +1,350 @@
 package qio
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/pkg/errors"
 	"github.com/qiangyt/go-comm/v3/qerr"
+	"github.com/qiangyt/go-comm/v3/qjson"
+	"github.com/spf13/afero"
 )
 
-// FileCache 文件缓存管理器
+const (
+	// DEFAULT_FILE_CACHE_LOCK_TIMEOUT 等待条目锁的最长时间
+	DEFAULT_FILE_CACHE_LOCK_TIMEOUT = 30 * time.Second
+	// DEFAULT_FILE_CACHE_STALE_LOCK 早于这个时间创建的锁文件视为持有者已崩溃
+	DEFAULT_FILE_CACHE_STALE_LOCK = 10 * time.Minute
+
+	FILE_CACHE_META_SUFFIX = ".json"
+	FILE_CACHE_LOCK_SUFFIX = ".lock"
+	FILE_CACHE_TEMP_SUFFIX = ATOMIC_TEMP_SUFFIX
+)
+
+// FileCacheOptions 文件缓存选项，零值表示不限制
+type FileCacheOptionsT struct {
+	// MaxSize 缓存总大小上限（字节），Put 之后超过上限时按最近访问时间从旧到新淘汰
+	MaxSize int64
+	// TTL 条目自 Put 起的有效期，过期的条目视为不存在，在访问或 Prune 时删除
+	TTL time.Duration
+	// LockTimeout 等待条目锁的最长时间，默认 DEFAULT_FILE_CACHE_LOCK_TIMEOUT
+	LockTimeout time.Duration
+	// StaleLock 锁文件存在超过这个时间就被删除，默认 DEFAULT_FILE_CACHE_STALE_LOCK
+	StaleLock time.Duration
+}
+
+type FileCacheOptions = *FileCacheOptionsT
+
+// FileCacheEntry 缓存条目的元数据，与缓存文件放在一起
+type FileCacheEntryT struct {
+	Key        string    `json:"key"`
+	Source     string    `json:"source"`
+	Hash       string    `json:"sha256"`
+	Size       int64     `json:"size"`
+	CreatedAt  time.Time `json:"createdAt"`
+	AccessedAt time.Time `json:"accessedAt"`
+
+	// path 缓存文件的路径
+	path string
+}
+
+type FileCacheEntry = *FileCacheEntryT
+
+// FileCache 文件缓存管理器。
+// 缓存文件以 key 的 SHA-256 命名，存放在 <cacheDir>/<前两位>/ 下，旁边是同名的 .json 元数据文件。
+// 读取时校验内容的 SHA-256，不一致的条目被删除。
+// Put 在条目锁内先写临时文件再改名，多个进程同时写入同一个 key 是安全的
 type FileCacheT struct {
+	fs       afero.Fs
 	cacheDir string
+	options  FileCacheOptionsT
+	now      func() time.Time
 }
 
 type FileCache = *FileCacheT
 
-// NewFileCache 创建文件缓存管理器
-// cacheDir: 缓存目录路径，如果为空则使用 ~/.cache/<appName>
+// fileCacheLock 同一进程内的条目锁，refs 是持有和等待它的数量，归零时从 _fileCacheLocks 中删除
+type fileCacheLock struct {
+	sync.Mutex
+	refs int
+}
+
+var (
+	// _fileCacheLocks 同一进程内的条目锁，有的 afero.Fs（如 MemMapFs）的 O_EXCL 不是原子的
+	_fileCacheLocks      = map[string]*fileCacheLock{}
+	_fileCacheLocksMutex sync.Mutex
+)
+
+// acquireFileCacheLock 获取 lockPath 的进程内锁
+func acquireFileCacheLock(lockPath string) *fileCacheLock {
+	_fileCacheLocksMutex.Lock()
+	r := _fileCacheLocks[lockPath]
+	if r == nil {
+		r = &fileCacheLock{}
+		_fileCacheLocks[lockPath] = r
+	}
+	r.refs++
+	_fileCacheLocksMutex.Unlock()
+
+	r.Lock()
+	return r
+}
+
+// releaseFileCacheLock 释放 lockPath 的进程内锁，没有其他使用者时删除它
+func releaseFileCacheLock(lockPath string, l *fileCacheLock) {
+	l.Unlock()
+
+	_fileCacheLocksMutex.Lock()
+	l.refs--
+	if l.refs == 0 {
+		delete(_fileCacheLocks, lockPath)
+	}
+	_fileCacheLocksMutex.Unlock()
+}
+
+// DefaultFileCacheDir 返回 <用户缓存目录>/<程序名>
+func DefaultFileCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", errors.Wrap(err, "get user cache directory")
+	}
+	name := filepath.Base(os.Args[0])
+	return filepath.Join(dir, strings.TrimSuffix(name, filepath.Ext(name))), nil
+}
+
+// NewFileCache 创建使用本地文件系统、不限大小和有效期的文件缓存管理器
+// cacheDir: 缓存目录路径，如果为空则使用 DefaultFileCacheDir()
 func NewFileCache(cacheDir string) FileCache {
+	return NewAferoFileCacheP(AppFs, cacheDir, nil)
+}
+
+func NewAferoFileCacheP(fs afero.Fs, cacheDir string, options FileCacheOptions) FileCache {
+	r, err := NewAferoFileCache(fs, cacheDir, options)
+	if err != nil {
+		panic(qerr.NewSystemError(err.Error(), err))
+	}
+	return r
+}
+
+// NewAferoFileCache 创建文件缓存管理器，options 为 nil 时不限大小和有效期
+func NewAferoFileCache(fs afero.Fs, cacheDir string, options FileCacheOptions) (FileCache, error) {
 	if cacheDir == "" {
-		// 获取用户缓存目录
-		homeDir, err := os.UserHomeDir()
-		if err != nil {
-			panic(qerr.NewSystemError("get user home directory", err))
+		var err error
+		if cacheDir, err = DefaultFileCacheDir(); err != nil {
+			return nil, err
 		}
-		cacheDir = filepath.Join(homeDir, ".cache", "amcopy")
 	}
 
 	// 创建缓存目录
-	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
-		panic(qerr.NewSystemError("create cache directory", err))
+	if err := fs.MkdirAll(cacheDir, 0o755); err != nil {
+		return nil, errors.Wrapf(err, "create cache directory: %s", cacheDir)
+	}
+
+	r := &FileCacheT{fs: fs, cacheDir: cacheDir, now: time.Now}
+	if options != nil {
+		r.options = *options
+	}
+	if r.options.LockTimeout <= 0 {
+		r.options.LockTimeout = DEFAULT_FILE_CACHE_LOCK_TIMEOUT
+	}
+	if r.options.StaleLock <= 0 {
+		r.options.StaleLock = DEFAULT_FILE_CACHE_STALE_LOCK
+	}
+	return r, nil
+}
+
+func (me FileCache) Fs() afero.Fs {
+	return me.fs
+}
+
+// GetCacheDir 获取缓存目录路径
+func (me FileCache) GetCacheDir() string {
+	return me.cacheDir
+}
+
+// getCachedPath 返回 key 对应的缓存文件路径，与 key 的内容无关，不会越出缓存目录
+func (me FileCache) getCachedPath(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(me.cacheDir, name[:2], name)
+}
+
+// lock 获取条目锁，返回释放函数。
+// 锁文件记录持有者的 pid 和随机串，释放时和清理过期的锁文件前都先确认它没有被其他人换掉
+func (me FileCache) lock(cachedPath string) (func(), error) {
+	lockPath := cachedPath + FILE_CACHE_LOCK_SUFFIX
+
+	mutex := acquireFileCacheLock(lockPath)
+
+	if err := me.fs.MkdirAll(filepath.Dir(lockPath), 0o755); err != nil {
+		releaseFileCacheLock(lockPath, mutex)
+		return nil, errors.Wrapf(err, "create cache directory: %s", filepath.Dir(lockPath))
+	}
+
+	nonce := make([]byte, 8)
+	rand.Read(nonce)
+	owner := fmt.Sprintf("%d %s\n", os.Getpid(), hex.EncodeToString(nonce))
+
+	deadline := time.Now().Add(me.options.LockTimeout)
+	for {
+		f, err := me.fs.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			_, err = f.Write([]byte(owner))
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				me.fs.Remove(lockPath)
+				releaseFileCacheLock(lockPath, mutex)
+				return nil, errors.Wrapf(err, "write lock file: %s", lockPath)
+			}
+			return func() {
+				if current, _, err := me.readLock(lockPath); err == nil && current == owner {
+					me.fs.Remove(lockPath)
+				}
+				releaseFileCacheLock(lockPath, mutex)
+			}, nil
+		}
+		if !os.IsExist(err) {
+			releaseFileCacheLock(lockPath, mutex)
+			return nil, errors.Wrapf(err, "create lock file: %s", lockPath)
+		}
+
+		// 持有者崩溃后留下的锁文件。删除前再读一次，确认还是同一个锁文件，
+		// 以免删掉另一个等待者刚刚清理过期锁后创建的锁文件
+		if stale, modTime, err := me.readLock(lockPath); err == nil && time.Since(modTime) > me.options.StaleLock {
+			if current, currentModTime, err := me.readLock(lockPath); err == nil &&
+				current == stale && currentModTime.Equal(modTime) {
+				me.fs.Remove(lockPath)
+			}
+			continue
+		}
+		if time.Now().After(deadline) {
+			releaseFileCacheLock(lockPath, mutex)
+			return nil, errors.Errorf("timeout waiting for lock file: %s", lockPath)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// readLock 返回锁文件的内容和修改时间
+func (me FileCache) readLock(lockPath string) (string, time.Time, error) {
+	fi, err := me.fs.Stat(lockPath)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	data, err := afero.ReadFile(me.fs, lockPath)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return string(data), fi.ModTime(), nil
+}
+
+func (me FileCache) readEntry(cachedPath string) (FileCacheEntry, error) {
+	bytes, err := afero.ReadFile(me.fs, cachedPath+FILE_CACHE_META_SUFFIX)
+	if err != nil {
+		return nil, err
+	}
+	r := &FileCacheEntryT{}
+	if err := qjson.UnmarshalJSON(bytes, r); err != nil {
+		return nil, errors.Wrapf(err, "parse cache entry: %s", cachedPath+FILE_CACHE_META_SUFFIX)
+	}
+	r.path = cachedPath
+	return r, nil
+}
+
+func (me FileCache) writeEntry(entry FileCacheEntry) error {
+	bytes, err := qjson.MarshalJSON(entry)
+	if err != nil {
+		return errors.Wrapf(err, "marshal cache entry: %s", entry.Key)
+	}
+	return WriteFileAtomic(me.fs, entry.path+FILE_CACHE_META_SUFFIX, bytes, AtomicWriteOptions{})
+}
+
+func (me FileCache) expired(entry FileCacheEntry) bool {
+	return me.options.TTL > 0 && me.now().Sub(entry.CreatedAt) > me.options.TTL
+}
+
+// removeEntry 先删除元数据，读取方看不到元数据就认为条目不存在
+func (me FileCache) removeEntry(cachedPath string) error {
+	for _, p := range []string{cachedPath + FILE_CACHE_META_SUFFIX, cachedPath} {
+		if err := me.fs.Remove(p); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "delete cache file: %s", p)
+		}
+	}
+	return nil
+}
+
+// verify 计算缓存文件的 SHA-256，与元数据比较
+func (me FileCache) verify(entry FileCacheEntry) (bool, error) {
+	f, err := me.fs.Open(entry.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return false, err
+	}
+	return n == entry.Size && hex.EncodeToString(h.Sum(nil)) == entry.Hash, nil
+}
+
+// lookup 返回有效的条目并更新访问时间；条目过期或损坏时删除它并返回 nil
+func (me FileCache) lookup(key string) FileCacheEntry {
+	cachedPath := me.getCachedPath(key)
+
+	entry, err := me.readEntry(cachedPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			me.Delete(key)
+		}
+		return nil
 	}
 
-	return &FileCacheT{
-		cacheDir: cacheDir,
+	valid := !me.expired(entry) && entry.Key == key
+	if valid {
+		if valid, err = me.verify(entry); err != nil {
+			panic(qerr.NewSystemError("verify cache file", err))
+		}
+	}
+	if !valid {
+		me.evict(entry)
+		return nil
+	}
+
+	me.touch(entry)
+	return entry
+}
+
+// touch 更新访问时间，元数据已被其他进程改写时不更新
+func (me FileCache) touch(entry FileCacheEntry) {
+	unlock, err := me.lock(entry.path)
+	if err != nil {
+		panic(qerr.NewSystemError("lock cache entry", err))
+	}
+	defer unlock()
+
+	current, err := me.readEntry(entry.path)
+	if err != nil || current.Hash != entry.Hash || !current.CreatedAt.Equal(entry.CreatedAt) {
+		return
+	}
+	entry.AccessedAt = me.now()
+	if err := me.writeEntry(entry); err != nil {
+		panic(qerr.NewSystemError("update cache entry", err))
 	}
 }
 
@@ -44,20 +355,19 @@ func (me FileCache) Get(key string) string {
 		return ""
 	}
 
-	cachedPath := me.getCachedPath(key)
-	if _, err := os.Stat(cachedPath); err == nil {
-		return cachedPath
+	entry := me.lookup(key)
+	if entry == nil {
+		return ""
 	}
-
-	return ""
+	return entry.path
 }
 
-func (me FileCache) getCachedPath(key string) string {
+// Stat 返回条目的元数据，条目不存在时返回 nil
+func (me FileCache) Stat(key string) FileCacheEntry {
 	if key == "" {
-		return ""
+		return nil
 	}
-
-	return filepath.Join(me.cacheDir, key)
+	return me.lookup(key)
 }
 
 // Has 检查缓存是否存在
@@ -65,69 +375,124 @@ func (me FileCache) Has(key string) bool {
 	return me.Get(key) != ""
 }
 
-// Put 将文件添加到缓存
+// evict 删除过期或损坏的条目。先取得条目锁再确认元数据没有变化，以免删掉其他进程刚刚写入的条目
+func (me FileCache) evict(stale FileCacheEntry) {
+	unlock, err := me.lock(stale.path)
+	if err != nil {
+		panic(qerr.NewSystemError("lock cache entry", err))
+	}
+	defer unlock()
+
+	if current, err := me.readEntry(stale.path); err == nil &&
+		(current.Hash != stale.Hash || !current.CreatedAt.Equal(stale.CreatedAt)) {
+		return
+	}
+	if err := me.removeEntry(stale.path); err != nil {
+		panic(qerr.NewSystemError("delete cache file", err))
+	}
+}
+
+// Put 将文件添加到缓存，已存在的有效条目不会被覆盖
 func (me FileCache) Put(srcPath string, key string) {
 	if key == "" {
 		panic(qerr.NewBusinessError("cache key is required", nil))
 	}
 
+	me.put(srcPath, key)
+
+	if me.options.MaxSize > 0 || me.options.TTL > 0 {
+		me.Prune()
+	}
+}
+
+func (me FileCache) put(srcPath string, key string) {
 	cachedPath := me.getCachedPath(key)
+	unlock, err := me.lock(cachedPath)
+	if err != nil {
+		panic(qerr.NewSystemError("lock cache entry", err))
+	}
+	defer unlock()
 
 	// 如果缓存已存在，不需要再复制
-	if _, err := os.Stat(cachedPath); err == nil {
-		return
+	if entry, err := me.readEntry(cachedPath); err == nil && !me.expired(entry) && entry.Key == key {
+		if valid, _ := me.verify(entry); valid {
+			return
+		}
+	}
+	if err := me.removeEntry(cachedPath); err != nil {
+		panic(qerr.NewSystemError("delete cache entry", err))
 	}
 
 	// 复制文件到缓存目录
-	src, err := os.Open(srcPath)
+	src, err := me.fs.Open(srcPath)
 	if err != nil {
 		panic(qerr.NewSystemError("open source file", err))
 	}
 	defer src.Close()
 
-	dst, err := os.Create(cachedPath)
+	h := sha256.New()
+	var size int64
+	err = WriteFileAtomicFunc(me.fs, cachedPath, func(f afero.File) error {
+		size, err = io.Copy(io.MultiWriter(f, h), src)
+		return err
+	}, AtomicWriteOptions{})
 	if err != nil {
-		panic(qerr.NewSystemError("create cache file", err))
+		panic(qerr.NewSystemError("copy file to cache", err))
 	}
-	defer dst.Close()
 
-	if _, err := io.Copy(dst, src); err != nil {
-		// 删除不完整的缓存文件
-		os.Remove(cachedPath)
-		panic(qerr.NewSystemError("copy file to cache", err))
+	now := me.now()
+	entry := &FileCacheEntryT{
+		Key:        key,
+		Source:     srcPath,
+		Hash:       hex.EncodeToString(h.Sum(nil)),
+		Size:       size,
+		CreatedAt:  now,
+		AccessedAt: now,
+		path:       cachedPath,
+	}
+	if err := me.writeEntry(entry); err != nil {
+		me.fs.Remove(cachedPath)
+		panic(qerr.NewSystemError("write cache entry", err))
 	}
 }
 
-// CopyTo 从缓存复制文件到目标位置
+// CopyTo 从缓存复制文件到目标位置，复制的同时校验内容
 func (me FileCache) CopyTo(key string, destPath string) {
-	cachedPath := me.Get(key)
-	if cachedPath == "" {
+	entry := me.Stat(key)
+	if entry == nil {
 		panic(qerr.NewBusinessError("file not found in cache", nil))
 	}
 
 	// 确保目标目录存在
 	destDir := filepath.Dir(destPath)
-	if err := os.MkdirAll(destDir, 0o755); err != nil {
+	if err := me.fs.MkdirAll(destDir, 0o755); err != nil {
 		panic(qerr.NewSystemError("create destination directory", err))
 	}
 
 	// 复制文件
-	src, err := os.Open(cachedPath)
+	src, err := me.fs.Open(entry.path)
 	if err != nil {
 		panic(qerr.NewSystemError("open cached file", err))
 	}
 	defer src.Close()
 
-	dst, err := os.Create(destPath)
+	dst, err := me.fs.Create(destPath)
 	if err != nil {
 		panic(qerr.NewSystemError("create destination file", err))
 	}
 	defer dst.Close()
 
-	if _, err := io.Copy(dst, src); err != nil {
-		os.Remove(destPath)
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(dst, h), src); err != nil {
+		me.fs.Remove(destPath)
 		panic(qerr.NewSystemError("copy from cache", err))
 	}
+	if hex.EncodeToString(h.Sum(nil)) != entry.Hash {
+		// Stat 校验之后缓存文件又被改动了
+		me.fs.Remove(destPath)
+		me.Delete(key)
+		panic(qerr.NewSystemError("copy from cache", errors.Errorf("cache file is corrupted: %s", key)))
+	}
 }
 
 // Delete 删除缓存文件
@@ -137,47 +502,85 @@ func (me FileCache) Delete(key string) {
 	}
 
 	cachedPath := me.getCachedPath(key)
-	if err := os.Remove(cachedPath); err != nil && !os.IsNotExist(err) {
+	unlock, err := me.lock(cachedPath)
+	if err != nil {
+		panic(qerr.NewSystemError("lock cache entry", err))
+	}
+	defer unlock()
+
+	if err := me.removeEntry(cachedPath); err != nil {
 		panic(qerr.NewSystemError("delete cache file", err))
 	}
 }
 
+// Entries 返回所有条目的元数据，包括已过期的，不校验内容，也不更新访问时间
+func (me FileCache) Entries() []FileCacheEntry {
+	r := []FileCacheEntry{}
+	err := afero.Walk(me.fs, me.cacheDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(path, FILE_CACHE_META_SUFFIX) || strings.HasPrefix(info.Name(), ".") {
+			return nil
+		}
+		entry, err := me.readEntry(strings.TrimSuffix(path, FILE_CACHE_META_SUFFIX))
+		if err != nil {
+			// 刚被删除或写坏的元数据
+			return nil
+		}
+		r = append(r, entry)
+		return nil
+	})
+	if err != nil {
+		panic(qerr.NewSystemError("list cache entries", err))
+	}
+	return r
+}
+
+// Prune 删除过期的条目，然后按最近访问时间从旧到新淘汰，直到总大小不超过 MaxSize
+func (me FileCache) Prune() {
+	entries := me.Entries()
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].AccessedAt.Before(entries[j].AccessedAt)
+	})
+
+	var total int64
+	for _, entry := range entries {
+		total += entry.Size
+	}
+
+	for _, entry := range entries {
+		if !me.expired(entry) && (me.options.MaxSize <= 0 || total <= me.options.MaxSize) {
+			continue
+		}
+		me.evict(entry)
+		total -= entry.Size
+	}
+}
+
 // Clear 清空所有缓存
 func (me FileCache) Clear() {
-	entries, err := os.ReadDir(me.cacheDir)
+	entries, err := afero.ReadDir(me.fs, me.cacheDir)
 	if err != nil {
 		panic(qerr.NewSystemError("read cache directory", err))
 	}
 
 	for _, entry := range entries {
-		path := me.getCachedPath(entry.Name())
-		if err := os.RemoveAll(path); err != nil {
+		path := filepath.Join(me.cacheDir, entry.Name())
+		if err := me.fs.RemoveAll(path); err != nil {
 			panic(qerr.NewSystemError("remove cache entry "+entry.Name(), err))
 		}
 	}
 }
 
-// GetCacheDir 获取缓存目录路径
-func (me FileCache) GetCacheDir() string {
-	return me.cacheDir
-}
-
-// Size 获取缓存总大小（字节）
+// Size 获取缓存总大小（字节），只计算缓存文件
 func (me FileCache) Size() int64 {
 	var size int64
-
-	err := filepath.Walk(me.cacheDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if !info.IsDir() {
-			size += info.Size()
-		}
-		return nil
-	})
-	if err != nil {
-		panic(qerr.NewSystemError("calculate cache size", err))
+	for _, entry := range me.Entries() {
+		size += entry.Size
 	}
-
 	return size
 }
@@ -1,10 +1,15 @@
 package qio
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/spf13/afero"
 	"github.com/stretchr/testify/require"
 )
 
@@ -228,3 +233,203 @@ func TestFileCache_Size(t *testing.T) {
 	size := cache.Size()
 	a.Greater(size, int64(0))
 }
+
+// newTestFileCache 返回使用内存文件系统的缓存，时钟每次调用前进 1 秒
+func newTestFileCache(t *testing.T, options FileCacheOptions) (FileCache, *time.Time) {
+	fs := afero.NewMemMapFs()
+	cache := NewAferoFileCacheP(fs, "/cache", options)
+
+	var mu sync.Mutex
+	clock := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	cache.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		clock = clock.Add(time.Second)
+		return clock
+	}
+	return cache, &clock
+}
+
+func TestFileCache_hashedKey(t *testing.T) {
+	a := require.New(t)
+
+	cache, _ := newTestFileCache(t, nil)
+	WriteFileTextP(cache.Fs(), "/src.txt", "hello")
+
+	key := "../../etc/passwd"
+	cache.Put("/src.txt", key)
+
+	p := cache.Get(key)
+	a.True(strings.HasPrefix(p, "/cache/"))
+	a.NotContains(p, "passwd")
+	a.Equal("hello", ReadFileTextP(cache.Fs(), p))
+	a.False(FileExistsP(cache.Fs(), "/etc/passwd"))
+
+	entry := cache.Stat(key)
+	a.Equal(key, entry.Key)
+	a.Equal("/src.txt", entry.Source)
+	a.Equal("2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", entry.Hash)
+	a.Equal(int64(5), entry.Size)
+	a.True(entry.AccessedAt.After(entry.CreatedAt))
+	a.Equal(int64(5), cache.Size())
+	a.Len(cache.Entries(), 1)
+}
+
+func TestFileCache_integrity(t *testing.T) {
+	a := require.New(t)
+
+	cache, _ := newTestFileCache(t, nil)
+	WriteFileTextP(cache.Fs(), "/src.txt", "hello")
+	cache.Put("/src.txt", "k")
+
+	// 缓存文件被改动后条目失效并被删除
+	WriteFileTextP(cache.Fs(), cache.Get("k"), "HELLO")
+	a.False(cache.Has("k"))
+	a.Empty(cache.Entries())
+	a.Panics(func() { cache.CopyTo("k", "/dest.txt") })
+
+	// 重新 Put 可以恢复
+	cache.Put("/src.txt", "k")
+	cache.CopyTo("k", "/out/dest.txt")
+	a.Equal("hello", ReadFileTextP(cache.Fs(), "/out/dest.txt"))
+}
+
+func TestFileCache_ttl(t *testing.T) {
+	a := require.New(t)
+
+	cache, clock := newTestFileCache(t, &FileCacheOptionsT{TTL: time.Minute})
+	WriteFileTextP(cache.Fs(), "/src.txt", "hello")
+	cache.Put("/src.txt", "k")
+	a.True(cache.Has("k"))
+
+	*clock = clock.Add(2 * time.Minute)
+	a.False(cache.Has("k"))
+	a.Empty(cache.Entries())
+
+	// 过期的条目可以被新的 Put 替换
+	cache.Put("/src.txt", "k")
+	a.True(cache.Has("k"))
+	*clock = clock.Add(2 * time.Minute)
+	cache.Prune()
+	a.Empty(cache.Entries())
+	a.Equal(int64(0), cache.Size())
+}
+
+func TestFileCache_lru(t *testing.T) {
+	a := require.New(t)
+
+	cache, _ := newTestFileCache(t, &FileCacheOptionsT{MaxSize: 10})
+	fs := cache.Fs()
+	WriteFileTextP(fs, "/a.txt", "aaaa")
+	WriteFileTextP(fs, "/b.txt", "bbbb")
+	WriteFileTextP(fs, "/c.txt", "cccc")
+
+	cache.Put("/a.txt", "a")
+	cache.Put("/b.txt", "b")
+	a.True(cache.Has("a"))
+
+	// b 最久没有访问，被淘汰
+	cache.Put("/c.txt", "c")
+	a.True(cache.Has("a"))
+	a.False(cache.Has("b"))
+	a.True(cache.Has("c"))
+	a.Equal(int64(8), cache.Size())
+}
+
+func TestFileCache_concurrentPut(t *testing.T) {
+	a := require.New(t)
+
+	cache, _ := newTestFileCache(t, &FileCacheOptionsT{MaxSize: 1 << 20})
+	WriteFileTextP(cache.Fs(), "/src.txt", "hello")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			cache.Put("/src.txt", "shared")
+			cache.Put("/src.txt", fmt.Sprintf("k%d", i%2))
+			cache.Get("shared")
+		}(i)
+	}
+	wg.Wait()
+
+	a.True(cache.Has("shared"))
+	a.True(cache.Has("k0"))
+	a.True(cache.Has("k1"))
+	a.Len(cache.Entries(), 3)
+
+	// 没有遗留的锁文件和临时文件
+	afero.Walk(cache.Fs(), "/cache", func(path string, info os.FileInfo, err error) error {
+		a.NoError(err)
+		a.False(strings.HasSuffix(path, FILE_CACHE_LOCK_SUFFIX), path)
+		a.False(strings.HasSuffix(path, FILE_CACHE_TEMP_SUFFIX), path)
+		return nil
+	})
+
+	// 释放后不再保留进程内的锁
+	_fileCacheLocksMutex.Lock()
+	defer _fileCacheLocksMutex.Unlock()
+	for lockPath := range _fileCacheLocks {
+		a.False(strings.HasPrefix(lockPath, cache.GetCacheDir()), lockPath)
+	}
+}
+
+func TestFileCache_lockFile(t *testing.T) {
+	a := require.New(t)
+
+	cache, _ := newTestFileCache(t, &FileCacheOptionsT{LockTimeout: 100 * time.Millisecond, StaleLock: time.Minute})
+	fs := cache.Fs()
+	WriteFileTextP(fs, "/src.txt", "hello")
+
+	// 其他进程持有锁时超时
+	lockPath := cache.getCachedPath("k") + FILE_CACHE_LOCK_SUFFIX
+	WriteFileTextP(fs, lockPath, "")
+	a.Panics(func() { cache.Put("/src.txt", "k") })
+
+	// 持有者崩溃后留下的锁文件被清理
+	a.NoError(fs.Chtimes(lockPath, time.Now(), time.Now().Add(-2*time.Minute)))
+	cache.Put("/src.txt", "k")
+	a.True(cache.Has("k"))
+}
+
+// lockRaceFs 第一次读取锁文件时模拟另一个等待者清理了过期的锁文件并创建了自己的锁文件
+type lockRaceFs struct {
+	afero.Fs
+	lockPath string
+	once     sync.Once
+}
+
+func (me *lockRaceFs) Open(name string) (afero.File, error) {
+	if name == me.lockPath {
+		me.once.Do(func() {
+			me.Fs.Remove(name)
+			afero.WriteFile(me.Fs, name, []byte("other"), 0o600)
+		})
+	}
+	return me.Fs.Open(name)
+}
+
+func TestFileCache_lockFile_race(t *testing.T) {
+	a := require.New(t)
+
+	cache, _ := newTestFileCache(t, &FileCacheOptionsT{LockTimeout: 100 * time.Millisecond, StaleLock: time.Minute})
+	lockPath := cache.getCachedPath("k") + FILE_CACHE_LOCK_SUFFIX
+	fs := &lockRaceFs{Fs: cache.Fs(), lockPath: lockPath}
+	cache.fs = fs
+	WriteFileTextP(fs, "/src.txt", "hello")
+
+	// 过期的锁文件在删除前被换掉了，新的锁文件不会被删除
+	WriteFileTextP(fs.Fs, lockPath, "crashed")
+	a.NoError(fs.Chtimes(lockPath, time.Now(), time.Now().Add(-2*time.Minute)))
+	a.Panics(func() { cache.Put("/src.txt", "k") })
+	a.Equal("other", ReadFileTextP(fs.Fs, lockPath))
+
+	// 释放时不删除已被换掉的锁文件
+	a.NoError(fs.Remove(lockPath))
+	unlock, err := cache.lock(cache.getCachedPath("k"))
+	a.NoError(err)
+	WriteFileTextP(fs.Fs, lockPath, "other")
+	unlock()
+	a.Equal("other", ReadFileTextP(fs.Fs, lockPath))
+}
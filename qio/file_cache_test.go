@@ -143,8 +143,10 @@ func TestNewFileCacheDefaultDir(t *testing.T) {
 	cache := NewFileCache("")
 	cacheDir := cache.GetCacheDir()
 
-	homeDir, _ := os.UserHomeDir()
-	expectedDir := filepath.Join(homeDir, ".cache", "amcopy")
+	expectedDir, err := DefaultFileCacheDir()
+	if err != nil {
+		t.Fatalf("DefaultFileCacheDir() error: %v", err)
+	}
 
 	if cacheDir != expectedDir {
 		t.Errorf("GetCacheDir() = %s, want %s", cacheDir, expectedDir)
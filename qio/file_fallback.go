@@ -3,17 +3,40 @@ package qio
 import (
 	"crypto/sha256"
 	"fmt"
+	"io"
+	"net/http"
 	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/pkg/errors"
+	"github.com/qiangyt/go-comm/v3/qjson"
 	"github.com/spf13/afero"
 )
 
+// FALLBACK_META_SUFFIX fallback 文件的元数据文件后缀
+const FALLBACK_META_SUFFIX = ".meta"
+
+// FallbackMetaT fallback 文件的元数据，保存在同目录下的 .meta 文件中
+type FallbackMetaT struct {
+	Url          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"lastModified,omitempty"`
+	FetchedAt    time.Time `json:"fetchedAt"`
+}
+
+type FallbackMeta = *FallbackMetaT
+
 func FallbackFilePath(fallbackDir string, url string) string {
 	sumBytes := sha256.Sum256([]byte(url))
 	sumText := fmt.Sprintf("%x", sumBytes)
 	return filepath.Join(fallbackDir, sumText)
 }
 
+func FallbackMetaPath(fallbackDir string, url string) string {
+	return FallbackFilePath(fallbackDir, url) + FALLBACK_META_SUFFIX
+}
+
 func HasFallbackFile(fallbackDir string, fs afero.Fs, url string) (bool, error) {
 	fallbackFilePath := FallbackFilePath(fallbackDir, url)
 	return FileExists(fs, fallbackFilePath)
@@ -33,6 +56,7 @@ func ReadFallbackFile(fallbackDir string, fs afero.Fs, url string) (string, []by
 	return fallbackFilePath, bytes, err
 }
 
+// WriteFallbackFile 同时删除原来的元数据，它描述的不再是这份内容
 func WriteFallbackFile(fallbackDir string, fs afero.Fs, url string, bytes []byte) (string, error) {
 	fallbackFilePath := FallbackFilePath(fallbackDir, url)
 
@@ -46,5 +70,124 @@ func WriteFallbackFile(fallbackDir string, fs afero.Fs, url string, bytes []byte
 			return "", err
 		}
 	}
+	if err := RemoveFile(fs, FallbackMetaPath(fallbackDir, url)); err != nil {
+		return "", err
+	}
 	return fallbackFilePath, WriteFile(fs, fallbackFilePath, bytes)
 }
+
+// ReadFallbackMeta 没有元数据时返回 nil
+func ReadFallbackMeta(fallbackDir string, fs afero.Fs, url string) (FallbackMeta, error) {
+	metaPath := FallbackMetaPath(fallbackDir, url)
+
+	exists, err := FileExists(fs, metaPath)
+	if err != nil || !exists {
+		return nil, err
+	}
+	bytes, err := ReadFileBytes(fs, metaPath)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &FallbackMetaT{}
+	if err := qjson.UnmarshalJSON(bytes, r); err != nil {
+		return nil, errors.Wrapf(err, "parse fallback meta file: %s", metaPath)
+	}
+	return r, nil
+}
+
+func WriteFallbackMeta(fallbackDir string, fs afero.Fs, meta FallbackMeta) (string, error) {
+	metaPath := FallbackMetaPath(fallbackDir, meta.Url)
+
+	bytes, err := qjson.MarshalJSON(meta)
+	if err != nil {
+		return metaPath, errors.Wrapf(err, "marshal fallback meta: %s", meta.Url)
+	}
+	return metaPath, WriteFile(fs, metaPath, bytes)
+}
+
+// readFallback 读取 fallback 文件和元数据，没有 fallback 文件时 meta 为 nil。
+// 没有元数据（旧版本写入的 fallback 文件）时，以文件的修改时间作为下载时间
+func readFallback(fallbackDir string, fs afero.Fs, url string) ([]byte, FallbackMeta, error) {
+	fallbackFilePath, bytes, err := ReadFallbackFile(fallbackDir, fs, url)
+	if err != nil || len(fallbackFilePath) == 0 {
+		return nil, nil, err
+	}
+
+	meta, err := ReadFallbackMeta(fallbackDir, fs, url)
+	if err != nil {
+		return nil, nil, err
+	}
+	if meta == nil {
+		fi, err := Stat(fs, fallbackFilePath, true)
+		if err != nil {
+			return nil, nil, err
+		}
+		meta = &FallbackMetaT{Url: url, FetchedAt: fi.ModTime()}
+	}
+	return bytes, meta, nil
+}
+
+func isHttpUrl(url string) bool {
+	lc := strings.ToLower(url)
+	return strings.HasPrefix(lc, HTTP) || strings.HasPrefix(lc, HTTPS)
+}
+
+// fetchConditional 用 GET 下载 url，meta 不为 nil 时带上 If-None-Match 和 If-Modified-Since。
+// 返回新的元数据，服务端返回 304 时 notModified 为 true，body 为 nil
+func fetchConditional(url string, credentials Credentials, timeout time.Duration, meta FallbackMeta) (body []byte, r FallbackMeta, notModified bool, err error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if credentials != nil && (len(credentials.User) > 0 || len(credentials.Password) > 0) {
+		req.SetBasicAuth(credentials.User, credentials.Password)
+	}
+	if meta != nil {
+		if len(meta.ETag) > 0 {
+			req.Header.Set("If-None-Match", meta.ETag)
+		}
+		if len(meta.LastModified) > 0 {
+			req.Header.Set("If-Modified-Since", meta.LastModified)
+		}
+	}
+
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	defer resp.Body.Close()
+
+	r = &FallbackMetaT{
+		Url:          url,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		FetchedAt:    time.Now(),
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && meta != nil:
+		// 304 可以不带这两个头，沿用原来的值
+		if len(r.ETag) == 0 {
+			r.ETag = meta.ETag
+		}
+		if len(r.LastModified) == 0 {
+			r.LastModified = meta.LastModified
+		}
+		return nil, r, true, nil
+	case resp.StatusCode == http.StatusOK:
+		body, err = io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		return body, r, false, nil
+	default:
+		return nil, nil, false, errors.Errorf("unexpected HTTP status: %s", resp.Status)
+	}
+}
+
+// fallbackStale 判断是否超过了 maxStale，maxStale <= 0 表示不限
+func fallbackStale(meta FallbackMeta, maxStale time.Duration) bool {
+	return maxStale > 0 && time.Since(meta.FetchedAt) > maxStale
+}
@@ -1,7 +1,12 @@
 package qio
 
 import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/require"
@@ -95,3 +100,165 @@ func TestWriteFallbackFile_overwrite(t *testing.T) {
 	_, content, _ := ReadFallbackFile("/fallback", fs, "http://example.com/file.txt")
 	a.Equal([]byte("content2"), content)
 }
+
+func TestWriteFallbackFile_removesMeta(t *testing.T) {
+	a := require.New(t)
+
+	fs := afero.NewMemMapFs()
+	url := "http://example.com/file.txt"
+	_, err := WriteFallbackMeta("/fallback", fs, &FallbackMetaT{Url: url, ETag: `"v1"`, FetchedAt: time.Now()})
+	a.NoError(err)
+
+	_, err = WriteFallbackFile("/fallback", fs, url, []byte("content"))
+	a.NoError(err)
+	meta, err := ReadFallbackMeta("/fallback", fs, url)
+	a.NoError(err)
+	a.Nil(meta)
+}
+
+// testConditionalServer 返回 content，支持 If-None-Match 和 If-Modified-Since，记录收到的条件
+type testConditionalServer struct {
+	mu           sync.Mutex
+	content      string
+	etag         string
+	lastModified time.Time
+	requests     []http.Header
+}
+
+func (me *testConditionalServer) set(content string, etag string, lastModified time.Time) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.content, me.etag, me.lastModified = content, etag, lastModified
+}
+
+func (me *testConditionalServer) last() http.Header {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.requests[len(me.requests)-1]
+}
+
+func (me *testConditionalServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.requests = append(me.requests, r.Header.Clone())
+
+	if len(me.etag) > 0 {
+		w.Header().Set("ETag", me.etag)
+		if r.Header.Get("If-None-Match") == me.etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	} else if !me.lastModified.IsZero() {
+		w.Header().Set("Last-Modified", me.lastModified.Format(http.TimeFormat))
+		if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !me.lastModified.After(since) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	io.WriteString(w, me.content)
+}
+
+func TestDownloadBytesWithFallback_etag(t *testing.T) {
+	a := require.New(t)
+
+	server := &testConditionalServer{}
+	server.set("v1", `"v1"`, time.Time{})
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	fs := afero.NewMemMapFs()
+	url := ts.URL + "/config.yaml"
+
+	a.Equal("v1", DownloadTextWithFallbackP(nil, "/fallback", fs, url, nil, 5*time.Second, 0))
+	a.Empty(server.last().Get("If-None-Match"))
+	meta, err := ReadFallbackMeta("/fallback", fs, url)
+	a.NoError(err)
+	a.Equal(`"v1"`, meta.ETag)
+	firstFetchedAt := meta.FetchedAt
+
+	// 没有变化时服务端返回 304，使用保存的副本并更新下载时间
+	a.Equal("v1", DownloadTextWithFallbackP(nil, "/fallback", fs, url, nil, 5*time.Second, 0))
+	a.Equal(`"v1"`, server.last().Get("If-None-Match"))
+	meta, _ = ReadFallbackMeta("/fallback", fs, url)
+	a.False(meta.FetchedAt.Before(firstFetchedAt))
+
+	server.set("v2", `"v2"`, time.Time{})
+	a.Equal("v2", DownloadTextWithFallbackP(nil, "/fallback", fs, url, nil, 5*time.Second, 0))
+	meta, _ = ReadFallbackMeta("/fallback", fs, url)
+	a.Equal(`"v2"`, meta.ETag)
+	_, content, _ := ReadFallbackFile("/fallback", fs, url)
+	a.Equal("v2", string(content))
+}
+
+func TestDownloadBytesWithFallback_lastModified(t *testing.T) {
+	a := require.New(t)
+
+	lastModified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	server := &testConditionalServer{}
+	server.set("v1", "", lastModified)
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	fs := afero.NewMemMapFs()
+	url := ts.URL + "/config.yaml"
+
+	a.Equal("v1", DownloadTextWithFallbackP(nil, "/fallback", fs, url, nil, 5*time.Second, 0))
+
+	// 直接修改保存的副本，可以看出 304 时用的是它
+	WriteFileTextP(fs, FallbackFilePath("/fallback", url), "cached")
+	_, err := WriteFallbackMeta("/fallback", fs, &FallbackMetaT{Url: url, LastModified: lastModified.Format(http.TimeFormat), FetchedAt: time.Now()})
+	a.NoError(err)
+
+	a.Equal("cached", DownloadTextWithFallbackP(nil, "/fallback", fs, url, nil, 5*time.Second, 0))
+	a.Equal(lastModified.Format(http.TimeFormat), server.last().Get("If-Modified-Since"))
+	a.Empty(server.last().Get("If-None-Match"))
+}
+
+func TestDownloadBytesWithFallback_offline(t *testing.T) {
+	a := require.New(t)
+
+	server := &testConditionalServer{}
+	server.set("v1", `"v1"`, time.Time{})
+	ts := httptest.NewServer(server)
+
+	fs := afero.NewMemMapFs()
+	url := ts.URL + "/config.yaml"
+
+	a.Equal("v1", DownloadTextWithFallbackP(nil, "/fallback", fs, url, nil, 5*time.Second, 0))
+	ts.Close()
+
+	// 没有副本时下载失败就报错
+	_, err := DownloadBytesWithFallback(nil, "/fallback", fs, url+"?never", nil, 5*time.Second, 0)
+	a.Error(err)
+
+	// 离线时使用副本
+	a.Equal("v1", DownloadTextWithFallbackP(nil, "/fallback", fs, url, nil, 5*time.Second, 0))
+	a.Equal("v1", DownloadTextWithFallbackP(nil, "/fallback", fs, url, nil, 5*time.Second, time.Hour))
+
+	// 副本超过 maxStale 时不再使用
+	_, err = WriteFallbackMeta("/fallback", fs, &FallbackMetaT{Url: url, ETag: `"v1"`, FetchedAt: time.Now().Add(-2 * time.Hour)})
+	a.NoError(err)
+	_, err = DownloadTextWithFallback(nil, "/fallback", fs, url, nil, 5*time.Second, time.Hour)
+	a.Error(err)
+	a.Equal("v1", DownloadTextWithFallbackP(nil, "/fallback", fs, url, nil, 5*time.Second, 3*time.Hour))
+
+	// 没有元数据的旧副本以文件修改时间作为下载时间
+	a.NoError(fs.Remove(FallbackMetaPath("/fallback", url)))
+	a.NoError(fs.Chtimes(FallbackFilePath("/fallback", url), time.Now(), time.Now().Add(-2*time.Hour)))
+	_, err = DownloadTextWithFallback(nil, "/fallback", fs, url, nil, 5*time.Second, time.Hour)
+	a.Error(err)
+	a.Equal("v1", DownloadTextWithFallbackP(nil, "/fallback", fs, url, nil, 5*time.Second, 0))
+}
+
+func TestDownloadBytesWithFallback_local(t *testing.T) {
+	a := require.New(t)
+
+	fs := afero.NewMemMapFs()
+	WriteFileTextP(fs, "/src/config.yaml", "local")
+
+	a.Equal("local", DownloadTextWithFallbackP(nil, "/fallback", fs, "/src/config.yaml", nil, 0, 0))
+	meta, err := ReadFallbackMeta("/fallback", fs, "/src/config.yaml")
+	a.NoError(err)
+	a.Equal("/src/config.yaml", meta.Url)
+	a.False(meta.FetchedAt.IsZero())
+}
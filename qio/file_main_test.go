@@ -1,6 +1,9 @@
 package qio
 
 import (
+	"io"
+	"os"
+	"strings"
 	"testing"
 
 	"github.com/qiangyt/go-comm/v3/qconfig"
@@ -217,3 +220,71 @@ func TestFromYamlP_happy(t *testing.T) {
 	a.Equal("test", result.Name)
 	a.Equal(30, result.Age)
 }
+
+func TestUploadReader(t *testing.T) {
+	a := require.New(t)
+
+	data, err := io.ReadAll(uploadReader(strings.NewReader("hello world"), 5))
+	a.NoError(err)
+	a.Equal("hello", string(data))
+
+	data, err = io.ReadAll(uploadReader(strings.NewReader("hello"), -1))
+	a.NoError(err)
+	a.Equal("hello", string(data))
+
+	_, err = io.ReadAll(uploadReader(strings.NewReader("abc"), 10))
+	a.ErrorContains(err, "7 bytes short")
+}
+
+// testFileStore 检查 File 的上传、查询、列目录和删除，所有文件位于 root 之下。
+// newFile 根据路径创建 File，read 返回服务端保存的内容，文件不存在时返回 false
+func testFileStore(t *testing.T, root string, newFile func(p string) File, read func(p string) (string, bool), listable bool) {
+	a := require.New(t)
+
+	f := newFile(root + "/dir/a.txt")
+	a.NoError(f.Upload(strings.NewReader("hello world"), 5))
+	content, ok := read(root + "/dir/a.txt")
+	a.True(ok)
+	a.Equal("hello", content)
+
+	info, err := f.Stat()
+	a.NoError(err)
+	a.Equal("a.txt", info.Name)
+	a.Equal(root+"/dir", info.Path)
+	a.Equal(int64(5), info.Size)
+	a.False(info.IsDir)
+
+	// 大小未知，覆盖已有的文件
+	f.UploadP(strings.NewReader("hello again"), -1)
+	content, _ = read(root + "/dir/a.txt")
+	a.Equal("hello again", content)
+	a.Equal(int64(11), f.StatP().Size)
+
+	newFile(root+"/dir/sub/b.txt").UploadP(strings.NewReader("b"), 1)
+
+	// 内容不足 size 字节时失败，不留下文件
+	short := newFile(root + "/dir/short.txt")
+	a.Error(short.Upload(strings.NewReader("abc"), 10))
+	_, ok = read(root + "/dir/short.txt")
+	a.False(ok)
+	_, err = short.Stat()
+	a.ErrorIs(err, os.ErrNotExist)
+
+	if listable {
+		names := map[string]bool{}
+		for _, info := range newFile(root + "/dir").ListP() {
+			a.Equal(root+"/dir", info.Path)
+			names[info.Name] = info.IsDir
+		}
+		a.Equal(map[string]bool{"a.txt": false, "sub": true}, names)
+	} else {
+		_, err = newFile(root + "/dir").List()
+		a.Error(err)
+	}
+
+	f.DeleteP()
+	_, ok = read(root + "/dir/a.txt")
+	a.False(ok)
+	_, err = f.Stat()
+	a.ErrorIs(err, os.ErrNotExist)
+}
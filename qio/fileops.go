@@ -303,6 +303,61 @@ func (f FileOps) RemoveFile(filePath string, useSudo bool, sudoPassword string)
 	}
 }
 
+// WriteFileAtomic 原子地写入文件：先写入同目录下的临时文件再重命名，读者不会看到写了一半的文件。
+// 文件已存在时保留其权限，否则使用 perm；useSudo 时经 sudo 复制和重命名，用于写入 /etc/hosts 等系统文件
+func (f FileOps) WriteFileAtomic(filePath string, content []byte, perm os.FileMode, useSudo bool, sudoPassword string) {
+	if info, err := f.fs.Stat(filePath); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	if useSudo && runtime.GOOS != "windows" {
+		f.writeFileAtomicWithSudo(filePath, content, perm, sudoPassword)
+		return
+	}
+
+	options := AtomicWriteOptions{Perm: perm, DirPerm: 0o755}
+	if err := WriteFileAtomic(f.fs, filePath, content, options); err != nil {
+		panic(qerr.NewSystemError(f.localize("FailedToWriteFile", nil), err))
+	}
+}
+
+// writeFileAtomicWithSudo 将内容写入本地临时文件，再用一次 sudo 复制到目标目录并重命名，
+// 只需输入一次密码。sudo 只能操作本地文件系统，与 f.fs 无关；filePath 是符号链接时替换链接指向的文件
+func (f FileOps) writeFileAtomicWithSudo(filePath string, content []byte, perm os.FileMode, sudoPassword string) {
+	filePath = ResolveSymlink(afero.NewOsFs(), filePath)
+
+	src, err := os.CreateTemp("", "."+filepath.Base(filePath)+".src-*")
+	if err != nil {
+		panic(qerr.NewSystemError(f.localize("FailedToWriteFile", nil), err))
+	}
+	defer os.Remove(src.Name())
+
+	_, err = src.Write(content)
+	if closeErr := src.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		panic(qerr.NewSystemError(f.localize("FailedToWriteFile", nil), err))
+	}
+
+	tmpPath := filepath.Join(filepath.Dir(filePath), "."+filepath.Base(filePath)+".tmp-"+strconv.Itoa(os.Getpid()))
+	script := `mkdir -p "$(dirname "$4")" && cp "$1" "$2" && chmod "$3" "$2" && mv -f "$2" "$4" || { rm -f "$2"; exit 1; }`
+	args := []string{"sh", "-c", script, "sh", src.Name(), tmpPath, strconv.FormatUint(uint64(perm), 8), filePath}
+
+	var cmd *exec.Cmd
+	if sudoPassword != "" {
+		cmd = exec.Command("sudo", append([]string{"-S"}, args...)...)
+		cmd.Stdin = strings.NewReader(sudoPassword + "\n")
+	} else {
+		cmd = exec.Command("sudo", args...)
+	}
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		panic(qerr.NewBusinessError(f.localize("FailedToWriteFileSudo", map[string]any{"output": string(output)}), err))
+	}
+}
+
 // GetFs 获取文件系统
 func (f FileOps) GetFs() afero.Fs {
 	return f.fs
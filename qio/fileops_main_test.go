@@ -1,6 +1,8 @@
 package qio
 
 import (
+	"os"
+	"path/filepath"
 	"runtime"
 	"testing"
 
@@ -252,3 +254,79 @@ func TestFileOps_SetLocalizeFunc(t *testing.T) {
 	// Just verify it doesn't panic - the function will be used in other operations
 	_ = a
 }
+
+func TestFileOps_WriteFileAtomic(t *testing.T) {
+	a := require.New(t)
+
+	fs := afero.NewMemMapFs()
+	ops := NewFileOps(fs)
+
+	ops.WriteFileAtomic("/etc/app/config", []byte("v1"), 0o600, false, "")
+	content, err := afero.ReadFile(fs, "/etc/app/config")
+	a.NoError(err)
+	a.Equal("v1", string(content))
+	info, err := fs.Stat("/etc/app/config")
+	a.NoError(err)
+	a.Equal(os.FileMode(0o600), info.Mode().Perm())
+
+	// 已有文件保留原有权限，不留下临时文件
+	a.NoError(fs.Chmod("/etc/app/config", 0o640))
+	ops.WriteFileAtomic("/etc/app/config", []byte("v2"), 0o600, false, "")
+	content, err = afero.ReadFile(fs, "/etc/app/config")
+	a.NoError(err)
+	a.Equal("v2", string(content))
+	info, err = fs.Stat("/etc/app/config")
+	a.NoError(err)
+	a.Equal(os.FileMode(0o640), info.Mode().Perm())
+
+	entries, err := afero.ReadDir(fs, "/etc/app")
+	a.NoError(err)
+	a.Len(entries, 1)
+
+	a.Panics(func() {
+		NewFileOps(afero.NewReadOnlyFs(fs)).WriteFileAtomic("/etc/app/config", []byte("v3"), 0o600, false, "")
+	})
+}
+
+func TestFileOps_WriteFileAtomic_sudo(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("sudo is not available on Windows")
+	}
+	a := require.New(t)
+
+	// 用只执行命令的假 sudo 验证写入流程，记录收到的密码
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "bin")
+	a.NoError(os.Mkdir(bin, 0o755))
+	passwordFile := filepath.Join(dir, "password")
+	fakeSudo := "#!/bin/sh\nif [ \"$1\" = \"-S\" ]; then shift; read pw; echo \"$pw\" > \"" + passwordFile + "\"; fi\nexec \"$@\"\n"
+	a.NoError(os.WriteFile(filepath.Join(bin, "sudo"), []byte(fakeSudo), 0o755))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	target := filepath.Join(dir, "etc", "hosts")
+	ops := NewFileOps(afero.NewOsFs())
+	ops.WriteFileAtomic(target, []byte("127.0.0.1 localhost\n"), 0o644, true, "secret")
+
+	content, err := os.ReadFile(target)
+	a.NoError(err)
+	a.Equal("127.0.0.1 localhost\n", string(content))
+	info, err := os.Stat(target)
+	a.NoError(err)
+	a.Equal(os.FileMode(0o644), info.Mode().Perm())
+	password, err := os.ReadFile(passwordFile)
+	a.NoError(err)
+	a.Equal("secret\n", string(password))
+
+	entries, err := os.ReadDir(filepath.Dir(target))
+	a.NoError(err)
+	a.Len(entries, 1)
+
+	// sudo 失败时 panic
+	a.NoError(os.WriteFile(filepath.Join(bin, "sudo"), []byte("#!/bin/sh\necho denied\nexit 1\n"), 0o755))
+	defer func() {
+		r := recover()
+		a.NotNil(r)
+		a.Contains(r.(error).Error(), "FailedToWriteFileSudo")
+	}()
+	ops.WriteFileAtomic(target, []byte("x"), 0o644, true, "")
+}
@@ -0,0 +1,479 @@
+package qio
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/qiangyt/go-comm/v3/qerr"
+	"github.com/qiangyt/go-comm/v3/qlang"
+	"github.com/spf13/afero"
+)
+
+// 受管理段的起止标记，如 "# BEGIN my-tool" 和 "# END my-tool"
+const (
+	HOSTS_BLOCK_BEGIN = "# BEGIN "
+	HOSTS_BLOCK_END   = "# END "
+)
+
+// ============================================================
+// HostsEntry hosts 文件中的一条映射
+// ============================================================
+
+// HostsEntryT 一个 IP 和它的主机名，第一个主机名是规范名，其余是别名
+type HostsEntryT struct {
+	IP    string
+	Names []string
+	// Comment 行尾注释，不含 '#'
+	Comment string
+}
+
+type HostsEntry = *HostsEntryT
+
+// NewHostsEntry 创建映射并校验 IP 和主机名
+func NewHostsEntry(ip string, names ...string) (HostsEntry, error) {
+	r := &HostsEntryT{IP: ip, Names: names}
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func NewHostsEntryP(ip string, names ...string) HostsEntry {
+	r, err := NewHostsEntry(ip, names...)
+	if err != nil {
+		panic(qerr.NewSystemError(err.Error(), err))
+	}
+	return r
+}
+
+// Validate 检查 IP 是否合法、至少有一个主机名且主机名不含空白和 '#'
+func (me HostsEntry) Validate() error {
+	if parseHostsIP(me.IP) == nil {
+		return errors.Errorf("invalid IP in hosts entry: '%s'", me.IP)
+	}
+	if len(me.Names) == 0 {
+		return errors.Errorf("no host name for %s", me.IP)
+	}
+	for _, name := range me.Names {
+		if len(name) == 0 || strings.ContainsAny(name, " \t\r\n#") {
+			return errors.Errorf("invalid host name for %s: '%s'", me.IP, name)
+		}
+	}
+	return nil
+}
+
+func (me HostsEntry) clone() HostsEntry {
+	r := *me
+	r.Names = append([]string{}, me.Names...)
+	return &r
+}
+
+// Hostname 返回规范名
+func (me HostsEntry) Hostname() string {
+	return me.Names[0]
+}
+
+// Aliases 返回别名
+func (me HostsEntry) Aliases() []string {
+	return me.Names[1:]
+}
+
+// HasName 是否包含该主机名，不区分大小写
+func (me HostsEntry) HasName(name string) bool {
+	for _, n := range me.Names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasIP 是否是该 IP，"::1" 与 "0:0::1" 视为相同
+func (me HostsEntry) HasIP(ip string) bool {
+	a, b := parseHostsIP(me.IP), parseHostsIP(ip)
+	return a != nil && a.Equal(b)
+}
+
+// String 返回 hosts 文件中的一行，如 "127.0.0.1\tlocalhost localhost.localdomain # loopback"
+func (me HostsEntry) String() string {
+	r := me.IP + "\t" + strings.Join(me.Names, " ")
+	if len(me.Comment) > 0 {
+		r += " # " + me.Comment
+	}
+	return r
+}
+
+// parseHostsIP 解析 IP，允许 IPv6 的 zone，如 "fe80::1%lo0"
+func parseHostsIP(s string) net.IP {
+	if i := strings.IndexByte(s, '%'); i > 0 && strings.Contains(s, ":") {
+		s = s[:i]
+	}
+	return net.ParseIP(s)
+}
+
+// parseHostsEntry 解析一行，注释、空行和无效行返回 nil
+func parseHostsEntry(text string) HostsEntry {
+	body, comment, _ := strings.Cut(text, "#")
+	fields := strings.Fields(body)
+	if len(fields) < 2 {
+		return nil
+	}
+
+	r := &HostsEntryT{IP: fields[0], Names: fields[1:], Comment: strings.TrimSpace(comment)}
+	if r.Validate() != nil {
+		return nil
+	}
+	return r
+}
+
+// ============================================================
+// HostsFile hosts 文件
+// ============================================================
+
+// hostsLine 文件中的一行。未修改的行按原文输出，entry 被替换的行重新格式化
+type hostsLine struct {
+	text  string
+	entry HostsEntry
+	// block 所在受管理段的名称，段的标记行也属于该段
+	block string
+}
+
+// HostsFileT hosts 文件的模型，保留注释、空行、原有格式和换行符，只重写修改过的行。
+// 受管理段由 "# BEGIN name" 和 "# END name" 包围，由 SetBlock 整体替换，
+// Add、RemoveName 和 RemoveIP 不会修改受管理段中的映射
+type HostsFileT struct {
+	fs   afero.Fs
+	path string
+
+	lines   []*hostsLine
+	newline string
+	// eol 文件是否以换行结尾
+	eol bool
+}
+
+type HostsFile = *HostsFileT
+
+func LoadHostsFileP(fs afero.Fs, path string) HostsFile {
+	r, err := LoadHostsFile(fs, path)
+	if err != nil {
+		panic(qerr.NewSystemError(err.Error(), err))
+	}
+	return r
+}
+
+// LoadHostsFile 读取 hosts 文件，path 为空时使用 DefaultEtcHosts()，文件不存在时返回空模型
+func LoadHostsFile(fs afero.Fs, path string) (HostsFile, error) {
+	if len(path) == 0 {
+		var err error
+		if path, err = DefaultEtcHosts(); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := afero.ReadFile(fs, path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrapf(err, "read hosts file: %s", path)
+	}
+	return ParseHostsFile(fs, path, data), nil
+}
+
+// ParseHostsFile 解析 hosts 文件内容，无法解析的行原样保留
+func ParseHostsFile(fs afero.Fs, path string, data []byte) HostsFile {
+	r := &HostsFileT{fs: fs, path: path, newline: "\n", eol: true}
+	if bytes.Contains(data, []byte("\r\n")) {
+		r.newline = "\r\n"
+	}
+	if len(data) == 0 {
+		return r
+	}
+
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	r.eol = strings.HasSuffix(text, "\n")
+	block, blockBegin := "", 0
+	for _, t := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		line := &hostsLine{text: t}
+		trimmed := strings.TrimSpace(t)
+
+		if name, ok := strings.CutPrefix(trimmed, HOSTS_BLOCK_BEGIN); ok && len(block) == 0 && len(strings.TrimSpace(name)) > 0 {
+			block, blockBegin = strings.TrimSpace(name), len(r.lines)
+			line.block = block
+		} else if name, ok := strings.CutPrefix(trimmed, HOSTS_BLOCK_END); ok && strings.TrimSpace(name) == block {
+			line.block = block
+			block = ""
+		} else {
+			line.block = block
+			line.entry = parseHostsEntry(t)
+		}
+		r.lines = append(r.lines, line)
+	}
+
+	// 没有结束标记的段不是受管理段
+	if len(block) > 0 {
+		for _, line := range r.lines[blockBegin:] {
+			line.block = ""
+		}
+	}
+	return r
+}
+
+// Path 返回文件路径
+func (me HostsFile) Path() string {
+	return me.path
+}
+
+// Bytes 返回文件内容
+func (me HostsFile) Bytes() []byte {
+	var r bytes.Buffer
+	for i, line := range me.lines {
+		r.WriteString(line.text)
+		if i+1 < len(me.lines) || me.eol {
+			r.WriteString(me.newline)
+		}
+	}
+	return r.Bytes()
+}
+
+func (me HostsFile) String() string {
+	return string(me.Bytes())
+}
+
+// Entries 返回所有映射的副本，包括受管理段中的映射
+func (me HostsFile) Entries() []HostsEntry {
+	r := []HostsEntry{}
+	for _, line := range me.lines {
+		if line.entry != nil {
+			r = append(r, line.entry.clone())
+		}
+	}
+	return r
+}
+
+// LookupName 返回包含该主机名的映射，不区分大小写
+func (me HostsFile) LookupName(name string) []HostsEntry {
+	r := []HostsEntry{}
+	for _, entry := range me.Entries() {
+		if entry.HasName(name) {
+			r = append(r, entry)
+		}
+	}
+	return r
+}
+
+// LookupIP 返回该 IP 的映射
+func (me HostsFile) LookupIP(ip string) []HostsEntry {
+	r := []HostsEntry{}
+	for _, entry := range me.Entries() {
+		if entry.HasIP(ip) {
+			r = append(r, entry)
+		}
+	}
+	return r
+}
+
+// Resolve 返回主机名对应的 IP，按文件中的顺序
+func (me HostsFile) Resolve(name string) []string {
+	r := []string{}
+	for _, entry := range me.LookupName(name) {
+		r = append(r, entry.IP)
+	}
+	return r
+}
+
+// Add 在受管理段之外添加一条映射，位于最后一条非受管理映射之后
+func (me HostsFile) Add(ip string, names ...string) (HostsEntry, error) {
+	entry, err := NewHostsEntry(ip, names...)
+	if err != nil {
+		return nil, err
+	}
+
+	at := -1
+	for i := len(me.lines) - 1; i >= 0 && at < 0; i-- {
+		if me.lines[i].entry != nil && len(me.lines[i].block) == 0 {
+			at = i + 1
+		}
+	}
+	if at < 0 {
+		// 没有非受管理映射时放在第一个受管理段之前
+		at = len(me.lines)
+		for i, line := range me.lines {
+			if len(line.block) > 0 {
+				at = i
+				break
+			}
+		}
+	}
+
+	me.lines = append(me.lines[:at], append([]*hostsLine{{text: entry.String(), entry: entry}}, me.lines[at:]...)...)
+	return entry.clone(), nil
+}
+
+// RemoveName 从受管理段之外的映射中删除主机名，没有剩余主机名的映射整行删除，返回修改的映射数
+func (me HostsFile) RemoveName(name string) int {
+	n := 0
+	lines := me.lines[:0]
+	for _, line := range me.lines {
+		if line.entry != nil && len(line.block) == 0 && line.entry.HasName(name) {
+			n++
+			names := []string{}
+			for _, existing := range line.entry.Names {
+				if !strings.EqualFold(existing, name) {
+					names = append(names, existing)
+				}
+			}
+			if len(names) == 0 {
+				continue
+			}
+			line.entry = &HostsEntryT{IP: line.entry.IP, Names: names, Comment: line.entry.Comment}
+			line.text = line.entry.String()
+		}
+		lines = append(lines, line)
+	}
+	me.lines = lines
+	return n
+}
+
+// RemoveIP 删除受管理段之外该 IP 的映射，返回删除的行数
+func (me HostsFile) RemoveIP(ip string) int {
+	n := 0
+	lines := me.lines[:0]
+	for _, line := range me.lines {
+		if line.entry != nil && len(line.block) == 0 && line.entry.HasIP(ip) {
+			n++
+			continue
+		}
+		lines = append(lines, line)
+	}
+	me.lines = lines
+	return n
+}
+
+// ============================================================
+// 受管理段
+// ============================================================
+
+// Blocks 返回受管理段的名称
+func (me HostsFile) Blocks() []string {
+	r := []string{}
+	for _, line := range me.lines {
+		if len(line.block) > 0 && (len(r) == 0 || r[len(r)-1] != line.block) {
+			r = append(r, line.block)
+		}
+	}
+	return r
+}
+
+// Block 返回受管理段中的映射，段不存在或名称无效时返回 false
+func (me HostsFile) Block(name string) ([]HostsEntry, bool) {
+	begin, _ := me.blockRange(name)
+	if begin < 0 {
+		return nil, false
+	}
+
+	r := []HostsEntry{}
+	for _, line := range me.lines {
+		if line.block == name && line.entry != nil {
+			r = append(r, line.entry.clone())
+		}
+	}
+	return r, true
+}
+
+// SetBlock 用 entries 替换受管理段的内容，段不存在时添加到文件末尾
+func (me HostsFile) SetBlock(name string, entries ...HostsEntry) error {
+	if err := validateHostsBlockName(name); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := entry.Validate(); err != nil {
+			return err
+		}
+	}
+
+	block := []*hostsLine{{text: HOSTS_BLOCK_BEGIN + name, block: name}}
+	for _, entry := range entries {
+		block = append(block, &hostsLine{text: entry.String(), entry: entry.clone(), block: name})
+	}
+	block = append(block, &hostsLine{text: HOSTS_BLOCK_END + name, block: name})
+
+	begin, end := me.blockRange(name)
+	if begin < 0 {
+		if n := len(me.lines); n > 0 && len(strings.TrimSpace(me.lines[n-1].text)) > 0 {
+			block = append([]*hostsLine{{}}, block...)
+		}
+		me.lines = append(me.lines, block...)
+		me.eol = true
+		return nil
+	}
+
+	me.lines = append(me.lines[:begin], append(block, me.lines[end:]...)...)
+	return nil
+}
+
+func (me HostsFile) SetBlockP(name string, entries ...HostsEntry) {
+	if err := me.SetBlock(name, entries...); err != nil {
+		panic(qerr.NewSystemError(err.Error(), err))
+	}
+}
+
+// RemoveBlock 删除受管理段及其标记行，段不存在或名称无效时返回 false
+func (me HostsFile) RemoveBlock(name string) bool {
+	begin, end := me.blockRange(name)
+	if begin < 0 {
+		return false
+	}
+
+	// 段在文件末尾时同时删除 SetBlock 添加在段前的空行
+	if end == len(me.lines) && begin > 0 && len(strings.TrimSpace(me.lines[begin-1].text)) == 0 {
+		begin--
+	}
+	me.lines = append(me.lines[:begin], me.lines[end:]...)
+	return true
+}
+
+// blockRange 返回受管理段所在行的范围 [begin, end)，段不存在时 begin 为 -1。
+// 受管理段之外的行的段名为空，名称无效时也返回 -1，避免把它们当作一个段
+func (me HostsFile) blockRange(name string) (int, int) {
+	begin := -1
+	if validateHostsBlockName(name) != nil {
+		return begin, len(me.lines)
+	}
+	for i, line := range me.lines {
+		if line.block != name {
+			if begin >= 0 {
+				return begin, i
+			}
+			continue
+		}
+		if begin < 0 {
+			begin = i
+		}
+	}
+	return begin, len(me.lines)
+}
+
+// validateHostsBlockName 段名不能为空，不能以空白开头或结尾，也不能跨行
+func validateHostsBlockName(name string) error {
+	if len(strings.TrimSpace(name)) == 0 || name != strings.TrimSpace(name) || strings.ContainsAny(name, "\r\n") {
+		return errors.Errorf("invalid hosts block name: '%s'", name)
+	}
+	return nil
+}
+
+// ============================================================
+// 保存
+// ============================================================
+
+// Save 原子地写回文件，保留原有权限（新文件为 0644）；useSudo 时经 FileOps 的 sudo 路径写入
+func (me HostsFile) Save(useSudo bool, sudoPassword string) (err error) {
+	defer func() { err = qlang.RecoverAsError(recover()) }()
+
+	me.SaveP(useSudo, sudoPassword)
+	return nil
+}
+
+func (me HostsFile) SaveP(useSudo bool, sudoPassword string) {
+	NewFileOps(me.fs).WriteFileAtomic(me.path, me.Bytes(), 0o644, useSudo, sudoPassword)
+}
@@ -0,0 +1,190 @@
+package qio
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+const testHostsContent = `# /etc/hosts
+127.0.0.1	localhost localhost.localdomain # loopback
+::1     localhost ip6-localhost
+
+# lan
+192.168.1.10  nas  nas.lan
+192.168.1.11  printer
+fe80::1%lo0   link-local
+not-an-entry
+
+# BEGIN dev-tool
+10.0.0.5	api.dev
+10.0.0.6	web.dev www.dev
+# END dev-tool
+`
+
+func TestParseHostsFile_roundTrip(t *testing.T) {
+	a := require.New(t)
+
+	for _, content := range []string{
+		testHostsContent,
+		strings.ReplaceAll(testHostsContent, "\n", "\r\n"),
+		strings.TrimSuffix(testHostsContent, "\n"),
+		"",
+		"# BEGIN unterminated\n10.0.0.1 a\n",
+	} {
+		hosts := ParseHostsFile(afero.NewMemMapFs(), "/etc/hosts", []byte(content))
+		a.Equal(content, hosts.String())
+	}
+}
+
+func TestHostsFile_lookup(t *testing.T) {
+	a := require.New(t)
+
+	hosts := ParseHostsFile(afero.NewMemMapFs(), "/etc/hosts", []byte(testHostsContent))
+	a.Len(hosts.Entries(), 7)
+
+	entries := hosts.LookupName("LOCALHOST")
+	a.Len(entries, 2)
+	a.Equal("127.0.0.1", entries[0].IP)
+	a.Equal("localhost", entries[0].Hostname())
+	a.Equal([]string{"localhost.localdomain"}, entries[0].Aliases())
+	a.Equal("loopback", entries[0].Comment)
+	a.Equal([]string{"127.0.0.1", "::1"}, hosts.Resolve("localhost"))
+
+	a.Equal([]string{"10.0.0.6"}, hosts.Resolve("www.dev"))
+	a.Equal([]string{"fe80::1%lo0"}, hosts.Resolve("link-local"))
+	a.Empty(hosts.Resolve("not-an-entry"))
+
+	entries = hosts.LookupIP("0:0::1")
+	a.Len(entries, 1)
+	a.Equal([]string{"localhost", "ip6-localhost"}, entries[0].Names)
+	a.Empty(hosts.LookupIP("10.9.9.9"))
+
+	// 返回的是副本
+	entries[0].Names[0] = "changed"
+	a.Len(hosts.LookupName("localhost"), 2)
+}
+
+func TestHostsFile_edit(t *testing.T) {
+	a := require.New(t)
+
+	hosts := ParseHostsFile(afero.NewMemMapFs(), "/etc/hosts", []byte(testHostsContent))
+
+	_, err := hosts.Add("192.168.1.12", "camera", "cam")
+	a.NoError(err)
+	a.Equal(2, hosts.RemoveName("nas.lan")+hosts.RemoveName("printer"))
+	a.Equal(1, hosts.RemoveIP("fe80::1"))
+	// 受管理段中的映射不受影响
+	a.Equal(0, hosts.RemoveName("api.dev"))
+
+	_, err = hosts.Add("999.1.1.1", "bad")
+	a.Error(err)
+	_, err = hosts.Add("10.0.0.1")
+	a.Error(err)
+	_, err = hosts.Add("10.0.0.1", "bad#name")
+	a.Error(err)
+
+	a.Equal(`# /etc/hosts
+127.0.0.1	localhost localhost.localdomain # loopback
+::1     localhost ip6-localhost
+
+# lan
+192.168.1.10	nas
+192.168.1.12	camera cam
+not-an-entry
+
+# BEGIN dev-tool
+10.0.0.5	api.dev
+10.0.0.6	web.dev www.dev
+# END dev-tool
+`, hosts.String())
+}
+
+func TestHostsFile_blocks(t *testing.T) {
+	a := require.New(t)
+
+	hosts := ParseHostsFile(afero.NewMemMapFs(), "/etc/hosts", []byte(testHostsContent))
+	a.Equal([]string{"dev-tool"}, hosts.Blocks())
+
+	entries, ok := hosts.Block("dev-tool")
+	a.True(ok)
+	a.Len(entries, 2)
+	_, ok = hosts.Block("other")
+	a.False(ok)
+
+	// 替换已有的段
+	a.NoError(hosts.SetBlock("dev-tool", NewHostsEntryP("10.0.0.7", "db.dev")))
+	a.True(strings.HasSuffix(hosts.String(), "# BEGIN dev-tool\n10.0.0.7\tdb.dev\n# END dev-tool\n"))
+	a.Empty(hosts.Resolve("api.dev"))
+
+	// 新的段添加到末尾，删除后恢复原样
+	before := hosts.String()
+	a.NoError(hosts.SetBlock("vpn", NewHostsEntryP("172.16.0.1", "gateway.vpn")))
+	a.Equal(before+"\n# BEGIN vpn\n172.16.0.1\tgateway.vpn\n# END vpn\n", hosts.String())
+	a.Equal([]string{"dev-tool", "vpn"}, hosts.Blocks())
+	a.True(hosts.RemoveBlock("vpn"))
+	a.False(hosts.RemoveBlock("vpn"))
+	a.Equal(before, hosts.String())
+
+	// 空段保留标记
+	a.NoError(hosts.SetBlock("dev-tool"))
+	entries, ok = hosts.Block("dev-tool")
+	a.True(ok)
+	a.Empty(entries)
+
+	a.Error(hosts.SetBlock(""))
+	a.Error(hosts.SetBlock("x", &HostsEntryT{IP: "10.0.0.1"}))
+
+	// 受管理段之外的行不是段，空白名称被拒绝
+	hosts = ParseHostsFile(afero.NewMemMapFs(), "/etc/hosts", []byte(testHostsContent))
+	for _, name := range []string{"", " ", "dev-tool "} {
+		_, ok = hosts.Block(name)
+		a.False(ok, name)
+		a.False(hosts.RemoveBlock(name), name)
+	}
+	a.Equal(testHostsContent, hosts.String())
+
+	// 没有非受管理映射时，Add 放在受管理段之前
+	hosts = ParseHostsFile(afero.NewMemMapFs(), "/etc/hosts", []byte("# BEGIN a\n10.0.0.1 x\n# END a\n"))
+	_, err := hosts.Add("10.0.0.2", "y")
+	a.NoError(err)
+	a.Equal("10.0.0.2\ty\n# BEGIN a\n10.0.0.1 x\n# END a\n", hosts.String())
+}
+
+func TestHostsFile_Save(t *testing.T) {
+	a := require.New(t)
+
+	fs := afero.NewMemMapFs()
+	hosts, err := LoadHostsFile(fs, "/etc/hosts")
+	a.NoError(err)
+	a.Empty(hosts.Entries())
+
+	a.NoError(hosts.SetBlock("dev-tool", NewHostsEntryP("10.0.0.5", "api.dev")))
+	a.NoError(hosts.Save(false, ""))
+
+	info, err := fs.Stat("/etc/hosts")
+	a.NoError(err)
+	a.Equal(os.FileMode(0o644), info.Mode().Perm())
+
+	reloaded := LoadHostsFileP(fs, "/etc/hosts")
+	a.Equal([]string{"10.0.0.5"}, reloaded.Resolve("api.dev"))
+	a.Equal("# BEGIN dev-tool\n10.0.0.5\tapi.dev\n# END dev-tool\n", reloaded.String())
+
+	// CRLF 文件保持 CRLF
+	a.NoError(afero.WriteFile(fs, "/win/hosts", []byte("127.0.0.1 localhost\r\n"), 0o600))
+	hosts = LoadHostsFileP(fs, "/win/hosts")
+	_, err = hosts.Add("10.0.0.1", "dev")
+	a.NoError(err)
+	a.NoError(hosts.Save(false, ""))
+	content, err := afero.ReadFile(fs, "/win/hosts")
+	a.NoError(err)
+	a.Equal("127.0.0.1 localhost\r\n10.0.0.1\tdev\r\n", string(content))
+	info, err = fs.Stat("/win/hosts")
+	a.NoError(err)
+	a.Equal(os.FileMode(0o600), info.Mode().Perm())
+
+	a.Error(LoadHostsFileP(afero.NewReadOnlyFs(fs), "/win/hosts").Save(false, ""))
+}
@@ -5,6 +5,7 @@
 package qio
 
 import (
+	io "io"
 	url "net/url"
 	reflect "reflect"
 	time "time"
@@ -50,6 +51,32 @@ func (mr *MockFileMockRecorder) Credentials() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Credentials", reflect.TypeOf((*MockFile)(nil).Credentials))
 }
 
+// Delete mocks base method.
+func (m *MockFile) Delete() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockFileMockRecorder) Delete() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockFile)(nil).Delete))
+}
+
+// DeleteP mocks base method.
+func (m *MockFile) DeleteP() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "DeleteP")
+}
+
+// DeleteP indicates an expected call of DeleteP.
+func (mr *MockFileMockRecorder) DeleteP() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteP", reflect.TypeOf((*MockFile)(nil).DeleteP))
+}
+
 // Dir mocks base method.
 func (m *MockFile) Dir() string {
 	m.ctrl.T.Helper()
@@ -93,6 +120,35 @@ func (mr *MockFileMockRecorder) DownloadP() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DownloadP", reflect.TypeOf((*MockFile)(nil).DownloadP))
 }
 
+// List mocks base method.
+func (m *MockFile) List() ([]*models.RemoteFile, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List")
+	ret0, _ := ret[0].([]*models.RemoteFile)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List.
+func (mr *MockFileMockRecorder) List() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockFile)(nil).List))
+}
+
+// ListP mocks base method.
+func (m *MockFile) ListP() []*models.RemoteFile {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListP")
+	ret0, _ := ret[0].([]*models.RemoteFile)
+	return ret0
+}
+
+// ListP indicates an expected call of ListP.
+func (mr *MockFileMockRecorder) ListP() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListP", reflect.TypeOf((*MockFile)(nil).ListP))
+}
+
 // Name mocks base method.
 func (m *MockFile) Name() string {
 	m.ctrl.T.Helper()
@@ -121,6 +177,35 @@ func (mr *MockFileMockRecorder) Protocol() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Protocol", reflect.TypeOf((*MockFile)(nil).Protocol))
 }
 
+// Stat mocks base method.
+func (m *MockFile) Stat() (*models.RemoteFile, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Stat")
+	ret0, _ := ret[0].(*models.RemoteFile)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Stat indicates an expected call of Stat.
+func (mr *MockFileMockRecorder) Stat() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stat", reflect.TypeOf((*MockFile)(nil).Stat))
+}
+
+// StatP mocks base method.
+func (m *MockFile) StatP() *models.RemoteFile {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StatP")
+	ret0, _ := ret[0].(*models.RemoteFile)
+	return ret0
+}
+
+// StatP indicates an expected call of StatP.
+func (mr *MockFileMockRecorder) StatP() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StatP", reflect.TypeOf((*MockFile)(nil).StatP))
+}
+
 // Timeout mocks base method.
 func (m *MockFile) Timeout() time.Duration {
 	m.ctrl.T.Helper()
@@ -149,6 +234,32 @@ func (mr *MockFileMockRecorder) URL() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "URL", reflect.TypeOf((*MockFile)(nil).URL))
 }
 
+// Upload mocks base method.
+func (m *MockFile) Upload(arg0 io.Reader, arg1 int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Upload", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Upload indicates an expected call of Upload.
+func (mr *MockFileMockRecorder) Upload(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Upload", reflect.TypeOf((*MockFile)(nil).Upload), arg0, arg1)
+}
+
+// UploadP mocks base method.
+func (m *MockFile) UploadP(arg0 io.Reader, arg1 int64) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "UploadP", arg0, arg1)
+}
+
+// UploadP indicates an expected call of UploadP.
+func (mr *MockFileMockRecorder) UploadP(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UploadP", reflect.TypeOf((*MockFile)(nil).UploadP), arg0, arg1)
+}
+
 // Url mocks base method.
 func (m *MockFile) Url() string {
 	m.ctrl.T.Helper()
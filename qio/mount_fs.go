@@ -0,0 +1,281 @@
+package qio
+
+import (
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/qiangyt/go-comm/v3/qerr"
+	"github.com/spf13/afero"
+)
+
+// MountFsT 按 URL 前缀把文件名路由到挂载的 afero.Fs，使 ReadFileText、ListSuffixedFiles 等函数可以直接使用 URL。
+// 挂载点形如 "https://example.com/files" 或 "s3://endpoint/bucket"，按最长的挂载点匹配，
+// 交给挂载的 Fs 的是挂载点之后的路径。没有协议的路径和 file:// 路径交给本地 Fs。
+// filepath.Join 等函数会把 "://" 规整为 ":/"，两种写法都可以识别
+type MountFsT struct {
+	local afero.Fs
+
+	mutex  sync.RWMutex
+	mounts []*mountT // 按前缀长度从长到短排列
+}
+
+type MountFs = *MountFsT
+
+type mountT struct {
+	prefix string
+	fs     afero.Fs
+}
+
+var _ afero.Fs = (*MountFsT)(nil)
+
+// 协议至少两个字符，以免把 Windows 的盘符当作协议
+var _urlNamePattern = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9+.\-]+):/+([^/]*)(.*)$`)
+
+func NewMountFs(local afero.Fs) MountFs {
+	return &MountFsT{local: local}
+}
+
+// splitUrlName 把带协议的文件名规整为 scheme://host/path，协议转为小写。
+// 不带协议时 ok 为 false
+func splitUrlName(name string) (scheme string, r string, ok bool) {
+	m := _urlNamePattern.FindStringSubmatch(strings.ReplaceAll(name, "\\", "/"))
+	if m == nil {
+		return "", "", false
+	}
+	scheme = strings.ToLower(m[1])
+	return scheme, scheme + "://" + m[2] + path.Clean("/"+m[3]), true
+}
+
+func (me MountFs) Name() string {
+	return "MountFs"
+}
+
+func (me MountFs) Local() afero.Fs {
+	return me.local
+}
+
+func (me MountFs) MountP(prefix string, fs afero.Fs) {
+	if err := me.Mount(prefix, fs); err != nil {
+		panic(qerr.NewSystemError(err.Error(), err))
+	}
+}
+
+// Mount 把 fs 挂载到 prefix，已有的同名挂载点被替换
+func (me MountFs) Mount(prefix string, fs afero.Fs) error {
+	scheme, normalized, ok := splitUrlName(prefix)
+	if !ok || scheme == "file" {
+		return errors.Errorf("mount point must be a remote url: %s", prefix)
+	}
+	normalized = strings.TrimSuffix(normalized, "/")
+
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+
+	mounts := []*mountT{{prefix: normalized, fs: fs}}
+	for _, m := range me.mounts {
+		if m.prefix != normalized {
+			mounts = append(mounts, m)
+		}
+	}
+	sort.SliceStable(mounts, func(i, j int) bool {
+		return len(mounts[i].prefix) > len(mounts[j].prefix)
+	})
+	me.mounts = mounts
+	return nil
+}
+
+func (me MountFs) MountUrlP(baseUrl string, credentials Credentials, timeout time.Duration) UrlFs {
+	r, err := me.MountUrl(baseUrl, credentials, timeout)
+	if err != nil {
+		panic(qerr.NewSystemError(err.Error(), err))
+	}
+	return r
+}
+
+// MountUrl 创建以 baseUrl 为根的 UrlFs 并挂载到 baseUrl（不含查询参数）
+func (me MountFs) MountUrl(baseUrl string, credentials Credentials, timeout time.Duration) (UrlFs, error) {
+	r, err := NewUrlFs(baseUrl, credentials, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix, _, _ := strings.Cut(baseUrl, "?")
+	if err := me.Mount(prefix, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Unmount 删除挂载点，返回是否找到
+func (me MountFs) Unmount(prefix string) bool {
+	_, normalized, _ := splitUrlName(prefix)
+	normalized = strings.TrimSuffix(normalized, "/")
+
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+
+	for i, m := range me.mounts {
+		if m.prefix == normalized {
+			me.mounts = append(me.mounts[:i:i], me.mounts[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// route 返回 name 所在的挂载点（本地文件为 nil）和交给该 Fs 的路径
+func (me MountFs) route(op string, name string) (*mountT, afero.Fs, string, error) {
+	scheme, normalized, ok := splitUrlName(name)
+	if !ok {
+		return nil, me.local, name, nil
+	}
+	if scheme == "file" {
+		if IsFileProtocol(name) {
+			return nil, me.local, name[len(FILE):], nil
+		}
+		return nil, me.local, name[len("file:"):], nil
+	}
+
+	me.mutex.RLock()
+	defer me.mutex.RUnlock()
+
+	for _, m := range me.mounts {
+		if normalized == m.prefix {
+			return m, m.fs, "/", nil
+		}
+		if strings.HasPrefix(normalized, m.prefix+"/") {
+			return m, m.fs, normalized[len(m.prefix):], nil
+		}
+	}
+	return nil, nil, "", &os.PathError{Op: op, Path: name, Err: errors.New("no file system mounted for the url")}
+}
+
+// wrap 让挂载的 Fs 打开的文件的 Name() 返回调用方使用的文件名
+func (me MountFs) wrap(m *mountT, name string, f afero.File, err error) (afero.File, error) {
+	if err != nil || m == nil {
+		return f, err
+	}
+	return &mountFileT{File: f, name: name}, nil
+}
+
+func (me MountFs) Create(name string) (afero.File, error) {
+	m, fs, p, err := me.route("open", name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := fs.Create(p)
+	return me.wrap(m, name, f, err)
+}
+
+func (me MountFs) Open(name string) (afero.File, error) {
+	m, fs, p, err := me.route("open", name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := fs.Open(p)
+	return me.wrap(m, name, f, err)
+}
+
+func (me MountFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	m, fs, p, err := me.route("open", name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := fs.OpenFile(p, flag, perm)
+	return me.wrap(m, name, f, err)
+}
+
+func (me MountFs) Mkdir(name string, perm os.FileMode) error {
+	_, fs, p, err := me.route("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return fs.Mkdir(p, perm)
+}
+
+func (me MountFs) MkdirAll(name string, perm os.FileMode) error {
+	_, fs, p, err := me.route("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return fs.MkdirAll(p, perm)
+}
+
+func (me MountFs) Remove(name string) error {
+	_, fs, p, err := me.route("remove", name)
+	if err != nil {
+		return err
+	}
+	return fs.Remove(p)
+}
+
+func (me MountFs) RemoveAll(name string) error {
+	_, fs, p, err := me.route("removeall", name)
+	if err != nil {
+		return err
+	}
+	return fs.RemoveAll(p)
+}
+
+// Rename 只能在同一个挂载点内改名
+func (me MountFs) Rename(oldname, newname string) error {
+	oldMount, fs, oldPath, err := me.route("rename", oldname)
+	if err != nil {
+		return err
+	}
+	newMount, _, newPath, err := me.route("rename", newname)
+	if err != nil {
+		return err
+	}
+	if oldMount != newMount {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: errors.New("cannot rename across mount points")}
+	}
+	return fs.Rename(oldPath, newPath)
+}
+
+func (me MountFs) Stat(name string) (os.FileInfo, error) {
+	_, fs, p, err := me.route("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Stat(p)
+}
+
+func (me MountFs) Chmod(name string, mode os.FileMode) error {
+	_, fs, p, err := me.route("chmod", name)
+	if err != nil {
+		return err
+	}
+	return fs.Chmod(p, mode)
+}
+
+func (me MountFs) Chown(name string, uid, gid int) error {
+	_, fs, p, err := me.route("chown", name)
+	if err != nil {
+		return err
+	}
+	return fs.Chown(p, uid, gid)
+}
+
+func (me MountFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	_, fs, p, err := me.route("chtimes", name)
+	if err != nil {
+		return err
+	}
+	return fs.Chtimes(p, atime, mtime)
+}
+
+type mountFileT struct {
+	afero.File
+	name string
+}
+
+func (me *mountFileT) Name() string {
+	return me.name
+}
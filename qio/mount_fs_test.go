@@ -0,0 +1,81 @@
+package qio
+
+import (
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitUrlName(t *testing.T) {
+	a := require.New(t)
+
+	for name, expected := range map[string]string{
+		"https://example.com/a/b.txt": "https://example.com/a/b.txt",
+		"HTTPS://example.com/a/../b":  "https://example.com/b",
+		"https:/example.com/a/b.txt":  "https://example.com/a/b.txt",
+		`s3:\endpoint\bucket\key`:     "s3://endpoint/bucket/key",
+		"sftp://u@host:2222":          "sftp://u@host:2222/",
+		"ftp://host//dir//":           "ftp://host/dir",
+		"git+ssh://host/repo":         "git+ssh://host/repo",
+	} {
+		_, r, ok := splitUrlName(name)
+		a.True(ok, name)
+		a.Equal(expected, r, name)
+	}
+
+	for _, name := range []string{"/etc/hosts", "relative/file.txt", `C:\Windows`, "C:/Windows", ""} {
+		_, _, ok := splitUrlName(name)
+		a.False(ok, name)
+	}
+}
+
+func TestMountFs_route(t *testing.T) {
+	a := require.New(t)
+
+	local := afero.NewMemMapFs()
+	outer := afero.NewMemMapFs()
+	inner := afero.NewMemMapFs()
+
+	fs := NewMountFs(local)
+	fs.MountP("https://example.com/x", outer)
+	fs.MountP("https://example.com/x/y/", inner)
+	a.Error(fs.Mount("/local", outer))
+	a.Error(fs.Mount("file:///local", outer))
+
+	// 按最长的挂载点匹配，交给挂载的 Fs 的是挂载点之后的路径
+	WriteFileTextP(fs, "https://example.com/x/a.txt", "outer")
+	WriteFileTextP(fs, "https://example.com/x/y/a.txt", "inner")
+	WriteFileTextP(fs, "/a.txt", "local")
+	a.Equal("outer", ReadFileTextP(outer, "/a.txt"))
+	a.Equal("inner", ReadFileTextP(inner, "/a.txt"))
+	a.Equal("local", ReadFileTextP(local, "/a.txt"))
+	a.Equal("local", ReadFileTextP(fs, "file:///a.txt"))
+	a.Equal("local", ReadFileTextP(fs, "file:/a.txt"))
+
+	// 挂载点本身是挂载的 Fs 的根目录
+	a.True(DirExistsP(fs, "https://example.com/x/y"))
+	a.Len(ListSuffixedFilesP(fs, "https://example.com/x", ".txt", false), 1)
+
+	f, err := fs.Open("https://example.com/x/y/a.txt")
+	a.NoError(err)
+	a.Equal("https://example.com/x/y/a.txt", f.Name())
+	f.Close()
+
+	// 同一挂载点内可以改名，跨挂载点不可以
+	a.NoError(fs.Rename("https://example.com/x/a.txt", "https://example.com/x/b.txt"))
+	a.Equal("outer", ReadFileTextP(outer, "/b.txt"))
+	a.Error(fs.Rename("https://example.com/x/b.txt", "/b.txt"))
+
+	_, err = fs.Stat("https://example.com/other/a.txt")
+	a.ErrorContains(err, "no file system mounted")
+	_, err = fs.Stat("https://example.com/xa.txt")
+	a.ErrorContains(err, "no file system mounted")
+
+	a.True(fs.Unmount("https://example.com/x/y"))
+	a.False(fs.Unmount("https://example.com/x/y"))
+	a.Equal("outer", ReadFileTextP(fs, "https://example.com/x/b.txt"))
+	_, err = fs.Stat("https://example.com/x/y/a.txt")
+	a.True(os.IsNotExist(err))
+}
@@ -90,6 +90,16 @@ func (p ProgressReader) Finish() {
 	p.reportProgress()
 }
 
+// reset 重新设置已传输字节数和总大小，用于断点续传时从新的位置继续报告
+func (p ProgressReader) reset(Transferred, Total int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.Transferred = Transferred
+	p.Total = Total
+	p.lastBytes = Transferred
+}
+
 // ProgressWriter 进度写入器，包装io.Writer并报告写入进度
 type ProgressWriterT struct {
 	writer      io.Writer
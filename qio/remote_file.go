@@ -1,7 +1,13 @@
 package qio
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net"
 	"net/url"
+	"path"
+	"strings"
 	"time"
 
 	"github.com/goodsru/go-universal-network-adapter/models"
@@ -93,3 +99,142 @@ func (me RemoteFile) Download() (Content, error) {
 
 	return r, nil
 }
+
+// remoteStore 按协议实现远程文件的读写、删除、查询和列目录。
+// Download() 仍由 universal-network-adapter 完成，downloadTo() 使用 remoteStore
+type remoteStore interface {
+	download(file RemoteFile, writer io.Writer) error
+	upload(file RemoteFile, reader io.Reader, size int64) error
+	delete(file RemoteFile) error
+	stat(file RemoteFile) (FileInfo, error)
+	list(file RemoteFile) ([]FileInfo, error)
+}
+
+var _remoteStores = map[string]remoteStore{
+	services.HTTP:  httpStore{},
+	services.HTTPS: httpStore{},
+	services.FTP:   ftpStore{},
+	services.FTPS:  ftpStore{},
+	services.SFTP:  sftpStore{},
+	services.S3:    s3Store{},
+}
+
+func (me RemoteFile) store() (remoteStore, error) {
+	r, ok := _remoteStores[strings.ToLower(me.Protocol())]
+	if !ok {
+		return nil, errors.Errorf("unsupported protocol: %s", me.Protocol())
+	}
+	return r, nil
+}
+
+// path 返回 URL 中的路径，不含查询参数
+func (me RemoteFile) path() string {
+	return me.backend.ParsedDestination.GetPath()
+}
+
+// dir 返回路径所在的目录，与 Dir() 不同，根目录返回 "/"
+func (me RemoteFile) dir() string {
+	return path.Dir(me.path())
+}
+
+// tempPath 返回上传时使用的同目录临时文件，名称带随机串，同时上传同一个文件时互不干扰
+func (me RemoteFile) tempPath() string {
+	suffix := make([]byte, 8)
+	rand.Read(suffix)
+	return path.Join(me.dir(), "."+me.Name()+"."+hex.EncodeToString(suffix)+ATOMIC_TEMP_SUFFIX)
+}
+
+// host 返回 host:port，URL 中没有端口时使用 defaultPort
+func (me RemoteFile) host(defaultPort string) string {
+	u := me.URL()
+	if len(u.Port()) > 0 {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), defaultPort)
+}
+
+// downloadTo 把文件内容写入 writer，文件不存在时返回的错误满足 errors.Is(err, os.ErrNotExist)
+func (me RemoteFile) downloadTo(writer io.Writer) error {
+	store, err := me.store()
+	if err != nil {
+		return err
+	}
+	if err := store.download(me, writer); err != nil {
+		return errors.Wrapf(err, "download %s", me.Url())
+	}
+	return nil
+}
+
+func (me RemoteFile) UploadP(reader io.Reader, size int64) {
+	if err := me.Upload(reader, size); err != nil {
+		panic(qerr.NewSystemError(err.Error(), err))
+	}
+}
+
+func (me RemoteFile) Upload(reader io.Reader, size int64) error {
+	store, err := me.store()
+	if err != nil {
+		return err
+	}
+	if err := store.upload(me, uploadReader(reader, size), size); err != nil {
+		return errors.Wrapf(err, "upload %s", me.Url())
+	}
+	return nil
+}
+
+func (me RemoteFile) DeleteP() {
+	if err := me.Delete(); err != nil {
+		panic(qerr.NewSystemError(err.Error(), err))
+	}
+}
+
+func (me RemoteFile) Delete() error {
+	store, err := me.store()
+	if err != nil {
+		return err
+	}
+	if err := store.delete(me); err != nil {
+		return errors.Wrapf(err, "delete %s", me.Url())
+	}
+	return nil
+}
+
+func (me RemoteFile) StatP() FileInfo {
+	r, err := me.Stat()
+	if err != nil {
+		panic(qerr.NewSystemError(err.Error(), err))
+	}
+	return r
+}
+
+func (me RemoteFile) Stat() (FileInfo, error) {
+	store, err := me.store()
+	if err != nil {
+		return nil, err
+	}
+	r, err := store.stat(me)
+	if err != nil {
+		return nil, errors.Wrapf(err, "stat %s", me.Url())
+	}
+	return r, nil
+}
+
+func (me RemoteFile) ListP() []FileInfo {
+	r, err := me.List()
+	if err != nil {
+		panic(qerr.NewSystemError(err.Error(), err))
+	}
+	return r
+}
+
+func (me RemoteFile) List() ([]FileInfo, error) {
+	store, err := me.store()
+	if err != nil {
+		return nil, err
+	}
+	r, err := store.list(me)
+	if err != nil {
+		return nil, errors.Wrapf(err, "list %s", me.Url())
+	}
+	return r, nil
+}
@@ -0,0 +1,149 @@
+package qio
+
+import (
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/secsy/goftp"
+)
+
+// FTP 的 "550 Requested action not taken"，多数服务端在文件不存在时返回
+const ftpReplyFileUnavailable = 550
+
+// FTP 的 "553 Requested action not taken. File name not allowed"，部分服务端在改名的目标已存在时返回
+const ftpReplyFileNameNotAllowed = 553
+
+// ftpStore 用 goftp 实现，FTPS 使用 Credentials 中的 TLSConfig 和 TLSMode
+type ftpStore struct{}
+
+func (me ftpStore) connect(file RemoteFile) (*goftp.Client, error) {
+	c := file.Credentials()
+	return goftp.DialConfig(goftp.Config{
+		User:      c.User,
+		Password:  c.Password,
+		Timeout:   file.Timeout(),
+		TLSConfig: c.TLSConfig,
+		TLSMode:   goftp.TLSMode(c.TLSMode),
+	}, file.host("21"))
+}
+
+// ftpNotExist 把 550 转换为 os.ErrNotExist
+func ftpNotExist(err error) error {
+	var e goftp.Error
+	if errors.As(err, &e) && e.Code() == ftpReplyFileUnavailable {
+		return errors.Wrap(os.ErrNotExist, err.Error())
+	}
+	return err
+}
+
+// ftpFileExists 改名的目标文件已存在时服务端的回复，如 IIS 的 "550 Cannot create a file when that file already exists."
+func ftpFileExists(err error) bool {
+	var e goftp.Error
+	if !errors.As(err, &e) || (e.Code() != ftpReplyFileUnavailable && e.Code() != ftpReplyFileNameNotAllowed) {
+		return false
+	}
+	message := strings.ToLower(e.Message())
+	return strings.Contains(message, "already exists") || strings.Contains(message, "file exists")
+}
+
+func (me ftpStore) download(file RemoteFile, writer io.Writer) error {
+	client, err := me.connect(file)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return ftpNotExist(client.Retrieve(file.path(), writer))
+}
+
+func (me ftpStore) upload(file RemoteFile, reader io.Reader, size int64) error {
+	client, err := me.connect(file)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	// 逐级创建目录，已存在的目录会返回错误，忽略即可，真正的问题由 Store 报告
+	dir := ""
+	for _, name := range strings.Split(strings.Trim(file.dir(), "/"), "/") {
+		if len(name) > 0 {
+			dir += "/" + name
+			client.Mkdir(dir)
+		}
+	}
+
+	// 先上传为同目录下的临时文件再改名，失败时不会留下不完整的文件
+	tmp := file.tempPath()
+	err = client.Store(tmp, reader)
+	if err == nil {
+		err = client.Rename(tmp, file.path())
+		if ftpFileExists(err) {
+			// IIS 等服务端不能改名覆盖已有的文件，确认目标文件存在后先删除它；其它错误保留原来的文件
+			if info, statErr := client.Stat(file.path()); statErr == nil && !info.IsDir() {
+				if err = client.Delete(file.path()); err == nil {
+					err = client.Rename(tmp, file.path())
+				}
+			}
+		}
+	}
+	if err != nil {
+		client.Delete(tmp)
+	}
+	return ftpNotExist(err)
+}
+
+func (me ftpStore) delete(file RemoteFile) error {
+	client, err := me.connect(file)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	info, err := client.Stat(file.path())
+	if err != nil {
+		return ftpNotExist(err)
+	}
+	if info.IsDir() {
+		return ftpNotExist(client.Rmdir(file.path()))
+	}
+	return ftpNotExist(client.Delete(file.path()))
+}
+
+func (me ftpStore) stat(file RemoteFile) (FileInfo, error) {
+	client, err := me.connect(file)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	info, err := client.Stat(file.path())
+	if err != nil {
+		return nil, ftpNotExist(err)
+	}
+	r := newFileInfo(file.dir(), info)
+	r.Name = file.Name()
+	return r, nil
+}
+
+func (me ftpStore) list(file RemoteFile) ([]FileInfo, error) {
+	client, err := me.connect(file)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	dir := path.Clean(file.path())
+	infos, err := client.ReadDir(dir)
+	if err != nil {
+		return nil, ftpNotExist(err)
+	}
+
+	r := make([]FileInfo, 0, len(infos))
+	for _, info := range infos {
+		r = append(r, newFileInfo(dir, info))
+	}
+	return r, nil
+}
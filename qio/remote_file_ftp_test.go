@@ -0,0 +1,249 @@
+package qio
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+// startTestFTPServer 启动只接受用户 u 密码 p 的 FTP 服务，只支持 goftp 用到的命令，文件保存在 fs 中，返回 host:port
+func startTestFTPServer(t *testing.T, fs afero.Fs) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go (&testFTPSession{fs: fs, conn: conn}).serve()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+type testFTPSession struct {
+	fs     afero.Fs
+	conn   net.Conn
+	user   string
+	passed bool
+	data   net.Listener
+	rnfr   string
+}
+
+func (me *testFTPSession) reply(format string, args ...any) {
+	fmt.Fprintf(me.conn, format+"\r\n", args...)
+}
+
+func (me *testFTPSession) mlst(info os.FileInfo, name string) string {
+	typ := "file"
+	if info.IsDir() {
+		typ = "dir"
+	}
+	return fmt.Sprintf("type=%s;size=%d;modify=%s; %s", typ, info.Size(), info.ModTime().UTC().Format("20060102150405"), name)
+}
+
+// transfer 接受 EPSV 打开的数据连接
+func (me *testFTPSession) transfer(f func(conn net.Conn) error) {
+	if me.data == nil {
+		me.reply("425 use EPSV first")
+		return
+	}
+	defer func() {
+		me.data.Close()
+		me.data = nil
+	}()
+
+	me.reply("150 opening data connection")
+	conn, err := me.data.Accept()
+	if err != nil {
+		me.reply("425 %s", err)
+		return
+	}
+	err = f(conn)
+	conn.Close()
+	if err != nil {
+		me.reply("451 %s", err)
+		return
+	}
+	me.reply("226 transfer complete")
+}
+
+func (me *testFTPSession) serve() {
+	defer me.conn.Close()
+
+	me.reply("220 ready")
+	reader := bufio.NewReader(me.conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+		cmd = strings.ToUpper(cmd)
+
+		if !me.passed && cmd != "USER" && cmd != "PASS" && cmd != "QUIT" {
+			me.reply("530 not logged in")
+			continue
+		}
+
+		switch cmd {
+		case "USER":
+			me.user = arg
+			me.reply("331 password required")
+		case "PASS":
+			if me.user != "u" || arg != "p" {
+				me.reply("530 login incorrect")
+				continue
+			}
+			me.passed = true
+			me.reply("230 logged in")
+		case "FEAT":
+			me.reply("211-Features:\r\n SIZE\r\n MLST type*;size*;modify*;\r\n211 End")
+		case "TYPE":
+			me.reply("200 type set")
+		case "EPSV":
+			if me.data, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+				me.reply("425 %s", err)
+				continue
+			}
+			me.reply("229 Entering Extended Passive Mode (|||%d|)", me.data.Addr().(*net.TCPAddr).Port)
+		case "STOR":
+			// 先创建文件再回复 150，客户端放弃上传后立即删除也不会留下文件
+			f, err := me.fs.OpenFile(arg, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+			if err != nil {
+				me.reply("553 %s", err)
+				continue
+			}
+			me.transfer(func(conn net.Conn) error {
+				defer f.Close()
+				_, err := io.Copy(f, conn)
+				return err
+			})
+		case "RETR":
+			f, err := me.fs.Open(arg)
+			if err != nil {
+				me.reply("550 %s", err)
+				continue
+			}
+			me.transfer(func(conn net.Conn) error {
+				defer f.Close()
+				_, err := io.Copy(conn, f)
+				return err
+			})
+		case "MLSD":
+			infos, err := afero.ReadDir(me.fs, arg)
+			if err != nil {
+				me.reply("550 %s", err)
+				continue
+			}
+			me.transfer(func(conn net.Conn) error {
+				for _, info := range infos {
+					fmt.Fprintf(conn, "%s\r\n", me.mlst(info, info.Name()))
+				}
+				return nil
+			})
+		case "MLST":
+			info, err := me.fs.Stat(arg)
+			if err != nil {
+				me.reply("550 %s", err)
+				continue
+			}
+			me.reply("250-Listing %s\r\n %s\r\n250 End", arg, me.mlst(info, arg))
+		case "SIZE":
+			info, err := me.fs.Stat(arg)
+			if err != nil {
+				me.reply("550 %s", err)
+				continue
+			}
+			me.reply("213 %d", info.Size())
+		case "MKD":
+			if err := me.fs.Mkdir(arg, 0o755); err != nil {
+				me.reply("550 %s", err)
+				continue
+			}
+			me.reply(`257 "%s" created`, arg)
+		case "DELE", "RMD":
+			if err := me.fs.Remove(arg); err != nil {
+				me.reply("550 %s", err)
+				continue
+			}
+			me.reply("250 removed")
+		case "RNFR":
+			if _, err := me.fs.Stat(arg); err != nil {
+				me.reply("550 %s", err)
+				continue
+			}
+			me.rnfr = arg
+			me.reply("350 ready for RNTO")
+		case "RNTO":
+			if strings.HasPrefix(arg, "/locked/") {
+				me.reply("550 permission denied")
+				continue
+			}
+			// 与 IIS 一样不能改名覆盖已有的文件
+			if _, err := me.fs.Stat(arg); err == nil {
+				me.reply("553 %s already exists", arg)
+				continue
+			}
+			if err := me.fs.Rename(me.rnfr, arg); err != nil {
+				me.reply("550 %s", err)
+				continue
+			}
+			me.reply("250 renamed")
+		case "QUIT":
+			me.reply("221 bye")
+			return
+		default:
+			me.reply("502 %s not implemented", cmd)
+		}
+	}
+}
+
+func TestRemoteFile_ftp(t *testing.T) {
+	a := require.New(t)
+
+	fs := afero.NewMemMapFs()
+	addr := startTestFTPServer(t, fs)
+	credentials := &CredentialsT{User: "u", Password: "p"}
+
+	testFileStore(t, "", func(p string) File {
+		return NewRemoteFileP("ftp://"+addr+p, credentials, 5*time.Second)
+	}, func(p string) (string, bool) {
+		data, err := afero.ReadFile(fs, p)
+		return string(data), err == nil
+	}, true)
+
+	// 删除空目录
+	a.NoError(fs.MkdirAll("/empty", 0o755))
+	NewRemoteFileP("ftp://"+addr+"/empty", credentials, 5*time.Second).DeleteP()
+	exists, err := afero.Exists(fs, "/empty")
+	a.NoError(err)
+	a.False(exists)
+
+	_, err = NewRemoteFileP("ftp://"+addr+"/dir", &CredentialsT{User: "u", Password: "wrong"}, 5*time.Second).List()
+	a.Error(err)
+
+	// 其它原因的改名失败不删除原来的文件
+	WriteFileTextP(fs, "/locked/a.txt", "old")
+	err = NewRemoteFileP("ftp://"+addr+"/locked/a.txt", credentials, 5*time.Second).Upload(strings.NewReader("new"), 3)
+	a.Error(err)
+	a.Equal("old", ReadFileTextP(fs, "/locked/a.txt"))
+	names, err := afero.ReadDir(fs, "/locked")
+	a.NoError(err)
+	a.Len(names, 1)
+
+	// 目录 URL 以 "/" 结尾时 Path 相同
+	a.Equal("/dir", NewRemoteFileP("ftp://"+addr+"/dir/", credentials, 5*time.Second).ListP()[0].Path)
+}
@@ -0,0 +1,89 @@
+package qio
+
+import (
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// httpStore 用 GET、PUT、DELETE 和 HEAD 实现下载、上传、删除和查询，HTTP 没有列目录的标准方法
+type httpStore struct{}
+
+func (me httpStore) do(file RemoteFile, method string, body io.Reader, size int64) (*http.Response, error) {
+	req, err := http.NewRequest(method, file.Url(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	if c := file.Credentials(); len(c.User) > 0 || len(c.Password) > 0 {
+		req.SetBasicAuth(c.User, c.Password)
+	}
+
+	client := &http.Client{Timeout: file.Timeout()}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+			return nil, errors.Wrap(os.ErrNotExist, resp.Status)
+		}
+		return nil, errors.Errorf("unexpected HTTP status: %s", resp.Status)
+	}
+	return resp, nil
+}
+
+func (me httpStore) download(file RemoteFile, writer io.Writer) error {
+	resp, err := me.do(file, http.MethodGet, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(writer, resp.Body)
+	return err
+}
+
+func (me httpStore) upload(file RemoteFile, reader io.Reader, size int64) error {
+	if size < 0 {
+		// 大小未知时使用 chunked 编码
+		size = -1
+	}
+	resp, err := me.do(file, http.MethodPut, reader, size)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (me httpStore) delete(file RemoteFile) error {
+	resp, err := me.do(file, http.MethodDelete, nil, 0)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (me httpStore) stat(file RemoteFile) (FileInfo, error) {
+	resp, err := me.do(file, http.MethodHead, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	r := &FileInfoT{Name: file.Name(), Path: file.dir(), Size: max(resp.ContentLength, 0)}
+	if lastmod, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		r.Lastmod = lastmod
+	}
+	return r, nil
+}
+
+func (me httpStore) list(file RemoteFile) ([]FileInfo, error) {
+	return nil, errors.New("listing is not supported over HTTP")
+}
@@ -0,0 +1,91 @@
+package qio
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testHTTPStore 支持 GET、PUT、HEAD 和 DELETE 的内存文件服务，要求 basic auth
+type testHTTPStore struct {
+	mu    sync.Mutex
+	files map[string]string
+}
+
+func (me *testHTTPStore) read(p string) (string, bool) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	r, ok := me.files[p]
+	return r, ok
+}
+
+func (me *testHTTPStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if user, password, ok := r.BasicAuth(); !ok || user != "u" || password != "p" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	content, found := me.files[r.URL.Path]
+	switch r.Method {
+	case http.MethodGet:
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		io.WriteString(w, content)
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		me.files[r.URL.Path] = string(data)
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodHead:
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.Header().Set("Last-Modified", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC).Format(http.TimeFormat))
+	case http.MethodDelete:
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(me.files, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestRemoteFile_http(t *testing.T) {
+	a := require.New(t)
+
+	store := &testHTTPStore{files: map[string]string{}}
+	ts := httptest.NewServer(store)
+	defer ts.Close()
+
+	testFileStore(t, "", func(p string) File {
+		return NewRemoteFileP(ts.URL+p, &CredentialsT{User: "u", Password: "p"}, 5*time.Second)
+	}, store.read, false)
+
+	store.mu.Lock()
+	store.files["/x.txt"] = "x"
+	store.mu.Unlock()
+	info := NewRemoteFileP(ts.URL+"/x.txt", &CredentialsT{User: "u", Password: "p"}, 5*time.Second).StatP()
+	a.Equal(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), info.Lastmod)
+
+	_, err := NewRemoteFileP(ts.URL+"/x.txt", nil, 5*time.Second).Stat()
+	a.ErrorContains(err, "401")
+}
@@ -0,0 +1,192 @@
+package qio
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/pkg/errors"
+)
+
+const DEFAULT_S3_REGION = "us-east-1"
+
+// s3Store 访问 S3 兼容的存储，URL 形如 s3://endpoint/bucket/key，使用 path-style 请求。
+// Credentials 的 User 和 Password 是 access key 和 secret key。
+// 查询参数 region 指定区域，默认 DEFAULT_S3_REGION；insecure=true 时用 HTTP 访问 endpoint
+type s3Store struct{}
+
+// location 返回 bucket 和 key，只有列目录时 key 可以为空
+func (me s3Store) location(file RemoteFile, needKey bool) (string, string, error) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(file.path(), "/"), "/")
+	if len(bucket) == 0 {
+		return "", "", errors.New("no bucket in s3 URL")
+	}
+	if needKey && len(key) == 0 {
+		return "", "", errors.New("no object key in s3 URL")
+	}
+	return bucket, key, nil
+}
+
+func (me s3Store) connect(file RemoteFile) (*s3.S3, error) {
+	query := file.URL().Query()
+
+	region := query.Get("region")
+	if len(region) == 0 {
+		region = DEFAULT_S3_REGION
+	}
+	scheme := "https://"
+	if query.Get("insecure") == "true" {
+		scheme = "http://"
+	}
+
+	c := file.Credentials()
+	sess, err := session.NewSession(&aws.Config{
+		Credentials:      credentials.NewStaticCredentials(c.User, c.Password, ""),
+		Endpoint:         aws.String(scheme + file.URL().Host),
+		Region:           aws.String(region),
+		S3ForcePathStyle: aws.Bool(true),
+		HTTPClient:       &http.Client{Timeout: file.Timeout()},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s3.New(sess), nil
+}
+
+// s3NotExist 把 404 转换为 os.ErrNotExist
+func s3NotExist(err error) error {
+	var e awserr.RequestFailure
+	if errors.As(err, &e) && e.StatusCode() == http.StatusNotFound {
+		return errors.Wrap(os.ErrNotExist, err.Error())
+	}
+	return err
+}
+
+func (me s3Store) download(file RemoteFile, writer io.Writer) error {
+	bucket, key, err := me.location(file, true)
+	if err != nil {
+		return err
+	}
+	client, err := me.connect(file)
+	if err != nil {
+		return err
+	}
+
+	out, err := client.GetObject(&s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return s3NotExist(err)
+	}
+	defer out.Body.Close()
+
+	_, err = io.Copy(writer, out.Body)
+	return err
+}
+
+func (me s3Store) upload(file RemoteFile, reader io.Reader, size int64) error {
+	bucket, key, err := me.location(file, true)
+	if err != nil {
+		return err
+	}
+	client, err := me.connect(file)
+	if err != nil {
+		return err
+	}
+
+	// s3manager 按需分片上传，不要求 reader 可以 Seek，也不需要预先知道大小
+	_, err = s3manager.NewUploaderWithClient(client).Upload(&s3manager.UploadInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   reader,
+	})
+	return s3NotExist(err)
+}
+
+func (me s3Store) delete(file RemoteFile) error {
+	bucket, key, err := me.location(file, true)
+	if err != nil {
+		return err
+	}
+	client, err := me.connect(file)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	return s3NotExist(err)
+}
+
+func (me s3Store) stat(file RemoteFile) (FileInfo, error) {
+	bucket, key, err := me.location(file, true)
+	if err != nil {
+		return nil, err
+	}
+	client, err := me.connect(file)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := client.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, s3NotExist(err)
+	}
+	return &FileInfoT{
+		Name:    path.Base(key),
+		Path:    file.dir(),
+		Size:    aws.Int64Value(out.ContentLength),
+		Lastmod: aws.TimeValue(out.LastModified),
+	}, nil
+}
+
+// list 把 key 当作前缀列出一层，更深的 key 归并为子目录
+func (me s3Store) list(file RemoteFile) ([]FileInfo, error) {
+	bucket, prefix, err := me.location(file, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(prefix) > 0 && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	client, err := me.connect(file)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := path.Clean("/" + bucket + "/" + prefix)
+	r := []FileInfo{}
+	err = client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, p := range page.CommonPrefixes {
+			name := strings.TrimSuffix(strings.TrimPrefix(aws.StringValue(p.Prefix), prefix), "/")
+			r = append(r, &FileInfoT{Name: name, Path: dir, IsDir: true})
+		}
+		for _, o := range page.Contents {
+			name := strings.TrimPrefix(aws.StringValue(o.Key), prefix)
+			if len(name) == 0 {
+				// 目录占位对象
+				continue
+			}
+			r = append(r, &FileInfoT{
+				Name:    name,
+				Path:    dir,
+				Size:    aws.Int64Value(o.Size),
+				Lastmod: aws.TimeValue(o.LastModified),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, s3NotExist(err)
+	}
+	return r, nil
+}
@@ -0,0 +1,154 @@
+package qio
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testS3Store 兼容 S3 的内存存储，只支持 path-style 的 GetObject、PutObject、HeadObject、DeleteObject 和 ListObjectsV2
+type testS3Store struct {
+	mu    sync.Mutex
+	files map[string]string
+}
+
+// read 的参数是 "/bucket/key"
+func (me *testS3Store) read(p string) (string, bool) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	r, ok := me.files[p]
+	return r, ok
+}
+
+type testS3ListResult struct {
+	XMLName        xml.Name `xml:"ListBucketResult"`
+	Name           string
+	Prefix         string
+	Delimiter      string
+	KeyCount       int
+	IsTruncated    bool
+	Contents       []testS3Object
+	CommonPrefixes []testS3Prefix
+}
+
+type testS3Object struct {
+	Key          string
+	LastModified string
+	Size         int
+}
+
+type testS3Prefix struct {
+	Prefix string
+}
+
+func (me *testS3Store) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.Contains(r.Header.Get("Authorization"), "Credential=u/") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	content, found := me.files[r.URL.Path]
+	lastmod := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	switch r.Method {
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		me.files[r.URL.Path] = string(data)
+		w.Header().Set("ETag", `"etag"`)
+	case http.MethodHead:
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.Header().Set("Last-Modified", lastmod.Format(http.TimeFormat))
+	case http.MethodDelete:
+		delete(me.files, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodGet:
+		if !r.URL.Query().Has("list-type") {
+			if !found {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			io.WriteString(w, content)
+			return
+		}
+		if r.URL.Query().Get("list-type") != "2" {
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
+
+		bucket := "/" + strings.Trim(r.URL.Path, "/") + "/"
+		result := testS3ListResult{Name: strings.Trim(bucket, "/"), Prefix: r.URL.Query().Get("prefix"), Delimiter: r.URL.Query().Get("delimiter")}
+		prefixes := map[string]bool{}
+		keys := []string{}
+		for p := range me.files {
+			if key, ok := strings.CutPrefix(p, bucket); ok && strings.HasPrefix(key, result.Prefix) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if i := strings.Index(key[len(result.Prefix):], result.Delimiter); len(result.Delimiter) > 0 && i >= 0 {
+				prefix := key[:len(result.Prefix)+i+1]
+				if !prefixes[prefix] {
+					prefixes[prefix] = true
+					result.CommonPrefixes = append(result.CommonPrefixes, testS3Prefix{prefix})
+				}
+				continue
+			}
+			result.Contents = append(result.Contents, testS3Object{key, lastmod.Format(time.RFC3339), len(me.files[bucket+key])})
+		}
+		result.KeyCount = len(result.Contents) + len(result.CommonPrefixes)
+
+		w.Header().Set("Content-Type", "application/xml")
+		xml.NewEncoder(w).Encode(&result)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestRemoteFile_s3(t *testing.T) {
+	a := require.New(t)
+
+	store := &testS3Store{files: map[string]string{}}
+	ts := httptest.NewServer(store)
+	defer ts.Close()
+	host := strings.TrimPrefix(ts.URL, "http://")
+	credentials := &CredentialsT{User: "u", Password: "secret"}
+
+	testFileStore(t, "/bucket", func(p string) File {
+		return NewRemoteFileP("s3://"+host+p+"?insecure=true&region=eu-west-1", credentials, 5*time.Second)
+	}, store.read, true)
+
+	// 列出 bucket 的根目录
+	list := NewRemoteFileP("s3://"+host+"/bucket?insecure=true", credentials, 5*time.Second).ListP()
+	a.Len(list, 1)
+	a.Equal("dir", list[0].Name)
+	a.Equal("/bucket", list[0].Path)
+	a.True(list[0].IsDir)
+
+	_, err := NewRemoteFileP("s3://"+host+"/?insecure=true", credentials, 5*time.Second).List()
+	a.ErrorContains(err, "no bucket")
+	_, err = NewRemoteFileP("s3://"+host+"/bucket?insecure=true", credentials, 5*time.Second).Stat()
+	a.ErrorContains(err, "no object key")
+	_, err = NewRemoteFileP("s3://"+host+"/bucket/dir/sub/b.txt?insecure=true", &CredentialsT{User: "other", Password: "secret"}, 5*time.Second).Stat()
+	a.Error(err)
+}
@@ -0,0 +1,234 @@
+package qio
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"os"
+	"path"
+
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SFTPKnownHostsFiles 校验 sftp 服务器主机密钥的 known_hosts 文件，与 ssh 的默认值相同，不存在的文件被忽略
+var SFTPKnownHostsFiles = []string{"~/.ssh/known_hosts", "/etc/ssh/ssh_known_hosts"}
+
+// SFTPHostKeyCallback 不为 nil 时代替 SFTPKnownHostsFiles 校验 sftp 服务器的主机密钥
+var SFTPHostKeyCallback ssh.HostKeyCallback
+
+// sftpStore 与 universal-network-adapter 一样用密码或 RSA 私钥登录。
+// 主机密钥按 SFTPKnownHostsFiles 校验，未记录的主机被拒绝，需要先用 ssh 或 ssh-keyscan 记录它的密钥
+type sftpStore struct{}
+
+// hostKeyCallback 返回校验主机密钥的回调，以及与 ssh 一样优先协商已记录的密钥类型的主机密钥算法
+func (me sftpStore) hostKeyCallback(addr string) (ssh.HostKeyCallback, []string, error) {
+	if SFTPHostKeyCallback != nil {
+		return SFTPHostKeyCallback, nil, nil
+	}
+
+	files := []string{}
+	for _, f := range SFTPKnownHostsFiles {
+		p, err := ExpandHomePath(f)
+		if err != nil {
+			continue
+		}
+		if _, err := os.Stat(p); err == nil {
+			files = append(files, p)
+		}
+	}
+	if len(files) == 0 {
+		return nil, nil, errors.Errorf("no known_hosts file to verify the host key of %s", addr)
+	}
+
+	callback, err := knownhosts.New(files...)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "load known_hosts")
+	}
+	return callback, sftpHostKeyAlgorithms(callback, addr), nil
+}
+
+// sftpHostKeyAlgorithms 用一个不会被记录的密钥探测 known_hosts 中该主机的密钥类型，
+// 把这些类型的算法排在前面；没有记录时返回 nil，使用默认顺序
+func sftpHostKeyAlgorithms(callback ssh.HostKeyCallback, addr string) []string {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil
+	}
+	probe, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return nil
+	}
+
+	var keyErr *knownhosts.KeyError
+	if !errors.As(callback(addr, &net.TCPAddr{}, probe), &keyErr) || len(keyErr.Want) == 0 {
+		return nil
+	}
+	known := map[string]bool{}
+	for _, w := range keyErr.Want {
+		known[w.Key.Type()] = true
+	}
+
+	preferred, rest := []string{}, []string{}
+	for _, algo := range ssh.SupportedAlgorithms().HostKeys {
+		keyType := algo
+		if algo == ssh.KeyAlgoRSASHA256 || algo == ssh.KeyAlgoRSASHA512 {
+			keyType = ssh.KeyAlgoRSA
+		}
+		if known[keyType] {
+			preferred = append(preferred, algo)
+		} else {
+			rest = append(rest, algo)
+		}
+	}
+	return append(preferred, rest...)
+}
+
+func (me sftpStore) connect(file RemoteFile) (*sftp.Client, func(), error) {
+	d := file.backend.ParsedDestination
+
+	var auth []ssh.AuthMethod
+	if len(d.GetPassword()) > 0 {
+		auth = append(auth, ssh.Password(d.GetPassword()))
+	}
+	if len(d.Credentials.RsaPrivateKey) > 0 {
+		signer, err := d.GetRsaPrivateKey()
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "parse private key")
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+
+	addr := file.host("22")
+	hostKeyCallback, hostKeyAlgorithms, err := me.hostKeyCallback(addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:              d.GetUser(),
+		Auth:              auth,
+		HostKeyCallback:   hostKeyCallback,
+		HostKeyAlgorithms: hostKeyAlgorithms,
+		Timeout:           file.Timeout(),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return client, func() {
+		client.Close()
+		conn.Close()
+	}, nil
+}
+
+func (me sftpStore) download(file RemoteFile, writer io.Writer) error {
+	client, closer, err := me.connect(file)
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	f, err := client.Open(file.path())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.WriteTo(writer)
+	return err
+}
+
+func (me sftpStore) upload(file RemoteFile, reader io.Reader, size int64) error {
+	client, closer, err := me.connect(file)
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	if err := client.MkdirAll(file.dir()); err != nil {
+		return errors.Wrapf(err, "create directory: %s", file.dir())
+	}
+
+	// 先写入同目录下的临时文件再改名，失败时不会留下不完整的文件
+	tmp := file.tempPath()
+	f, err := client.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, reader)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = client.PosixRename(tmp, file.path())
+		if sftpOpUnsupported(err) {
+			// 服务端不支持 posix-rename 扩展时，普通的 rename 不能覆盖已有的文件，先删除目标文件
+			if err = client.Remove(file.path()); err == nil || errors.Is(err, os.ErrNotExist) {
+				err = client.Rename(tmp, file.path())
+			}
+		}
+	}
+	if err != nil {
+		client.Remove(tmp)
+	}
+	return err
+}
+
+// sftpOpUnsupported 服务端是否回复了 SSH_FX_OP_UNSUPPORTED
+func sftpOpUnsupported(err error) bool {
+	var e *sftp.StatusError
+	return errors.As(err, &e) && e.FxCode() == sftp.ErrSSHFxOpUnsupported
+}
+
+func (me sftpStore) delete(file RemoteFile) error {
+	client, closer, err := me.connect(file)
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	return client.Remove(file.path())
+}
+
+func (me sftpStore) stat(file RemoteFile) (FileInfo, error) {
+	client, closer, err := me.connect(file)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	info, err := client.Stat(file.path())
+	if err != nil {
+		return nil, err
+	}
+	return newFileInfo(file.dir(), info), nil
+}
+
+func (me sftpStore) list(file RemoteFile) ([]FileInfo, error) {
+	client, closer, err := me.connect(file)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	dir := path.Clean(file.path())
+	infos, err := client.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	r := make([]FileInfo, 0, len(infos))
+	for _, info := range infos {
+		r = append(r, newFileInfo(dir, info))
+	}
+	return r, nil
+}
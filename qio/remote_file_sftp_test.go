@@ -0,0 +1,188 @@
+package qio
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// startTestSFTPServer 启动只接受用户 u 密码 p 的 SFTP 服务，文件保存在内存中，返回 host:port。
+// 服务的主机密钥记录在临时的 known_hosts 中，测试结束前 SFTPKnownHostsFiles 指向它
+func startTestSFTPServer(t *testing.T) string {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if c.User() == "u" && string(password) == "p" {
+				return nil, nil
+			}
+			return nil, io.EOF
+		},
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	handlers := sftp.InMemHandler()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestSFTP(conn, config, handlers)
+		}
+	}()
+
+	addr := listener.Addr().String()
+	useTestKnownHosts(t, knownhosts.Line([]string{addr}, signer.PublicKey()))
+	return addr
+}
+
+// useTestKnownHosts 在测试期间用只包含 lines 的 known_hosts 校验 sftp 主机密钥
+func useTestKnownHosts(t *testing.T, lines ...string) {
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	require.NoError(t, os.WriteFile(file, []byte(strings.Join(lines, "\n")+"\n"), 0o600))
+
+	old := SFTPKnownHostsFiles
+	SFTPKnownHostsFiles = []string{file}
+	t.Cleanup(func() { SFTPKnownHostsFiles = old })
+}
+
+func serveTestSFTP(conn net.Conn, config *ssh.ServerConfig, handlers sftp.Handlers) {
+	defer conn.Close()
+
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if ok {
+					server := sftp.NewRequestServer(channel, handlers)
+					server.Serve()
+					server.Close()
+				}
+			}
+		}()
+	}
+}
+
+func TestRemoteFile_sftp(t *testing.T) {
+	a := require.New(t)
+
+	addr := startTestSFTPServer(t)
+	credentials := &CredentialsT{User: "u", Password: "p"}
+
+	read := func(p string) (string, bool) {
+		client, closer, err := sftpStore{}.connect(NewRemoteFileP("sftp://"+addr+p, credentials, 5*time.Second))
+		a.NoError(err)
+		defer closer()
+
+		f, err := client.Open(p)
+		if err != nil {
+			return "", false
+		}
+		defer f.Close()
+		data, err := io.ReadAll(f)
+		a.NoError(err)
+		return string(data), true
+	}
+
+	testFileStore(t, "", func(p string) File {
+		return NewRemoteFileP("sftp://"+addr+p, credentials, 5*time.Second)
+	}, read, true)
+
+	// 用户名和密码也可以放在 URL 中
+	f := NewRemoteFileP("sftp://u:p@"+addr+"/url/c.txt", nil, 5*time.Second)
+	a.NoError(f.Upload(io.LimitReader(rand.Reader, 1024), 1024))
+	a.Equal(int64(1024), f.StatP().Size)
+
+	_, err := NewRemoteFileP("sftp://"+addr+"/url/c.txt", &CredentialsT{User: "u", Password: "wrong"}, 5*time.Second).Stat()
+	a.Error(err)
+}
+
+func TestRemoteFile_sftp_hostKey(t *testing.T) {
+	a := require.New(t)
+
+	addr := startTestSFTPServer(t)
+	f := NewRemoteFileP("sftp://"+addr+"/a.txt", &CredentialsT{User: "u", Password: "p"}, 5*time.Second)
+
+	// 未记录的主机被拒绝，不会发送密码
+	useTestKnownHosts(t, "# empty")
+	_, err := f.Stat()
+	a.Error(err)
+	a.Contains(err.Error(), "key is unknown")
+
+	// 主机密钥变化
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	a.NoError(err)
+	other, err := ssh.NewSignerFromKey(priv)
+	a.NoError(err)
+	useTestKnownHosts(t, knownhosts.Line([]string{addr}, other.PublicKey()))
+	_, err = f.Stat()
+	a.Error(err)
+	a.Contains(err.Error(), "key mismatch")
+
+	SFTPKnownHostsFiles = []string{filepath.Join(t.TempDir(), "missing")}
+	_, err = f.Stat()
+	a.Error(err)
+	a.Contains(err.Error(), "no known_hosts file")
+
+	// 优先协商已记录的密钥类型
+	useTestKnownHosts(t, knownhosts.Line([]string{addr}, other.PublicKey()))
+	callback, algorithms, err := sftpStore{}.hostKeyCallback(addr)
+	a.NoError(err)
+	a.NotNil(callback)
+	a.Equal(ssh.KeyAlgoED25519, algorithms[0])
+	a.Contains(algorithms, ssh.KeyAlgoRSASHA256)
+	_, algorithms, err = sftpStore{}.hostKeyCallback("127.0.0.1:1")
+	a.NoError(err)
+	a.Nil(algorithms)
+
+	// 显式的回调代替 known_hosts
+	SFTPHostKeyCallback = ssh.FixedHostKey(other.PublicKey())
+	t.Cleanup(func() { SFTPHostKeyCallback = nil })
+	_, err = f.Stat()
+	a.Error(err)
+}
+
+func TestSFTPOpUnsupported(t *testing.T) {
+	a := require.New(t)
+
+	a.True(sftpOpUnsupported(&sftp.StatusError{Code: uint32(sftp.ErrSSHFxOpUnsupported)}))
+	a.True(sftpOpUnsupported(errors.Wrap(&sftp.StatusError{Code: uint32(sftp.ErrSSHFxOpUnsupported)}, "rename")))
+	a.False(sftpOpUnsupported(&sftp.StatusError{Code: uint32(sftp.ErrSSHFxFailure)}))
+	a.False(sftpOpUnsupported(os.ErrPermission))
+	a.False(sftpOpUnsupported(nil))
+}
@@ -2,6 +2,7 @@ package qio
 
 import (
 	"io"
+	"strings"
 	"testing"
 	"time"
 
@@ -30,3 +31,13 @@ func Test_RemoteFile_happy(t *testing.T) {
 
 	a.Equal("The list of Debian mirror sites is available here: https://www.debian.org/mirror/list\n", string(txt))
 }
+
+func Test_RemoteFile_tempPath(t *testing.T) {
+	a := require.New(t)
+
+	f := NewRemoteFileP("sftp://example.com/dir/a.txt", nil, 10*time.Second)
+	tmp := f.tempPath()
+	a.True(strings.HasPrefix(tmp, "/dir/.a.txt."), tmp)
+	a.True(strings.HasSuffix(tmp, ATOMIC_TEMP_SUFFIX), tmp)
+	a.NotEqual(tmp, f.tempPath())
+}
@@ -0,0 +1,489 @@
+package qio
+
+import (
+	"bytes"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/qiangyt/go-comm/v3/qerr"
+	"github.com/spf13/afero"
+)
+
+// UrlFsT 以远程 URL 为根目录的 afero.Fs，文件名是相对于根 URL 的路径，
+// 根 URL 中的查询参数（如 s3 的 region）附加到每个文件的 URL 上。
+// 文件内容在第一次读写时整体下载到内存，修改过的内容在 Close 或 Sync 时整体上传。
+// 目录在上传时自动创建，所以 Mkdir 和 MkdirAll 什么也不做；不支持 Rename、Chmod、Chown 和 Chtimes
+type UrlFsT struct {
+	base        *url.URL
+	credentials Credentials
+	timeout     time.Duration
+}
+
+type UrlFs = *UrlFsT
+
+var _ afero.Fs = (*UrlFsT)(nil)
+
+var errUrlFsUnsupported = errors.New("operation not supported by UrlFs")
+
+func NewUrlFsP(baseUrl string, credentials Credentials, timeout time.Duration) UrlFs {
+	r, err := NewUrlFs(baseUrl, credentials, timeout)
+	if err != nil {
+		panic(qerr.NewSystemError(err.Error(), err))
+	}
+	return r
+}
+
+func NewUrlFs(baseUrl string, credentials Credentials, timeout time.Duration) (UrlFs, error) {
+	if !IsRemote(baseUrl) {
+		return nil, errors.Errorf("not a remote url: %s", baseUrl)
+	}
+	base, err := url.Parse(baseUrl)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse url: %s", baseUrl)
+	}
+	base.Path = path.Clean("/" + base.Path)
+	base.RawPath = ""
+
+	if credentials == nil {
+		credentials = &CredentialsT{}
+	}
+	return &UrlFsT{base: base, credentials: credentials, timeout: timeout}, nil
+}
+
+func (me UrlFs) Name() string {
+	return "UrlFs"
+}
+
+// BaseUrl 返回根 URL
+func (me UrlFs) BaseUrl() string {
+	return me.base.String()
+}
+
+// Url 返回 name 对应的 URL
+func (me UrlFs) Url(name string) string {
+	u := *me.base
+	u.Path = path.Join(me.base.Path, path.Clean("/"+strings.ReplaceAll(name, "\\", "/")))
+	return u.String()
+}
+
+func (me UrlFs) remoteFile(name string) (RemoteFile, error) {
+	return NewRemoteFile(me.Url(name), me.credentials, me.timeout)
+}
+
+// urlFsError 把错误包装为 *os.PathError，使 os.IsNotExist() 等函数可以识别
+func urlFsError(op string, name string, err error) error {
+	if errors.Is(err, os.ErrNotExist) {
+		err = os.ErrNotExist
+	}
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
+
+// stat 查询失败时尝试列目录，能列出内容的是目录。
+// s3 这样没有真正目录的协议，目录不存在与目录为空无法区分，所以空目录也视为不存在
+func (me UrlFs) stat(file RemoteFile) (os.FileInfo, error) {
+	info, err := file.Stat()
+	if err == nil {
+		return urlFileInfo(info), nil
+	}
+
+	if entries, listErr := file.List(); listErr == nil && len(entries) > 0 {
+		return urlFileInfo(&FileInfoT{Name: path.Base(file.path()), Path: file.dir(), IsDir: true}), nil
+	}
+	return nil, err
+}
+
+func (me UrlFs) Stat(name string) (os.FileInfo, error) {
+	file, err := me.remoteFile(name)
+	if err != nil {
+		return nil, urlFsError("stat", name, err)
+	}
+	r, err := me.stat(file)
+	if err != nil {
+		return nil, urlFsError("stat", name, err)
+	}
+	return r, nil
+}
+
+func (me UrlFs) Open(name string) (afero.File, error) {
+	return me.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (me UrlFs) Create(name string) (afero.File, error) {
+	return me.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o666)
+}
+
+func (me UrlFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	file, err := me.remoteFile(name)
+	if err != nil {
+		return nil, urlFsError("open", name, err)
+	}
+
+	info, err := me.stat(file)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) || flag&os.O_CREATE == 0 {
+			return nil, urlFsError("open", name, err)
+		}
+		info = nil
+	} else if flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL {
+		return nil, urlFsError("open", name, os.ErrExist)
+	}
+
+	r := &urlFileT{name: name, file: file, info: info, flag: flag}
+	if r.writable() {
+		if info != nil && info.IsDir() {
+			return nil, urlFsError("open", name, syscall.EISDIR)
+		}
+		if info == nil || flag&os.O_TRUNC != 0 {
+			// 新建或截断的文件不需要下载原来的内容，关闭时总是上传
+			r.loaded = true
+			r.dirty = true
+		}
+	}
+	return r, nil
+}
+
+func (me UrlFs) Remove(name string) error {
+	file, err := me.remoteFile(name)
+	if err == nil {
+		err = file.Delete()
+	}
+	if err != nil {
+		return urlFsError("remove", name, err)
+	}
+	return nil
+}
+
+// RemoveAll 逐个删除目录中的文件，最后删除目录本身，name 不存在时返回 nil
+func (me UrlFs) RemoveAll(name string) error {
+	file, err := me.remoteFile(name)
+	if err != nil {
+		return urlFsError("removeall", name, err)
+	}
+	info, err := me.stat(file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return urlFsError("removeall", name, err)
+	}
+
+	if info.IsDir() {
+		entries, err := file.List()
+		if err != nil {
+			return urlFsError("removeall", name, err)
+		}
+		for _, entry := range entries {
+			if err := me.RemoveAll(path.Join(name, entry.Name)); err != nil {
+				return err
+			}
+		}
+		// s3 的目录随最后一个文件消失
+		if err := file.Delete(); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return urlFsError("removeall", name, err)
+		}
+		return nil
+	}
+
+	if err := file.Delete(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return urlFsError("removeall", name, err)
+	}
+	return nil
+}
+
+func (me UrlFs) Mkdir(name string, perm os.FileMode) error {
+	return nil
+}
+
+func (me UrlFs) MkdirAll(name string, perm os.FileMode) error {
+	return nil
+}
+
+func (me UrlFs) Rename(oldname, newname string) error {
+	return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: errUrlFsUnsupported}
+}
+
+func (me UrlFs) Chmod(name string, mode os.FileMode) error {
+	return urlFsError("chmod", name, errUrlFsUnsupported)
+}
+
+func (me UrlFs) Chown(name string, uid, gid int) error {
+	return urlFsError("chown", name, errUrlFsUnsupported)
+}
+
+func (me UrlFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return urlFsError("chtimes", name, errUrlFsUnsupported)
+}
+
+// ============================================================================
+// urlFileInfoT
+// ============================================================================
+
+// urlFileInfoT 把 FileInfo 适配为 os.FileInfo
+type urlFileInfoT struct {
+	info FileInfo
+}
+
+func urlFileInfo(info FileInfo) os.FileInfo {
+	return urlFileInfoT{info}
+}
+
+func (me urlFileInfoT) Name() string {
+	return me.info.Name
+}
+
+func (me urlFileInfoT) Size() int64 {
+	return me.info.Size
+}
+
+func (me urlFileInfoT) Mode() os.FileMode {
+	if me.info.IsDir {
+		return os.ModeDir | 0o755
+	}
+	return 0o644
+}
+
+func (me urlFileInfoT) ModTime() time.Time {
+	return me.info.Lastmod
+}
+
+func (me urlFileInfoT) IsDir() bool {
+	return me.info.IsDir
+}
+
+func (me urlFileInfoT) Sys() any {
+	return me.info
+}
+
+// ============================================================================
+// urlFileT
+// ============================================================================
+
+// urlFileT 内容在第一次读写时下载到内存，修改过的内容在 Close 或 Sync 时整体上传
+type urlFileT struct {
+	name   string
+	file   RemoteFile
+	info   os.FileInfo // 新建的文件为 nil
+	flag   int
+	data   []byte
+	offset int64
+	loaded bool
+	dirty  bool
+	closed bool
+
+	// Readdir 尚未返回的目录项，nil 表示还没有列目录
+	entries []os.FileInfo
+}
+
+var _ afero.File = (*urlFileT)(nil)
+
+func (me *urlFileT) writable() bool {
+	return me.flag&(os.O_WRONLY|os.O_RDWR) != 0
+}
+
+func (me *urlFileT) isDir() bool {
+	return me.info != nil && me.info.IsDir()
+}
+
+// ready 检查文件是否可以读写，需要时下载内容
+func (me *urlFileT) ready(op string) error {
+	if me.closed {
+		return urlFsError(op, me.name, os.ErrClosed)
+	}
+	if me.isDir() {
+		return urlFsError(op, me.name, syscall.EISDIR)
+	}
+	if me.loaded {
+		return nil
+	}
+
+	buf := &bytes.Buffer{}
+	if err := me.file.downloadTo(buf); err != nil {
+		return urlFsError(op, me.name, err)
+	}
+	me.data = buf.Bytes()
+	me.loaded = true
+	return nil
+}
+
+func (me *urlFileT) Name() string {
+	return me.name
+}
+
+func (me *urlFileT) Read(p []byte) (int, error) {
+	n, err := me.ReadAt(p, me.offset)
+	me.offset += int64(n)
+	return n, err
+}
+
+func (me *urlFileT) ReadAt(p []byte, off int64) (int, error) {
+	if err := me.ready("read"); err != nil {
+		return 0, err
+	}
+	if me.flag&os.O_WRONLY != 0 {
+		return 0, urlFsError("read", me.name, os.ErrPermission)
+	}
+	if off < 0 {
+		return 0, urlFsError("read", me.name, os.ErrInvalid)
+	}
+	if off >= int64(len(me.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, me.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (me *urlFileT) Seek(offset int64, whence int) (int64, error) {
+	if err := me.ready("seek"); err != nil {
+		return 0, err
+	}
+	switch whence {
+	case io.SeekCurrent:
+		offset += me.offset
+	case io.SeekEnd:
+		offset += int64(len(me.data))
+	}
+	if offset < 0 {
+		return 0, urlFsError("seek", me.name, os.ErrInvalid)
+	}
+	me.offset = offset
+	return offset, nil
+}
+
+func (me *urlFileT) Write(p []byte) (int, error) {
+	if me.flag&os.O_APPEND != 0 {
+		if err := me.ready("write"); err != nil {
+			return 0, err
+		}
+		me.offset = int64(len(me.data))
+	}
+	n, err := me.WriteAt(p, me.offset)
+	me.offset += int64(n)
+	return n, err
+}
+
+func (me *urlFileT) WriteAt(p []byte, off int64) (int, error) {
+	if err := me.ready("write"); err != nil {
+		return 0, err
+	}
+	if !me.writable() {
+		return 0, urlFsError("write", me.name, os.ErrPermission)
+	}
+	if off < 0 {
+		return 0, urlFsError("write", me.name, os.ErrInvalid)
+	}
+	if end := off + int64(len(p)); end > int64(len(me.data)) {
+		me.data = append(me.data, make([]byte, end-int64(len(me.data)))...)
+	}
+	copy(me.data[off:], p)
+	me.dirty = true
+	return len(p), nil
+}
+
+func (me *urlFileT) WriteString(s string) (int, error) {
+	return me.Write([]byte(s))
+}
+
+func (me *urlFileT) Truncate(size int64) error {
+	if err := me.ready("truncate"); err != nil {
+		return err
+	}
+	if !me.writable() {
+		return urlFsError("truncate", me.name, os.ErrPermission)
+	}
+	if size < 0 {
+		return urlFsError("truncate", me.name, os.ErrInvalid)
+	}
+	if size > int64(len(me.data)) {
+		me.data = append(me.data, make([]byte, size-int64(len(me.data)))...)
+	} else {
+		me.data = me.data[:size]
+	}
+	me.dirty = true
+	return nil
+}
+
+// Sync 上传修改过的内容
+func (me *urlFileT) Sync() error {
+	if me.closed {
+		return urlFsError("sync", me.name, os.ErrClosed)
+	}
+	if !me.dirty {
+		return nil
+	}
+	if err := me.file.Upload(bytes.NewReader(me.data), int64(len(me.data))); err != nil {
+		return urlFsError("sync", me.name, err)
+	}
+	me.dirty = false
+	me.info = urlFileInfo(&FileInfoT{Name: path.Base(me.file.path()), Path: me.file.dir(), Size: int64(len(me.data)), Lastmod: time.Now()})
+	return nil
+}
+
+func (me *urlFileT) Close() error {
+	err := me.Sync()
+	me.closed = true
+	me.data = nil
+	return err
+}
+
+func (me *urlFileT) Stat() (os.FileInfo, error) {
+	if me.closed {
+		return nil, urlFsError("stat", me.name, os.ErrClosed)
+	}
+	if me.dirty || me.info == nil {
+		return urlFileInfo(&FileInfoT{Name: path.Base(me.file.path()), Path: me.file.dir(), Size: int64(len(me.data)), Lastmod: time.Now()}), nil
+	}
+	return me.info, nil
+}
+
+// Readdir 与 os.File.Readdir 相同：count > 0 时最多返回 count 项，没有更多时返回 io.EOF；
+// count <= 0 时返回剩余的全部
+func (me *urlFileT) Readdir(count int) ([]os.FileInfo, error) {
+	if me.closed {
+		return nil, urlFsError("readdir", me.name, os.ErrClosed)
+	}
+	if !me.isDir() {
+		return nil, urlFsError("readdir", me.name, syscall.ENOTDIR)
+	}
+
+	if me.entries == nil {
+		list, err := me.file.List()
+		if err != nil {
+			return nil, urlFsError("readdir", me.name, err)
+		}
+		me.entries = make([]os.FileInfo, 0, len(list))
+		for _, info := range list {
+			me.entries = append(me.entries, urlFileInfo(info))
+		}
+	}
+
+	if count <= 0 {
+		r := me.entries
+		me.entries = me.entries[len(r):]
+		return r, nil
+	}
+	if len(me.entries) == 0 {
+		return nil, io.EOF
+	}
+	count = min(count, len(me.entries))
+	r := me.entries[:count]
+	me.entries = me.entries[count:]
+	return r, nil
+}
+
+func (me *urlFileT) Readdirnames(n int) ([]string, error) {
+	infos, err := me.Readdir(n)
+	r := make([]string, 0, len(infos))
+	for _, info := range infos {
+		r = append(r, info.Name())
+	}
+	return r, err
+}
@@ -0,0 +1,161 @@
+package qio
+
+import (
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+// testUrlFs 通过 MountFs 用已有的文件函数读写 dir 下的远程文件
+func testUrlFs(t *testing.T, fs afero.Fs, dir string, listable bool) {
+	a := require.New(t)
+
+	WriteFileTextP(fs, dir+"/conf/a.yaml", "name: a\n")
+	a.Equal("name: a\n", ReadFileTextP(fs, dir+"/conf/a.yaml"))
+	a.Equal("a", MapFromYamlFileP(fs, dir+"/conf/a.yaml", false)["name"])
+	a.True(FileExistsP(fs, dir+"/conf/a.yaml"))
+	a.False(FileExistsP(fs, dir+"/conf/missing.yaml"))
+
+	_, err := fs.Open(dir + "/conf/missing.yaml")
+	a.True(os.IsNotExist(err))
+
+	// 覆盖写入，再追加
+	WriteFileTextP(fs, dir+"/conf/b.txt", "first")
+	WriteFileTextP(fs, dir+"/conf/b.txt", "hello")
+	f, err := fs.OpenFile(dir+"/conf/b.txt", os.O_WRONLY|os.O_APPEND, 0)
+	a.NoError(err)
+	_, err = f.WriteString(" world")
+	a.NoError(err)
+	a.NoError(f.Close())
+	a.Equal("hello world", ReadFileTextP(fs, dir+"/conf/b.txt"))
+	a.Equal(int64(11), StatP(fs, dir+"/conf/b.txt", true).Size())
+
+	// 只读打开时 Seek 和 ReadAt 可用
+	f, err = fs.Open(dir + "/conf/b.txt")
+	a.NoError(err)
+	a.Equal(dir+"/conf/b.txt", f.Name())
+	_, err = f.Seek(6, io.SeekStart)
+	a.NoError(err)
+	rest, err := io.ReadAll(f)
+	a.NoError(err)
+	a.Equal("world", string(rest))
+	_, err = f.Write([]byte("x"))
+	a.ErrorIs(err, os.ErrPermission)
+	a.NoError(f.Close())
+
+	if listable {
+		a.True(DirExistsP(fs, dir+"/conf"))
+
+		// ListSuffixedFiles 用 filepath.Join 拼出的路径也能直接读取
+		files := ListSuffixedFilesP(fs, dir+"/conf", ".yaml", false)
+		a.Equal(map[string]string{"a": filepath.Join(dir+"/conf", "a.yaml")}, files)
+		a.Equal("name: a\n", ReadFileTextP(fs, files["a"]))
+
+		names, err := afero.ReadDir(fs, dir+"/conf")
+		a.NoError(err)
+		a.Len(names, 2)
+		a.Equal("a.yaml", names[0].Name())
+		a.Equal("b.txt", names[1].Name())
+		a.False(names[1].IsDir())
+	}
+
+	RemoveFileP(fs, dir+"/conf/a.yaml")
+	a.False(FileExistsP(fs, dir+"/conf/a.yaml"))
+
+	if listable {
+		a.NoError(fs.RemoveAll(dir + "/conf"))
+		a.False(FileExistsP(fs, dir+"/conf/b.txt"))
+	}
+	a.NoError(fs.RemoveAll(dir + "/conf/never"))
+}
+
+func TestUrlFs_http(t *testing.T) {
+	store := &testHTTPStore{files: map[string]string{}}
+	ts := httptest.NewServer(store)
+	defer ts.Close()
+
+	fs := NewMountFs(afero.NewMemMapFs())
+	fs.MountUrlP(ts.URL+"/files", &CredentialsT{User: "u", Password: "p"}, 5*time.Second)
+
+	testUrlFs(t, fs, ts.URL+"/files", false)
+
+	_, found := store.read("/files/conf/b.txt")
+	require.True(t, found)
+}
+
+func TestUrlFs_sftp(t *testing.T) {
+	addr := startTestSFTPServer(t)
+
+	fs := NewMountFs(afero.NewMemMapFs())
+	fs.MountUrlP("sftp://"+addr+"/", &CredentialsT{User: "u", Password: "p"}, 5*time.Second)
+
+	testUrlFs(t, fs, "sftp://"+addr+"/data", true)
+}
+
+func TestUrlFs_ftp(t *testing.T) {
+	remote := afero.NewMemMapFs()
+	addr := startTestFTPServer(t, remote)
+
+	fs := NewMountFs(afero.NewMemMapFs())
+	fs.MountUrlP("ftp://"+addr+"/data", &CredentialsT{User: "u", Password: "p"}, 5*time.Second)
+
+	testUrlFs(t, fs, "ftp://"+addr+"/data", true)
+}
+
+func TestUrlFs_s3(t *testing.T) {
+	store := &testS3Store{files: map[string]string{}}
+	ts := httptest.NewServer(store)
+	defer ts.Close()
+	host := strings.TrimPrefix(ts.URL, "http://")
+
+	fs := NewMountFs(afero.NewMemMapFs())
+	fs.MountUrlP("s3://"+host+"/bucket?insecure=true", &CredentialsT{User: "u", Password: "secret"}, 5*time.Second)
+
+	testUrlFs(t, fs, "s3://"+host+"/bucket", true)
+}
+
+func TestUrlFs_openFile(t *testing.T) {
+	a := require.New(t)
+
+	store := &testHTTPStore{files: map[string]string{"/files/a.txt": "abc"}}
+	ts := httptest.NewServer(store)
+	defer ts.Close()
+
+	fs := NewUrlFsP(ts.URL+"/files", &CredentialsT{User: "u", Password: "p"}, 5*time.Second)
+	a.Equal(ts.URL+"/files/a.txt", fs.Url("a.txt"))
+	a.Equal(ts.URL+"/files/a.txt", fs.Url("/x/../a.txt"))
+
+	_, err := fs.OpenFile("/a.txt", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	a.True(os.IsExist(err))
+
+	// 只写打开时不能读，内容在 Close 时才上传
+	f, err := fs.OpenFile("/a.txt", os.O_WRONLY, 0)
+	a.NoError(err)
+	_, err = f.Read(make([]byte, 1))
+	a.ErrorIs(err, os.ErrPermission)
+	_, err = f.WriteAt([]byte("B"), 1)
+	a.NoError(err)
+	a.NoError(f.Truncate(2))
+	content, _ := store.read("/files/a.txt")
+	a.Equal("abc", content)
+	a.NoError(f.Close())
+	content, _ = store.read("/files/a.txt")
+	a.Equal("aB", content)
+
+	_, err = f.Write([]byte("x"))
+	a.ErrorIs(err, os.ErrClosed)
+
+	a.Error(fs.Rename("/a.txt", "/b.txt"))
+	a.Error(fs.Chmod("/a.txt", 0o600))
+	a.NoError(fs.MkdirAll("/any", 0o755))
+
+	_, err = NewUrlFs("/local/dir", nil, 5*time.Second)
+	a.Error(err)
+}
@@ -0,0 +1,84 @@
+package qnet
+
+import (
+	"math/big"
+	"net"
+
+	"github.com/pkg/errors"
+	"github.com/qiangyt/go-comm/v3/qerr"
+)
+
+// maxCIDRSplit limits the number of subnets SplitCIDR returns
+const maxCIDRSplit = 1 << 16
+
+func ParseCIDRP(s string) *net.IPNet {
+	r, err := ParseCIDR(s)
+	if err != nil {
+		panic(qerr.NewSystemError("parse CIDR", err))
+	}
+	return r
+}
+
+// ParseCIDR parses "192.168.1.0/24" or "fd00::/8" into the network it denotes,
+// host bits are cleared and IPv4 addresses are 4 bytes long
+func ParseCIDR(s string) (*net.IPNet, error) {
+	_, r, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse CIDR '%s'", s)
+	}
+	return r, nil
+}
+
+// CIDRContains tells whether inner lies entirely within outer. Networks of different
+// address families never contain each other.
+func CIDRContains(outer *net.IPNet, inner *net.IPNet) bool {
+	outerOnes, outerBits := outer.Mask.Size()
+	innerOnes, innerBits := inner.Mask.Size()
+	if outerBits != innerBits || innerOnes < outerOnes {
+		return false
+	}
+	return outer.Contains(inner.IP)
+}
+
+// CIDROverlaps tells whether two networks share at least one address
+func CIDROverlaps(a *net.IPNet, b *net.IPNet) bool {
+	return CIDRContains(a, b) || CIDRContains(b, a)
+}
+
+func SplitCIDRP(cidr *net.IPNet, prefix int) []*net.IPNet {
+	r, err := SplitCIDR(cidr, prefix)
+	if err != nil {
+		panic(qerr.NewSystemError("split CIDR", err))
+	}
+	return r
+}
+
+// SplitCIDR divides cidr into consecutive subnets of the given prefix length,
+// e.g. 10.0.0.0/24 into four /26 networks
+func SplitCIDR(cidr *net.IPNet, prefix int) ([]*net.IPNet, error) {
+	ones, bits := cidr.Mask.Size()
+	if bits == 0 {
+		return nil, errors.Errorf("non-canonical mask in CIDR %s", cidr)
+	}
+	if prefix < ones || prefix > bits {
+		return nil, errors.Errorf("cannot split %s into /%d networks", cidr, prefix)
+	}
+	if prefix-ones > 16 {
+		return nil, errors.Errorf("splitting %s into /%d networks exceeds %d subnets", cidr, prefix, maxCIDRSplit)
+	}
+
+	size := bits / 8
+	base := new(big.Int).SetBytes(cidr.IP.Mask(cidr.Mask))
+	step := new(big.Int).Lsh(big.NewInt(1), uint(bits-prefix))
+	mask := net.CIDRMask(prefix, bits)
+
+	count := 1 << (prefix - ones)
+	r := make([]*net.IPNet, 0, count)
+	for i := 0; i < count; i++ {
+		ip := make(net.IP, size)
+		base.FillBytes(ip)
+		r = append(r, &net.IPNet{IP: ip, Mask: mask})
+		base.Add(base, step)
+	}
+	return r, nil
+}
@@ -0,0 +1,66 @@
+package qnet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCIDR(t *testing.T) {
+	a := require.New(t)
+
+	r, err := ParseCIDR("192.168.1.77/24")
+	a.NoError(err)
+	a.Equal("192.168.1.0/24", r.String())
+	a.Len(r.IP, 4)
+
+	_, err = ParseCIDR("192.168.1.0")
+	a.Error(err)
+	a.Panics(func() { ParseCIDRP("bad") })
+}
+
+func TestCIDRContains(t *testing.T) {
+	a := require.New(t)
+
+	a.True(CIDRContains(ParseCIDRP("10.0.0.0/8"), ParseCIDRP("10.1.0.0/16")))
+	a.True(CIDRContains(ParseCIDRP("10.0.0.0/8"), ParseCIDRP("10.0.0.0/8")))
+	a.False(CIDRContains(ParseCIDRP("10.1.0.0/16"), ParseCIDRP("10.0.0.0/8")))
+	a.False(CIDRContains(ParseCIDRP("10.0.0.0/8"), ParseCIDRP("11.0.0.0/16")))
+	a.True(CIDRContains(ParseCIDRP("fd00::/8"), ParseCIDRP("fd12:3456::/32")))
+	a.False(CIDRContains(ParseCIDRP("::/0"), ParseCIDRP("10.0.0.0/8")))
+	a.False(CIDRContains(ParseCIDRP("0.0.0.0/0"), ParseCIDRP("::ffff:0:0/96")))
+}
+
+func TestCIDROverlaps(t *testing.T) {
+	a := require.New(t)
+
+	a.True(CIDROverlaps(ParseCIDRP("10.1.0.0/16"), ParseCIDRP("10.0.0.0/8")))
+	a.True(CIDROverlaps(ParseCIDRP("10.0.0.0/8"), ParseCIDRP("10.1.2.3/32")))
+	a.False(CIDROverlaps(ParseCIDRP("10.0.0.0/24"), ParseCIDRP("10.0.1.0/24")))
+	a.False(CIDROverlaps(ParseCIDRP("172.16.0.0/12"), ParseCIDRP("192.168.0.0/16")))
+	a.False(CIDROverlaps(ParseCIDRP("fd00::/8"), ParseCIDRP("fe80::/10")))
+}
+
+func TestSplitCIDR(t *testing.T) {
+	a := require.New(t)
+
+	split := func(cidr string, prefix int) []string {
+		r := []string{}
+		for _, n := range SplitCIDRP(ParseCIDRP(cidr), prefix) {
+			r = append(r, n.String())
+		}
+		return r
+	}
+
+	a.Equal([]string{"10.0.0.0/26", "10.0.0.64/26", "10.0.0.128/26", "10.0.0.192/26"}, split("10.0.0.0/24", 26))
+	a.Equal([]string{"192.168.1.0/24"}, split("192.168.1.0/24", 24))
+	a.Equal([]string{"fd00::/9", "fd80::/9"}, split("fd00::/8", 9))
+	a.Len(split("0.0.0.0/0", 16), 65536)
+
+	_, err := SplitCIDR(ParseCIDRP("10.0.0.0/24"), 23)
+	a.Error(err)
+	_, err = SplitCIDR(ParseCIDRP("10.0.0.0/24"), 33)
+	a.Error(err)
+	_, err = SplitCIDR(ParseCIDRP("10.0.0.0/8"), 32)
+	a.Error(err)
+}
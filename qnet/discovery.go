@@ -0,0 +1,723 @@
+package qnet
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/qiangyt/go-comm/v3/qerr"
+	"golang.org/x/net/ipv4"
+)
+
+// DiscoveryProtocol is a way of announcing services on the local network
+type DiscoveryProtocol string
+
+const (
+	// DiscoveryBroadcast sends JSON datagrams to the IPv4 broadcast address
+	DiscoveryBroadcast DiscoveryProtocol = "broadcast"
+	// DiscoveryMDNS uses multicast DNS service discovery (RFC 6762/6763) over IPv4
+	DiscoveryMDNS DiscoveryProtocol = "mdns"
+)
+
+const (
+	DefaultDiscoveryPort = 47600
+	DefaultDiscoveryTTL  = 2 * time.Minute
+)
+
+var (
+	DefaultDiscoveryBroadcastAddr = &net.UDPAddr{IP: net.IPv4bcast, Port: DefaultDiscoveryPort}
+	DefaultDiscoveryMDNSAddr      = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+)
+
+// discoveryExpiryInterval is how often a Browser looks for expired services
+const discoveryExpiryInterval = 200 * time.Millisecond
+
+// maxPendingDiscoveryEvents caps the events a Browser keeps for a consumer that doesn't read Events
+const maxPendingDiscoveryEvents = 1024
+
+// Service is a service instance on the local network
+type Service struct {
+	// Name is the service type shared by all instances, e.g. "agent"
+	Name string
+	// Instance identifies the announcer, "<hostname>-<port>" by default
+	Instance string
+	// Host is the announcer's host name, the local host name by default
+	Host string
+	Port int
+	Meta map[string]string
+
+	// Addrs are announced along with the service, the IPv4 addresses of the up, non-loopback
+	// interfaces by default. For a discovered service the packet source comes first.
+	Addrs []net.IP
+	// TTL is how long a discovered service stays known without being announced again
+	TTL time.Duration
+	// Protocols lists the protocols a discovered service was seen by
+	Protocols []DiscoveryProtocol
+}
+
+// Key identifies a service instance
+func (me *Service) Key() string {
+	return strings.ToLower(me.Name + "/" + me.Instance)
+}
+
+func (me *Service) String() string {
+	return fmt.Sprintf("%s/%s@%s:%d", me.Name, me.Instance, me.Host, me.Port)
+}
+
+// Addr returns "ip:port" of the first known address, or "host:port" when no address is known
+func (me *Service) Addr() string {
+	host := me.Host
+	if len(me.Addrs) > 0 {
+		host = me.Addrs[0].String()
+	}
+	return net.JoinHostPort(host, fmt.Sprint(me.Port))
+}
+
+func (me *Service) clone() *Service {
+	r := *me
+	r.Meta = maps.Clone(me.Meta)
+	r.Addrs = slices.Clone(me.Addrs)
+	r.Protocols = slices.Clone(me.Protocols)
+	return &r
+}
+
+// sameAs tells whether the announced content of two services is equal
+func (me *Service) sameAs(other *Service) bool {
+	return me.Host == other.Host && me.Port == other.Port && maps.Equal(me.Meta, other.Meta) &&
+		slices.EqualFunc(me.Addrs, other.Addrs, net.IP.Equal) && slices.Equal(me.Protocols, other.Protocols)
+}
+
+// validateDiscoveryLabel checks that s fits into a single DNS label
+func validateDiscoveryLabel(what string, s string) error {
+	if len(s) == 0 || len(s) > 63 {
+		return errors.Errorf("service %s must have 1 to 63 characters: '%s'", what, s)
+	}
+	if strings.ContainsAny(s, ".\\") {
+		return errors.Errorf("service %s must not contain '.' or '\\': '%s'", what, s)
+	}
+	return nil
+}
+
+// DiscoveryOptions configures announcing and browsing; the zero value uses both protocols on
+// all interfaces with their default addresses
+type DiscoveryOptions struct {
+	Protocols []DiscoveryProtocol
+
+	// BroadcastAddr is where broadcast announcements are sent and received; defaults to the
+	// broadcast address of Interface, or 255.255.255.255, on DefaultDiscoveryPort
+	BroadcastAddr *net.UDPAddr
+	// MDNSAddr defaults to 224.0.0.251:5353. A non-multicast address is treated as a
+	// broadcast address, which allows using mDNS over the loopback broadcast 127.255.255.255.
+	MDNSAddr *net.UDPAddr
+	// Interface restricts the multicast group membership and the default broadcast address
+	Interface *net.Interface
+
+	// TTL of announcements, DefaultDiscoveryTTL by default
+	TTL time.Duration
+	// Interval between announcements, a third of TTL by default
+	Interval time.Duration
+}
+
+func (me *DiscoveryOptions) protocols() []DiscoveryProtocol {
+	if me == nil || len(me.Protocols) == 0 {
+		return []DiscoveryProtocol{DiscoveryBroadcast, DiscoveryMDNS}
+	}
+	return me.Protocols
+}
+
+func (me *DiscoveryOptions) ttl() time.Duration {
+	if me == nil || me.TTL <= 0 {
+		return DefaultDiscoveryTTL
+	}
+	if me.TTL < time.Second {
+		return time.Second
+	}
+	return me.TTL
+}
+
+func (me *DiscoveryOptions) interval() time.Duration {
+	if me == nil || me.Interval <= 0 {
+		return me.ttl() / 3
+	}
+	return me.Interval
+}
+
+func (me *DiscoveryOptions) addr(protocol DiscoveryProtocol) (*net.UDPAddr, error) {
+	var intf *net.Interface
+	if me != nil {
+		intf = me.Interface
+		if protocol == DiscoveryBroadcast && me.BroadcastAddr != nil {
+			return me.BroadcastAddr, nil
+		}
+		if protocol == DiscoveryMDNS && me.MDNSAddr != nil {
+			return me.MDNSAddr, nil
+		}
+	}
+
+	switch protocol {
+	case DiscoveryMDNS:
+		return DefaultDiscoveryMDNSAddr, nil
+	case DiscoveryBroadcast:
+		if intf == nil {
+			return DefaultDiscoveryBroadcastAddr, nil
+		}
+		ip, err := BroadcastIpWithInterface(*intf)
+		if err != nil {
+			return nil, err
+		}
+		if ip == nil {
+			return nil, errors.Errorf("no IPv4 broadcast address on interface %s", intf.Name)
+		}
+		return &net.UDPAddr{IP: ip, Port: DefaultDiscoveryPort}, nil
+	}
+	return nil, errors.Errorf("unknown discovery protocol: %s", protocol)
+}
+
+// ============================================================
+// Transport
+// ============================================================
+
+// discoveryMessage is a decoded datagram
+type discoveryMessage struct {
+	// queries are the service names asked for
+	queries []string
+	// services are announced services, TTL 0 announces that a service is leaving
+	services []*Service
+}
+
+// discoveryCodec encodes and decodes the datagrams of one protocol
+type discoveryCodec interface {
+	encodeAnnouncement(service *Service, ttl time.Duration) ([]byte, error)
+	encodeQuery(name string) ([]byte, error)
+	decode(data []byte) (*discoveryMessage, error)
+}
+
+// discoveryConn is a UDP socket shared with other announcers and browsers on the same port
+type discoveryConn struct {
+	protocol DiscoveryProtocol
+	codec    discoveryCodec
+	conn     *net.UDPConn
+	addr     *net.UDPAddr
+}
+
+func listenDiscovery(protocol DiscoveryProtocol, options *DiscoveryOptions) (*discoveryConn, error) {
+	addr, err := options.addr(protocol)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &discoveryConn{protocol: protocol, addr: addr}
+	switch protocol {
+	case DiscoveryBroadcast:
+		r.codec = broadcastCodec{}
+	case DiscoveryMDNS:
+		r.codec = mdnsCodec{}
+	}
+
+	config := net.ListenConfig{Control: controlDiscoverySocket}
+	conn, err := config.ListenPacket(context.Background(), "udp4", fmt.Sprintf("0.0.0.0:%d", addr.Port))
+	if err != nil {
+		return nil, errors.Wrapf(err, "listen on %s port %d", protocol, addr.Port)
+	}
+	r.conn = conn.(*net.UDPConn)
+
+	if addr.IP.IsMulticast() {
+		var intf *net.Interface
+		if options != nil {
+			intf = options.Interface
+		}
+		p := ipv4.NewPacketConn(r.conn)
+		if err := p.JoinGroup(intf, &net.UDPAddr{IP: addr.IP}); err != nil {
+			r.conn.Close()
+			return nil, errors.Wrapf(err, "join multicast group %s", addr.IP)
+		}
+		if intf != nil {
+			p.SetMulticastInterface(intf)
+		}
+		p.SetMulticastLoopback(true)
+	}
+	return r, nil
+}
+
+func (me *discoveryConn) send(data []byte) error {
+	_, err := me.conn.WriteToUDP(data, me.addr)
+	return errors.Wrapf(err, "send %s datagram to %s", me.protocol, me.addr)
+}
+
+// read calls handle for each valid datagram until the socket is closed
+func (me *discoveryConn) read(handle func(message *discoveryMessage, from *net.UDPAddr)) {
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := me.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		message, err := me.codec.decode(buf[:n])
+		if err != nil {
+			// not ours, or malformed
+			continue
+		}
+		handle(message, from)
+	}
+}
+
+// ============================================================
+// Announcer
+// ============================================================
+
+// Announcer periodically announces a service and answers queries for it
+type Announcer struct {
+	service *Service
+	ttl     time.Duration
+	conns   []*discoveryConn
+
+	done      chan struct{}
+	closeOnce sync.Once
+	stop      func() bool
+	wait      sync.WaitGroup
+}
+
+func AnnounceP(ctx context.Context, service *Service, options *DiscoveryOptions) *Announcer {
+	r, err := Announce(ctx, service, options)
+	if err != nil {
+		panic(qerr.NewSystemError("announce service", err))
+	}
+	return r
+}
+
+// Announce starts announcing service until ctx is cancelled or Close is called, both of which
+// tell browsers that the service is leaving. Only Name and Port are required.
+func Announce(ctx context.Context, service *Service, options *DiscoveryOptions) (*Announcer, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	s := service.clone()
+	if len(s.Host) == 0 {
+		hostname, err := Hostname()
+		if err != nil {
+			return nil, errors.Wrap(err, "get hostname")
+		}
+		s.Host = strings.SplitN(hostname, ".", 2)[0]
+	}
+	if len(s.Instance) == 0 {
+		s.Instance = fmt.Sprintf("%s-%d", strings.ReplaceAll(s.Host, ".", "-"), s.Port)
+	}
+	if err := validateDiscoveryLabel("name", s.Name); err != nil {
+		return nil, err
+	}
+	if err := validateDiscoveryLabel("instance", s.Instance); err != nil {
+		return nil, err
+	}
+	if s.Port <= 0 || s.Port > 65535 {
+		return nil, errors.Errorf("bad service port: %d", s.Port)
+	}
+	if len(s.Addrs) == 0 {
+		s.Addrs = localDiscoveryAddrs(options)
+	}
+
+	r := &Announcer{service: s, ttl: options.ttl(), done: make(chan struct{})}
+	for _, protocol := range options.protocols() {
+		conn, err := listenDiscovery(protocol, options)
+		if err != nil {
+			r.closeConns()
+			return nil, err
+		}
+		r.conns = append(r.conns, conn)
+	}
+
+	for _, conn := range r.conns {
+		if err := r.announce(conn, r.ttl); err != nil {
+			r.closeConns()
+			return nil, err
+		}
+	}
+
+	for _, conn := range r.conns {
+		r.wait.Add(1)
+		go func() {
+			defer r.wait.Done()
+			conn.read(func(message *discoveryMessage, from *net.UDPAddr) {
+				for _, name := range message.queries {
+					if strings.EqualFold(name, s.Name) {
+						r.announce(conn, r.ttl)
+						return
+					}
+				}
+			})
+		}()
+	}
+
+	r.wait.Add(1)
+	go r.repeat(options.interval())
+	r.stop = context.AfterFunc(ctx, func() { r.Close() })
+	return r, nil
+}
+
+// localDiscoveryAddrs returns the IPv4 addresses of Interface, or of all up, non-loopback interfaces
+func localDiscoveryAddrs(options *DiscoveryOptions) []net.IP {
+	var r []net.IP
+	infos, _ := Interfaces()
+	for _, info := range infos {
+		if options != nil && options.Interface != nil && options.Interface.Name != info.Name {
+			continue
+		}
+		if !info.IsUp() || info.IsLoopback() {
+			continue
+		}
+		for _, addr := range info.IPv4 {
+			r = append(r, addr.IP)
+		}
+	}
+	return r
+}
+
+// Service returns the announced service with defaults filled in
+func (me *Announcer) Service() *Service {
+	return me.service.clone()
+}
+
+func (me *Announcer) announce(conn *discoveryConn, ttl time.Duration) error {
+	data, err := conn.codec.encodeAnnouncement(me.service, ttl)
+	if err != nil {
+		return err
+	}
+	return conn.send(data)
+}
+
+func (me *Announcer) repeat(interval time.Duration) {
+	defer me.wait.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-me.done:
+			return
+		case <-ticker.C:
+			for _, conn := range me.conns {
+				me.announce(conn, me.ttl)
+			}
+		}
+	}
+}
+
+// Close stops announcing and tells browsers that the service is leaving
+func (me *Announcer) Close() error {
+	me.closeOnce.Do(func() {
+		if me.stop != nil {
+			me.stop()
+		}
+		close(me.done)
+		for _, conn := range me.conns {
+			me.announce(conn, 0)
+		}
+		me.closeConns()
+		me.wait.Wait()
+	})
+	return nil
+}
+
+func (me *Announcer) closeConns() {
+	for _, conn := range me.conns {
+		conn.conn.Close()
+	}
+}
+
+// ============================================================
+// Browser
+// ============================================================
+
+// DiscoveryEventType is the kind of change a Browser reports
+type DiscoveryEventType string
+
+const (
+	DiscoveryJoin   DiscoveryEventType = "join"
+	DiscoveryUpdate DiscoveryEventType = "update"
+	DiscoveryLeave  DiscoveryEventType = "leave"
+)
+
+// DiscoveryEvent reports a service that joined, changed or left
+type DiscoveryEvent struct {
+	Type    DiscoveryEventType
+	Service *Service
+}
+
+// pendingDiscoveryEvent is an event waiting for deliver and the key of its service
+type pendingDiscoveryEvent struct {
+	key string
+	DiscoveryEvent
+}
+
+// discoveredService tracks a service and when each protocol last saw it
+type discoveredService struct {
+	service *Service
+	expires map[DiscoveryProtocol]time.Time
+}
+
+// Browser keeps track of the instances of a service on the local network
+type Browser struct {
+	name  string
+	conns []*discoveryConn
+
+	mutex    sync.Mutex
+	services map[string]*discoveredService
+	pending  []pendingDiscoveryEvent // events waiting for deliver, at most one per service, guarded by mutex
+	notify   chan struct{}
+	events   chan DiscoveryEvent
+
+	done      chan struct{}
+	closeOnce sync.Once
+	stop      func() bool
+	wait      sync.WaitGroup
+}
+
+func BrowseP(ctx context.Context, name string, options *DiscoveryOptions) *Browser {
+	r, err := Browse(ctx, name, options)
+	if err != nil {
+		panic(qerr.NewSystemError("browse services", err))
+	}
+	return r
+}
+
+// Browse looks for instances of the named service until ctx is cancelled or Close is called.
+// Changes are reported by Events, and a service leaves when it says so or its TTL expires.
+func Browse(ctx context.Context, name string, options *DiscoveryOptions) (*Browser, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := validateDiscoveryLabel("name", name); err != nil {
+		return nil, err
+	}
+
+	r := &Browser{
+		name:     name,
+		services: map[string]*discoveredService{},
+		notify:   make(chan struct{}, 1),
+		events:   make(chan DiscoveryEvent, 64),
+		done:     make(chan struct{}),
+	}
+	for _, protocol := range options.protocols() {
+		conn, err := listenDiscovery(protocol, options)
+		if err != nil {
+			r.closeConns()
+			return nil, err
+		}
+		r.conns = append(r.conns, conn)
+	}
+
+	for _, conn := range r.conns {
+		r.wait.Add(1)
+		go func() {
+			defer r.wait.Done()
+			conn.read(func(message *discoveryMessage, from *net.UDPAddr) {
+				for _, s := range message.services {
+					r.handle(conn.protocol, s, from)
+				}
+			})
+		}()
+	}
+	r.wait.Add(2)
+	go r.expire()
+	go r.deliver()
+
+	// ask running announcers to answer now instead of at their next interval
+	for _, conn := range r.conns {
+		data, err := conn.codec.encodeQuery(name)
+		if err == nil {
+			err = conn.send(data)
+		}
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+	}
+
+	r.stop = context.AfterFunc(ctx, func() { r.Close() })
+	return r, nil
+}
+
+// Events reports joins, updates and leaves; it is closed by Close.
+// While the consumer lags behind, the queued events of a service are merged into one that
+// carries its latest state, and at most 1024 services are queued: the oldest are dropped beyond that
+func (me *Browser) Events() <-chan DiscoveryEvent {
+	return me.events
+}
+
+// Services returns the currently known instances, ordered by instance name
+func (me *Browser) Services() []*Service {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+
+	r := make([]*Service, 0, len(me.services))
+	for _, d := range me.services {
+		r = append(r, d.service.clone())
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].Instance < r[j].Instance })
+	return r
+}
+
+func (me *Browser) handle(protocol DiscoveryProtocol, s *Service, from *net.UDPAddr) {
+	if !strings.EqualFold(s.Name, me.name) {
+		return
+	}
+
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+
+	key := s.Key()
+	d := me.services[key]
+	if s.TTL <= 0 {
+		if d != nil {
+			delete(d.expires, protocol)
+			me.update(key, d)
+		}
+		return
+	}
+
+	// the packet source is the address the service is known to be reachable at
+	s.Addrs = append([]net.IP{from.IP.To4()}, slices.DeleteFunc(s.Addrs, from.IP.Equal)...)
+	if d == nil {
+		d = &discoveredService{expires: map[DiscoveryProtocol]time.Time{}}
+		me.services[key] = d
+	}
+	d.expires[protocol] = time.Now().Add(s.TTL)
+
+	// the protocol seen last decides the content
+	s.Protocols = slices.Sorted(maps.Keys(d.expires))
+	old := d.service
+	d.service = s
+	if old == nil {
+		me.emit(key, DiscoveryJoin, s)
+	} else if !s.sameAs(old) {
+		me.emit(key, DiscoveryUpdate, s)
+	}
+}
+
+// update removes the service when no protocol sees it anymore, must be called with the mutex held
+func (me *Browser) update(key string, d *discoveredService) {
+	if len(d.expires) == 0 {
+		delete(me.services, key)
+		me.emit(key, DiscoveryLeave, d.service)
+		return
+	}
+
+	protocols := slices.Sorted(maps.Keys(d.expires))
+	if !slices.Equal(protocols, d.service.Protocols) {
+		d.service = d.service.clone()
+		d.service.Protocols = protocols
+		me.emit(key, DiscoveryUpdate, d.service)
+	}
+}
+
+// emit queues an event for deliver, must be called with the mutex held.
+// It never blocks, so a consumer that stops reading Events doesn't stall Services or the packet handlers;
+// the queue stays bounded because an event is merged with the one already queued for the same service
+func (me *Browser) emit(key string, t DiscoveryEventType, s *Service) {
+	e := DiscoveryEvent{Type: t, Service: s.clone()}
+
+	if i := slices.IndexFunc(me.pending, func(p pendingDiscoveryEvent) bool { return p.key == key }); i >= 0 {
+		switch queued := me.pending[i].Type; {
+		case queued == DiscoveryJoin && t == DiscoveryLeave:
+			// the consumer never saw the service
+			me.pending = slices.Delete(me.pending, i, i+1)
+			return
+		case queued == DiscoveryJoin:
+			e.Type = DiscoveryJoin
+		case queued == DiscoveryLeave && t == DiscoveryJoin:
+			// the consumer still knows the service
+			e.Type = DiscoveryUpdate
+		}
+		me.pending[i].DiscoveryEvent = e
+		return
+	}
+
+	if len(me.pending) >= maxPendingDiscoveryEvents {
+		me.pending = slices.Delete(me.pending, 0, len(me.pending)-maxPendingDiscoveryEvents+1)
+	}
+	me.pending = append(me.pending, pendingDiscoveryEvent{key: key, DiscoveryEvent: e})
+	select {
+	case me.notify <- struct{}{}:
+	default:
+	}
+}
+
+// deliver sends the queued events to Events in order until the browser is closed
+func (me *Browser) deliver() {
+	defer me.wait.Done()
+
+	for {
+		select {
+		case <-me.done:
+			return
+		case <-me.notify:
+		}
+
+		me.mutex.Lock()
+		events := me.pending
+		me.pending = nil
+		me.mutex.Unlock()
+
+		for _, e := range events {
+			select {
+			case me.events <- e.DiscoveryEvent:
+			case <-me.done:
+				return
+			}
+		}
+	}
+}
+
+func (me *Browser) expire() {
+	defer me.wait.Done()
+
+	ticker := time.NewTicker(discoveryExpiryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-me.done:
+			return
+		case now := <-ticker.C:
+			me.mutex.Lock()
+			for key, d := range me.services {
+				for protocol, expires := range d.expires {
+					if now.After(expires) {
+						delete(d.expires, protocol)
+					}
+				}
+				me.update(key, d)
+			}
+			me.mutex.Unlock()
+		}
+	}
+}
+
+// Close stops browsing and closes Events
+func (me *Browser) Close() error {
+	me.closeOnce.Do(func() {
+		if me.stop != nil {
+			me.stop()
+		}
+		close(me.done)
+		me.closeConns()
+		me.wait.Wait()
+		close(me.events)
+	})
+	return nil
+}
+
+func (me *Browser) closeConns() {
+	for _, conn := range me.conns {
+		conn.conn.Close()
+	}
+}
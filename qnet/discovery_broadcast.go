@@ -0,0 +1,90 @@
+package qnet
+
+import (
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/qiangyt/go-comm/v3/qjson"
+)
+
+// broadcastDiscoveryMagic marks datagrams of the broadcast protocol
+const broadcastDiscoveryMagic = "qnet.discovery/1"
+
+// broadcastDatagram is the JSON datagram of the broadcast protocol, e.g.
+// {"magic":"qnet.discovery/1","op":"announce","name":"agent","instance":"box-8080","host":"box","port":8080,"ttl":120}
+type broadcastDatagram struct {
+	Magic    string            `json:"magic"`
+	Op       string            `json:"op"`
+	Name     string            `json:"name"`
+	Instance string            `json:"instance,omitempty"`
+	Host     string            `json:"host,omitempty"`
+	Port     int               `json:"port,omitempty"`
+	Meta     map[string]string `json:"meta,omitempty"`
+	Addrs    []string          `json:"addrs,omitempty"`
+	// TTL in seconds, 0 when the service is leaving
+	TTL int `json:"ttl,omitempty"`
+}
+
+const (
+	broadcastOpAnnounce = "announce"
+	broadcastOpQuery    = "query"
+)
+
+type broadcastCodec struct{}
+
+func (me broadcastCodec) encodeAnnouncement(service *Service, ttl time.Duration) ([]byte, error) {
+	addrs := make([]string, 0, len(service.Addrs))
+	for _, ip := range service.Addrs {
+		addrs = append(addrs, ip.String())
+	}
+	return qjson.MarshalJSON(&broadcastDatagram{
+		Magic:    broadcastDiscoveryMagic,
+		Op:       broadcastOpAnnounce,
+		Name:     service.Name,
+		Instance: service.Instance,
+		Host:     service.Host,
+		Port:     service.Port,
+		Meta:     service.Meta,
+		Addrs:    addrs,
+		TTL:      int(ttl / time.Second),
+	})
+}
+
+func (me broadcastCodec) encodeQuery(name string) ([]byte, error) {
+	return qjson.MarshalJSON(&broadcastDatagram{Magic: broadcastDiscoveryMagic, Op: broadcastOpQuery, Name: name})
+}
+
+func (me broadcastCodec) decode(data []byte) (*discoveryMessage, error) {
+	var d broadcastDatagram
+	if err := qjson.UnmarshalJSON(data, &d); err != nil {
+		return nil, err
+	}
+	if d.Magic != broadcastDiscoveryMagic {
+		return nil, errors.New("not a discovery datagram")
+	}
+
+	switch d.Op {
+	case broadcastOpQuery:
+		return &discoveryMessage{queries: []string{d.Name}}, nil
+	case broadcastOpAnnounce:
+		if validateDiscoveryLabel("name", d.Name) != nil || validateDiscoveryLabel("instance", d.Instance) != nil {
+			return nil, errors.New("bad service in discovery datagram")
+		}
+		s := &Service{
+			Name:     d.Name,
+			Instance: d.Instance,
+			Host:     d.Host,
+			Port:     d.Port,
+			Meta:     d.Meta,
+			TTL:      time.Duration(d.TTL) * time.Second,
+		}
+		for _, addr := range d.Addrs {
+			if ip := net.ParseIP(addr); ip != nil {
+				s.Addrs = append(s.Addrs, ip)
+			}
+		}
+		return &discoveryMessage{services: []*Service{s}}, nil
+	}
+	return nil, errors.Errorf("unknown discovery operation: %s", d.Op)
+}
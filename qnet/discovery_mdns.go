@@ -0,0 +1,227 @@
+package qnet
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// mdnsCacheFlush is the cache-flush bit of the record class, which tells caches that the
+// record replaces what they have for the name (RFC 6762 section 10.2)
+const mdnsCacheFlush = 0x8000
+
+// mDNS names of a service, e.g. "_agent._tcp.local.", "box-8080._agent._tcp.local." and "box.local."
+func mdnsTypeName(name string) string {
+	return "_" + name + "._tcp.local."
+}
+
+func mdnsInstanceName(name string, instance string) string {
+	return instance + "." + mdnsTypeName(name)
+}
+
+func mdnsHostName(host string) string {
+	return host + ".local."
+}
+
+// mdnsCodec encodes announcements as DNS-SD responses (PTR, SRV, TXT and A records)
+// and queries as PTR questions for the service type
+type mdnsCodec struct{}
+
+func (me mdnsCodec) encodeAnnouncement(service *Service, ttl time.Duration) ([]byte, error) {
+	typeName, err := dnsmessage.NewName(mdnsTypeName(service.Name))
+	if err != nil {
+		return nil, errors.Wrapf(err, "mdns name of service %s", service)
+	}
+	instanceName, err := dnsmessage.NewName(mdnsInstanceName(service.Name, service.Instance))
+	if err != nil {
+		return nil, errors.Wrapf(err, "mdns name of service %s", service)
+	}
+	hostName, err := dnsmessage.NewName(mdnsHostName(service.Host))
+	if err != nil {
+		return nil, errors.Wrapf(err, "mdns name of service %s", service)
+	}
+
+	keys := make([]string, 0, len(service.Meta))
+	for k := range service.Meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	txt := []string{}
+	for _, k := range keys {
+		kv := k + "=" + service.Meta[k]
+		if len(kv) > 255 {
+			return nil, errors.Errorf("metadata '%s' of service %s is longer than 255 bytes", k, service)
+		}
+		txt = append(txt, kv)
+	}
+	if len(txt) == 0 {
+		// a TXT record must have at least one string
+		txt = append(txt, "")
+	}
+
+	seconds := uint32(ttl / time.Second)
+	header := func(name dnsmessage.Name, class dnsmessage.Class) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{Name: name, Class: class, TTL: seconds}
+	}
+	unique := dnsmessage.ClassINET | mdnsCacheFlush
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{Response: true, Authoritative: true})
+	b.EnableCompression()
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+	if err := b.PTRResource(header(typeName, dnsmessage.ClassINET), dnsmessage.PTRResource{PTR: instanceName}); err != nil {
+		return nil, err
+	}
+	if err := b.SRVResource(header(instanceName, unique), dnsmessage.SRVResource{Target: hostName, Port: uint16(service.Port)}); err != nil {
+		return nil, err
+	}
+	if err := b.TXTResource(header(instanceName, unique), dnsmessage.TXTResource{TXT: txt}); err != nil {
+		return nil, err
+	}
+	for _, ip := range service.Addrs {
+		if ip4 := ip.To4(); ip4 != nil {
+			err = b.AResource(header(hostName, unique), dnsmessage.AResource{A: [4]byte(ip4)})
+		} else {
+			err = b.AAAAResource(header(hostName, unique), dnsmessage.AAAAResource{AAAA: [16]byte(ip.To16())})
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+func (me mdnsCodec) encodeQuery(name string) ([]byte, error) {
+	typeName, err := dnsmessage.NewName(mdnsTypeName(name))
+	if err != nil {
+		return nil, errors.Wrapf(err, "mdns name of service %s", name)
+	}
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{})
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(dnsmessage.Question{Name: typeName, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
+
+func (me mdnsCodec) decode(data []byte) (*discoveryMessage, error) {
+	var p dnsmessage.Parser
+	header, err := p.Start(data)
+	if err != nil {
+		return nil, err
+	}
+	questions, err := p.AllQuestions()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &discoveryMessage{}
+	if !header.Response {
+		for _, q := range questions {
+			if q.Type != dnsmessage.TypePTR && q.Type != dnsmessage.TypeALL {
+				continue
+			}
+			if name, ok := mdnsServiceOfType(q.Name.String()); ok {
+				r.queries = append(r.queries, name)
+			}
+		}
+		return r, nil
+	}
+
+	// known-answer and additional sections carry the SRV, TXT and address records
+	var records []dnsmessage.Resource
+	for _, all := range []func() ([]dnsmessage.Resource, error){p.AllAnswers, p.AllAuthorities, p.AllAdditionals} {
+		section, err := all()
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, section...)
+	}
+	lookup := func(name string, t dnsmessage.Type) []dnsmessage.Resource {
+		var found []dnsmessage.Resource
+		for _, record := range records {
+			if record.Header.Type == t && strings.EqualFold(record.Header.Name.String(), name) {
+				found = append(found, record)
+			}
+		}
+		return found
+	}
+
+	for _, record := range records {
+		ptr, ok := record.Body.(*dnsmessage.PTRResource)
+		if !ok {
+			continue
+		}
+		name, ok := mdnsServiceOfType(record.Header.Name.String())
+		if !ok {
+			continue
+		}
+		instanceName := ptr.PTR.String()
+		suffix := "." + mdnsTypeName(name)
+		if len(instanceName) <= len(suffix) || !strings.EqualFold(instanceName[len(instanceName)-len(suffix):], suffix) {
+			continue
+		}
+
+		s := &Service{
+			Name:     name,
+			Instance: instanceName[:len(instanceName)-len(suffix)],
+			TTL:      time.Duration(record.Header.TTL) * time.Second,
+		}
+		if validateDiscoveryLabel("instance", s.Instance) != nil {
+			continue
+		}
+
+		srv := lookup(instanceName, dnsmessage.TypeSRV)
+		if len(srv) == 0 {
+			if s.TTL == 0 {
+				r.services = append(r.services, s)
+			}
+			continue
+		}
+		target := srv[0].Body.(*dnsmessage.SRVResource)
+		s.Port = int(target.Port)
+		s.Host = strings.TrimSuffix(strings.TrimSuffix(target.Target.String(), "."), ".local")
+
+		for _, txt := range lookup(instanceName, dnsmessage.TypeTXT) {
+			for _, kv := range txt.Body.(*dnsmessage.TXTResource).TXT {
+				if k, v, ok := strings.Cut(kv, "="); ok && len(k) > 0 {
+					if s.Meta == nil {
+						s.Meta = map[string]string{}
+					}
+					s.Meta[k] = v
+				}
+			}
+		}
+		for _, a := range lookup(target.Target.String(), dnsmessage.TypeA) {
+			ip := a.Body.(*dnsmessage.AResource).A
+			s.Addrs = append(s.Addrs, net.IP(ip[:]))
+		}
+		for _, a := range lookup(target.Target.String(), dnsmessage.TypeAAAA) {
+			ip := a.Body.(*dnsmessage.AAAAResource).AAAA
+			s.Addrs = append(s.Addrs, net.IP(ip[:]))
+		}
+		r.services = append(r.services, s)
+	}
+	return r, nil
+}
+
+// mdnsServiceOfType returns "agent" for "_agent._tcp.local."
+func mdnsServiceOfType(typeName string) (string, bool) {
+	lower := strings.ToLower(typeName)
+	if !strings.HasPrefix(lower, "_") || !strings.HasSuffix(lower, "._tcp.local.") {
+		return "", false
+	}
+	name := typeName[1 : len(typeName)-len("._tcp.local.")]
+	if validateDiscoveryLabel("name", name) != nil {
+		return "", false
+	}
+	return name, true
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package qnet
+
+import (
+	"syscall"
+)
+
+// controlDiscoverySocket does nothing here, so the discovery port cannot be shared
+// by several announcers and browsers on this platform
+func controlDiscoverySocket(network string, address string, c syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,27 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package qnet
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// controlDiscoverySocket lets several announcers and browsers share the discovery port.
+// Go enables SO_BROADCAST on UDP sockets itself.
+func controlDiscoverySocket(network string, address string, c syscall.RawConn) error {
+	var err error
+	cerr := c.Control(func(fd uintptr) {
+		for _, opt := range []int{unix.SO_REUSEADDR, unix.SO_REUSEPORT} {
+			if err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, opt, 1); err != nil {
+				return
+			}
+		}
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return err
+}
@@ -0,0 +1,329 @@
+package qnet
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testDiscoveryOptions uses the loopback broadcast address for both protocols
+func testDiscoveryOptions(t *testing.T) *DiscoveryOptions {
+	port := func() int {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		require.NoError(t, err)
+		defer conn.Close()
+		return conn.LocalAddr().(*net.UDPAddr).Port
+	}
+
+	return &DiscoveryOptions{
+		BroadcastAddr: &net.UDPAddr{IP: net.IPv4(127, 255, 255, 255), Port: port()},
+		MDNSAddr:      &net.UDPAddr{IP: net.IPv4(127, 255, 255, 255), Port: port()},
+		TTL:           time.Minute,
+		Interval:      time.Minute,
+	}
+}
+
+// waitDiscoveryEvent returns the first event that matches, skipping the others
+func waitDiscoveryEvent(t *testing.T, browser *Browser, match func(e DiscoveryEvent) bool) DiscoveryEvent {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e, ok := <-browser.Events():
+			require.True(t, ok, "events closed")
+			if match(e) {
+				return e
+			}
+		case <-timeout:
+			require.FailNow(t, "no matching discovery event")
+		}
+	}
+}
+
+func TestDiscoveryCodecs(t *testing.T) {
+	a := require.New(t)
+
+	service := &Service{
+		Name:     "agent",
+		Instance: "box-8080",
+		Host:     "box",
+		Port:     8080,
+		Meta:     map[string]string{"version": "1.2", "os": "linux"},
+		Addrs:    []net.IP{net.ParseIP("192.168.1.10").To4(), net.ParseIP("fe80::1")},
+	}
+
+	for _, codec := range []discoveryCodec{broadcastCodec{}, mdnsCodec{}} {
+		data, err := codec.encodeAnnouncement(service, time.Minute)
+		a.NoError(err)
+		message, err := codec.decode(data)
+		a.NoError(err)
+		a.Empty(message.queries)
+		a.Len(message.services, 1)
+
+		s := message.services[0]
+		a.Equal("agent", s.Name)
+		a.Equal("box-8080", s.Instance)
+		a.Equal("box", s.Host)
+		a.Equal(8080, s.Port)
+		a.Equal(service.Meta, s.Meta)
+		a.Equal(time.Minute, s.TTL)
+		a.Len(s.Addrs, 2)
+		a.True(s.Addrs[0].Equal(service.Addrs[0]))
+		a.True(s.Addrs[1].Equal(service.Addrs[1]))
+
+		data, err = codec.encodeAnnouncement(service, 0)
+		a.NoError(err)
+		message, err = codec.decode(data)
+		a.NoError(err)
+		a.Equal(time.Duration(0), message.services[0].TTL)
+
+		data, err = codec.encodeQuery("agent")
+		a.NoError(err)
+		message, err = codec.decode(data)
+		a.NoError(err)
+		a.Equal([]string{"agent"}, message.queries)
+		a.Empty(message.services)
+
+		_, err = codec.decode([]byte("garbage"))
+		a.Error(err)
+	}
+
+	_, err := broadcastCodec{}.decode([]byte(`{"magic":"other","op":"query","name":"agent"}`))
+	a.Error(err)
+	_, err = mdnsCodec{}.encodeAnnouncement(&Service{Name: "agent", Instance: "x", Host: "box", Port: 1,
+		Meta: map[string]string{"k": string(make([]byte, 300))}}, time.Minute)
+	a.Error(err)
+}
+
+func TestAnnounce_validation(t *testing.T) {
+	a := require.New(t)
+	options := testDiscoveryOptions(t)
+
+	_, err := Announce(context.Background(), &Service{Port: 80}, options)
+	a.Error(err)
+	_, err = Announce(context.Background(), &Service{Name: "a.b", Port: 80}, options)
+	a.Error(err)
+	_, err = Announce(context.Background(), &Service{Name: "agent"}, options)
+	a.Error(err)
+	_, err = Browse(context.Background(), "", options)
+	a.Error(err)
+
+	announcer, err := Announce(context.Background(), &Service{Name: "agent", Port: 80}, options)
+	a.NoError(err)
+	defer announcer.Close()
+
+	s := announcer.Service()
+	a.NotEmpty(s.Host)
+	a.NotContains(s.Host, ".")
+	a.Contains(s.Instance, "-80")
+}
+
+func TestDiscovery_joinAndLeave(t *testing.T) {
+	a := require.New(t)
+	options := testDiscoveryOptions(t)
+
+	// an announcer that runs before the browser answers the browser's query
+	early, err := Announce(context.Background(), &Service{Name: "agent", Instance: "early", Port: 7001,
+		Meta: map[string]string{"role": "leader"}}, options)
+	a.NoError(err)
+	defer early.Close()
+	other, err := Announce(context.Background(), &Service{Name: "printer", Instance: "early", Port: 631}, options)
+	a.NoError(err)
+	defer other.Close()
+
+	browser, err := Browse(context.Background(), "agent", options)
+	a.NoError(err)
+	defer browser.Close()
+
+	e := waitDiscoveryEvent(t, browser, func(e DiscoveryEvent) bool {
+		return e.Service.Instance == "early" && len(e.Service.Protocols) == 2
+	})
+	a.Equal("agent", e.Service.Name)
+	a.Equal(7001, e.Service.Port)
+	a.Equal("leader", e.Service.Meta["role"])
+	a.Equal("127.0.0.1", e.Service.Addrs[0].String())
+	a.Equal("127.0.0.1:7001", e.Service.Addr())
+
+	// a new announcer joins
+	late, err := Announce(context.Background(), &Service{Name: "agent", Instance: "late", Port: 7002}, options)
+	a.NoError(err)
+	e = waitDiscoveryEvent(t, browser, func(e DiscoveryEvent) bool {
+		return e.Service.Instance == "late"
+	})
+	a.Equal(DiscoveryJoin, e.Type)
+
+	a.Eventually(func() bool {
+		services := browser.Services()
+		return len(services) == 2 && len(services[1].Protocols) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	a.Equal("early", browser.Services()[0].Instance)
+
+	// closing says goodbye on both protocols
+	a.NoError(late.Close())
+	waitDiscoveryEvent(t, browser, func(e DiscoveryEvent) bool {
+		return e.Type == DiscoveryLeave && e.Service.Instance == "late"
+	})
+	a.Len(browser.Services(), 1)
+
+	// the browser's ctx closes it and its events
+	ctx, cancel := context.WithCancel(context.Background())
+	second, err := Browse(ctx, "agent", options)
+	a.NoError(err)
+	waitDiscoveryEvent(t, second, func(e DiscoveryEvent) bool { return e.Type == DiscoveryJoin })
+	cancel()
+	drained := make(chan struct{})
+	go func() {
+		for range second.Events() {
+		}
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(5 * time.Second):
+		a.Fail("events are not closed")
+	}
+}
+
+func TestDiscovery_expiry(t *testing.T) {
+	a := require.New(t)
+	options := testDiscoveryOptions(t)
+	options.Protocols = []DiscoveryProtocol{DiscoveryBroadcast}
+
+	browser, err := Browse(context.Background(), "agent", options)
+	a.NoError(err)
+	defer browser.Close()
+
+	// an announcer that goes away without saying goodbye
+	conn, err := net.DialUDP("udp4", nil, options.BroadcastAddr)
+	a.NoError(err)
+	defer conn.Close()
+	data, err := broadcastCodec{}.encodeAnnouncement(&Service{Name: "agent", Instance: "crashed", Host: "box", Port: 7003}, time.Second)
+	a.NoError(err)
+	_, err = conn.Write(data)
+	a.NoError(err)
+
+	e := waitDiscoveryEvent(t, browser, func(e DiscoveryEvent) bool { return true })
+	a.Equal(DiscoveryJoin, e.Type)
+	a.Equal(time.Second, e.Service.TTL)
+
+	start := time.Now()
+	e = waitDiscoveryEvent(t, browser, func(e DiscoveryEvent) bool { return true })
+	a.Equal(DiscoveryLeave, e.Type)
+	a.Equal("crashed", e.Service.Instance)
+	a.GreaterOrEqual(time.Since(start), 500*time.Millisecond)
+	a.Empty(browser.Services())
+}
+
+func TestDiscovery_update(t *testing.T) {
+	a := require.New(t)
+	options := testDiscoveryOptions(t)
+	options.Protocols = []DiscoveryProtocol{DiscoveryMDNS}
+
+	browser, err := Browse(context.Background(), "agent", options)
+	a.NoError(err)
+	defer browser.Close()
+
+	service := &Service{Name: "agent", Instance: "box", Port: 7004, Meta: map[string]string{"state": "starting"}}
+	announcer, err := Announce(context.Background(), service, options)
+	a.NoError(err)
+	e := waitDiscoveryEvent(t, browser, func(e DiscoveryEvent) bool { return true })
+	a.Equal(DiscoveryJoin, e.Type)
+	a.Equal([]DiscoveryProtocol{DiscoveryMDNS}, e.Service.Protocols)
+
+	// a restarted announcer with new metadata is reported as an update
+	a.NoError(announcer.Close())
+	waitDiscoveryEvent(t, browser, func(e DiscoveryEvent) bool { return e.Type == DiscoveryLeave })
+
+	announcer, err = Announce(context.Background(), service, options)
+	a.NoError(err)
+	defer announcer.Close()
+	waitDiscoveryEvent(t, browser, func(e DiscoveryEvent) bool { return e.Type == DiscoveryJoin })
+
+	service.Meta["state"] = "ready"
+	updated, err := Announce(context.Background(), service, options)
+	a.NoError(err)
+	defer updated.Close()
+	e = waitDiscoveryEvent(t, browser, func(e DiscoveryEvent) bool { return true })
+	a.Equal(DiscoveryUpdate, e.Type)
+	a.Equal("ready", e.Service.Meta["state"])
+}
+
+func TestDiscovery_slowConsumer(t *testing.T) {
+	a := require.New(t)
+	options := testDiscoveryOptions(t)
+	options.Protocols = []DiscoveryProtocol{DiscoveryBroadcast}
+
+	browser, err := Browse(context.Background(), "agent", options)
+	a.NoError(err)
+	defer browser.Close()
+
+	conn, err := net.DialUDP("udp4", nil, options.BroadcastAddr)
+	a.NoError(err)
+	defer conn.Close()
+
+	// more joins than Events can buffer, nobody reads Events meanwhile
+	const count = 100
+	for i := 0; i < count; i++ {
+		data, err := broadcastCodec{}.encodeAnnouncement(&Service{Name: "agent", Instance: fmt.Sprintf("box-%03d", i), Port: 7005}, time.Minute)
+		a.NoError(err)
+		_, err = conn.Write(data)
+		a.NoError(err)
+		if i%10 == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	a.Eventually(func() bool { return len(browser.Services()) == count }, 5*time.Second, 20*time.Millisecond)
+
+	for i := 0; i < count; i++ {
+		e := waitDiscoveryEvent(t, browser, func(e DiscoveryEvent) bool { return true })
+		a.Equal(DiscoveryJoin, e.Type)
+		a.Equal(fmt.Sprintf("box-%03d", i), e.Service.Instance)
+	}
+}
+
+func TestBrowser_emit(t *testing.T) {
+	a := require.New(t)
+	browser := &Browser{notify: make(chan struct{}, 1)}
+	service := func(instance string, port int) *Service {
+		return &Service{Name: "agent", Instance: instance, Port: port}
+	}
+	emit := func(t DiscoveryEventType, s *Service) { browser.emit(s.Key(), t, s) }
+	queued := func() []string {
+		r := []string{}
+		for _, e := range browser.pending {
+			r = append(r, fmt.Sprintf("%s %s %d", e.Type, e.Service.Instance, e.Service.Port))
+		}
+		return r
+	}
+
+	// the queued event of a service carries its latest state
+	emit(DiscoveryJoin, service("a", 1))
+	emit(DiscoveryJoin, service("b", 1))
+	emit(DiscoveryUpdate, service("a", 2))
+	a.Equal([]string{"join a 2", "join b 1"}, queued())
+
+	// a service that comes and goes unseen is not reported
+	emit(DiscoveryLeave, service("b", 1))
+	a.Equal([]string{"join a 2"}, queued())
+
+	browser.pending = nil
+	emit(DiscoveryUpdate, service("a", 3))
+	emit(DiscoveryLeave, service("a", 3))
+	a.Equal([]string{"leave a 3"}, queued())
+	emit(DiscoveryJoin, service("a", 4))
+	a.Equal([]string{"update a 4"}, queued())
+
+	// a flood of services drops the oldest
+	browser.pending = nil
+	for i := 0; i < maxPendingDiscoveryEvents+10; i++ {
+		emit(DiscoveryJoin, service(fmt.Sprintf("box-%04d", i), 1))
+	}
+	a.Len(browser.pending, maxPendingDiscoveryEvents)
+	a.Equal("box-0010", browser.pending[0].Service.Instance)
+}
@@ -0,0 +1,510 @@
+package qnet
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+	"golang.org/x/crypto/ssh"
+)
+
+// knownHostsHashMagic prefixes hashed host names, see HashKnownHosts in ssh_config(5)
+const knownHostsHashMagic = "|1|"
+
+// Markers of known_hosts entries
+const (
+	KnownHostsMarkerCertAuthority = "@cert-authority"
+	KnownHostsMarkerRevoked       = "@revoked"
+)
+
+// KnownHostsEntry is one key line of a known_hosts file
+type KnownHostsEntry struct {
+	// Marker is "", KnownHostsMarkerCertAuthority or KnownHostsMarkerRevoked
+	Marker string
+	// Hosts are the comma separated host patterns; a hashed name is kept as "|1|salt|hash"
+	Hosts   []string
+	Key     ssh.PublicKey
+	Comment string
+
+	File string
+	Line int
+}
+
+// Match reports whether the entry applies to a host name in known_hosts form,
+// i.e. "host" for port 22 and "[host]:port" otherwise. A matching negated pattern
+// excludes the host even if another pattern matches.
+func (me *KnownHostsEntry) Match(name string) bool {
+	name = strings.ToLower(name)
+
+	r := false
+	for _, p := range me.Hosts {
+		if strings.HasPrefix(p, knownHostsHashMagic) {
+			if matchHashedKnownHost(p, name) {
+				r = true
+			}
+			continue
+		}
+
+		negated := strings.HasPrefix(p, "!")
+		if negated {
+			p = p[1:]
+		}
+		if SimpleWildcardMatch(strings.ToLower(p), name) {
+			if negated {
+				return false
+			}
+			r = true
+		}
+	}
+	return r
+}
+
+// String returns the entry as a known_hosts line
+func (me *KnownHostsEntry) String() string {
+	fields := []string{}
+	if len(me.Marker) > 0 {
+		fields = append(fields, me.Marker)
+	}
+	fields = append(fields, strings.Join(me.Hosts, ","), me.Key.Type(), base64.StdEncoding.EncodeToString(me.Key.Marshal()))
+	if len(me.Comment) > 0 {
+		fields = append(fields, me.Comment)
+	}
+	return strings.Join(fields, " ")
+}
+
+// KnownHostsName returns the name under which a host is recorded in known_hosts:
+// the lower-cased host for port 22 and "[host]:port" otherwise
+func KnownHostsName(host string, port int) string {
+	host = strings.ToLower(strings.Trim(host, "[]"))
+	if port == 0 || port == 22 {
+		return host
+	}
+	return "[" + host + "]:" + strconv.Itoa(port)
+}
+
+// knownHostsNameOfAddress converts a "host:port" address as passed to ssh.HostKeyCallback
+func knownHostsNameOfAddress(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return KnownHostsName(addr, 22)
+	}
+	return KnownHostsName(host, ParseIntDefault(port, 22))
+}
+
+// HashKnownHostsName hashes a host name with a random salt, as ssh does when HashKnownHosts is set
+func HashKnownHostsName(name string) string {
+	salt := make([]byte, sha1.Size)
+	if _, err := rand.Read(salt); err != nil {
+		panic(err)
+	}
+	return encodeHashedKnownHost(salt, strings.ToLower(name))
+}
+
+func encodeHashedKnownHost(salt []byte, name string) string {
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(name))
+	return knownHostsHashMagic + base64.StdEncoding.EncodeToString(salt) + "|" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func matchHashedKnownHost(hashed string, name string) bool {
+	parts := strings.Split(hashed[len(knownHostsHashMagic):], "|")
+	if len(parts) != 2 {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return false
+	}
+	return hmac.Equal([]byte(encodeHashedKnownHost(salt, name)), []byte(hashed))
+}
+
+// ParseKnownHosts parses the content of a known_hosts file. Like ssh, lines that
+// cannot be parsed are skipped; file is only used to annotate the entries.
+func ParseKnownHosts(data []byte, file string) []*KnownHostsEntry {
+	r := []*KnownHostsEntry{}
+	for i, line := range strings.Split(string(data), "\n") {
+		if entry := parseKnownHostsLine(line); entry != nil {
+			entry.File = file
+			entry.Line = i + 1
+			r = append(r, entry)
+		}
+	}
+	return r
+}
+
+func parseKnownHostsLine(line string) *KnownHostsEntry {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+		return nil
+	}
+
+	r := &KnownHostsEntry{}
+	if strings.HasPrefix(fields[0], "@") {
+		r.Marker = fields[0]
+		if r.Marker != KnownHostsMarkerCertAuthority && r.Marker != KnownHostsMarkerRevoked {
+			return nil
+		}
+		fields = fields[1:]
+	}
+	if len(fields) < 3 {
+		return nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(fields[2])
+	if err != nil {
+		return nil
+	}
+	key, err := ssh.ParsePublicKey(data)
+	if err != nil || key.Type() != fields[1] {
+		return nil
+	}
+
+	r.Hosts = strings.Split(fields[0], ",")
+	r.Key = key
+	r.Comment = strings.Join(fields[3:], " ")
+	return r
+}
+
+// ============================================================
+// KnownHosts
+// ============================================================
+
+// KnownHosts is the set of known_hosts files consulted for one connection
+type KnownHosts struct {
+	fs      afero.Fs
+	entries []*KnownHostsEntry
+	// appendFile receives new keys, empty when keys cannot be recorded
+	appendFile string
+
+	mutex sync.Mutex
+}
+
+// LoadKnownHosts loads known_hosts files in order, missing files are treated as empty.
+// New keys are appended to the first file.
+func LoadKnownHosts(fs afero.Fs, files ...string) (*KnownHosts, error) {
+	r := &KnownHosts{fs: fs}
+	if len(files) > 0 {
+		r.appendFile = files[0]
+	}
+	for _, f := range files {
+		if err := r.load(f); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// LoadKnownHostsForConfig loads the UserKnownHostsFile and GlobalKnownHostsFile of a host,
+// new keys are appended to the first user file
+func LoadKnownHostsForConfig(fs afero.Fs, config *SSHHostConfig) (*KnownHosts, error) {
+	userFiles := strings.Fields(config.UserKnownHostsFile)
+	r, err := LoadKnownHosts(fs, userFiles...)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range strings.Fields(config.GlobalKnownHostsFile) {
+		if err := r.load(f); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (me *KnownHosts) load(file string) error {
+	data, err := afero.ReadFile(me.fs, file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "read known hosts file: %s", file)
+	}
+	me.entries = append(me.entries, ParseKnownHosts(data, file)...)
+	return nil
+}
+
+// Entries returns the loaded entries in file order
+func (me *KnownHosts) Entries() []*KnownHostsEntry {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	return append([]*KnownHostsEntry{}, me.entries...)
+}
+
+// Lookup returns the entries without marker that match the host name
+func (me *KnownHosts) Lookup(name string) []*KnownHostsEntry {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+
+	r := []*KnownHostsEntry{}
+	for _, e := range me.entries {
+		if len(e.Marker) == 0 && e.Match(name) {
+			r = append(r, e)
+		}
+	}
+	return r
+}
+
+// KnownHostsKeyError reports a host key that is not in known_hosts. Want lists the keys of
+// the same type recorded for the host; it is empty when no such key is known, otherwise
+// the key has changed.
+type KnownHostsKeyError struct {
+	Name string
+	Key  ssh.PublicKey
+	Want []*KnownHostsEntry
+}
+
+func (me *KnownHostsKeyError) Error() string {
+	if len(me.Want) == 0 {
+		return fmt.Sprintf("host key %s for '%s' is unknown", ssh.FingerprintSHA256(me.Key), me.Name)
+	}
+	w := me.Want[0]
+	return fmt.Sprintf("host key for '%s' has changed to %s, known key at %s:%d is %s",
+		me.Name, ssh.FingerprintSHA256(me.Key), w.File, w.Line, ssh.FingerprintSHA256(w.Key))
+}
+
+// Unknown reports whether no key of the presented type is recorded for the host
+func (me *KnownHostsKeyError) Unknown() bool {
+	return len(me.Want) == 0
+}
+
+// KnownHostsRevokedError reports a host key, or the CA of a host certificate, marked @revoked
+type KnownHostsRevokedError struct {
+	Name    string
+	Revoked *KnownHostsEntry
+}
+
+func (me *KnownHostsRevokedError) Error() string {
+	return fmt.Sprintf("host key %s for '%s' is revoked at %s:%d",
+		ssh.FingerprintSHA256(me.Revoked.Key), me.Name, me.Revoked.File, me.Revoked.Line)
+}
+
+// Check verifies the key presented by a host. name is in known_hosts form, see KnownHostsName.
+// A certificate is accepted when it is valid for the host and signed by a matching
+// @cert-authority key; a key, the key inside a certificate or a CA marked @revoked is always rejected. Otherwise the result
+// is nil, a KnownHostsKeyError or a KnownHostsRevokedError. Like ssh, only recorded keys of
+// the presented type count as the known keys, a key of another type is unknown and not changed.
+func (me *KnownHosts) Check(name string, key ssh.PublicKey) error {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+
+	if revoked := me.revoked(key); revoked != nil {
+		return &KnownHostsRevokedError{Name: name, Revoked: revoked}
+	}
+
+	if cert, ok := key.(*ssh.Certificate); ok {
+		// like ssh, a certificate is revoked with the plain key inside it as well as with its CA
+		for _, k := range []ssh.PublicKey{cert.Key, cert.SignatureKey} {
+			if revoked := me.revoked(k); revoked != nil {
+				return &KnownHostsRevokedError{Name: name, Revoked: revoked}
+			}
+		}
+		if me.certAuthority(name, cert.SignatureKey) {
+			return me.checkCert(name, cert)
+		}
+		// fall back to the plain key inside the certificate, as ssh does
+		key = cert.Key
+	}
+
+	keyBytes := key.Marshal()
+	want := []*KnownHostsEntry{}
+	for _, e := range me.entries {
+		if len(e.Marker) > 0 || e.Key.Type() != key.Type() || !e.Match(name) {
+			continue
+		}
+		if bytes.Equal(e.Key.Marshal(), keyBytes) {
+			return nil
+		}
+		want = append(want, e)
+	}
+	return &KnownHostsKeyError{Name: name, Key: key, Want: want}
+}
+
+// HostKeyAlgorithms returns the host key algorithms for ssh.ClientConfig.HostKeyAlgorithms,
+// ordered as ssh does: algorithms of the key types recorded for the host come first, certificate
+// algorithms first of all when a @cert-authority matches, then the remaining ones. The result
+// is nil when nothing is recorded for the host, leaving the default order.
+func (me *KnownHosts) HostKeyAlgorithms(name string) []string {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+
+	known := map[string]bool{}
+	certAuthority := false
+	for _, e := range me.entries {
+		if !e.Match(name) {
+			continue
+		}
+		switch e.Marker {
+		case "":
+			known[e.Key.Type()] = true
+		case KnownHostsMarkerCertAuthority:
+			certAuthority = true
+		}
+	}
+	if len(known) == 0 && !certAuthority {
+		return nil
+	}
+
+	certs, preferred, rest := []string{}, []string{}, []string{}
+	for _, algo := range ssh.SupportedAlgorithms().HostKeys {
+		keyType, isCert := hostKeyTypeOfAlgorithm(algo)
+		switch {
+		case isCert && certAuthority:
+			certs = append(certs, algo)
+		case !isCert && known[keyType]:
+			preferred = append(preferred, algo)
+		default:
+			rest = append(rest, algo)
+		}
+	}
+	return append(append(certs, preferred...), rest...)
+}
+
+// hostKeyTypeOfAlgorithm returns the key type signed by a host key algorithm,
+// i.e. "ssh-rsa" for "rsa-sha2-256", and whether the algorithm is a certificate one
+func hostKeyTypeOfAlgorithm(algo string) (string, bool) {
+	isCert := strings.HasSuffix(algo, "-cert-v01@openssh.com")
+	keyType := strings.TrimSuffix(algo, "-cert-v01@openssh.com")
+	if keyType == "rsa-sha2-256" || keyType == "rsa-sha2-512" {
+		keyType = ssh.KeyAlgoRSA
+	}
+	return keyType, isCert
+}
+
+func (me *KnownHosts) revoked(key ssh.PublicKey) *KnownHostsEntry {
+	keyBytes := key.Marshal()
+	for _, e := range me.entries {
+		// a revoked key is rejected whatever host it is presented for
+		if e.Marker == KnownHostsMarkerRevoked && bytes.Equal(e.Key.Marshal(), keyBytes) {
+			return e
+		}
+	}
+	return nil
+}
+
+func (me *KnownHosts) certAuthority(name string, key ssh.PublicKey) bool {
+	keyBytes := key.Marshal()
+	for _, e := range me.entries {
+		if e.Marker == KnownHostsMarkerCertAuthority && e.Match(name) && bytes.Equal(e.Key.Marshal(), keyBytes) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCert checks validity period, principals and signature of a host certificate
+func (me *KnownHosts) checkCert(name string, cert *ssh.Certificate) error {
+	if cert.CertType != ssh.HostCert {
+		return errors.Errorf("certificate presented by '%s' is not a host certificate", name)
+	}
+
+	principal := name
+	if strings.HasPrefix(name, "[") {
+		principal = strings.TrimPrefix(name[:strings.LastIndex(name, "]")], "[")
+	}
+	checker := &ssh.CertChecker{}
+	if err := checker.CheckCert(principal, cert); err != nil {
+		return errors.Wrapf(err, "check host certificate of '%s'", name)
+	}
+	return nil
+}
+
+// Append records a host key in the first known_hosts file. Like ssh the line is appended
+// with O_APPEND in a single write, so keys appended concurrently by ssh or another process
+// are kept and a symlinked file stays a symlink; a new file is created with mode 0644 in
+// a directory created with mode 0700.
+func (me *KnownHosts) Append(name string, key ssh.PublicKey, hash bool) error {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+
+	if len(me.appendFile) == 0 {
+		return errors.Errorf("no known hosts file to record the key of '%s'", name)
+	}
+
+	host := strings.ToLower(name)
+	if hash {
+		host = HashKnownHostsName(host)
+	}
+	entry := &KnownHostsEntry{Hosts: []string{host}, Key: key, File: me.appendFile}
+
+	if err := me.fs.MkdirAll(filepath.Dir(me.appendFile), 0o700); err != nil {
+		return errors.Wrapf(err, "create directory of known hosts file: %s", me.appendFile)
+	}
+	f, err := me.fs.OpenFile(me.appendFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return errors.Wrapf(err, "open known hosts file: %s", me.appendFile)
+	}
+
+	// the line number is informational, lines appended by others meanwhile may shift it
+	data, err := afero.ReadFile(me.fs, me.appendFile)
+	if err != nil {
+		f.Close()
+		return errors.Wrapf(err, "read known hosts file: %s", me.appendFile)
+	}
+	line := entry.String() + "\n"
+	entry.Line = bytes.Count(data, []byte("\n")) + 1
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		line = "\n" + line
+		entry.Line++
+	}
+
+	_, err = f.Write([]byte(line))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrapf(err, "write known hosts file: %s", me.appendFile)
+	}
+	me.entries = append(me.entries, entry)
+	return nil
+}
+
+// CheckHostKey applies StrictHostKeyChecking to the key presented by a host:
+//   - "yes" and "ask" reject unknown and changed keys; without a terminal there is nobody to ask
+//   - "accept-new" records the key of an unknown host and rejects changed keys
+//   - "no" and "off" record the key of an unknown host and accept changed keys, like ssh
+//     which only warns in that case
+//
+// A revoked key is rejected in all modes.
+func (me *KnownHosts) CheckHostKey(name string, key ssh.PublicKey, strictHostKeyChecking string, hash bool) error {
+	err := me.Check(name, key)
+	var keyErr *KnownHostsKeyError
+	if err == nil || !errors.As(err, &keyErr) {
+		return err
+	}
+
+	switch strings.ToLower(strictHostKeyChecking) {
+	case "accept-new":
+		if !keyErr.Unknown() {
+			return err
+		}
+	case "no", "off", "false":
+		if !keyErr.Unknown() {
+			return nil
+		}
+	default:
+		return err
+	}
+
+	if cert, ok := key.(*ssh.Certificate); ok {
+		key = cert.Key
+	}
+	return me.Append(name, key, hash)
+}
+
+// HostKeyCallback returns an ssh.HostKeyCallback applying StrictHostKeyChecking,
+// the host name is derived from the dialed address
+func (me *KnownHosts) HostKeyCallback(strictHostKeyChecking string, hash bool) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		return me.CheckHostKey(knownHostsNameOfAddress(hostname), key, strictHostKeyChecking, hash)
+	}
+}
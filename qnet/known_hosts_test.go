@@ -0,0 +1,356 @@
+package qnet
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestHostKey(t *testing.T) ssh.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	r, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+	return r
+}
+
+func newTestRSAHostKey(t *testing.T) ssh.Signer {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	r, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+	return r
+}
+
+func knownHostsLine(marker string, hosts string, key ssh.PublicKey) string {
+	entry := &KnownHostsEntry{Marker: marker, Hosts: strings.Split(hosts, ","), Key: key}
+	return entry.String() + "\n"
+}
+
+func TestKnownHostsName(t *testing.T) {
+	a := require.New(t)
+
+	a.Equal("example.com", KnownHostsName("Example.COM", 22))
+	a.Equal("[example.com]:2222", KnownHostsName("example.com", 2222))
+	a.Equal("[::1]:2222", KnownHostsName("::1", 2222))
+	a.Equal("example.com", knownHostsNameOfAddress("example.com:22"))
+	a.Equal("[10.0.0.1]:2200", knownHostsNameOfAddress("10.0.0.1:2200"))
+}
+
+func TestParseKnownHosts(t *testing.T) {
+	a := require.New(t)
+
+	key := newTestHostKey(t).PublicKey()
+	hashed := HashKnownHostsName("[secret.example.com]:2222")
+	content := "# comment\n\n" +
+		knownHostsLine("", "a.example.com,*.b.example.com,!bad.b.example.com", key) +
+		"garbage line\n" +
+		"@unknown-marker host " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))) + "\n" +
+		knownHostsLine("", hashed, key) +
+		knownHostsLine(KnownHostsMarkerCertAuthority, "*.example.com", key) +
+		strings.TrimSuffix(knownHostsLine(KnownHostsMarkerRevoked, "*", key), "\n") + " old key of 2019"
+
+	entries := ParseKnownHosts([]byte(content), "known_hosts")
+	a.Len(entries, 4)
+
+	a.Equal(3, entries[0].Line)
+	a.Equal("known_hosts", entries[0].File)
+	a.True(entries[0].Match("a.example.com"))
+	a.True(entries[0].Match("X.B.example.com"))
+	a.False(entries[0].Match("bad.b.example.com"))
+	a.False(entries[0].Match("[a.example.com]:2222"))
+
+	a.True(entries[1].Match("[secret.example.com]:2222"))
+	a.False(entries[1].Match("secret.example.com"))
+
+	a.Equal(KnownHostsMarkerCertAuthority, entries[2].Marker)
+	a.Equal(KnownHostsMarkerRevoked, entries[3].Marker)
+	a.Equal("old key of 2019", entries[3].Comment)
+}
+
+func TestKnownHosts_Check(t *testing.T) {
+	a := require.New(t)
+
+	key := newTestHostKey(t).PublicKey()
+	other := newTestHostKey(t).PublicKey()
+	revoked := newTestHostKey(t).PublicKey()
+
+	fs := afero.NewMemMapFs()
+	a.NoError(afero.WriteFile(fs, "/user", []byte(knownHostsLine("", "host1", key)), 0o644))
+	a.NoError(afero.WriteFile(fs, "/global", []byte(
+		knownHostsLine("", "[host2]:2222", key)+
+			knownHostsLine("", "host3", revoked)+
+			knownHostsLine(KnownHostsMarkerRevoked, "*", revoked)), 0o644))
+
+	kh, err := LoadKnownHosts(fs, "/user", "/missing", "/global")
+	a.NoError(err)
+	a.Len(kh.Entries(), 4)
+	a.Len(kh.Lookup("host1"), 1)
+
+	a.NoError(kh.Check("host1", key))
+	a.NoError(kh.Check("[host2]:2222", key))
+
+	var keyErr *KnownHostsKeyError
+	err = kh.Check("host1", other)
+	a.True(errors.As(err, &keyErr))
+	a.False(keyErr.Unknown())
+	a.Equal("/user", keyErr.Want[0].File)
+	a.Contains(err.Error(), "has changed")
+
+	err = kh.Check("host2", key)
+	a.True(errors.As(err, &keyErr))
+	a.True(keyErr.Unknown())
+
+	var revokedErr *KnownHostsRevokedError
+	err = kh.Check("host3", revoked)
+	a.True(errors.As(err, &revokedErr))
+	a.Equal(3, revokedErr.Revoked.Line)
+}
+
+func TestKnownHosts_Check_otherKeyType(t *testing.T) {
+	a := require.New(t)
+
+	rsaKey := newTestRSAHostKey(t).PublicKey()
+	ed25519Key := newTestHostKey(t).PublicKey()
+
+	fs := afero.NewMemMapFs()
+	a.NoError(afero.WriteFile(fs, "/known_hosts", []byte(knownHostsLine("", "host", rsaKey)), 0o644))
+	kh, err := LoadKnownHosts(fs, "/known_hosts")
+	a.NoError(err)
+
+	// only an ssh-rsa key is recorded, an ed25519 key is unknown rather than changed
+	var keyErr *KnownHostsKeyError
+	a.True(errors.As(kh.Check("host", ed25519Key), &keyErr))
+	a.True(keyErr.Unknown())
+	a.Error(kh.CheckHostKey("host", ed25519Key, "yes", false))
+
+	// the recorded type is negotiated first
+	algos := kh.HostKeyAlgorithms("host")
+	a.Equal([]string{ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSASHA512}, algos[:2])
+	a.Contains(algos, ssh.KeyAlgoED25519)
+	a.Nil(kh.HostKeyAlgorithms("other"))
+
+	a.NoError(afero.WriteFile(fs, "/ca", []byte(knownHostsLine(KnownHostsMarkerCertAuthority, "*", ed25519Key)), 0o644))
+	kh, err = LoadKnownHosts(fs, "/known_hosts", "/ca")
+	a.NoError(err)
+	algos = kh.HostKeyAlgorithms("host")
+	a.True(strings.HasSuffix(algos[0], "-cert-v01@openssh.com"))
+	a.Less(slices.Index(algos, ssh.KeyAlgoRSASHA256), slices.Index(algos, ssh.KeyAlgoED25519))
+}
+
+func TestKnownHosts_Check_certificate(t *testing.T) {
+	a := require.New(t)
+
+	ca := newTestHostKey(t)
+	hostKey := newTestHostKey(t)
+
+	newCert := func(principals []string, validBefore uint64) *ssh.Certificate {
+		cert := &ssh.Certificate{
+			Key:             hostKey.PublicKey(),
+			CertType:        ssh.HostCert,
+			ValidPrincipals: principals,
+			ValidBefore:     validBefore,
+		}
+		a.NoError(cert.SignCert(rand.Reader, ca))
+		return cert
+	}
+
+	fs := afero.NewMemMapFs()
+	a.NoError(afero.WriteFile(fs, "/known_hosts", []byte(knownHostsLine(KnownHostsMarkerCertAuthority, "*.example.com,[*.example.com]:*", ca.PublicKey())), 0o644))
+	kh, err := LoadKnownHosts(fs, "/known_hosts")
+	a.NoError(err)
+
+	a.NoError(kh.Check("web.example.com", newCert([]string{"web.example.com"}, ssh.CertTimeInfinity)))
+	a.NoError(kh.Check("[web.example.com]:2222", newCert([]string{"web.example.com"}, ssh.CertTimeInfinity)))
+
+	err = kh.Check("web.example.com", newCert([]string{"db.example.com"}, ssh.CertTimeInfinity))
+	a.Error(err)
+	a.Contains(err.Error(), "principal")
+
+	err = kh.Check("web.example.com", newCert(nil, uint64(time.Now().Add(-time.Hour).Unix())))
+	a.Error(err)
+	a.Contains(err.Error(), "expired")
+
+	// the CA is not trusted for other domains, the plain key is checked instead
+	var keyErr *KnownHostsKeyError
+	err = kh.Check("web.example.org", newCert(nil, ssh.CertTimeInfinity))
+	a.True(errors.As(err, &keyErr))
+	a.True(keyErr.Unknown())
+
+	// a revoked CA invalidates all certificates it signed
+	a.NoError(afero.WriteFile(fs, "/revoked", []byte(knownHostsLine(KnownHostsMarkerRevoked, "*", ca.PublicKey())), 0o644))
+	kh, err = LoadKnownHosts(fs, "/known_hosts", "/revoked")
+	a.NoError(err)
+	var revokedErr *KnownHostsRevokedError
+	a.True(errors.As(kh.Check("web.example.com", newCert(nil, ssh.CertTimeInfinity)), &revokedErr))
+
+	// so does a revoked host key inside a certificate of a trusted CA
+	a.NoError(afero.WriteFile(fs, "/revoked", []byte(knownHostsLine(KnownHostsMarkerRevoked, "*", hostKey.PublicKey())), 0o644))
+	kh, err = LoadKnownHosts(fs, "/known_hosts", "/revoked")
+	a.NoError(err)
+	a.True(errors.As(kh.Check("web.example.com", newCert(nil, ssh.CertTimeInfinity)), &revokedErr))
+	a.Equal(hostKey.PublicKey().Marshal(), revokedErr.Revoked.Key.Marshal())
+}
+
+func TestKnownHosts_Append(t *testing.T) {
+	a := require.New(t)
+
+	key := newTestHostKey(t).PublicKey()
+	other := newTestHostKey(t).PublicKey()
+
+	fs := afero.NewMemMapFs()
+	a.NoError(fs.MkdirAll("/home/alice/.ssh", 0o700))
+	a.NoError(afero.WriteFile(fs, "/home/alice/.ssh/known_hosts", []byte("# keep me"), 0o600))
+
+	kh, err := LoadKnownHosts(fs, "/home/alice/.ssh/known_hosts", "/etc/ssh/ssh_known_hosts")
+	a.NoError(err)
+	a.NoError(kh.Append("[Host1]:2222", key, false))
+	a.NoError(kh.Append("host2", other, true))
+	a.NoError(kh.Check("[host1]:2222", key))
+	a.NoError(kh.Check("host2", other))
+
+	data, err := afero.ReadFile(fs, "/home/alice/.ssh/known_hosts")
+	a.NoError(err)
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	a.Len(lines, 3)
+	a.Equal("# keep me", lines[0])
+	a.True(strings.HasPrefix(lines[1], "[host1]:2222 ssh-ed25519 "))
+	a.True(strings.HasPrefix(lines[2], "|1|"))
+	a.NotContains(lines[2], "host2")
+
+	info, err := fs.Stat("/home/alice/.ssh/known_hosts")
+	a.NoError(err)
+	a.Equal(os.FileMode(0o600), info.Mode().Perm())
+
+	// reloaded entries match the in-memory ones
+	reloaded, err := LoadKnownHosts(fs, "/home/alice/.ssh/known_hosts")
+	a.NoError(err)
+	a.NoError(reloaded.Check("host2", other))
+	a.Equal(3, reloaded.Lookup("host2")[0].Line)
+
+	empty, err := LoadKnownHosts(fs)
+	a.NoError(err)
+	a.Error(empty.Append("host", key, false))
+}
+
+func TestKnownHosts_Append_concurrent(t *testing.T) {
+	a := require.New(t)
+	if runtime.GOOS == "windows" {
+		t.Skip("creating symlinks needs privileges")
+	}
+
+	key := newTestHostKey(t).PublicKey()
+	other := newTestHostKey(t).PublicKey()
+
+	// known_hosts is a symlink managed by a dotfile manager
+	dir := t.TempDir()
+	real := filepath.Join(dir, "dotfiles", "known_hosts")
+	a.NoError(os.MkdirAll(filepath.Dir(real), 0o700))
+	a.NoError(os.WriteFile(real, []byte(knownHostsLine("", "host1", key)), 0o600))
+	link := filepath.Join(dir, "known_hosts")
+	a.NoError(os.Symlink(real, link))
+
+	fs := afero.NewOsFs()
+	kh, err := LoadKnownHosts(fs, link)
+	a.NoError(err)
+
+	// a key appended by ssh after the file was loaded is kept
+	f, err := os.OpenFile(link, os.O_WRONLY|os.O_APPEND, 0)
+	a.NoError(err)
+	_, err = f.WriteString(knownHostsLine("", "host2", other))
+	a.NoError(err)
+	a.NoError(f.Close())
+
+	a.NoError(kh.Append("host3", other, false))
+	a.Equal(3, kh.Lookup("host3")[0].Line)
+
+	info, err := os.Lstat(link)
+	a.NoError(err)
+	a.NotZero(info.Mode() & os.ModeSymlink)
+
+	reloaded, err := LoadKnownHosts(fs, link)
+	a.NoError(err)
+	a.NoError(reloaded.Check("host1", key))
+	a.NoError(reloaded.Check("host2", other))
+	a.NoError(reloaded.Check("host3", other))
+}
+
+func TestKnownHosts_CheckHostKey(t *testing.T) {
+	a := require.New(t)
+
+	key := newTestHostKey(t).PublicKey()
+	changed := newTestHostKey(t).PublicKey()
+
+	for _, c := range []struct {
+		mode          string
+		unknownOK     bool
+		changedOK     bool
+		unknownStored bool
+	}{
+		{"yes", false, false, false},
+		{"ask", false, false, false},
+		{"accept-new", true, false, true},
+		{"no", true, true, true},
+	} {
+		fs := afero.NewMemMapFs()
+		a.NoError(afero.WriteFile(fs, "/known_hosts", []byte(knownHostsLine("", "known", key)), 0o644))
+		kh, err := LoadKnownHosts(fs, "/known_hosts")
+		a.NoError(err)
+
+		err = kh.CheckHostKey("new", key, c.mode, false)
+		a.Equal(c.unknownOK, err == nil, "%s: %v", c.mode, err)
+		a.Equal(c.unknownStored, len(kh.Lookup("new")) == 1, c.mode)
+
+		err = kh.CheckHostKey("known", changed, c.mode, false)
+		a.Equal(c.changedOK, err == nil, "%s: %v", c.mode, err)
+		// a changed key is never recorded
+		a.Len(kh.Lookup("known"), 1, c.mode)
+	}
+
+	fs := afero.NewMemMapFs()
+	a.NoError(afero.WriteFile(fs, "/known_hosts", []byte(knownHostsLine(KnownHostsMarkerRevoked, "*", key)), 0o644))
+	kh, err := LoadKnownHosts(fs, "/known_hosts")
+	a.NoError(err)
+	a.Error(kh.CheckHostKey("new", key, "no", false))
+
+	callback := kh.HostKeyCallback("accept-new", false)
+	a.NoError(callback("Example.com:2222", nil, changed))
+	a.NoError(kh.Check("[example.com]:2222", changed))
+}
+
+func TestLoadKnownHostsForConfig(t *testing.T) {
+	a := require.New(t)
+
+	key := newTestHostKey(t).PublicKey()
+	fs := afero.NewMemMapFs()
+	a.NoError(afero.WriteFile(fs, "/etc/ssh/ssh_known_hosts", []byte(knownHostsLine("", "global", key)), 0o644))
+
+	kh, err := LoadKnownHostsForConfig(fs, &SSHHostConfig{
+		UserKnownHostsFile:   "/home/alice/.ssh/known_hosts /home/alice/.ssh/known_hosts2",
+		GlobalKnownHostsFile: "/etc/ssh/ssh_known_hosts /etc/ssh/ssh_known_hosts2",
+	})
+	a.NoError(err)
+	a.NoError(kh.Check("global", key))
+
+	a.NoError(kh.CheckHostKey("new", key, "accept-new", false))
+	exists, err := afero.Exists(fs, "/home/alice/.ssh/known_hosts")
+	a.NoError(err)
+	a.True(exists)
+
+	// UserKnownHostsFile none
+	kh, err = LoadKnownHostsForConfig(fs, &SSHHostConfig{GlobalKnownHostsFile: "/etc/ssh/ssh_known_hosts"})
+	a.NoError(err)
+	a.Error(kh.CheckHostKey("new", key, "accept-new", false))
+}
@@ -0,0 +1,352 @@
+package qnet
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/qiangyt/go-comm/v3/qerr"
+)
+
+// InterfaceInfo describes a network interface together with its addresses
+type InterfaceInfo struct {
+	Index        int
+	Name         string
+	MTU          int
+	HardwareAddr net.HardwareAddr
+	Flags        net.Flags
+
+	// IPv4 and IPv6 addresses assigned to the interface, with their masks
+	IPv4 []*net.IPNet
+	IPv6 []*net.IPNet
+
+	// Default is true when the interface carries the IPv4 default route
+	Default bool
+}
+
+func (me *InterfaceInfo) IsUp() bool {
+	return me.Flags&net.FlagUp != 0
+}
+
+func (me *InterfaceInfo) IsLoopback() bool {
+	return me.Flags&net.FlagLoopback != 0
+}
+
+func (me *InterfaceInfo) IsBroadcast() bool {
+	return me.Flags&net.FlagBroadcast != 0
+}
+
+func (me *InterfaceInfo) IsMulticast() bool {
+	return me.Flags&net.FlagMulticast != 0
+}
+
+func (me *InterfaceInfo) IsPointToPoint() bool {
+	return me.Flags&net.FlagPointToPoint != 0
+}
+
+// Addrs returns the IPv4 addresses followed by the IPv6 addresses
+func (me *InterfaceInfo) Addrs() []*net.IPNet {
+	r := make([]*net.IPNet, 0, len(me.IPv4)+len(me.IPv6))
+	r = append(r, me.IPv4...)
+	return append(r, me.IPv6...)
+}
+
+// HasIP tells whether ip is assigned to the interface
+func (me *InterfaceInfo) HasIP(ip net.IP) bool {
+	for _, addr := range me.Addrs() {
+		if addr.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func InterfacesP() []*InterfaceInfo {
+	r, err := Interfaces()
+	if err != nil {
+		panic(qerr.NewSystemError("get network interfaces", err))
+	}
+	return r
+}
+
+// Interfaces enumerates the network interfaces of this host, ordered by index.
+// The interface of the default route is marked when the route can be determined.
+func Interfaces() ([]*InterfaceInfo, error) {
+	netIfs, err := net.Interfaces()
+	if err != nil {
+		return nil, errors.Wrap(err, "get network interfaces")
+	}
+
+	r := make([]*InterfaceInfo, 0, len(netIfs))
+	for _, netIf := range netIfs {
+		info, err := newInterfaceInfo(netIf)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, info)
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].Index < r[j].Index })
+
+	if route, err := DefaultRoute(); err == nil {
+		for _, info := range r {
+			info.Default = info.Name == route.Interface
+		}
+	}
+	return r, nil
+}
+
+func InterfaceByNameP(name string) *InterfaceInfo {
+	r, err := InterfaceByName(name)
+	if err != nil {
+		panic(qerr.NewSystemError("get network interface", err))
+	}
+	return r
+}
+
+// InterfaceByName returns the interface with the given name
+func InterfaceByName(name string) (*InterfaceInfo, error) {
+	infos, err := Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		if info.Name == name {
+			return info, nil
+		}
+	}
+	return nil, errors.Errorf("network interface not found: %s", name)
+}
+
+func newInterfaceInfo(netIf net.Interface) (*InterfaceInfo, error) {
+	r := &InterfaceInfo{
+		Index:        netIf.Index,
+		Name:         netIf.Name,
+		MTU:          netIf.MTU,
+		HardwareAddr: netIf.HardwareAddr,
+		Flags:        netIf.Flags,
+	}
+
+	addrs, err := netIf.Addrs()
+	if err != nil {
+		return nil, errors.Wrapf(err, "get addresses for interface: %s", netIf.Name)
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ip4 := ipNet.IP.To4(); ip4 != nil {
+			mask := ipNet.Mask
+			if len(mask) == net.IPv6len {
+				mask = mask[12:]
+			}
+			r.IPv4 = append(r.IPv4, &net.IPNet{IP: ip4, Mask: mask})
+		} else {
+			r.IPv6 = append(r.IPv6, ipNet)
+		}
+	}
+	return r, nil
+}
+
+// ============================================================
+// Routes
+// ============================================================
+
+// Route is an IPv4 routing table entry
+type Route struct {
+	Interface   string
+	Destination *net.IPNet
+	// Gateway is nil for directly connected networks
+	Gateway net.IP
+	Metric  int
+}
+
+// IsDefault tells whether the route is a default route (0.0.0.0/0)
+func (me *Route) IsDefault() bool {
+	ones, _ := me.Destination.Mask.Size()
+	return ones == 0
+}
+
+func DefaultRouteP() *Route {
+	r, err := DefaultRoute()
+	if err != nil {
+		panic(qerr.NewSystemError("get default route", err))
+	}
+	return r
+}
+
+// DefaultRoute returns the IPv4 default route with the lowest metric. On Linux it is read from
+// /proc/net/route; elsewhere, or when that is unavailable, it is derived from the preferred
+// outbound IP and has no gateway.
+func DefaultRoute() (*Route, error) {
+	if routes, err := systemRoutes(); err == nil {
+		if r := defaultRouteOf(routes); r != nil {
+			return r, nil
+		}
+	}
+
+	ip, err := PreferredOutboundIP(false)
+	if err != nil {
+		return nil, errors.Wrap(err, "get default route")
+	}
+	infos, err := net.Interfaces()
+	if err != nil {
+		return nil, errors.Wrap(err, "get default route")
+	}
+	for _, netIf := range infos {
+		info, err := newInterfaceInfo(netIf)
+		if err == nil && info.HasIP(ip) {
+			return &Route{
+				Interface:   info.Name,
+				Destination: &net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)},
+			}, nil
+		}
+	}
+	return nil, errors.Errorf("no default route found")
+}
+
+func defaultRouteOf(routes []*Route) *Route {
+	var r *Route
+	for _, route := range routes {
+		if route.IsDefault() && (r == nil || route.Metric < r.Metric) {
+			r = route
+		}
+	}
+	return r
+}
+
+// Flags of /proc/net/route, see linux/route.h
+const (
+	procRouteFlagUp      = 0x0001
+	procRouteFlagGateway = 0x0002
+)
+
+// ParseProcNetRoute parses the content of Linux /proc/net/route, skipping routes that are not up.
+// Addresses in that file are hexadecimal in host byte order.
+func ParseProcNetRoute(data []byte) ([]*Route, error) {
+	r := []*Route{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		fields := strings.Fields(scanner.Text())
+		if lineNo == 1 || len(fields) == 0 {
+			// header: Iface Destination Gateway Flags RefCnt Use Metric Mask ...
+			continue
+		}
+		if len(fields) < 8 {
+			return nil, errors.Errorf("bad route at line %d: too few fields", lineNo)
+		}
+
+		destination, err1 := parseProcRouteIP(fields[1])
+		gateway, err2 := parseProcRouteIP(fields[2])
+		flags, err3 := strconv.ParseUint(fields[3], 16, 32)
+		metric, err4 := strconv.Atoi(fields[6])
+		mask, err5 := parseProcRouteIP(fields[7])
+		for _, err := range []error{err1, err2, err3, err4, err5} {
+			if err != nil {
+				return nil, errors.Wrapf(err, "bad route at line %d", lineNo)
+			}
+		}
+		if flags&procRouteFlagUp == 0 {
+			continue
+		}
+
+		route := &Route{
+			Interface:   fields[0],
+			Destination: &net.IPNet{IP: destination, Mask: net.IPMask(mask)},
+			Metric:      metric,
+		}
+		if flags&procRouteFlagGateway != 0 {
+			route.Gateway = gateway
+		}
+		r = append(r, route)
+	}
+	return r, scanner.Err()
+}
+
+func parseProcRouteIP(s string) (net.IP, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != net.IPv4len {
+		return nil, errors.Errorf("bad address '%s'", s)
+	}
+	r := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(r, binary.NativeEndian.Uint32(b))
+	return r, nil
+}
+
+// ============================================================
+// Outbound IP
+// ============================================================
+
+// probe addresses for PreferredOutboundIP, no packet is sent to them
+const (
+	outboundProbeIPv4 = "192.0.2.1:9"
+	outboundProbeIPv6 = "[2001:db8::1]:9"
+)
+
+func PreferredOutboundIPP(ipv6 bool) net.IP {
+	r, err := PreferredOutboundIP(ipv6)
+	if err != nil {
+		panic(qerr.NewSystemError("get preferred outbound IP", err))
+	}
+	return r
+}
+
+// PreferredOutboundIP returns the local IP the system would use to reach the internet.
+// It asks the kernel to route a UDP socket (nothing is sent), and falls back to the first
+// global unicast address of an up, non-loopback interface when there is no route.
+func PreferredOutboundIP(ipv6 bool) (net.IP, error) {
+	network, probe := "udp4", outboundProbeIPv4
+	if ipv6 {
+		network, probe = "udp6", outboundProbeIPv6
+	}
+
+	if conn, err := net.Dial(network, probe); err == nil {
+		ip := conn.LocalAddr().(*net.UDPAddr).IP
+		conn.Close()
+		if !ip.IsUnspecified() {
+			return normalizeIP(ip, ipv6), nil
+		}
+	}
+
+	netIfs, err := net.Interfaces()
+	if err != nil {
+		return nil, errors.Wrap(err, "get network interfaces")
+	}
+	sort.Slice(netIfs, func(i, j int) bool { return netIfs[i].Index < netIfs[j].Index })
+	for _, netIf := range netIfs {
+		info, err := newInterfaceInfo(netIf)
+		if err != nil || !info.IsUp() || info.IsLoopback() {
+			continue
+		}
+		addrs := info.IPv4
+		if ipv6 {
+			addrs = info.IPv6
+		}
+		for _, addr := range addrs {
+			if addr.IP.IsGlobalUnicast() {
+				return addr.IP, nil
+			}
+		}
+	}
+
+	if ipv6 {
+		return nil, errors.New("no outbound IPv6 address found")
+	}
+	return nil, errors.New("no outbound IPv4 address found")
+}
+
+func normalizeIP(ip net.IP, ipv6 bool) net.IP {
+	if ip4 := ip.To4(); ip4 != nil && !ipv6 {
+		return ip4
+	}
+	return ip
+}
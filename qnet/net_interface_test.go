@@ -0,0 +1,112 @@
+package qnet
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testProcNetRoute = `Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+eth0	00000000	0100A8C0	0003	0	0	100	00000000	0	0	0
+wlan0	00000000	0101A8C0	0003	0	0	600	00000000	0	0	0
+eth0	0000A8C0	00000000	0001	0	0	100	00FFFFFF	0	0	0
+docker0	000011AC	00000000	0000	0	0	0	0000FFFF	0	0	0
+`
+
+func TestParseProcNetRoute(t *testing.T) {
+	a := require.New(t)
+
+	routes, err := ParseProcNetRoute([]byte(testProcNetRoute))
+	a.NoError(err)
+	a.Len(routes, 3)
+
+	a.Equal("eth0", routes[0].Interface)
+	a.True(routes[0].IsDefault())
+	a.Equal("192.168.0.1", routes[0].Gateway.String())
+	a.Equal(100, routes[0].Metric)
+
+	a.False(routes[2].IsDefault())
+	a.Equal("192.168.0.0/24", routes[2].Destination.String())
+	a.Nil(routes[2].Gateway)
+
+	r := defaultRouteOf(routes)
+	a.Equal("eth0", r.Interface)
+	a.Nil(defaultRouteOf(routes[2:]))
+
+	_, err = ParseProcNetRoute([]byte("header\neth0 zz 00000000 0001 0 0 0 00000000\n"))
+	a.Error(err)
+	_, err = ParseProcNetRoute([]byte("header\neth0 00000000\n"))
+	a.Error(err)
+}
+
+func TestInterfaces(t *testing.T) {
+	a := require.New(t)
+
+	infos, err := Interfaces()
+	a.NoError(err)
+	a.NotEmpty(infos)
+
+	var loopback *InterfaceInfo
+	for i, info := range infos {
+		if i > 0 {
+			a.Less(infos[i-1].Index, info.Index)
+		}
+		for _, addr := range info.IPv4 {
+			a.Len(addr.IP, net.IPv4len)
+			a.Len(addr.Mask, net.IPv4len)
+		}
+		for _, addr := range info.IPv6 {
+			a.Nil(addr.IP.To4())
+		}
+		if info.IsLoopback() && len(info.IPv4) > 0 {
+			loopback = info
+		}
+	}
+	if loopback == nil {
+		t.Skip("no IPv4 loopback interface")
+	}
+
+	a.True(loopback.IsUp())
+	a.True(loopback.HasIP(net.ParseIP("127.0.0.1")))
+	a.Positive(loopback.MTU)
+
+	byName, err := InterfaceByName(loopback.Name)
+	a.NoError(err)
+	a.Equal(loopback.Index, byName.Index)
+
+	_, err = InterfaceByName("nonexistent-interface-xyz")
+	a.Error(err)
+}
+
+func TestDefaultRoute(t *testing.T) {
+	a := require.New(t)
+
+	route, err := DefaultRoute()
+	if err != nil {
+		t.Skipf("no default route: %v", err)
+	}
+	a.True(route.IsDefault())
+
+	info, err := InterfaceByName(route.Interface)
+	a.NoError(err)
+	a.True(info.Default)
+}
+
+func TestPreferredOutboundIP(t *testing.T) {
+	a := require.New(t)
+
+	ip, err := PreferredOutboundIP(false)
+	if err != nil {
+		t.Skipf("no outbound IPv4 address: %v", err)
+	}
+	a.Len(ip, net.IPv4len)
+	a.False(ip.IsLoopback())
+	a.False(ip.IsUnspecified())
+
+	found := false
+	for _, info := range InterfacesP() {
+		found = found || info.HasIP(ip)
+	}
+	a.True(found)
+}
@@ -0,0 +1,21 @@
+//go:build linux
+// +build linux
+
+package qnet
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+const procNetRouteFile = "/proc/net/route"
+
+// systemRoutes reads the IPv4 routing table of the kernel
+func systemRoutes() ([]*Route, error) {
+	data, err := os.ReadFile(procNetRouteFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read %s", procNetRouteFile)
+	}
+	return ParseProcNetRoute(data)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package qnet
+
+import (
+	"github.com/pkg/errors"
+)
+
+// systemRoutes is not supported here, DefaultRoute falls back to the preferred outbound IP
+func systemRoutes() ([]*Route, error) {
+	return nil, errors.New("routing table is not supported on this platform")
+}
@@ -0,0 +1,67 @@
+package qnet
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/qiangyt/go-comm/v3/qerr"
+)
+
+func FreePortP(host string) int {
+	r, err := FreePort(host)
+	if err != nil {
+		panic(qerr.NewSystemError("allocate free port", err))
+	}
+	return r
+}
+
+// FreePort asks the kernel for a TCP port that is currently free on host ("" for all addresses).
+// The port is released before returning, so another process may take it in the meantime.
+func FreePort(host string) (int, error) {
+	r, err := FreePorts(host, 1)
+	if err != nil {
+		return 0, err
+	}
+	return r[0], nil
+}
+
+func FreePortsP(host string, count int) []int {
+	r, err := FreePorts(host, count)
+	if err != nil {
+		panic(qerr.NewSystemError("allocate free ports", err))
+	}
+	return r
+}
+
+// FreePorts returns count distinct free TCP ports on host. All ports are held until every one
+// has been allocated, so the kernel never hands out the same port twice.
+func FreePorts(host string, count int) ([]int, error) {
+	listeners := make([]net.Listener, 0, count)
+	defer func() {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+	}()
+
+	r := make([]int, 0, count)
+	for i := 0; i < count; i++ {
+		listener, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+		if err != nil {
+			return nil, errors.Wrapf(err, "allocate free port on '%s'", host)
+		}
+		listeners = append(listeners, listener)
+		r = append(r, listener.Addr().(*net.TCPAddr).Port)
+	}
+	return r, nil
+}
+
+// IsPortFree tells whether a TCP listener can currently be bound to host:port
+func IsPortFree(host string, port int) bool {
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return false
+	}
+	listener.Close()
+	return true
+}
@@ -0,0 +1,39 @@
+package qnet
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFreePort(t *testing.T) {
+	a := require.New(t)
+
+	port, err := FreePort("127.0.0.1")
+	a.NoError(err)
+	a.Positive(port)
+	a.True(IsPortFree("127.0.0.1", port))
+
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	a.NoError(err)
+	defer listener.Close()
+	a.False(IsPortFree("127.0.0.1", port))
+}
+
+func TestFreePorts(t *testing.T) {
+	a := require.New(t)
+
+	ports := FreePortsP("127.0.0.1", 20)
+	a.Len(ports, 20)
+
+	seen := map[int]bool{}
+	for _, port := range ports {
+		a.False(seen[port])
+		seen[port] = true
+	}
+
+	_, err := FreePorts("256.0.0.1", 1)
+	a.Error(err)
+}
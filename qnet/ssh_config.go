@@ -1,7 +1,6 @@
 package qnet
 
 import (
-	"bufio"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -20,7 +19,10 @@ func ParseIntDefault(s string, defaultValue int) int {
 	return defaultValue
 }
 
-// SSHHostConfig represents SSH configuration for a specific host
+// SSHHostConfig represents SSH configuration for a specific host, resolved the way
+// `ssh -G` does. File names are expanded (~, ${VAR} and %-tokens), forwards use the
+// normalized form printed by `ssh -G`, and multi-state options use their config
+// keywords (e.g. StrictHostKeyCheck is one of yes, no, ask, accept-new).
 type SSHHostConfig struct {
 	Host                 string
 	HostName             string
@@ -37,6 +39,9 @@ type SSHHostConfig struct {
 	ServerAliveCountMax  int
 	StrictHostKeyCheck   string
 	UserKnownHostsFile   string
+	GlobalKnownHostsFile string
+	HashKnownHosts       bool
+	HostKeyAlias         string
 	ConnectTimeout       int
 	PasswordAuth         bool
 	PubkeyAuth           bool
@@ -55,285 +60,101 @@ type SSHHostConfig struct {
 	ControlPersist       string
 }
 
-// SSHConfig represents the parsed SSH configuration
+// SSHConfig represents the parsed SSH configuration.
+//
+// Configuration files are evaluated per host following ssh_config(5): Include, Host and
+// Match blocks (all, canonical, final, exec, host, originalhost, user, localuser), the
+// first obtained value of each option wins, and a "Match final" block triggers a second
+// pass against the resolved HostName. Hostname canonicalization is not performed, and
+// keywords that SSHHostConfig does not model are accepted without validation.
 type SSHConfig struct {
-	hosts map[string]*SSHHostConfig
+	files []*sshConfigFile
+	env   *sshLocalEnv
 }
 
 // NewSSHConfig creates a new SSHConfig
 func NewSSHConfig() *SSHConfig {
-	return &SSHConfig{
-		hosts: make(map[string]*SSHHostConfig),
-	}
+	return &SSHConfig{}
 }
 
-// LoadSSHConfig loads SSH configuration from ~/.ssh/config
+// LoadSSHConfig loads SSH configuration from ~/.ssh/config followed by the
+// system-wide /etc/ssh/ssh_config, like ssh does when no -F option is given
 func LoadSSHConfig() (*SSHConfig, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		return nil, errors.Wrap(err, "get user home directory")
 	}
 
-	configPath := filepath.Join(homeDir, ".ssh", "config")
-	return LoadSSHConfigFromFile(configPath)
+	r := NewSSHConfig()
+	env := r.localEnv()
+	if err := r.load(filepath.Join(homeDir, ".ssh", "config"), filepath.Join(env.home, ".ssh")); err != nil {
+		return nil, err
+	}
+	if err := r.load(sshSystemConfigFile, filepath.Dir(sshSystemConfigFile)); err != nil {
+		return nil, err
+	}
+	return r, nil
 }
 
-// LoadSSHConfigFromFile loads SSH configuration from specified file
+// sshSystemConfigFile is the system-wide configuration file
+const sshSystemConfigFile = "/etc/ssh/ssh_config"
+
+// LoadSSHConfigFromFile loads SSH configuration from specified file, like `ssh -F`.
+// Relative Include paths are resolved against ~/.ssh.
 func LoadSSHConfigFromFile(path string) (*SSHConfig, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			// Return empty config if file doesn't exist
-			return NewSSHConfig(), nil
-		}
-		return nil, errors.Wrapf(err, "open SSH config file: %s", path)
+	r := NewSSHConfig()
+	if err := r.load(path, filepath.Join(r.localEnv().home, ".ssh")); err != nil {
+		return nil, err
 	}
-	defer file.Close()
-
-	config := NewSSHConfig()
-	scanner := bufio.NewScanner(file)
-
-	var currentHost *SSHHostConfig
-	var currentHostPatterns []string
-
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-
-		// Skip empty lines and comments
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-
-		// Split into key and value
-		parts := strings.Fields(line)
-		if len(parts) < 2 {
-			continue
-		}
-
-		key := strings.ToLower(parts[0])
-		value := strings.Join(parts[1:], " ")
-
-		// Remove quotes if present
-		value = strings.Trim(value, "\"'")
-
-		switch key {
-		case "host":
-			// Save previous host config
-			if currentHost != nil {
-				for _, pattern := range currentHostPatterns {
-					config.hosts[pattern] = currentHost
-				}
-			}
-
-			// Start new host config
-			currentHostPatterns = parts[1:]
-			currentHost = &SSHHostConfig{
-				Host:                currentHostPatterns[0],
-				Port:                22, // default SSH port
-				IdentityFiles:       []string{},
-				PreferredAuth:       []string{},
-				SendEnv:             []string{},
-				SetEnv:              make(map[string]string),
-				LocalForward:        []string{},
-				RemoteForward:       []string{},
-				DynamicForward:      []string{},
-				ServerAliveInterval: 0,
-				ServerAliveCountMax: 3,
-				StrictHostKeyCheck:  "ask",
-			}
-
-		case "hostname":
-			if currentHost != nil {
-				currentHost.HostName = value
-			}
-
-		case "user":
-			if currentHost != nil {
-				currentHost.User = value
-			}
-
-		case "port":
-			if currentHost != nil {
-				port := ParseIntDefault(value, 22)
-				currentHost.Port = port
-			}
-
-		case "identityfile":
-			if currentHost != nil {
-				// Expand ~ to home directory
-				if strings.HasPrefix(value, "~/") {
-					homeDir, _ := os.UserHomeDir()
-					value = filepath.Join(homeDir, value[2:])
-				}
-				currentHost.IdentityFiles = append(currentHost.IdentityFiles, value)
-				if currentHost.IdentityFile == "" {
-					currentHost.IdentityFile = value
-				}
-			}
-
-		case "preferredauthentications":
-			if currentHost != nil {
-				auths := strings.Split(value, ",")
-				for i, auth := range auths {
-					auths[i] = strings.TrimSpace(auth)
-				}
-				currentHost.PreferredAuth = auths
-			}
-
-		case "proxyjump":
-			if currentHost != nil {
-				currentHost.ProxyJump = value
-			}
-
-		case "proxycommand":
-			if currentHost != nil {
-				currentHost.ProxyCommand = value
-			}
-
-		case "forwardagent":
-			if currentHost != nil {
-				currentHost.ForwardAgent = strings.ToLower(value) == "yes"
-			}
-
-		case "compression":
-			if currentHost != nil {
-				currentHost.Compression = strings.ToLower(value) == "yes"
-			}
-
-		case "serveraliveinterval":
-			if currentHost != nil {
-				currentHost.ServerAliveInterval = ParseIntDefault(value, 0)
-			}
-
-		case "serveralivecountmax":
-			if currentHost != nil {
-				currentHost.ServerAliveCountMax = ParseIntDefault(value, 3)
-			}
-
-		case "stricthostkeychecking":
-			if currentHost != nil {
-				currentHost.StrictHostKeyCheck = value
-			}
-
-		case "userknownhostsfile":
-			if currentHost != nil {
-				currentHost.UserKnownHostsFile = value
-			}
-
-		case "connecttimeout":
-			if currentHost != nil {
-				currentHost.ConnectTimeout = ParseIntDefault(value, 0)
-			}
-
-		case "passwordauthentication":
-			if currentHost != nil {
-				currentHost.PasswordAuth = strings.ToLower(value) == "yes"
-			}
-
-		case "pubkeyauthentication":
-			if currentHost != nil {
-				currentHost.PubkeyAuth = strings.ToLower(value) == "yes"
-			}
-
-		case "kbdinteractiveauthentication":
-			if currentHost != nil {
-				currentHost.KbdInteractiveAuth = strings.ToLower(value) == "yes"
-			}
-
-		case "rekeylimit":
-			if currentHost != nil {
-				currentHost.RekeyLimit = value
-			}
-
-		case "sendenv":
-			if currentHost != nil {
-				currentHost.SendEnv = append(currentHost.SendEnv, value)
-			}
-
-		case "setenv":
-			if currentHost != nil {
-				envParts := strings.SplitN(value, "=", 2)
-				if len(envParts) == 2 {
-					currentHost.SetEnv[envParts[0]] = envParts[1]
-				}
-			}
-
-		case "requesttty":
-			if currentHost != nil {
-				currentHost.RequestTTY = value
-			}
-
-		case "remotecommand":
-			if currentHost != nil {
-				currentHost.RemoteCommand = value
-			}
-
-		case "localforward":
-			if currentHost != nil {
-				currentHost.LocalForward = append(currentHost.LocalForward, value)
-			}
-
-		case "remoteforward":
-			if currentHost != nil {
-				currentHost.RemoteForward = append(currentHost.RemoteForward, value)
-			}
-
-		case "dynamicforward":
-			if currentHost != nil {
-				currentHost.DynamicForward = append(currentHost.DynamicForward, value)
-			}
-
-		case "exitonforwardfailure":
-			if currentHost != nil {
-				currentHost.ExitOnForwardFailure = strings.ToLower(value) == "yes"
-			}
-
-		case "controlmaster":
-			if currentHost != nil {
-				currentHost.ControlMaster = value
-			}
-
-		case "controlpath":
-			if currentHost != nil {
-				currentHost.ControlPath = value
-			}
+	return r, nil
+}
 
-		case "controlpersist":
-			if currentHost != nil {
-				currentHost.ControlPersist = value
-			}
-		}
+// load appends a configuration file, a missing file is ignored
+func (c *SSHConfig) load(path string, includeDir string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
 	}
 
-	// Save last host config
-	if currentHost != nil {
-		for _, pattern := range currentHostPatterns {
-			config.hosts[pattern] = currentHost
-		}
+	loader := &sshConfigLoader{env: c.localEnv(), includeDir: includeDir}
+	f, err := loader.loadFile(path, 0)
+	if err != nil {
+		return err
 	}
+	c.files = append(c.files, f)
+	return nil
+}
 
-	if err := scanner.Err(); err != nil {
-		return nil, errors.Wrap(err, "read SSH config file")
+func (c *SSHConfig) localEnv() *sshLocalEnv {
+	if c.env == nil {
+		c.env = defaultSSHLocalEnv()
 	}
+	return c.env
+}
 
-	return config, nil
+// Resolve returns the effective configuration for the host as `ssh -G host` would
+// print it, including defaults for options that no block sets
+func (c *SSHConfig) Resolve(host string) (*SSHHostConfig, error) {
+	r, _, err := c.resolve(host)
+	return r, err
 }
 
-// GetHostConfig returns SSH configuration for the specified host
-func (c *SSHConfig) GetHostConfig(host string) *SSHHostConfig {
-	// Try exact match first
-	if config, ok := c.hosts[host]; ok {
-		return config
+func (c *SSHConfig) resolve(host string) (*SSHHostConfig, bool, error) {
+	resolver := newSSHResolver(c.localEnv(), c.files, host)
+	r, err := resolver.resolve()
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "resolve SSH config for host '%s'", host)
 	}
+	return r, resolver.matched, nil
+}
 
-	// Try wildcard match
-	for pattern, config := range c.hosts {
-		if MatchSSHPattern(pattern, host) {
-			return config
-		}
+// GetHostConfig returns SSH configuration for the specified host, or nil when no
+// directive of the configuration applies to it or it cannot be resolved (see Resolve)
+func (c *SSHConfig) GetHostConfig(host string) *SSHHostConfig {
+	r, matched, err := c.resolve(host)
+	if err != nil || !matched {
+		return nil
 	}
-
-	return nil
+	return r
 }
 
 // MatchSSHPattern matches host against SSH config pattern
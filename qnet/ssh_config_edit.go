@@ -0,0 +1,492 @@
+package qnet
+
+import (
+	"bytes"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/qiangyt/go-comm/v3/qio"
+	"github.com/spf13/afero"
+)
+
+// sshConfigDefaultIndent indents directives of new Host blocks when the file has no indented directive
+const sshConfigDefaultIndent = "    "
+
+// SSHConfigEditor edits an ssh_config file in place. Lines that are not edited, including
+// comments, blank lines, indentation, keyword spelling and line endings, are written back
+// unchanged. Include directives are kept but not followed.
+type SSHConfigEditor struct {
+	fs   afero.Fs
+	path string
+
+	// global holds the lines before the first Host or Match line
+	global *SSHConfigBlock
+	blocks []*SSHConfigBlock
+
+	newline string
+	indent  string
+}
+
+// SSHConfigBlock is the global section of an ssh_config file or one Host or Match block
+type SSHConfigBlock struct {
+	editor *SSHConfigEditor
+	// header is the Host or Match line, nil for the global section
+	header *sshConfigEditLine
+	// leading holds the comment lines directly above the header, which belong to the block
+	leading []*sshConfigEditLine
+	lines   []*sshConfigEditLine
+}
+
+// sshConfigEditLine is one line of the edited file
+type sshConfigEditLine struct {
+	// text is the line without its line ending, eol is "\n", "\r\n" or "" for a last line without one
+	text string
+	eol  string
+
+	keyword string // lower-cased, empty for blank and comment lines
+	args    []string
+	// prefix is everything up to the arguments: indentation, keyword and separator
+	prefix string
+	// comment is a trailing comment including the whitespace before it
+	comment string
+}
+
+// LoadSSHConfigEditor reads the file at path for editing, a missing file is edited as an empty one
+func LoadSSHConfigEditor(fs afero.Fs, path string) (*SSHConfigEditor, error) {
+	data, err := afero.ReadFile(fs, path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrapf(err, "read SSH config file: %s", path)
+	}
+	return ParseSSHConfigEditor(fs, path, data), nil
+}
+
+// ParseSSHConfigEditor parses data as the content of the file at path
+func ParseSSHConfigEditor(fs afero.Fs, path string, data []byte) *SSHConfigEditor {
+	r := &SSHConfigEditor{fs: fs, path: path, newline: "\n"}
+	if bytes.Contains(data, []byte("\r\n")) {
+		r.newline = "\r\n"
+	}
+	r.global = &SSHConfigBlock{editor: r}
+
+	current := r.global
+	for len(data) > 0 {
+		var text, eol string
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			text, eol, data = string(data[:i]), "\n", data[i+1:]
+			if strings.HasSuffix(text, "\r") {
+				text, eol = text[:len(text)-1], "\r\n"
+			}
+		} else {
+			text, data = string(data), nil
+		}
+
+		line := parseSSHConfigEditLine(text)
+		line.eol = eol
+		if line.keyword == "host" || line.keyword == "match" {
+			current = &SSHConfigBlock{editor: r, header: line, leading: current.takeLeadingComments()}
+			r.blocks = append(r.blocks, current)
+			continue
+		}
+		current.lines = append(current.lines, line)
+
+		if len(r.indent) == 0 && current.header != nil && len(line.keyword) > 0 {
+			r.indent = line.indent()
+		}
+	}
+
+	if len(r.indent) == 0 {
+		r.indent = sshConfigDefaultIndent
+	}
+	return r
+}
+
+// parseSSHConfigEditLine splits a line into its parts, an unparsable line is kept as a comment
+func parseSSHConfigEditLine(text string) *sshConfigEditLine {
+	r := &sshConfigEditLine{text: text}
+
+	keyword, rest, ok := splitSSHKeyword(text)
+	if !ok {
+		return r
+	}
+	args, err := splitSSHArgs(rest)
+	if err != nil {
+		return r
+	}
+
+	content := strings.TrimRight(text, " \t\r\f")
+	r.keyword = strings.ToLower(keyword)
+	r.args = args
+	r.prefix = content[:len(content)-len(rest)]
+	if i := sshCommentIndex(rest); i >= 0 {
+		r.comment = rest[len(strings.TrimRight(rest[:i], " \t")):]
+	}
+	return r
+}
+
+// sshCommentIndex returns where a trailing comment starts in the arguments, or -1
+func sshCommentIndex(s string) int {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s):
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t'):
+			return i
+		}
+	}
+	return -1
+}
+
+func (me *sshConfigEditLine) indent() string {
+	return me.text[:len(me.text)-len(strings.TrimLeft(me.text, " \t"))]
+}
+
+func (me *sshConfigEditLine) isBlank() bool {
+	return len(strings.TrimSpace(me.text)) == 0
+}
+
+func (me *sshConfigEditLine) isComment() bool {
+	return len(me.keyword) == 0 && !me.isBlank()
+}
+
+// setArgs rewrites the arguments, keeping the keyword spelling, separator and trailing comment
+func (me *sshConfigEditLine) setArgs(args []string) {
+	me.args = args
+	me.text = me.prefix + formatSSHArgs(args) + me.comment
+}
+
+// takeLeadingComments removes the comment lines at the end of the block when they are
+// separated from its directives by a blank line, they describe the block that follows
+func (me *SSHConfigBlock) takeLeadingComments() []*sshConfigEditLine {
+	i := len(me.lines)
+	for i > 0 && me.lines[i-1].isComment() {
+		i--
+	}
+	if i == len(me.lines) || i == 0 || !me.lines[i-1].isBlank() {
+		return nil
+	}
+
+	r := me.lines[i:]
+	me.lines = me.lines[:i:i]
+	return r
+}
+
+// ============================================================
+// file
+// ============================================================
+
+// Path returns the path of the edited file
+func (me *SSHConfigEditor) Path() string {
+	return me.path
+}
+
+// Bytes returns the edited content
+func (me *SSHConfigEditor) Bytes() []byte {
+	lines := me.global.allLines()
+	for _, b := range me.blocks {
+		lines = append(lines, b.allLines()...)
+	}
+
+	var r bytes.Buffer
+	for i, line := range lines {
+		r.WriteString(line.text)
+		if len(line.eol) == 0 && i+1 < len(lines) {
+			r.WriteString(me.newline)
+		} else {
+			r.WriteString(line.eol)
+		}
+	}
+	return r.Bytes()
+}
+
+// String returns the edited content
+func (me *SSHConfigEditor) String() string {
+	return string(me.Bytes())
+}
+
+// Save writes the edited content back to the file. The content is written to a temporary
+// file that replaces the original, which keeps its permissions; a new file is created
+// with mode 0600 in a directory created with mode 0700. When the file is a symlink, as
+// dotfile managers create, the file it points to is replaced and the link is kept.
+func (me *SSHConfigEditor) Save() error {
+	options := qio.AtomicWriteOptions{Perm: 0o600, KeepPerm: true, DirPerm: 0o700}
+	if err := qio.WriteFileAtomic(me.fs, me.path, me.Bytes(), options); err != nil {
+		return errors.Wrapf(err, "write SSH config file: %s", me.path)
+	}
+	return nil
+}
+
+// Global returns the section before the first Host or Match line, whose options apply to every host
+func (me *SSHConfigEditor) Global() *SSHConfigBlock {
+	return me.global
+}
+
+// Blocks returns the Host and Match blocks in file order
+func (me *SSHConfigEditor) Blocks() []*SSHConfigBlock {
+	return append([]*SSHConfigBlock{}, me.blocks...)
+}
+
+// Host returns the Host block whose patterns are exactly the given ones, or nil
+func (me *SSHConfigEditor) Host(patterns ...string) *SSHConfigBlock {
+	for _, b := range me.blocks {
+		if b.header.keyword == "host" && sameStrings(b.header.args, patterns) {
+			return b
+		}
+	}
+	return nil
+}
+
+// AddHost appends a Host block. As the first obtained value of an option wins, the new block
+// is inserted before a trailing "Host *" block so that the defaults there do not shadow it.
+func (me *SSHConfigEditor) AddHost(patterns ...string) (*SSHConfigBlock, error) {
+	if len(patterns) == 0 {
+		return nil, errors.New("Host directive without patterns")
+	}
+	header := &sshConfigEditLine{keyword: "host", prefix: "Host ", eol: me.newline}
+	if err := validateSSHOption(header.toConfigLine(me.path, patterns)); err != nil {
+		return nil, err
+	}
+	header.setArgs(patterns)
+
+	r := &SSHConfigBlock{editor: me, header: header}
+
+	at := len(me.blocks)
+	if at > 0 && me.blocks[at-1].isCatchAll() {
+		at--
+		// keep the blank line that separated the catch-all block
+		r.lines = append(r.lines, me.blankLine())
+	}
+
+	before := me.global
+	if at > 0 {
+		before = me.blocks[at-1]
+	}
+	if last := before.lastLine(); last != nil && !last.isBlank() {
+		r.leading = append(r.leading, me.blankLine())
+	}
+
+	me.blocks = append(me.blocks[:at], append([]*SSHConfigBlock{r}, me.blocks[at:]...)...)
+	return r, nil
+}
+
+// RemoveHost removes the Host block whose patterns are exactly the given ones, together
+// with the comments directly above it, and reports whether it was found
+func (me *SSHConfigEditor) RemoveHost(patterns ...string) bool {
+	b := me.Host(patterns...)
+	if b == nil {
+		return false
+	}
+	me.RemoveBlock(b)
+	return true
+}
+
+// RemoveBlock removes a Host or Match block
+func (me *SSHConfigEditor) RemoveBlock(block *SSHConfigBlock) {
+	for i, b := range me.blocks {
+		if b == block {
+			me.blocks = append(me.blocks[:i], me.blocks[i+1:]...)
+			return
+		}
+	}
+}
+
+func (me *SSHConfigEditor) blankLine() *sshConfigEditLine {
+	return &sshConfigEditLine{eol: me.newline}
+}
+
+// ============================================================
+// block
+// ============================================================
+
+// Keyword returns "Host" or "Match" as written in the file, or "" for the global section
+func (me *SSHConfigBlock) Keyword() string {
+	if me.header == nil {
+		return ""
+	}
+	keyword := strings.TrimRight(strings.TrimSpace(me.header.prefix), " \t=")
+	return strings.Trim(keyword, `"`)
+}
+
+// Patterns returns the arguments of the Host or Match line
+func (me *SSHConfigBlock) Patterns() []string {
+	if me.header == nil {
+		return nil
+	}
+	return append([]string{}, me.header.args...)
+}
+
+// Get returns the arguments of the first occurrence of an option
+func (me *SSHConfigBlock) Get(keyword string) ([]string, bool) {
+	keyword = strings.ToLower(keyword)
+	for _, line := range me.lines {
+		if line.keyword == keyword {
+			return append([]string{}, line.args...), true
+		}
+	}
+	return nil, false
+}
+
+// GetAll returns the arguments of every occurrence of an option, e.g. IdentityFile
+func (me *SSHConfigBlock) GetAll(keyword string) [][]string {
+	keyword = strings.ToLower(keyword)
+	r := [][]string{}
+	for _, line := range me.lines {
+		if line.keyword == keyword {
+			r = append(r, append([]string{}, line.args...))
+		}
+	}
+	return r
+}
+
+// Set makes args the only value of an option: the first occurrence is rewritten in place
+// and the others are removed, or a new line is added when the option is absent
+func (me *SSHConfigBlock) Set(keyword string, args ...string) error {
+	line, err := me.newLine(keyword, args)
+	if err != nil {
+		return err
+	}
+
+	kept := me.lines[:0]
+	found := false
+	for _, l := range me.lines {
+		if l.keyword != line.keyword {
+			kept = append(kept, l)
+		} else if !found {
+			found = true
+			l.setArgs(args)
+			kept = append(kept, l)
+		}
+	}
+	me.lines = kept
+
+	if !found {
+		me.insert(line)
+	}
+	return nil
+}
+
+// Add appends another occurrence of an option, for options that accumulate like IdentityFile or LocalForward
+func (me *SSHConfigBlock) Add(keyword string, args ...string) error {
+	line, err := me.newLine(keyword, args)
+	if err != nil {
+		return err
+	}
+	me.insert(line)
+	return nil
+}
+
+// Remove deletes every occurrence of an option and returns how many lines were removed
+func (me *SSHConfigBlock) Remove(keyword string) int {
+	keyword = strings.ToLower(keyword)
+	kept := me.lines[:0]
+	for _, l := range me.lines {
+		if l.keyword != keyword {
+			kept = append(kept, l)
+		}
+	}
+	r := len(me.lines) - len(kept)
+	me.lines = kept
+	return r
+}
+
+// newLine creates a validated directive line indented like the block
+func (me *SSHConfigBlock) newLine(keyword string, args []string) (*sshConfigEditLine, error) {
+	lower := strings.ToLower(keyword)
+	switch lower {
+	case "host", "match", "include":
+		return nil, errors.Errorf("%s cannot be edited as an option", keyword)
+	}
+	if len(args) == 0 {
+		return nil, errors.Errorf("no argument for keyword \"%s\"", keyword)
+	}
+
+	r := &sshConfigEditLine{keyword: lower, eol: me.editor.newline}
+	if err := validateSSHOption(r.toConfigLine(me.editor.path, args)); err != nil {
+		return nil, err
+	}
+
+	r.prefix = me.indent() + keyword + " "
+	r.setArgs(args)
+	return r, nil
+}
+
+// insert adds a line after the last directive of the block, before trailing blank lines and comments
+func (me *SSHConfigBlock) insert(line *sshConfigEditLine) {
+	at := len(me.lines)
+	for at > 0 && len(me.lines[at-1].keyword) == 0 {
+		at--
+	}
+	me.lines = append(me.lines[:at], append([]*sshConfigEditLine{line}, me.lines[at:]...)...)
+}
+
+func (me *SSHConfigBlock) indent() string {
+	for _, l := range me.lines {
+		if len(l.keyword) > 0 {
+			return l.indent()
+		}
+	}
+	if me.header == nil {
+		return ""
+	}
+	return me.editor.indent
+}
+
+// isCatchAll reports whether the block is "Host *"
+func (me *SSHConfigBlock) isCatchAll() bool {
+	return me.header != nil && me.header.keyword == "host" && sameStrings(me.header.args, []string{"*"})
+}
+
+func (me *SSHConfigBlock) allLines() []*sshConfigEditLine {
+	r := append([]*sshConfigEditLine{}, me.leading...)
+	if me.header != nil {
+		r = append(r, me.header)
+	}
+	return append(r, me.lines...)
+}
+
+func (me *SSHConfigBlock) lastLine() *sshConfigEditLine {
+	lines := me.allLines()
+	if len(lines) == 0 {
+		return nil
+	}
+	return lines[len(lines)-1]
+}
+
+// toConfigLine converts to the loader's representation for validation
+func (me *sshConfigEditLine) toConfigLine(path string, args []string) *sshConfigLine {
+	return &sshConfigLine{file: path, keyword: me.keyword, args: args, raw: strings.Join(args, " ")}
+}
+
+// formatSSHArgs joins arguments, quoting those that splitSSHArgs would otherwise split or strip
+func formatSSHArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if len(arg) > 0 && !strings.ContainsAny(arg, " \t\"'\\#") {
+			quoted[i] = arg
+			continue
+		}
+		arg = strings.ReplaceAll(arg, `\`, `\\`)
+		quoted[i] = `"` + strings.ReplaceAll(arg, `"`, `\"`) + `"`
+	}
+	return strings.Join(quoted, " ")
+}
+
+func sameStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
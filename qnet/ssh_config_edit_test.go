@@ -0,0 +1,256 @@
+package qnet
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+const testSSHConfigEditContent = `# managed by hand
+Compression yes
+
+# production
+Host prod prod.example.com
+  HostName 10.0.0.1
+  User=admin # the ops account
+  IdentityFile "~/.ssh/id prod"
+  IdentityFile ~/.ssh/id_rsa
+
+Match host *.ci.example.com
+	User builder
+
+# defaults
+Host *
+  ServerAliveInterval 30
+`
+
+func TestSSHConfigEditor_roundTrip(t *testing.T) {
+	a := require.New(t)
+
+	for _, content := range []string{
+		testSSHConfigEditContent,
+		strings.ReplaceAll(testSSHConfigEditContent, "\n", "\r\n"),
+		strings.TrimSuffix(testSSHConfigEditContent, "\n"),
+		"",
+		"Host broken \"unterminated\n  User x\n",
+	} {
+		editor := ParseSSHConfigEditor(afero.NewMemMapFs(), "/config", []byte(content))
+		a.Equal(content, editor.String())
+	}
+}
+
+func TestSSHConfigEditor_blocks(t *testing.T) {
+	a := require.New(t)
+
+	editor := ParseSSHConfigEditor(afero.NewMemMapFs(), "/config", []byte(testSSHConfigEditContent))
+
+	compression, ok := editor.Global().Get("compression")
+	a.True(ok)
+	a.Equal([]string{"yes"}, compression)
+
+	blocks := editor.Blocks()
+	a.Len(blocks, 3)
+	a.Equal("Host", blocks[0].Keyword())
+	a.Equal([]string{"prod", "prod.example.com"}, blocks[0].Patterns())
+	a.Equal("Match", blocks[1].Keyword())
+	a.Equal([]string{"host", "*.ci.example.com"}, blocks[1].Patterns())
+
+	prod := editor.Host("prod", "prod.example.com")
+	a.NotNil(prod)
+	a.Nil(editor.Host("prod"))
+
+	user, ok := prod.Get("User")
+	a.True(ok)
+	a.Equal([]string{"admin"}, user)
+	a.Equal([][]string{{"~/.ssh/id prod"}, {"~/.ssh/id_rsa"}}, prod.GetAll("IdentityFile"))
+
+	_, ok = prod.Get("Port")
+	a.False(ok)
+}
+
+func TestSSHConfigEditor_editOptions(t *testing.T) {
+	a := require.New(t)
+
+	editor := ParseSSHConfigEditor(afero.NewMemMapFs(), "/config", []byte(testSSHConfigEditContent))
+	prod := editor.Host("prod", "prod.example.com")
+
+	a.NoError(prod.Set("User", "deploy"))
+	a.NoError(prod.Set("Port", "2222"))
+	a.NoError(prod.Set("IdentityFile", "~/.ssh/deploy key"))
+	a.NoError(prod.Add("LocalForward", "8080", "localhost:80"))
+	a.Equal(1, editor.Host("*").Remove("serveraliveinterval"))
+	a.NoError(editor.Global().Set("ForwardAgent", "no"))
+
+	a.Equal(`# managed by hand
+Compression yes
+ForwardAgent no
+
+# production
+Host prod prod.example.com
+  HostName 10.0.0.1
+  User=deploy # the ops account
+  IdentityFile "~/.ssh/deploy key"
+  Port 2222
+  LocalForward 8080 localhost:80
+
+Match host *.ci.example.com
+	User builder
+
+# defaults
+Host *
+`, editor.String())
+}
+
+func TestSSHConfigEditor_editErrors(t *testing.T) {
+	a := require.New(t)
+
+	editor := ParseSSHConfigEditor(afero.NewMemMapFs(), "/config", []byte(testSSHConfigEditContent))
+	prod := editor.Host("prod", "prod.example.com")
+
+	a.Error(prod.Set("Port", "abc"))
+	a.Error(prod.Set("Host", "other"))
+	a.Error(prod.Add("Include", "other.conf"))
+	a.Error(prod.Set("User"))
+	a.Error(prod.Add("LocalForward", "8080"))
+
+	_, err := editor.AddHost()
+	a.Error(err)
+
+	a.Equal(testSSHConfigEditContent, editor.String())
+}
+
+func TestSSHConfigEditor_hosts(t *testing.T) {
+	a := require.New(t)
+
+	editor := ParseSSHConfigEditor(afero.NewMemMapFs(), "/config", []byte(testSSHConfigEditContent))
+
+	staging, err := editor.AddHost("staging")
+	a.NoError(err)
+	a.NoError(staging.Set("HostName", "10.0.0.2"))
+	a.NoError(staging.Set("User", "deploy"))
+
+	a.True(editor.RemoveHost("prod", "prod.example.com"))
+	a.False(editor.RemoveHost("prod", "prod.example.com"))
+
+	a.Equal(`# managed by hand
+Compression yes
+
+Match host *.ci.example.com
+	User builder
+
+Host staging
+  HostName 10.0.0.2
+  User deploy
+
+# defaults
+Host *
+  ServerAliveInterval 30
+`, editor.String())
+
+	// removing the added block restores the original layout
+	a.True(editor.RemoveHost("staging"))
+	a.Equal(`# managed by hand
+Compression yes
+
+Match host *.ci.example.com
+	User builder
+
+# defaults
+Host *
+  ServerAliveInterval 30
+`, editor.String())
+}
+
+func TestSSHConfigEditor_emptyFile(t *testing.T) {
+	a := require.New(t)
+
+	fs := afero.NewMemMapFs()
+	editor, err := LoadSSHConfigEditor(fs, "/home/alice/.ssh/config")
+	a.NoError(err)
+
+	web, err := editor.AddHost("web")
+	a.NoError(err)
+	a.NoError(web.Set("User", "www"))
+	db, err := editor.AddHost("db")
+	a.NoError(err)
+	a.NoError(db.Set("Port", "5432"))
+
+	a.Equal("Host web\n    User www\n\nHost db\n    Port 5432\n", editor.String())
+
+	a.NoError(editor.Save())
+	info, err := fs.Stat("/home/alice/.ssh/config")
+	a.NoError(err)
+	a.Equal(os.FileMode(0o600), info.Mode().Perm())
+	dirInfo, err := fs.Stat("/home/alice/.ssh")
+	a.NoError(err)
+	a.Equal(os.FileMode(0o700), dirInfo.Mode().Perm())
+
+	entries, err := afero.ReadDir(fs, "/home/alice/.ssh")
+	a.NoError(err)
+	a.Len(entries, 1)
+}
+
+func TestSSHConfigEditor_save(t *testing.T) {
+	a := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "config")
+	a.NoError(os.WriteFile(path, []byte(testSSHConfigEditContent), 0o640))
+
+	fs := afero.NewOsFs()
+	editor, err := LoadSSHConfigEditor(fs, path)
+	a.NoError(err)
+
+	app, err := editor.AddHost("app")
+	a.NoError(err)
+	a.NoError(app.Set("IdentityFile", "/keys/app key"))
+	a.NoError(app.Set("ProxyCommand", "ssh", "-W", "%h:%p", "gateway"))
+	a.NoError(editor.Save())
+
+	info, err := os.Stat(path)
+	a.NoError(err)
+	a.Equal(os.FileMode(0o640), info.Mode().Perm())
+
+	// the saved file resolves as expected, the new block is not shadowed by "Host *"
+	config, err := LoadSSHConfigFromFile(path)
+	a.NoError(err)
+	r := config.GetHostConfig("app")
+	a.NotNil(r)
+	a.Equal([]string{"/keys/app key"}, r.IdentityFiles)
+	a.Equal("ssh -W %h:%p gateway", r.ProxyCommand)
+	a.Equal(30, r.ServerAliveInterval)
+	a.True(r.Compression)
+}
+
+func TestSSHConfigEditor_saveSymlink(t *testing.T) {
+	a := require.New(t)
+	if runtime.GOOS == "windows" {
+		t.Skip("creating symlinks needs privileges")
+	}
+
+	// ~/.ssh/config linked to a file in a dotfiles repository
+	dir := t.TempDir()
+	real := filepath.Join(dir, "dotfiles", "ssh_config")
+	a.NoError(os.MkdirAll(filepath.Dir(real), 0o755))
+	a.NoError(os.WriteFile(real, []byte(testSSHConfigEditContent), 0o600))
+	link := filepath.Join(dir, "config")
+	a.NoError(os.Symlink(filepath.Join("dotfiles", "ssh_config"), link))
+
+	editor, err := LoadSSHConfigEditor(afero.NewOsFs(), link)
+	a.NoError(err)
+	_, err = editor.AddHost("app")
+	a.NoError(err)
+	a.NoError(editor.Save())
+
+	info, err := os.Lstat(link)
+	a.NoError(err)
+	a.NotZero(info.Mode() & os.ModeSymlink)
+
+	data, err := os.ReadFile(real)
+	a.NoError(err)
+	a.Equal(editor.String(), string(data))
+}
@@ -0,0 +1,275 @@
+package qnet
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// sshConfigMaxIncludeDepth mirrors READCONF_MAX_DEPTH in OpenSSH
+const sshConfigMaxIncludeDepth = 16
+
+// sshConfigFile is one parsed configuration file
+type sshConfigFile struct {
+	path  string
+	lines []*sshConfigLine
+}
+
+// sshConfigLine is one directive of a configuration file
+type sshConfigLine struct {
+	file    string
+	line    int
+	keyword string // lower-cased
+	args    []string
+	// raw is the unsplit argument text, used by directives that take a command line
+	raw string
+
+	// included holds the files matched by an Include directive, in glob order
+	included []*sshConfigFile
+	// criteria holds the parsed arguments of a Match directive
+	criteria []sshMatchCriterion
+}
+
+// sshMatchCriterion is one criterion of a Match line, e.g. "!host a,b"
+type sshMatchCriterion struct {
+	name    string // lower-cased
+	negated bool
+	arg     string
+}
+
+func (me *sshConfigLine) errorf(format string, args ...any) error {
+	return fmt.Errorf("%s line %d: %s", me.file, me.line, fmt.Sprintf(format, args...))
+}
+
+// sshConfigLoader loads a configuration file with its includes
+type sshConfigLoader struct {
+	env *sshLocalEnv
+	// includeDir is where relative Include paths are resolved: ~/.ssh for user
+	// configuration, /etc/ssh for the system-wide one
+	includeDir string
+}
+
+func (me *sshConfigLoader) loadFile(path string, depth int) (*sshConfigFile, error) {
+	if depth > sshConfigMaxIncludeDepth {
+		return nil, errors.Errorf("include nesting too deep: %s", path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open SSH config file: %s", path)
+	}
+	defer file.Close()
+
+	r := &sshConfigFile{path: path}
+	scanner := bufio.NewScanner(file)
+	linenum := 0
+	for scanner.Scan() {
+		linenum++
+
+		line, err := me.parseLine(path, linenum, scanner.Text(), depth)
+		if err != nil {
+			return nil, err
+		}
+		if line != nil {
+			r.lines = append(r.lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "read SSH config file: %s", path)
+	}
+	return r, nil
+}
+
+func (me *sshConfigLoader) parseLine(path string, linenum int, text string, depth int) (*sshConfigLine, error) {
+	keyword, rest, ok := splitSSHKeyword(text)
+	if !ok {
+		return nil, nil
+	}
+
+	r := &sshConfigLine{file: path, line: linenum, keyword: strings.ToLower(keyword), raw: rest}
+	if len(rest) == 0 {
+		return nil, r.errorf("no argument after keyword \"%s\"", r.keyword)
+	}
+
+	args, err := splitSSHArgs(rest)
+	if err != nil {
+		return nil, r.errorf("%v", err)
+	}
+	r.args = args
+
+	switch r.keyword {
+	case "include":
+		if err := me.loadIncludes(r, depth); err != nil {
+			return nil, err
+		}
+	case "match":
+		criteria, err := parseSSHMatch(r)
+		if err != nil {
+			return nil, err
+		}
+		r.criteria = criteria
+	default:
+		if err := validateSSHOption(r); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// loadIncludes expands the glob patterns of an Include directive
+func (me *sshConfigLoader) loadIncludes(line *sshConfigLine, depth int) error {
+	for _, arg := range line.args {
+		pattern := arg
+		if strings.HasPrefix(pattern, "~") {
+			expanded, err := me.env.expandTilde(pattern)
+			if err != nil {
+				return line.errorf("%v", err)
+			}
+			pattern = expanded
+		} else if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(me.includeDir, pattern)
+		}
+
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return line.errorf("invalid include pattern '%s': %v", arg, err)
+		}
+		sort.Strings(matches)
+
+		for _, m := range matches {
+			if info, err := os.Stat(m); err == nil && info.IsDir() {
+				continue
+			}
+			f, err := me.loadFile(m, depth+1)
+			if err != nil {
+				return err
+			}
+			line.included = append(line.included, f)
+		}
+	}
+	return nil
+}
+
+// splitSSHKeyword splits a line into its keyword and the remaining argument text.
+// The keyword is separated by whitespace or by a single '=' with optional whitespace around it.
+func splitSSHKeyword(text string) (string, string, bool) {
+	s := strings.TrimLeft(text, " \t\r\f")
+	s = strings.TrimRight(s, " \t\r\f\n")
+	if len(s) == 0 || s[0] == '#' {
+		return "", "", false
+	}
+
+	var keyword string
+	if s[0] == '"' {
+		end := strings.IndexByte(s[1:], '"')
+		if end < 0 {
+			return s, "", true
+		}
+		keyword = s[1 : end+1]
+		s = s[end+2:]
+	} else {
+		end := strings.IndexAny(s, " \t=")
+		if end < 0 {
+			return s, "", true
+		}
+		keyword = s[:end]
+		s = s[end:]
+	}
+
+	s = strings.TrimLeft(s, " \t")
+	if strings.HasPrefix(s, "=") {
+		s = strings.TrimLeft(s[1:], " \t")
+	}
+	return keyword, s, true
+}
+
+// splitSSHArgs splits the arguments of a directive the way OpenSSH's argv_split does:
+// single and double quotes group words, a backslash escapes quotes, backslashes and
+// whitespace, and a word starting with '#' begins a comment.
+func splitSSHArgs(s string) ([]string, error) {
+	r := []string{}
+	i := 0
+	for {
+		for i < len(s) && (s[i] == ' ' || s[i] == '\t') {
+			i++
+		}
+		if i >= len(s) || s[i] == '#' {
+			return r, nil
+		}
+
+		var arg strings.Builder
+		var quote byte
+		for ; i < len(s); i++ {
+			c := s[i]
+			if quote == 0 && (c == ' ' || c == '\t') {
+				break
+			}
+			if c == '\\' && i+1 < len(s) {
+				next := s[i+1]
+				if next == '\'' || next == '"' || next == '\\' || (quote == 0 && (next == ' ' || next == '\t')) {
+					arg.WriteByte(next)
+					i++
+					continue
+				}
+			}
+			if quote == 0 && (c == '\'' || c == '"') {
+				quote = c
+				continue
+			}
+			if quote != 0 && c == quote {
+				quote = 0
+				continue
+			}
+			arg.WriteByte(c)
+		}
+		if quote != 0 {
+			return nil, errors.New("invalid quotes")
+		}
+		r = append(r, arg.String())
+	}
+}
+
+// parseSSHMatch parses the criteria of a Match line
+func parseSSHMatch(line *sshConfigLine) ([]sshMatchCriterion, error) {
+	r := []sshMatchCriterion{}
+	for i := 0; i < len(line.args); i++ {
+		c := sshMatchCriterion{name: strings.ToLower(line.args[i])}
+		if strings.HasPrefix(c.name, "!") {
+			c.negated = true
+			c.name = c.name[1:]
+		}
+
+		switch c.name {
+		case "all":
+			// like OpenSSH, "all" must come last and may follow at most one other attribute
+			if len(r) > 1 || i+1 < len(line.args) {
+				return nil, line.errorf("'all' cannot be combined with other Match attributes")
+			}
+		case "canonical", "final":
+		case "host", "originalhost", "user", "localuser", "exec":
+			if i+1 >= len(line.args) || len(line.args[i+1]) == 0 {
+				return nil, line.errorf("missing argument for Match keyword '%s'", c.name)
+			}
+			i++
+			c.arg = line.args[i]
+			if c.name == "exec" {
+				if err := validateSSHTokens(line, c.arg, sshTokensExec); err != nil {
+					return nil, err
+				}
+			}
+		default:
+			return nil, line.errorf("unsupported Match attribute %s", c.name)
+		}
+		r = append(r, c)
+	}
+
+	if len(r) == 0 {
+		return nil, line.errorf("one or more attributes required for Match")
+	}
+	return r, nil
+}
@@ -0,0 +1,1157 @@
+package qnet
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// sshDefaultIdentityFiles are tried when no IdentityFile applies, in OpenSSH order
+var sshDefaultIdentityFiles = []string{
+	"~/.ssh/id_rsa",
+	"~/.ssh/id_ecdsa",
+	"~/.ssh/id_ecdsa_sk",
+	"~/.ssh/id_ed25519",
+	"~/.ssh/id_ed25519_sk",
+	"~/.ssh/id_xmss",
+	"~/.ssh/id_dsa",
+}
+
+// Percent tokens accepted by each kind of directive
+const (
+	sshTokensHostName = "h"
+	sshTokensFile     = "CdhikLlnpru"
+	sshTokensExec     = sshTokensFile
+)
+
+// sshKeywordAliases maps deprecated keywords to their current name
+var sshKeywordAliases = map[string]string{
+	"challengeresponseauthentication": "kbdinteractiveauthentication",
+}
+
+// ============================================================
+// local environment
+// ============================================================
+
+// sshLocalEnv describes the local side used by token and tilde expansion
+type sshLocalEnv struct {
+	user     string
+	uid      string
+	home     string
+	hostname string
+}
+
+// defaultSSHLocalEnv takes the home directory from the password database, as ssh does,
+// so that $HOME does not influence ~ and %d
+func defaultSSHLocalEnv() *sshLocalEnv {
+	r := &sshLocalEnv{}
+	if u, err := user.Current(); err == nil {
+		r.user = u.Username
+		r.uid = u.Uid
+		r.home = u.HomeDir
+	}
+	if len(r.user) == 0 {
+		r.user = os.Getenv("USER")
+	}
+	if len(r.home) == 0 {
+		r.home, _ = os.UserHomeDir()
+	}
+	r.hostname, _ = os.Hostname()
+	return r
+}
+
+// expandTilde expands a leading ~ or ~user
+func (me *sshLocalEnv) expandTilde(path string) (string, error) {
+	if !strings.HasPrefix(path, "~") {
+		return path, nil
+	}
+
+	name, rest, _ := strings.Cut(path[1:], "/")
+	home := me.home
+	if len(name) > 0 {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", errors.Wrapf(err, "expand '%s'", path)
+		}
+		home = u.HomeDir
+	}
+	if len(rest) == 0 {
+		return home, nil
+	}
+	return filepath.Join(home, rest), nil
+}
+
+// ============================================================
+// validation at load time
+// ============================================================
+
+// validateSSHOption checks the arguments of the directives that SSHHostConfig models;
+// other keywords are accepted without validation
+func validateSSHOption(line *sshConfigLine) error {
+	keyword := line.keyword
+	if alias, ok := sshKeywordAliases[keyword]; ok {
+		keyword = alias
+	}
+
+	switch keyword {
+	case "host":
+		for _, arg := range line.args {
+			if len(arg) == 0 {
+				return line.errorf("Host directive with empty pattern")
+			}
+		}
+		return nil
+
+	case "hostname":
+		if err := sshSingleArg(line); err != nil {
+			return err
+		}
+		return validateSSHTokens(line, line.args[0], sshTokensHostName)
+
+	case "user", "hostkeyalias", "preferredauthentications":
+		return sshSingleArg(line)
+
+	case "port":
+		if err := sshSingleArg(line); err != nil {
+			return err
+		}
+		if port, err := strconv.Atoi(line.args[0]); err != nil || port <= 0 || port > 65535 {
+			return line.errorf("Badly formatted port number.")
+		}
+		return nil
+
+	case "identityfile", "controlpath":
+		if err := sshSingleArg(line); err != nil {
+			return err
+		}
+		return validateSSHTokens(line, line.args[0], sshTokensFile)
+
+	case "userknownhostsfile":
+		for _, arg := range line.args {
+			if err := validateSSHTokens(line, arg, sshTokensFile); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "remotecommand":
+		return validateSSHTokens(line, line.raw, sshTokensFile)
+
+	case "proxyjump":
+		if err := sshSingleArg(line); err != nil {
+			return err
+		}
+		if line.args[0] == "none" {
+			return nil
+		}
+		for _, hop := range strings.Split(line.args[0], ",") {
+			if _, _, host, _, _, err := ParseSSHURL(hop); err != nil || len(host) == 0 {
+				return line.errorf("invalid ProxyJump \"%s\"", line.args[0])
+			}
+		}
+		return nil
+
+	case "compression", "passwordauthentication", "kbdinteractiveauthentication",
+		"exitonforwardfailure", "hashknownhosts":
+		if err := sshSingleArg(line); err != nil {
+			return err
+		}
+		if _, ok := parseSSHBool(line.args[0]); !ok {
+			return line.errorf("unsupported option \"%s\".", line.args[0])
+		}
+		return nil
+
+	case "forwardagent":
+		// a socket path or environment variable name is accepted as well as yes/no
+		return sshSingleArg(line)
+
+	case "pubkeyauthentication":
+		if err := sshSingleArg(line); err != nil {
+			return err
+		}
+		if _, ok := parseSSHBool(line.args[0]); !ok && line.args[0] != "unbound" && line.args[0] != "host-bound" {
+			return line.errorf("unsupported option \"%s\".", line.args[0])
+		}
+		return nil
+
+	case "stricthostkeychecking", "requesttty", "controlmaster":
+		if err := sshSingleArg(line); err != nil {
+			return err
+		}
+		if len(sshEnumValue(keyword, line.args[0])) == 0 {
+			return line.errorf("unsupported option \"%s\".", line.args[0])
+		}
+		return nil
+
+	case "serveraliveinterval", "connecttimeout":
+		if err := sshSingleArg(line); err != nil {
+			return err
+		}
+		if keyword == "connecttimeout" && line.args[0] == "none" {
+			return nil
+		}
+		if _, err := parseSSHTime(line.args[0]); err != nil {
+			return line.errorf("invalid time value.")
+		}
+		return nil
+
+	case "serveralivecountmax":
+		if err := sshSingleArg(line); err != nil {
+			return err
+		}
+		if n, err := strconv.Atoi(line.args[0]); err != nil || n < 0 {
+			return line.errorf("invalid integer value \"%s\".", line.args[0])
+		}
+		return nil
+
+	case "controlpersist":
+		if err := sshSingleArg(line); err != nil {
+			return err
+		}
+		if _, ok := parseSSHBool(line.args[0]); ok {
+			return nil
+		}
+		if _, err := parseSSHTime(line.args[0]); err != nil {
+			return line.errorf("Bad ControlPersist argument")
+		}
+		return nil
+
+	case "rekeylimit":
+		if len(line.args) > 2 {
+			return line.errorf("keyword %s extra arguments at end of line", line.keyword)
+		}
+		if line.args[0] != "default" {
+			n, err := parseSSHSize(line.args[0])
+			if err != nil {
+				return line.errorf("Bad number.")
+			}
+			if n != 0 && n < 16 {
+				return line.errorf("RekeyLimit too small")
+			}
+		}
+		if len(line.args) == 2 && line.args[1] != "none" {
+			if _, err := parseSSHTime(line.args[1]); err != nil {
+				return line.errorf("invalid time value.")
+			}
+		}
+		return nil
+
+	case "sendenv":
+		for _, arg := range line.args {
+			if len(arg) == 0 || strings.Contains(arg, "=") {
+				return line.errorf("Invalid environment name.")
+			}
+		}
+		return nil
+
+	case "setenv":
+		for _, arg := range line.args {
+			if !strings.Contains(arg, "=") {
+				return line.errorf("Invalid environment.")
+			}
+		}
+		return nil
+
+	case "localforward", "remoteforward", "dynamicforward":
+		if _, err := parseSSHForward(keyword, line.args); err != nil {
+			return line.errorf("%v", err)
+		}
+		return nil
+	}
+	return nil
+}
+
+func sshSingleArg(line *sshConfigLine) error {
+	if len(line.args) != 1 {
+		return line.errorf("keyword %s extra arguments at end of line", line.keyword)
+	}
+	return nil
+}
+
+// validateSSHTokens checks that s only uses the percent tokens in allowed
+func validateSSHTokens(line *sshConfigLine, s string, allowed string) error {
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			continue
+		}
+		if i+1 >= len(s) {
+			return line.errorf("invalid format: '%%' at end of \"%s\"", s)
+		}
+		i++
+		if s[i] != '%' && strings.IndexByte(allowed, s[i]) < 0 {
+			return line.errorf("unknown key %%%c in \"%s\"", s[i], s)
+		}
+	}
+	return nil
+}
+
+// parseSSHBool parses yes/no/true/false
+func parseSSHBool(s string) (bool, bool) {
+	switch strings.ToLower(s) {
+	case "yes", "true":
+		return true, true
+	case "no", "false":
+		return false, true
+	}
+	return false, false
+}
+
+// sshEnumValue returns the canonical config value of a multi-state option, or "" when invalid
+func sshEnumValue(keyword string, s string) string {
+	s = strings.ToLower(s)
+	if b, ok := parseSSHBool(s); ok {
+		if b {
+			return "yes"
+		}
+		return "no"
+	}
+
+	switch keyword {
+	case "stricthostkeychecking":
+		switch s {
+		case "off":
+			return "no"
+		case "ask", "accept-new":
+			return s
+		}
+	case "requesttty":
+		if s == "force" || s == "auto" {
+			return s
+		}
+	case "controlmaster":
+		if s == "auto" || s == "ask" || s == "autoask" {
+			return s
+		}
+	}
+	return ""
+}
+
+// parseSSHTime parses an OpenSSH time format such as "90", "10m" or "1h30m" into seconds
+func parseSSHTime(s string) (int, error) {
+	if len(s) == 0 {
+		return 0, errors.New("empty time value")
+	}
+
+	total, n, digits := 0, 0, false
+	for _, c := range s {
+		if c >= '0' && c <= '9' {
+			n = n*10 + int(c-'0')
+			digits = true
+			continue
+		}
+		if !digits {
+			return 0, errors.Errorf("invalid time value '%s'", s)
+		}
+
+		var unit int
+		switch c {
+		case 's', 'S':
+			unit = 1
+		case 'm', 'M':
+			unit = 60
+		case 'h', 'H':
+			unit = 3600
+		case 'd', 'D':
+			unit = 86400
+		case 'w', 'W':
+			unit = 604800
+		default:
+			return 0, errors.Errorf("invalid time value '%s'", s)
+		}
+		total += n * unit
+		n, digits = 0, false
+	}
+	return total + n, nil
+}
+
+// parseSSHSize parses a byte count with an optional K/M/G/T suffix
+func parseSSHSize(s string) (int64, error) {
+	multiplier := int64(1)
+	if len(s) > 0 {
+		switch s[len(s)-1] {
+		case 'k', 'K':
+			multiplier = 1 << 10
+		case 'm', 'M':
+			multiplier = 1 << 20
+		case 'g', 'G':
+			multiplier = 1 << 30
+		case 't', 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			s = s[:len(s)-1]
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.Errorf("bad number '%s'", s)
+	}
+	return n * multiplier, nil
+}
+
+// ============================================================
+// forwarding specifications
+// ============================================================
+
+// sshForwardField is one colon-separated field of a forwarding specification
+type sshForwardField struct {
+	arg    string
+	isPath bool
+}
+
+// splitSSHForwardFields splits a forwarding specification like OpenSSH's parse_fwd_field:
+// fields are separated by ':', a field in [] is taken literally and a field containing '/' is a unix socket path
+func splitSSHForwardFields(spec string) ([]sshForwardField, bool) {
+	r := []sshForwardField{}
+	s := spec
+	for len(s) > 0 {
+		if len(r) == 4 {
+			return nil, false
+		}
+
+		if s[0] == '[' {
+			end := strings.IndexByte(s, ']')
+			if end < 0 || (end+1 < len(s) && s[end+1] != ':') {
+				return nil, false
+			}
+			field := sshForwardField{arg: s[1:end], isPath: strings.Contains(s[1:end], "/")}
+			r = append(r, field)
+			s = s[min(end+2, len(s)):]
+			continue
+		}
+
+		var b strings.Builder
+		field := sshForwardField{}
+		i := 0
+		for ; i < len(s) && s[i] != ':'; i++ {
+			if s[i] == '\\' {
+				i++
+				if i >= len(s) {
+					return nil, false
+				}
+			} else if s[i] == '/' {
+				field.isPath = true
+			}
+			b.WriteByte(s[i])
+		}
+		field.arg = b.String()
+		r = append(r, field)
+		s = s[min(i+1, len(s)):]
+	}
+	return r, true
+}
+
+// NormalizeSSHForward converts the arguments of a LocalForward, RemoteForward or DynamicForward
+// directive, e.g. "8080" and "db:5432", into the form used by SSHHostConfig, e.g. "8080 [db]:5432"
+func NormalizeSSHForward(keyword string, args ...string) (string, error) {
+	keyword = strings.ToLower(keyword)
+	switch keyword {
+	case "localforward", "remoteforward", "dynamicforward":
+		return parseSSHForward(keyword, args)
+	}
+	return "", errors.Errorf("not a forwarding keyword: %s", keyword)
+}
+
+// parseSSHForward validates a forwarding directive and returns it in the form printed by ssh -G:
+// the listen side as "port", "[host]:port" or a socket path, followed for Local/RemoteForward by
+// the target as "[host]:port" or a socket path. A RemoteForward without target is a remote
+// dynamic (SOCKS) forward, which ssh -G reports with the target "[socks]:0".
+func parseSSHForward(keyword string, args []string) (string, error) {
+	dynamic := keyword == "dynamicforward"
+	remote := keyword == "remoteforward"
+
+	var spec string
+	switch {
+	case dynamic:
+		if len(args) != 1 {
+			return "", errors.New("bad forwarding specification")
+		}
+		spec = args[0]
+	case len(args) == 1 && remote:
+		dynamic = true
+		spec = args[0]
+	case len(args) == 2:
+		spec = args[0] + ":" + args[1]
+	default:
+		return "", errors.New("missing second argument")
+	}
+
+	fields, ok := splitSSHForwardFields(spec)
+	if !ok {
+		return "", errors.Errorf("bad forwarding specification '%s'", spec)
+	}
+
+	port := func(s string) int {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 || n > 65535 {
+			return -1
+		}
+		return n
+	}
+
+	var listenHost, listenPath, connectHost, connectPath string
+	listenPort, connectPort := -1, -1
+	f := fields
+	switch len(f) {
+	case 1:
+		if f[0].isPath {
+			listenPath = f[0].arg
+		} else {
+			listenPort = port(f[0].arg)
+		}
+		connectHost = "socks"
+	case 2:
+		switch {
+		case f[0].isPath && f[1].isPath:
+			listenPath, connectPath = f[0].arg, f[1].arg
+		case f[1].isPath:
+			listenPort, connectPath = port(f[0].arg), f[1].arg
+		default:
+			listenHost, listenPort = f[0].arg, port(f[1].arg)
+			connectHost = "socks"
+		}
+	case 3:
+		switch {
+		case f[0].isPath:
+			listenPath, connectHost, connectPort = f[0].arg, f[1].arg, port(f[2].arg)
+		case f[2].isPath:
+			listenHost, listenPort, connectPath = f[0].arg, port(f[1].arg), f[2].arg
+		default:
+			listenPort, connectHost, connectPort = port(f[0].arg), f[1].arg, port(f[2].arg)
+		}
+	case 4:
+		listenHost, listenPort = f[0].arg, port(f[1].arg)
+		connectHost, connectPort = f[2].arg, port(f[3].arg)
+	default:
+		return "", errors.Errorf("bad forwarding specification '%s'", spec)
+	}
+
+	bad := errors.Errorf("bad forwarding specification '%s'", spec)
+	if dynamic {
+		if len(f) != 1 && len(f) != 2 {
+			return "", bad
+		}
+	} else {
+		if len(f) != 3 && len(f) != 4 && len(connectPath) == 0 && len(listenPath) == 0 {
+			return "", bad
+		}
+		if connectPort <= 0 && len(connectPath) == 0 {
+			return "", bad
+		}
+	}
+	if (listenPort < 0 && len(listenPath) == 0) || (!remote && listenPort == 0) {
+		return "", bad
+	}
+
+	var r string
+	switch {
+	case len(listenPath) > 0:
+		r = listenPath
+	case len(listenHost) == 0:
+		r = strconv.Itoa(listenPort)
+	default:
+		r = fmt.Sprintf("[%s]:%d", listenHost, listenPort)
+	}
+
+	if keyword == "dynamicforward" {
+		return r, nil
+	}
+	if len(connectPath) > 0 {
+		return r + " " + connectPath, nil
+	}
+	if connectPort < 0 {
+		connectPort = 0
+	}
+	return fmt.Sprintf("%s [%s]:%d", r, connectHost, connectPort), nil
+}
+
+// ============================================================
+// resolver
+// ============================================================
+
+// sshResolver evaluates the configuration for one host the way ssh(1) does:
+// the first obtained value of each option wins, Host lines match the host name given
+// on the command line, and a "Match final" re-evaluates everything once HostName is known
+type sshResolver struct {
+	env          *sshLocalEnv
+	files        []*sshConfigFile
+	originalHost string
+
+	// host is what Host lines are matched against in the current pass
+	host      string
+	finalPass bool
+	wantFinal bool
+	matched   bool
+
+	values        map[string][]string
+	identityFiles []string
+	localForward  []string
+	remoteForward []string
+	dynamic       []string
+	sendEnv       []string
+	setEnv        []string
+}
+
+func newSSHResolver(env *sshLocalEnv, files []*sshConfigFile, host string) *sshResolver {
+	return &sshResolver{
+		env:          env,
+		files:        files,
+		originalHost: host,
+		values:       map[string][]string{},
+	}
+}
+
+// resolve runs the configuration passes and builds the host configuration
+func (me *sshResolver) resolve() (*SSHHostConfig, error) {
+	if err := me.pass(me.originalHost, false); err != nil {
+		return nil, err
+	}
+
+	hostname := me.originalHost
+	if v, ok := me.values["hostname"]; ok {
+		hostname = expandSSHHostName(v[0], me.originalHost)
+	}
+	hostname = strings.ToLower(hostname)
+
+	if me.wantFinal {
+		me.values["hostname"] = []string{hostname}
+		if err := me.pass(hostname, true); err != nil {
+			return nil, err
+		}
+	}
+	return me.build(hostname)
+}
+
+func (me *sshResolver) pass(host string, final bool) error {
+	me.host = host
+	me.finalPass = final
+	for _, f := range me.files {
+		active := true
+		if err := me.evalLines(f.lines, &active, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// evalLines processes directives; neverMatch is set inside an Include read from an inactive block
+func (me *sshResolver) evalLines(lines []*sshConfigLine, active *bool, neverMatch bool) error {
+	for _, line := range lines {
+		switch line.keyword {
+		case "host":
+			*active = !neverMatch && me.matchHost(line.args)
+			me.matched = me.matched || *active
+
+		case "match":
+			m, err := me.matchCriteria(line)
+			if err != nil {
+				return err
+			}
+			*active = !neverMatch && m
+			me.matched = me.matched || *active
+
+		case "include":
+			// every included file starts with, and leaves behind, the state of the Include line
+			outer := *active
+			for _, f := range line.included {
+				if err := me.evalLines(f.lines, active, neverMatch || !outer); err != nil {
+					return err
+				}
+				*active = outer
+			}
+
+		default:
+			if *active {
+				me.apply(line)
+				me.matched = true
+			}
+		}
+	}
+	return nil
+}
+
+// matchHost evaluates the patterns of a Host line; a matching negated pattern excludes the host
+func (me *sshResolver) matchHost(patterns []string) bool {
+	r := false
+	for _, p := range patterns {
+		if strings.HasPrefix(p, "!") {
+			if SimpleWildcardMatch(p[1:], me.host) {
+				return false
+			}
+		} else if SimpleWildcardMatch(p, me.host) {
+			r = true
+		}
+	}
+	return r
+}
+
+// matchCriteria evaluates a Match line, all criteria must hold
+func (me *sshResolver) matchCriteria(line *sshConfigLine) (bool, error) {
+	// the configuration is incomplete at this point, fall back to defaults
+	host := me.originalHost
+	if me.finalPass {
+		host = me.values["hostname"][0]
+	} else if v, ok := me.values["hostname"]; ok {
+		host = expandSSHHostName(v[0], me.originalHost)
+	}
+	remoteUser := me.env.user
+	if v, ok := me.values["user"]; ok {
+		remoteUser = v[0]
+	}
+
+	r := true
+	for _, c := range line.criteria {
+		var m bool
+		switch c.name {
+		case "all":
+			m = true
+		case "canonical":
+			m = me.finalPass
+		case "final":
+			if !c.negated {
+				me.wantFinal = true
+			}
+			m = me.finalPass
+		case "host":
+			m = matchSSHPatternList(host, c.arg, true) == 1
+		case "originalhost":
+			m = matchSSHPatternList(me.originalHost, c.arg, true) == 1
+		case "user":
+			m = matchSSHPatternList(remoteUser, c.arg, false) == 1
+		case "localuser":
+			m = matchSSHPatternList(me.env.user, c.arg, false) == 1
+		case "exec":
+			if !r {
+				// like ssh, commands are not run once an earlier criterion failed
+				continue
+			}
+			cmd, err := expandSSHTokens(c.arg, me.tokens(host, remoteUser))
+			if err != nil {
+				return false, line.errorf("%v", err)
+			}
+			m = runSSHMatchExec(cmd)
+		}
+		if m == c.negated {
+			r = false
+		}
+	}
+	return r, nil
+}
+
+// runSSHMatchExec runs the command of a "Match exec" criterion with the user's shell
+func runSSHMatchExec(command string) bool {
+	shell := os.Getenv("SHELL")
+	if len(shell) == 0 {
+		shell = "/bin/sh"
+	}
+	cmd := exec.Command(shell, "-c", command)
+	return cmd.Run() == nil
+}
+
+// matchSSHPatternList matches s against a comma-separated pattern list with optional '!' negation:
+// 1 when a positive pattern matches and no negated one does, -1 when a negated pattern matches, 0 otherwise
+func matchSSHPatternList(s string, list string, lowerPatterns bool) int {
+	r := 0
+	for _, p := range strings.Split(list, ",") {
+		if lowerPatterns {
+			p = strings.ToLower(p)
+		}
+		negated := strings.HasPrefix(p, "!")
+		if negated {
+			p = p[1:]
+		}
+		if SimpleWildcardMatch(p, s) {
+			if negated {
+				return -1
+			}
+			r = 1
+		}
+	}
+	return r
+}
+
+// apply records an active directive, keeping the first obtained value
+func (me *sshResolver) apply(line *sshConfigLine) {
+	keyword := line.keyword
+	if alias, ok := sshKeywordAliases[keyword]; ok {
+		keyword = alias
+	}
+
+	switch keyword {
+	case "identityfile":
+		if !containsString(me.identityFiles, line.args[0]) {
+			me.identityFiles = append(me.identityFiles, line.args[0])
+		}
+
+	case "localforward", "remoteforward", "dynamicforward":
+		fwd, _ := parseSSHForward(keyword, line.args)
+		list := &me.localForward
+		if keyword == "remoteforward" {
+			list = &me.remoteForward
+		} else if keyword == "dynamicforward" {
+			list = &me.dynamic
+		}
+		if !containsString(*list, fwd) {
+			*list = append(*list, fwd)
+		}
+
+	case "sendenv":
+		for _, arg := range line.args {
+			if strings.HasPrefix(arg, "-") {
+				kept := me.sendEnv[:0]
+				for _, e := range me.sendEnv {
+					if !SimpleWildcardMatch(arg[1:], e) {
+						kept = append(kept, e)
+					}
+				}
+				me.sendEnv = kept
+			} else {
+				me.sendEnv = append(me.sendEnv, arg)
+			}
+		}
+
+	case "setenv":
+		// only the first SetEnv line is used, duplicate names on it are ignored
+		if me.setEnv != nil {
+			return
+		}
+		me.setEnv = []string{}
+		names := map[string]bool{}
+		for _, arg := range line.args {
+			name, _, _ := strings.Cut(arg, "=")
+			if !names[name] {
+				names[name] = true
+				me.setEnv = append(me.setEnv, arg)
+			}
+		}
+
+	case "proxyjump", "proxycommand":
+		// whichever of ProxyJump and ProxyCommand is obtained first wins
+		_, jump := me.values["proxyjump"]
+		_, command := me.values["proxycommand"]
+		if jump || command {
+			return
+		}
+		if keyword == "proxycommand" {
+			me.values[keyword] = []string{line.raw}
+		} else {
+			me.values[keyword] = line.args
+		}
+
+	case "remotecommand":
+		if _, ok := me.values[keyword]; !ok {
+			me.values[keyword] = []string{line.raw}
+		}
+
+	case "rekeylimit":
+		if _, ok := me.values[keyword]; !ok {
+			me.values[keyword] = line.args[:1]
+		}
+		if len(line.args) == 2 && line.args[1] != "none" {
+			if _, ok := me.values["rekeyinterval"]; !ok {
+				me.values["rekeyinterval"] = line.args[1:]
+			}
+		}
+
+	default:
+		if _, ok := me.values[keyword]; !ok {
+			me.values[keyword] = line.args
+		}
+	}
+}
+
+// first returns the first argument of an obtained option
+func (me *sshResolver) first(keyword string) (string, bool) {
+	if v, ok := me.values[keyword]; ok && len(v) > 0 {
+		return v[0], true
+	}
+	return "", false
+}
+
+// tokens returns the values of percent tokens
+func (me *sshResolver) tokens(hostname string, remoteUser string) map[byte]string {
+	port := "22"
+	if v, ok := me.first("port"); ok {
+		port = v
+	}
+	hostKeyAlias := me.originalHost
+	if v, ok := me.first("hostkeyalias"); ok {
+		hostKeyAlias = v
+	}
+	shortHost, _, _ := strings.Cut(me.env.hostname, ".")
+
+	sum := sha1.Sum([]byte(me.env.hostname + hostname + port + remoteUser))
+	return map[byte]string{
+		'C': hex.EncodeToString(sum[:]),
+		'd': me.env.home,
+		'h': hostname,
+		'i': me.env.uid,
+		'k': hostKeyAlias,
+		'L': shortHost,
+		'l': me.env.hostname,
+		'n': me.originalHost,
+		'p': port,
+		'r': remoteUser,
+		'u': me.env.user,
+	}
+}
+
+// expandPath applies ~, ${VAR} and percent token expansion to a file name
+func (me *sshResolver) expandPath(path string, tokens map[byte]string) (string, error) {
+	r, err := me.env.expandTilde(path)
+	if err != nil {
+		return "", err
+	}
+	if r, err = expandSSHEnv(r); err != nil {
+		return "", err
+	}
+	return expandSSHTokens(r, tokens)
+}
+
+// build converts the obtained options into an SSHHostConfig, filling in ssh's defaults
+func (me *sshResolver) build(hostname string) (*SSHHostConfig, error) {
+	remoteUser := me.env.user
+	if v, ok := me.first("user"); ok {
+		remoteUser = v
+	}
+	tokens := me.tokens(hostname, remoteUser)
+
+	r := &SSHHostConfig{
+		Host:                 me.originalHost,
+		HostName:             hostname,
+		User:                 remoteUser,
+		Port:                 22,
+		IdentityFiles:        []string{},
+		PreferredAuth:        []string{},
+		ServerAliveCountMax:  3,
+		StrictHostKeyCheck:   "ask",
+		PasswordAuth:         true,
+		PubkeyAuth:           true,
+		KbdInteractiveAuth:   true,
+		RekeyLimit:           "0 0",
+		SendEnv:              append([]string{}, me.sendEnv...),
+		SetEnv:               map[string]string{},
+		RequestTTY:           "auto",
+		LocalForward:         append([]string{}, me.localForward...),
+		RemoteForward:        append([]string{}, me.remoteForward...),
+		DynamicForward:       append([]string{}, me.dynamic...),
+		ControlMaster:        "no",
+		ControlPersist:       "no",
+		GlobalKnownHostsFile: "/etc/ssh/ssh_known_hosts /etc/ssh/ssh_known_hosts2",
+	}
+
+	if v, ok := me.first("port"); ok {
+		r.Port, _ = strconv.Atoi(v)
+	}
+
+	identityFiles := me.identityFiles
+	if len(identityFiles) == 0 {
+		identityFiles = sshDefaultIdentityFiles
+	}
+	for _, f := range identityFiles {
+		path, err := me.expandPath(f, tokens)
+		if err != nil {
+			return nil, err
+		}
+		r.IdentityFiles = append(r.IdentityFiles, path)
+	}
+	r.IdentityFile = r.IdentityFiles[0]
+
+	if v, ok := me.first("preferredauthentications"); ok {
+		r.PreferredAuth = strings.Split(v, ",")
+	}
+	if v, ok := me.first("proxyjump"); ok && v != "none" {
+		r.ProxyJump = v
+	}
+	if v, ok := me.first("proxycommand"); ok && v != "none" {
+		r.ProxyCommand = v
+	}
+	if v, ok := me.first("forwardagent"); ok {
+		// anything other than no is an agent socket to forward
+		b, isBool := parseSSHBool(v)
+		r.ForwardAgent = b || !isBool
+	}
+	r.Compression = me.boolValue("compression", false)
+	r.PasswordAuth = me.boolValue("passwordauthentication", true)
+	r.KbdInteractiveAuth = me.boolValue("kbdinteractiveauthentication", true)
+	r.ExitOnForwardFailure = me.boolValue("exitonforwardfailure", false)
+	r.HashKnownHosts = me.boolValue("hashknownhosts", false)
+	if v, ok := me.first("pubkeyauthentication"); ok {
+		b, isBool := parseSSHBool(v)
+		r.PubkeyAuth = b || !isBool
+	}
+
+	if v, ok := me.first("serveraliveinterval"); ok {
+		r.ServerAliveInterval, _ = parseSSHTime(v)
+	}
+	if v, ok := me.first("serveralivecountmax"); ok {
+		r.ServerAliveCountMax, _ = strconv.Atoi(v)
+	}
+	if v, ok := me.first("connecttimeout"); ok && v != "none" {
+		r.ConnectTimeout, _ = parseSSHTime(v)
+	}
+	if v, ok := me.first("stricthostkeychecking"); ok {
+		r.StrictHostKeyCheck = sshEnumValue("stricthostkeychecking", v)
+	}
+	if v, ok := me.first("requesttty"); ok {
+		r.RequestTTY = sshEnumValue("requesttty", v)
+	}
+	if v, ok := me.first("controlmaster"); ok {
+		r.ControlMaster = sshEnumValue("controlmaster", v)
+	}
+	if v, ok := me.first("controlpersist"); ok {
+		if b, isBool := parseSSHBool(v); isBool {
+			r.ControlPersist = map[bool]string{true: "yes", false: "no"}[b]
+		} else if secs, _ := parseSSHTime(v); secs == 0 {
+			r.ControlPersist = "yes"
+		} else {
+			r.ControlPersist = strconv.Itoa(secs)
+		}
+	}
+
+	var rekeyBytes int64
+	var rekeySecs int
+	if v, ok := me.first("rekeylimit"); ok && v != "default" {
+		rekeyBytes, _ = parseSSHSize(v)
+	}
+	if v, ok := me.first("rekeyinterval"); ok {
+		rekeySecs, _ = parseSSHTime(v)
+	}
+	r.RekeyLimit = fmt.Sprintf("%d %d", rekeyBytes, rekeySecs)
+
+	for _, kv := range me.setEnv {
+		name, value, _ := strings.Cut(kv, "=")
+		r.SetEnv[name] = value
+	}
+
+	if v, ok := me.first("hostkeyalias"); ok {
+		r.HostKeyAlias = v
+	}
+
+	knownHostsFiles := []string{"~/.ssh/known_hosts", "~/.ssh/known_hosts2"}
+	if v, ok := me.values["userknownhostsfile"]; ok {
+		knownHostsFiles = v
+	}
+	knownHosts := make([]string, 0, len(knownHostsFiles))
+	for _, f := range knownHostsFiles {
+		if f == "none" {
+			knownHosts = knownHosts[:0]
+			break
+		}
+		path, err := me.expandPath(f, tokens)
+		if err != nil {
+			return nil, err
+		}
+		knownHosts = append(knownHosts, path)
+	}
+	r.UserKnownHostsFile = strings.Join(knownHosts, " ")
+	if v, ok := me.values["globalknownhostsfile"]; ok {
+		r.GlobalKnownHostsFile = strings.Join(v, " ")
+	}
+
+	if v, ok := me.first("controlpath"); ok && v != "none" {
+		path, err := me.expandPath(v, tokens)
+		if err != nil {
+			return nil, err
+		}
+		r.ControlPath = path
+	}
+	if v, ok := me.first("remotecommand"); ok && v != "none" {
+		command, err := expandSSHTokens(v, tokens)
+		if err != nil {
+			return nil, err
+		}
+		r.RemoteCommand = command
+	}
+
+	return r, nil
+}
+
+func (me *sshResolver) boolValue(keyword string, def bool) bool {
+	if v, ok := me.first(keyword); ok {
+		b, _ := parseSSHBool(v)
+		return b
+	}
+	return def
+}
+
+// expandSSHHostName expands the tokens allowed in HostName
+func expandSSHHostName(hostname string, host string) string {
+	r, err := expandSSHTokens(hostname, map[byte]string{'h': host})
+	if err != nil {
+		return hostname
+	}
+	return r
+}
+
+// expandSSHTokens replaces %x tokens, "%%" is a literal percent sign
+func expandSSHTokens(s string, tokens map[byte]string) (string, error) {
+	if !strings.Contains(s, "%") {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		if i >= len(s) {
+			return "", errors.Errorf("invalid format: '%%' at end of \"%s\"", s)
+		}
+		if s[i] == '%' {
+			b.WriteByte('%')
+			continue
+		}
+		v, ok := tokens[s[i]]
+		if !ok {
+			return "", errors.Errorf("unknown key %%%c in \"%s\"", s[i], s)
+		}
+		b.WriteString(v)
+	}
+	return b.String(), nil
+}
+
+// expandSSHEnv replaces ${VAR} references with environment variables
+func expandSSHEnv(s string) (string, error) {
+	var b strings.Builder
+	for {
+		start := strings.Index(s, "${")
+		if start < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		end := strings.IndexByte(s[start:], '}')
+		if end < 0 {
+			return "", errors.Errorf("unterminated variable in \"%s\"", s)
+		}
+
+		name := s[start+2 : start+end]
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", errors.Errorf("env var ${%s} has no value", name)
+		}
+		b.WriteString(s[:start])
+		b.WriteString(value)
+		s = s[start+end+1:]
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,470 @@
+package qnet
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testSSHLocalEnv is a fixed local side so that expected values do not depend on the test machine
+func testSSHLocalEnv() *sshLocalEnv {
+	return &sshLocalEnv{user: "alice", uid: "1000", home: "/home/alice", hostname: "box.local"}
+}
+
+// copySSHConfigFixtures copies testdata/ssh_config into a temp dir, replacing @DIR@ with that dir
+func copySSHConfigFixtures(t *testing.T) string {
+	a := require.New(t)
+
+	src := filepath.Join("testdata", "ssh_config")
+	dir := t.TempDir()
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		a.NoError(err)
+		rel, _ := filepath.Rel(src, path)
+		target := filepath.Join(dir, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0o755)
+		}
+		data, err := os.ReadFile(path)
+		a.NoError(err)
+		data = bytes.ReplaceAll(data, []byte("@DIR@"), []byte(filepath.ToSlash(dir)))
+		return os.WriteFile(target, data, 0o644)
+	})
+	a.NoError(err)
+	return dir
+}
+
+func loadTestSSHConfig(t *testing.T, path string) *SSHConfig {
+	r := &SSHConfig{env: testSSHLocalEnv()}
+	require.NoError(t, r.load(path, filepath.Join(r.env.home, ".ssh")))
+	return r
+}
+
+func TestSSHConfig_Resolve_firstValueWins(t *testing.T) {
+	a := require.New(t)
+
+	config := loadTestSSHConfig(t, filepath.Join(copySSHConfigFixtures(t), "basic.conf"))
+
+	web1, err := config.Resolve("web1")
+	a.NoError(err)
+	a.Equal("web1.internal.example.com", web1.HostName)
+	a.Equal("deploy", web1.User)
+	a.Equal(2201, web1.Port)
+	a.Equal(60, web1.ServerAliveInterval)
+	a.Equal(5, web1.ServerAliveCountMax)
+	a.True(web1.Compression)
+	a.Equal("no", web1.StrictHostKeyCheck)
+	a.Equal([]string{"/home/alice/.ssh/web_key", "/opt/keys/with space", "/home/alice/.ssh/shared"}, web1.IdentityFiles)
+	a.Equal("/home/alice/.ssh/web_key", web1.IdentityFile)
+
+	api, err := config.Resolve("api.example.com")
+	a.NoError(err)
+	a.Equal("example", api.User)
+	a.Equal(15, api.ConnectTimeout)
+	a.Equal(22, api.Port)
+
+	legacy, err := config.Resolve("legacy.example.com")
+	a.NoError(err)
+	a.Equal("fallback", legacy.User)
+	a.Equal(0, legacy.ConnectTimeout)
+
+	db, err := config.Resolve("db")
+	a.NoError(err)
+	a.Equal("db.example.com", db.HostName)
+	a.Equal("accept-new", db.StrictHostKeyCheck)
+	a.False(db.PasswordAuth)
+	a.Equal("nc %h %p", db.ProxyCommand)
+	a.Empty(db.ProxyJump)
+}
+
+func TestSSHConfig_Resolve_defaults(t *testing.T) {
+	a := require.New(t)
+
+	config := loadTestSSHConfig(t, filepath.Join(copySSHConfigFixtures(t), "options.conf"))
+
+	r, err := config.Resolve("plain")
+	a.NoError(err)
+	a.Equal("plain", r.HostName)
+	a.Equal("alice", r.User)
+	a.Equal(22, r.Port)
+	a.Equal("ask", r.StrictHostKeyCheck)
+	a.Equal(3, r.ServerAliveCountMax)
+	a.Equal("/home/alice/.ssh/id_rsa", r.IdentityFile)
+	a.Len(r.IdentityFiles, len(sshDefaultIdentityFiles))
+	a.Equal("/home/alice/.ssh/known_hosts /home/alice/.ssh/known_hosts2", r.UserKnownHostsFile)
+	a.Equal("auto", r.RequestTTY)
+	a.Equal("0 0", r.RekeyLimit)
+	a.True(r.PubkeyAuth)
+
+	// nothing but defaults
+	a.Nil(config.GetHostConfig("plain"))
+	a.NotNil(config.GetHostConfig("fwd"))
+}
+
+func TestSSHConfig_Resolve_include(t *testing.T) {
+	a := require.New(t)
+
+	config := loadTestSSHConfig(t, filepath.Join(copySSHConfigFixtures(t), "include.conf"))
+
+	app, err := config.Resolve("app")
+	a.NoError(err)
+	a.Equal("global", app.User)
+	a.Equal("app.internal", app.HostName)
+	a.Equal(2022, app.Port)
+	a.Equal([]string{"/home/alice/.ssh/app_key"}, app.IdentityFiles)
+	a.True(app.Compression)
+
+	// a Host line inside an include stays in effect until the end of the included file
+	other, err := config.Resolve("other")
+	a.NoError(err)
+	a.Equal(3333, other.Port)
+	a.Equal("app.internal", other.HostName)
+	a.True(other.ForwardAgent)
+
+	zzz, err := config.Resolve("zzz")
+	a.NoError(err)
+	a.Equal("global", zzz.User)
+	a.False(zzz.Compression)
+}
+
+func TestSSHConfig_Resolve_match(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Match exec requires a POSIX shell")
+	}
+	a := require.New(t)
+
+	config := loadTestSSHConfig(t, filepath.Join(copySSHConfigFixtures(t), "match.conf"))
+
+	build1, err := config.Resolve("build-1")
+	a.NoError(err)
+	a.Equal("builder", build1.User)
+	a.Equal("build-1.ci.example.com", build1.HostName)
+	a.Equal(2222, build1.Port)
+	a.Equal(10, build1.ServerAliveInterval)
+	a.True(build1.Compression)
+	a.Equal(7, build1.ConnectTimeout)
+	a.Equal("force", build1.RequestTTY)
+	a.Equal("auto", build1.ControlMaster)
+
+	build2, err := config.Resolve("build-2")
+	a.NoError(err)
+	a.False(build2.Compression)
+	a.Equal(0, build2.ConnectTimeout)
+
+	// the local user alice matches "Match user", as User defaults to the local user
+	plain, err := config.Resolve("plain")
+	a.NoError(err)
+	a.Equal("alice", plain.User)
+	a.Equal(22, plain.Port)
+	a.Equal("force", plain.RequestTTY)
+}
+
+func TestSSHConfig_Resolve_tokens(t *testing.T) {
+	a := require.New(t)
+
+	config := loadTestSSHConfig(t, filepath.Join(copySSHConfigFixtures(t), "tokens.conf"))
+
+	tok, err := config.Resolve("tok")
+	a.NoError(err)
+	a.Equal("tok.example.org", tok.HostName)
+	a.Equal("tok-alias", tok.HostKeyAlias)
+	a.Equal("/home/alice/.ssh/cm/remote@tok.example.org:2200-"+
+		"1bc5be5913123c0085db690b85d4de78f64eddd9", tok.ControlPath)
+	a.Equal("/home/alice/.ssh/known_hosts_tok-alias /etc/qnet/tok_alice", tok.UserKnownHostsFile)
+	a.Equal("echo tok tok.example.org 2200 remote alice % box box.local 1000 tok-alias", tok.RemoteCommand)
+	a.Equal([]string{"/home/alice/.ssh/id_remote_tok.example.org"}, tok.IdentityFiles)
+
+	tok2, err := config.Resolve("tok2")
+	a.NoError(err)
+	a.Empty(tok2.ProxyJump)
+	a.Empty(tok2.ProxyCommand)
+	a.Empty(tok2.ControlPath)
+
+	tok3, err := config.Resolve("tok3")
+	a.NoError(err)
+	a.Equal("admin@gw1:2222,gw2", tok3.ProxyJump)
+}
+
+func TestSSHConfig_Resolve_options(t *testing.T) {
+	a := require.New(t)
+
+	config := loadTestSSHConfig(t, filepath.Join(copySSHConfigFixtures(t), "options.conf"))
+
+	r, err := config.Resolve("fwd")
+	a.NoError(err)
+	a.Equal([]string{"8080 [localhost]:80", "[127.0.0.1]:8443 [::1]:443", "/tmp/qnet.sock [db]:5432"}, r.LocalForward)
+	a.Equal([]string{"9090 [localhost]:90", "9091 [socks]:0"}, r.RemoteForward)
+	a.Equal([]string{"1080", "[localhost]:1081"}, r.DynamicForward)
+	a.Equal([]string{"LANG", "TZ"}, r.SendEnv)
+	a.Equal(map[string]string{"A": "1", "B": "two words"}, r.SetEnv)
+	a.Equal("1073741824 3600", r.RekeyLimit)
+	a.Equal("600", r.ControlPersist)
+	a.Equal("yes", r.ControlMaster)
+	a.Equal("no", r.RequestTTY)
+	a.True(r.ExitOnForwardFailure)
+	a.Equal([]string{"publickey", "keyboard-interactive"}, r.PreferredAuth)
+	a.False(r.PubkeyAuth)
+	a.False(r.KbdInteractiveAuth)
+	a.True(r.ForwardAgent)
+	a.True(r.HashKnownHosts)
+	a.Equal("/etc/qnet/global_known_hosts", r.GlobalKnownHostsFile)
+	a.Equal(5400, r.ServerAliveInterval)
+}
+
+func TestSSHConfig_load_errors(t *testing.T) {
+	cases := map[string]string{
+		"bad port":        "Host x\n  Port 70000\n",
+		"bad bool":        "Compression maybe\n",
+		"unknown match":   "Match color blue\n",
+		"match all":       "Match all host x\n",
+		"missing arg":     "Match host\n",
+		"bad forward":     "LocalForward 8080\n",
+		"unknown token":   "ControlPath ~/.ssh/%z\n",
+		"invalid quotes":  "IdentityFile \"~/.ssh/id_rsa\n",
+		"no argument":     "User\n",
+		"bad time":        "ConnectTimeout 5x\n",
+		"recursive":       "Include @SELF@\n",
+		"bad setenv":      "SetEnv NOVALUE\n",
+		"hostname tokens": "HostName %u.example.com\n",
+	}
+
+	for name, content := range cases {
+		t.Run(name, func(t *testing.T) {
+			a := require.New(t)
+
+			path := filepath.Join(t.TempDir(), "config")
+			content = strings.ReplaceAll(content, "@SELF@", filepath.ToSlash(path))
+			a.NoError(os.WriteFile(path, []byte(content), 0o644))
+
+			_, err := LoadSSHConfigFromFile(path)
+			a.Error(err)
+			a.Contains(err.Error(), path)
+		})
+	}
+}
+
+func TestNormalizeSSHForward(t *testing.T) {
+	a := require.New(t)
+
+	r, err := NormalizeSSHForward("LocalForward", "8080", "db:5432")
+	a.NoError(err)
+	a.Equal("8080 [db]:5432", r)
+
+	r, err = NormalizeSSHForward("remoteforward", "*:9090")
+	a.NoError(err)
+	a.Equal("[*]:9090 [socks]:0", r)
+
+	r, err = NormalizeSSHForward("DynamicForward", "localhost:1080")
+	a.NoError(err)
+	a.Equal("[localhost]:1080", r)
+
+	_, err = NormalizeSSHForward("LocalForward", "8080")
+	a.Error(err)
+	_, err = NormalizeSSHForward("User", "bob")
+	a.Error(err)
+}
+
+func TestSSHConfig_Resolve_unknownKeywordIgnored(t *testing.T) {
+	a := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "config")
+	a.NoError(os.WriteFile(path, []byte("Host x\n  SomeFutureOption whatever\n  User bob\n"), 0o644))
+
+	config, err := LoadSSHConfigFromFile(path)
+	a.NoError(err)
+	a.Equal("bob", config.GetHostConfig("x").User)
+}
+
+func TestSplitSSHArgs(t *testing.T) {
+	a := require.New(t)
+
+	args, err := splitSSHArgs(`a "b c" 'd e' f\ g h\"i # comment`)
+	a.NoError(err)
+	a.Equal([]string{"a", "b c", "d e", "f g", `h"i`}, args)
+
+	args, err = splitSSHArgs(`"" x`)
+	a.NoError(err)
+	a.Equal([]string{"", "x"}, args)
+
+	_, err = splitSSHArgs(`"unterminated`)
+	a.Error(err)
+
+	keyword, rest, ok := splitSSHKeyword("  Port=2222")
+	a.True(ok)
+	a.Equal("Port", keyword)
+	a.Equal("2222", rest)
+
+	keyword, rest, ok = splitSSHKeyword(`"User" = bob`)
+	a.True(ok)
+	a.Equal("User", keyword)
+	a.Equal("bob", rest)
+
+	_, _, ok = splitSSHKeyword("   # comment")
+	a.False(ok)
+}
+
+func TestParseSSHTime(t *testing.T) {
+	a := require.New(t)
+
+	for s, want := range map[string]int{"0": 0, "30": 30, "10m": 600, "1h30m": 5400, "1w": 604800, "2D": 172800} {
+		got, err := parseSSHTime(s)
+		a.NoError(err, s)
+		a.Equal(want, got, s)
+	}
+	for _, s := range []string{"", "m", "10x", "-1"} {
+		_, err := parseSSHTime(s)
+		a.Error(err, s)
+	}
+}
+
+// TestSSHConfig_Resolve_openssh compares the resolved configuration with `ssh -G`
+func TestSSHConfig_Resolve_openssh(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping on windows")
+	}
+	if _, err := exec.LookPath("ssh"); err != nil {
+		t.Skip("ssh is not installed")
+	}
+	a := require.New(t)
+
+	dir := copySSHConfigFixtures(t)
+	cases := []struct{ file, host string }{
+		{"basic.conf", "web1"},
+		{"basic.conf", "web2"},
+		{"basic.conf", "api.example.com"},
+		{"basic.conf", "legacy.example.com"},
+		{"basic.conf", "db"},
+		{"basic.conf", "other"},
+		{"include.conf", "app"},
+		{"include.conf", "other"},
+		{"include.conf", "zzz"},
+		{"match.conf", "build-1"},
+		{"match.conf", "build-2"},
+		{"match.conf", "plain"},
+		{"tokens.conf", "tok"},
+		{"tokens.conf", "tok2"},
+		{"tokens.conf", "tok3"},
+		{"options.conf", "fwd"},
+		{"options.conf", "plain"},
+	}
+
+	for _, c := range cases {
+		path := filepath.Join(dir, c.file)
+
+		config, err := LoadSSHConfigFromFile(path)
+		a.NoError(err)
+		r, err := config.Resolve(c.host)
+		a.NoError(err)
+
+		out, err := exec.Command("ssh", "-G", "-F", path, c.host).Output()
+		if err != nil {
+			t.Skipf("ssh -G failed: %v", err)
+		}
+		want := parseSSHDashG(out, config.localEnv())
+		got := renderSSHDashG(r)
+
+		for key, values := range got {
+			if key == "identityfile" && strings.Contains(strings.Join(want[key], " "), "%") {
+				continue
+			}
+			a.Equal(want[key], values, "%s %s: %s", c.file, c.host, key)
+		}
+	}
+}
+
+// parseSSHDashG parses the output of `ssh -G`, normalizing what ssh prints differently
+func parseSSHDashG(out []byte, env *sshLocalEnv) map[string][]string {
+	r := map[string][]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), " ")
+		switch value {
+		case "true":
+			value = "yes"
+		case "false":
+			value = "no"
+		}
+		if key == "identityfile" {
+			// printed without token expansion
+			value, _ = env.expandTilde(value)
+		}
+		r[key] = append(r[key], value)
+	}
+	sort.Strings(r["setenv"])
+	return r
+}
+
+// renderSSHDashG renders the fields of SSHHostConfig under the keys `ssh -G` prints
+func renderSSHDashG(c *SSHHostConfig) map[string][]string {
+	yesNo := func(b bool) []string {
+		if b {
+			return []string{"yes"}
+		}
+		return []string{"no"}
+	}
+	optional := func(s string) []string {
+		if len(s) == 0 {
+			return nil
+		}
+		return []string{s}
+	}
+	list := func(l []string) []string {
+		if len(l) == 0 {
+			return nil
+		}
+		return l
+	}
+
+	connectTimeout := "none"
+	if c.ConnectTimeout > 0 {
+		connectTimeout = strconv.Itoa(c.ConnectTimeout)
+	}
+	setEnv := []string{}
+	for k, v := range c.SetEnv {
+		setEnv = append(setEnv, k+"="+v)
+	}
+	sort.Strings(setEnv)
+
+	return map[string][]string{
+		"hostname":                     {c.HostName},
+		"user":                         {c.User},
+		"port":                         {strconv.Itoa(c.Port)},
+		"identityfile":                 list(c.IdentityFiles),
+		"preferredauthentications":     optional(strings.Join(c.PreferredAuth, ",")),
+		"proxyjump":                    optional(c.ProxyJump),
+		"proxycommand":                 optional(c.ProxyCommand),
+		"forwardagent":                 yesNo(c.ForwardAgent),
+		"compression":                  yesNo(c.Compression),
+		"serveraliveinterval":          {strconv.Itoa(c.ServerAliveInterval)},
+		"serveralivecountmax":          {strconv.Itoa(c.ServerAliveCountMax)},
+		"stricthostkeychecking":        {c.StrictHostKeyCheck},
+		"userknownhostsfile":           {c.UserKnownHostsFile},
+		"globalknownhostsfile":         {c.GlobalKnownHostsFile},
+		"hashknownhosts":               yesNo(c.HashKnownHosts),
+		"hostkeyalias":                 optional(c.HostKeyAlias),
+		"connecttimeout":               {connectTimeout},
+		"passwordauthentication":       yesNo(c.PasswordAuth),
+		"pubkeyauthentication":         yesNo(c.PubkeyAuth),
+		"kbdinteractiveauthentication": yesNo(c.KbdInteractiveAuth),
+		"rekeylimit":                   {c.RekeyLimit},
+		"sendenv":                      list(c.SendEnv),
+		"setenv":                       list(setEnv),
+		"requesttty":                   {c.RequestTTY},
+		"remotecommand":                optional(c.RemoteCommand),
+		"localforward":                 list(c.LocalForward),
+		"remoteforward":                list(c.RemoteForward),
+		"dynamicforward":               list(c.DynamicForward),
+		"exitonforwardfailure":         yesNo(c.ExitOnForwardFailure),
+		"controlmaster":                {c.ControlMaster},
+		"controlpath":                  optional(c.ControlPath),
+		"controlpersist":               {c.ControlPersist},
+	}
+}
@@ -1,6 +1,7 @@
 package qshell
 
 import (
+	"bytes"
 	"context"
 	"io"
 	"os"
@@ -15,7 +16,6 @@ import (
 	"github.com/qiangyt/go-comm/v3/qlang"
 	"github.com/qiangyt/go-comm/v3/qsys"
 	"github.com/spf13/afero"
-	"github.com/spf13/cast"
 	"mvdan.cc/sh/v3/interp"
 )
 
@@ -35,6 +35,9 @@ type CommandOutputT struct {
 	Vars map[string]string
 	Text string
 	Json any
+
+	// Result 结构化的执行结果：退出码、分开的 stdout/stderr、耗时和资源使用
+	Result CommandResult
 }
 
 type CommandOutput = *CommandOutputT
@@ -131,14 +134,26 @@ func RunShellCommandP(vars map[string]string, dir string, sh string, cmd string,
 }
 
 func RunShellCommand(vars map[string]string, dir string, sh string, cmd string, passwordInput FnInput) (CommandOutput, error) {
+	return RunShellCommandContext(context.Background(), vars, dir, sh, cmd, passwordInput)
+}
+
+func RunShellCommandContextP(ctx context.Context, vars map[string]string, dir string, sh string, cmd string, passwordInput FnInput) CommandOutput {
+	r, err := RunShellCommandContext(ctx, vars, dir, sh, cmd, passwordInput)
+	if err != nil {
+		panic(qerr.NewSystemError(err.Error(), err))
+	}
+	return r
+}
+
+func RunShellCommandContext(ctx context.Context, vars map[string]string, dir string, sh string, cmd string, passwordInput FnInput) (CommandOutput, error) {
 	if len(sh) == 0 || sh == "gosh" {
-		return RunGoshCommand(vars, dir, cmd, passwordInput)
+		return RunGoshCommandContext(ctx, vars, dir, cmd, passwordInput)
 	}
 
 	if IsSudoCommand(cmd) {
-		return RunSudoCommand(vars, dir, cmd, passwordInput)
+		return RunSudoCommandContext(ctx, vars, dir, cmd, passwordInput)
 	}
-	return RunUserCommand(vars, dir, cmd)
+	return RunUserCommandContext(ctx, vars, dir, cmd)
 }
 
 func RunUserCommandP(vars map[string]string, dir string, cmd string) CommandOutput {
@@ -149,6 +164,22 @@ func RunUserCommandP(vars map[string]string, dir string, cmd string) CommandOutp
 	return r
 }
 
+func RunUserCommand(vars map[string]string, dir string, cmd string) (CommandOutput, error) {
+	return RunUserCommandContext(context.Background(), vars, dir, cmd)
+}
+
+func RunUserCommandContextP(ctx context.Context, vars map[string]string, dir string, cmd string) CommandOutput {
+	r, err := RunUserCommandContext(ctx, vars, dir, cmd)
+	if err != nil {
+		panic(qerr.NewSystemError(err.Error(), err))
+	}
+	return r
+}
+
+func RunSudoCommand(vars map[string]string, dir string, cmd string, passwordInput FnInput) (CommandOutput, error) {
+	return RunSudoCommandContext(context.Background(), vars, dir, cmd, passwordInput)
+}
+
 /*
 func RunShellScriptFile(afs afero.Fs, url string, credentials Credentials, timeout time.Duration,
 	dir string, sh string) string {
@@ -158,13 +189,32 @@ func RunShellScriptFile(afs afero.Fs, url string, credentials Credentials, timeo
 }*/
 
 func NewExecCommand(vars map[string]string, dir string, cmd string, args ...string) (*exec.Cmd, error) {
-	r := exec.Command(cmd, args...)
+	return NewExecCommandContext(context.Background(), vars, dir, cmd, args...)
+}
+
+// NewExecCommandContext 创建受 ctx 控制的命令。
+// ctx 可取消时，命令运行在独立的进程组中，取消时整个进程树都会被终止
+func NewExecCommandContext(ctx context.Context, vars map[string]string, dir string, cmd string, args ...string) (*exec.Cmd, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	r := exec.CommandContext(ctx, cmd, args...)
 	env, err := qsys.EnvironList(vars)
 	if err != nil {
 		return nil, err
 	}
 	r.Env = env
 	r.Dir = dir
+
+	if ctx.Done() != nil {
+		setProcessGroup(r)
+		r.Cancel = func() error {
+			return killProcessGroup(r)
+		}
+		// 孙进程可能仍持有输出管道，避免 Wait 无限期阻塞
+		r.WaitDelay = commandWaitDelay
+	}
 	return r, nil
 }
 
@@ -177,21 +227,32 @@ func RunCommandNoInputP(vars map[string]string, dir string, cmd string, args ...
 }
 
 func RunCommandNoInput(vars map[string]string, dir string, cmd string, args ...string) (CommandOutput, error) {
-	_cmd, err := NewExecCommand(vars, dir, cmd, args...)
+	return RunCommandNoInputContext(context.Background(), vars, dir, cmd, args...)
+}
+
+func RunCommandNoInputContextP(ctx context.Context, vars map[string]string, dir string, cmd string, args ...string) CommandOutput {
+	r, err := RunCommandNoInputContext(ctx, vars, dir, cmd, args...)
 	if err != nil {
-		return nil, err
+		panic(qerr.NewSystemError(err.Error(), err))
 	}
+	return r
+}
 
-	b, err := _cmd.Output()
+func RunCommandNoInputContext(ctx context.Context, vars map[string]string, dir string, cmd string, args ...string) (CommandOutput, error) {
+	_cmd, err := NewExecCommandContext(ctx, vars, dir, cmd, args...)
 	if err != nil {
-		cli := strings.Join(append([]string{cmd}, args...), " ")
-		return nil, errors.Wrapf(err, "get output for command '%s'", cli)
+		return nil, err
 	}
 
-	return ParseCommandOutput(cast.ToString(b))
+	cli := strings.Join(append([]string{cmd}, args...), " ")
+	return runExecCommand(ctx, cli, _cmd)
 }
 
 func RunCommandWithInput(vars map[string]string, dir string, cmd string, args ...string) func(...string) (CommandOutput, error) {
+	return RunCommandWithInputContext(context.Background(), vars, dir, cmd, args...)
+}
+
+func RunCommandWithInputContext(ctx context.Context, vars map[string]string, dir string, cmd string, args ...string) func(...string) (CommandOutput, error) {
 	return func(input ...string) (CommandOutput, error) {
 		if IsSudoCommand(cmd) && len(input) > 0 {
 			cmd = InstrumentSudoCommand(cmd)
@@ -199,7 +260,7 @@ func RunCommandWithInput(vars map[string]string, dir string, cmd string, args ..
 
 		cli := cmd + " " + strings.Join(args, " ")
 
-		_cmd, err := NewExecCommand(vars, dir, cmd, args...)
+		_cmd, err := NewExecCommandContext(ctx, vars, dir, cmd, args...)
 		if err != nil {
 			return nil, err
 		}
@@ -222,13 +283,41 @@ func RunCommandWithInput(vars map[string]string, dir string, cmd string, args ..
 		stdin.Close()
 		stdin = nil
 
-		b, err := _cmd.Output()
-		if err != nil {
-			return nil, errors.Wrapf(err, "get output for command '%s'", cli)
+		return runExecCommand(ctx, cli, _cmd)
+	}
+}
+
+// runExecCommand 运行命令并解析 stdout
+// 非零退出时返回 ExitError，ctx 取消或超时时返回包含部分输出的 CommandContextError
+func runExecCommand(ctx context.Context, cli string, cmd *exec.Cmd) (CommandOutput, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	result := newCommandResult(cli)
+	err := cmd.Run()
+	result.finish(cmd.ProcessState)
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+
+	if err != nil {
+		if ctx != nil && ctx.Err() != nil {
+			return nil, newCommandContextError(cli, result.Stdout, ctx.Err())
+		}
+
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, newExitError(result)
 		}
+		return nil, errors.Wrapf(err, "get output for command '%s'", cli)
+	}
 
-		return ParseCommandOutput(cast.ToString(b))
+	r, err := ParseCommandOutput(result.Stdout)
+	if err != nil {
+		return nil, err
 	}
+	r.Result = result
+	return r, nil
 }
 
 func IsSudoCommand(cmd string) bool {
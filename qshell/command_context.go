@@ -0,0 +1,78 @@
+package qshell
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// commandWaitDelay ctx 取消后等待输出管道关闭的最长时间
+const commandWaitDelay = 2 * time.Second
+
+// CommandContextErrorT 命令因 ctx 取消或超时而被终止时返回的错误
+type CommandContextErrorT struct {
+	// Command 被终止的命令
+	Command string
+	// Output 终止前已收集到的输出
+	Output string
+	// Err ctx 的错误：context.Canceled 或 context.DeadlineExceeded
+	Err error
+}
+
+// CommandContextError 是 CommandContextErrorT 的指针别名
+type CommandContextError = *CommandContextErrorT
+
+func newCommandContextError(cmd string, output string, err error) CommandContextError {
+	return &CommandContextErrorT{
+		Command: cmd,
+		Output:  output,
+		Err:     err,
+	}
+}
+
+// Error 实现 error 接口
+func (me CommandContextError) Error() string {
+	if me.IsDeadlineExceeded() {
+		return fmt.Sprintf("command '%s' timed out: %v", me.Command, me.Err)
+	}
+	return fmt.Sprintf("command '%s' canceled: %v", me.Command, me.Err)
+}
+
+// Unwrap 支持 errors.Is(err, context.DeadlineExceeded) 等判断
+func (me CommandContextError) Unwrap() error {
+	return me.Err
+}
+
+// IsDeadlineExceeded 是否因超时而终止
+func (me CommandContextError) IsDeadlineExceeded() bool {
+	return errors.Is(me.Err, context.DeadlineExceeded)
+}
+
+// PartialOutput 将终止前已收集到的输出解析为 CommandOutput
+func (me CommandContextError) PartialOutput() CommandOutput {
+	r, err := ParseCommandOutput(me.Output)
+	if err != nil {
+		return &CommandOutputT{
+			Kind: COMMAND_OUTPUT_KIND_TEXT,
+			Vars: map[string]string{},
+			Text: me.Output,
+		}
+	}
+	return r
+}
+
+// IsCommandContextError 检查错误是否为 ctx 取消或超时导致
+func IsCommandContextError(err error) bool {
+	return GetCommandContextError(err) != nil
+}
+
+// GetCommandContextError 获取 ctx 取消或超时错误详情
+func GetCommandContextError(err error) CommandContextError {
+	var r CommandContextError
+	if errors.As(err, &r) {
+		return r
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package qshell
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunCommandNoInputContext_happy(t *testing.T) {
+	a := require.New(t)
+
+	result, err := RunCommandNoInputContext(context.Background(), nil, "", "echo", "hello")
+	a.NoError(err)
+	a.Contains(result.Text, "hello")
+}
+
+func TestRunCommandNoInputContext_deadline(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下 sh 不可用")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	begin := time.Now()
+	_, err := RunCommandNoInputContext(ctx, nil, "", "sh", "-c", "echo partial; sleep 30")
+	a.Error(err)
+	a.Less(time.Since(begin), 5*time.Second)
+
+	a.True(errors.Is(err, context.DeadlineExceeded))
+	a.True(IsCommandContextError(err))
+
+	ctxErr := GetCommandContextError(err)
+	a.NotNil(ctxErr)
+	a.True(ctxErr.IsDeadlineExceeded())
+	a.Equal("partial\n", ctxErr.Output)
+	a.Equal("partial\n", ctxErr.PartialOutput().Text)
+	a.Contains(ctxErr.Error(), "timed out")
+}
+
+func TestRunCommandNoInputContext_killsProcessTree(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下 sh 不可用")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+
+	// 后台的 sleep 是孙进程，只杀直接子进程时它会一直持有 stdout
+	begin := time.Now()
+	_, err := RunCommandNoInputContext(ctx, nil, "", "sh", "-c", "sleep 30 & sleep 30")
+	a.Error(err)
+	a.Less(time.Since(begin), commandWaitDelay)
+
+	a.True(errors.Is(err, context.Canceled))
+	a.False(GetCommandContextError(err).IsDeadlineExceeded())
+	a.Contains(err.Error(), "canceled")
+}
+
+func TestRunCommandWithInputContext_canceled(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下 cat 不可用")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := RunCommandWithInputContext(ctx, nil, "", "cat")("hello")
+	a.Error(err)
+	a.True(IsCommandContextError(err))
+}
+
+func TestRunShellCommandContext_user(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS != "linux" {
+		t.Skip("RunUserCommand 仅在 Linux 下通过 sh 执行")
+	}
+
+	script := filepath.Join(t.TempDir(), "script.sh")
+	a.NoError(os.WriteFile(script, []byte("echo partial; sleep 30\n"), 0o644))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	_, err := RunShellCommandContext(ctx, nil, "", "sh", script, nil)
+	a.True(errors.Is(err, context.DeadlineExceeded))
+	a.Equal("partial\n", GetCommandContextError(err).Output)
+
+	a.NoError(os.WriteFile(script, []byte("echo hi\n"), 0o644))
+	output := RunShellCommandContextP(context.Background(), nil, "", "sh", script, nil)
+	a.Equal("hi\n", output.Text)
+}
+
+func TestRunGoshCommandContext_deadline(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下 sleep 不可用")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	begin := time.Now()
+	_, err := RunGoshCommandContext(ctx, nil, "", "echo partial; sleep 30", nil)
+	a.Error(err)
+	a.Less(time.Since(begin), 5*time.Second)
+
+	a.True(errors.Is(err, context.DeadlineExceeded))
+	a.Equal("partial\n", GetCommandContextError(err).Output)
+}
+
+func TestRunGoshCommandContextP_happy(t *testing.T) {
+	a := require.New(t)
+
+	output := RunGoshCommandContextP(context.Background(), nil, "", "echo hello", nil)
+	a.Equal("hello\n", output.Text)
+
+	a.Panics(func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		RunGoshCommandContextP(ctx, nil, "", "sleep 30", nil)
+	})
+}
+
+func TestCommandContextError_PartialOutput(t *testing.T) {
+	a := require.New(t)
+
+	err := newCommandContextError("cmd", "$json$\n\n{invalid", context.Canceled)
+	r := err.PartialOutput()
+	a.Equal(COMMAND_OUTPUT_KIND_TEXT, r.Kind)
+	a.Equal("$json$\n\n{invalid", r.Text)
+
+	err = newCommandContextError("cmd", "$vars$\n\nk=v", context.Canceled)
+	a.Equal("v", err.PartialOutput().Vars["k"])
+
+	a.Nil(GetCommandContextError(errors.New("other")))
+}
@@ -4,12 +4,15 @@
 package qshell
 
 import (
+	"context"
 	"fmt"
 	"strings"
+
+	"github.com/qiangyt/go-comm/v3/qerr"
 )
 
-func RunSudoCommand(vars map[string]string, dir string, cmd string, passwordInput FnInput) (CommandOutput, error) {
-	return RunAppleScript(vars, passwordInput(), dir, cmd)
+func RunSudoCommandContext(ctx context.Context, vars map[string]string, dir string, cmd string, passwordInput FnInput) (CommandOutput, error) {
+	return RunAppleScriptContext(ctx, vars, passwordInput(), dir, cmd)
 }
 
 func RunAppleScriptP(vars map[string]string, adminPassword string, dir string, script string) CommandOutput {
@@ -21,6 +24,10 @@ func RunAppleScriptP(vars map[string]string, adminPassword string, dir string, s
 }
 
 func RunAppleScript(vars map[string]string, adminPassword string, dir string, script string) (CommandOutput, error) {
+	return RunAppleScriptContext(context.Background(), vars, adminPassword, dir, script)
+}
+
+func RunAppleScriptContext(ctx context.Context, vars map[string]string, adminPassword string, dir string, script string) (CommandOutput, error) {
 	subArgs := []string{fmt.Sprintf(`do shell script "%s"`, script)}
 
 	if len(adminPassword) > 0 {
@@ -28,9 +35,9 @@ func RunAppleScript(vars map[string]string, adminPassword string, dir string, sc
 	}
 	subArgs = append(subArgs, "with administrator privileges")
 
-	return RunCommandNoInput(vars, dir, "osascript", "-e", strings.Join(subArgs, " "))
+	return RunCommandNoInputContext(ctx, vars, dir, "osascript", "-e", strings.Join(subArgs, " "))
 }
 
-func RunUserCommand(vars map[string]string, dir string, cmd string) (CommandOutput, error) {
-	return RunCommandNoInput(vars, dir, "open", cmd)
+func RunUserCommandContext(ctx context.Context, vars map[string]string, dir string, cmd string) (CommandOutput, error) {
+	return RunCommandNoInputContext(ctx, vars, dir, "open", cmd)
 }
@@ -3,11 +3,13 @@
 
 package qshell
 
-func RunSudoCommand(vars map[string]string, dir string, cmd string, passwordInput FnInput) (CommandOutput, error) {
+import "context"
+
+func RunSudoCommandContext(ctx context.Context, vars map[string]string, dir string, cmd string, passwordInput FnInput) (CommandOutput, error) {
 	password := passwordInput()
-	return RunCommandWithInput(vars, dir, "sh", cmd)(password)
+	return RunCommandWithInputContext(ctx, vars, dir, "sh", cmd)(password)
 }
 
-func RunUserCommand(vars map[string]string, dir string, cmd string) (CommandOutput, error) {
-	return RunCommandNoInput(vars, dir, "sh", cmd)
+func RunUserCommandContext(ctx context.Context, vars map[string]string, dir string, cmd string) (CommandOutput, error) {
+	return RunCommandNoInputContext(ctx, vars, dir, "sh", cmd)
 }
@@ -0,0 +1,234 @@
+package qshell
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ============================================================
+// CommandResult 命令执行结果
+// ============================================================
+
+// CommandResultT 命令执行的结构化结果
+type CommandResultT struct {
+	// Command 执行的命令
+	Command string
+	// ExitCode 退出码，被信号终止时为 -1（gosh 中为 128+信号值）
+	ExitCode int
+	// Signal 终止进程的信号，正常退出时为 nil
+	Signal os.Signal
+	// Stdout 标准输出
+	Stdout string
+	// Stderr 标准错误
+	Stderr string
+	// StartTime 开始时间
+	StartTime time.Time
+	// EndTime 结束时间
+	EndTime time.Time
+	// UserTime 用户态 CPU 时间（gosh 中为所有外部命令之和）
+	UserTime time.Duration
+	// SystemTime 内核态 CPU 时间（gosh 中为所有外部命令之和）
+	SystemTime time.Duration
+	// MaxRSS 最大常驻内存（字节），不支持的平台上为 0（gosh 中为所有外部命令的最大值）
+	MaxRSS int64
+}
+
+// CommandResult 是 CommandResultT 的指针别名
+type CommandResult = *CommandResultT
+
+func newCommandResult(command string) CommandResult {
+	return &CommandResultT{
+		Command:   command,
+		StartTime: time.Now(),
+	}
+}
+
+// Duration 返回命令执行的墙钟时间
+func (me CommandResult) Duration() time.Duration {
+	return me.EndTime.Sub(me.StartTime)
+}
+
+// CPUTime 返回命令消耗的 CPU 时间（用户态 + 内核态）
+func (me CommandResult) CPUTime() time.Duration {
+	return me.UserTime + me.SystemTime
+}
+
+// Success 命令是否正常退出且退出码为 0
+func (me CommandResult) Success() bool {
+	return me.ExitCode == 0 && me.Signal == nil
+}
+
+// Signaled 命令是否被信号终止
+func (me CommandResult) Signaled() bool {
+	return me.Signal != nil
+}
+
+// finish 记录结束时间和进程状态
+func (me CommandResult) finish(state *os.ProcessState) {
+	me.EndTime = time.Now()
+	if state == nil {
+		return
+	}
+
+	status := newExitStatus(state)
+	me.ExitCode = status.Code
+	me.Signal = status.Signal
+	me.UserTime = state.UserTime()
+	me.SystemTime = state.SystemTime()
+	me.MaxRSS = maxRSS(state)
+}
+
+// ============================================================
+// ExitError 非零退出错误
+// ============================================================
+
+// ExitErrorT 命令以非零退出码结束或被信号终止时返回的错误，携带完整的执行结果
+type ExitErrorT struct {
+	Result CommandResult
+}
+
+// ExitError 是 ExitErrorT 的指针别名
+type ExitError = *ExitErrorT
+
+func newExitError(result CommandResult) ExitError {
+	return &ExitErrorT{Result: result}
+}
+
+// Error 实现 error 接口
+func (me ExitError) Error() string {
+	var r string
+	if me.Result.Signaled() {
+		r = fmt.Sprintf("command '%s' terminated by signal: %v", me.Result.Command, me.Result.Signal)
+	} else {
+		r = fmt.Sprintf("command '%s' exited with status %d", me.Result.Command, me.Result.ExitCode)
+	}
+
+	if stderr := strings.TrimSpace(me.Result.Stderr); len(stderr) > 0 {
+		r += ": " + stderr
+	}
+	return r
+}
+
+// ExitCode 返回退出码
+func (me ExitError) ExitCode() int {
+	return me.Result.ExitCode
+}
+
+// IsExitError 检查错误是否为非零退出导致
+func IsExitError(err error) bool {
+	return GetExitError(err) != nil
+}
+
+// GetExitError 获取非零退出错误详情
+func GetExitError(err error) ExitError {
+	var r ExitError
+	if errors.As(err, &r) {
+		return r
+	}
+	return nil
+}
+
+// ============================================================
+// 资源使用统计
+// ============================================================
+
+// resourceUsage 汇总 gosh 执行过程中所有外部命令的资源使用
+type resourceUsage struct {
+	mu         sync.Mutex
+	userTime   time.Duration
+	systemTime time.Duration
+	maxRSS     int64
+	lastSignal os.Signal
+}
+
+type resourceUsageKey struct{}
+
+func withResourceUsage(ctx context.Context, usage *resourceUsage) context.Context {
+	return context.WithValue(ctx, resourceUsageKey{}, usage)
+}
+
+func getResourceUsage(ctx context.Context) *resourceUsage {
+	r, _ := ctx.Value(resourceUsageKey{}).(*resourceUsage)
+	return r
+}
+
+// add 累加一个已结束进程的资源使用
+func (me *resourceUsage) add(state *os.ProcessState) {
+	if state == nil {
+		return
+	}
+
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	me.userTime += state.UserTime()
+	me.systemTime += state.SystemTime()
+	if rss := maxRSS(state); rss > me.maxRSS {
+		me.maxRSS = rss
+	}
+	me.lastSignal = exitSignal(state)
+}
+
+// apply 将汇总的资源使用写入执行结果
+func (me *resourceUsage) apply(result CommandResult) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	result.UserTime = me.userTime
+	result.SystemTime = me.systemTime
+	result.MaxRSS = me.maxRSS
+
+	// gosh 用 128+信号值 表示被信号终止的外部命令
+	if s, ok := me.lastSignal.(syscall.Signal); ok && result.ExitCode == 128+int(s) {
+		result.Signal = me.lastSignal
+	}
+}
+
+// ============================================================
+// outputRecorder
+// ============================================================
+
+// outputRecorder 并发安全地记录合并输出以及分开的 stdout/stderr
+type outputRecorder struct {
+	mu       sync.Mutex
+	combined bytes.Buffer
+	stdout   bytes.Buffer
+	stderr   bytes.Buffer
+}
+
+// outputRecorderWriter 写入 outputRecorder 中指定输出流的 io.Writer
+type outputRecorderWriter struct {
+	recorder *outputRecorder
+	stream   OutputStream
+}
+
+func (me outputRecorderWriter) Write(p []byte) (int, error) {
+	me.recorder.mu.Lock()
+	defer me.recorder.mu.Unlock()
+
+	me.recorder.combined.Write(p)
+	if me.stream == StreamStderr {
+		return me.recorder.stderr.Write(p)
+	}
+	return me.recorder.stdout.Write(p)
+}
+
+func (me *outputRecorder) writer(stream OutputStream) io.Writer {
+	return outputRecorderWriter{recorder: me, stream: stream}
+}
+
+// output 返回合并输出、stdout 和 stderr
+func (me *outputRecorder) output() (combined, stdout, stderr string) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.combined.String(), me.stdout.String(), me.stderr.String()
+}
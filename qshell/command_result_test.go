@@ -0,0 +1,140 @@
+package qshell
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunCommandNoInput_result(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下 sh 不可用")
+	}
+
+	output, err := RunCommandNoInput(nil, "", "sh", "-c", "echo out; echo err >&2")
+	a.NoError(err)
+	a.Equal("out\n", output.Text)
+
+	result := output.Result
+	a.NotNil(result)
+	a.Equal("sh -c echo out; echo err >&2", result.Command)
+	a.True(result.Success())
+	a.Equal(0, result.ExitCode)
+	a.Nil(result.Signal)
+	a.Equal("out\n", result.Stdout)
+	a.Equal("err\n", result.Stderr)
+	a.False(result.StartTime.IsZero())
+	a.False(result.EndTime.Before(result.StartTime))
+	a.GreaterOrEqual(result.Duration(), time.Duration(0))
+	a.Equal(result.UserTime+result.SystemTime, result.CPUTime())
+	a.Greater(result.MaxRSS, int64(0))
+}
+
+func TestRunCommandNoInput_exitError(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下 sh 不可用")
+	}
+
+	output, err := RunCommandNoInput(nil, "", "sh", "-c", "echo partial; echo boom >&2; exit 3")
+	a.Nil(output)
+	a.Error(err)
+
+	a.True(IsExitError(err))
+	exitErr := GetExitError(err)
+	a.Equal(3, exitErr.ExitCode())
+	a.False(exitErr.Result.Signaled())
+	a.Equal("partial\n", exitErr.Result.Stdout)
+	a.Equal("boom\n", exitErr.Result.Stderr)
+	a.Contains(err.Error(), "exited with status 3: boom")
+
+	// 包装后仍可提取
+	var extracted ExitError
+	a.True(errors.As(errors.Wrap(err, "outer"), &extracted))
+	a.Same(exitErr, extracted)
+
+	a.False(IsExitError(errors.New("other")))
+	a.Nil(GetExitError(nil))
+}
+
+func TestRunCommandNoInput_signaled(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下没有信号")
+	}
+
+	_, err := RunCommandNoInput(nil, "", "sh", "-c", "kill -KILL $$")
+	exitErr := GetExitError(err)
+	a.NotNil(exitErr)
+	a.True(exitErr.Result.Signaled())
+	a.Equal(syscall.SIGKILL, exitErr.Result.Signal)
+	a.Equal(-1, exitErr.ExitCode())
+	a.Contains(err.Error(), "terminated by signal: killed")
+}
+
+func TestRunUserCommand_result(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS != "linux" {
+		t.Skip("仅 Linux 下 RunUserCommand 以 sh 执行脚本")
+	}
+
+	script := filepath.Join(t.TempDir(), "script.sh")
+	a.NoError(os.WriteFile(script, []byte("echo hello\necho warn >&2\nexit 5\n"), 0o644))
+
+	_, err := RunUserCommand(nil, "", script)
+	exitErr := GetExitError(err)
+	a.NotNil(exitErr)
+	a.Equal(5, exitErr.ExitCode())
+	a.Equal("hello\n", exitErr.Result.Stdout)
+	a.Equal("warn\n", exitErr.Result.Stderr)
+}
+
+func TestRunGoshCommand_result(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下 sh 不可用")
+	}
+
+	output, err := RunGoshCommand(nil, "", "echo out; echo err >&2; sh -c 'echo child'", nil)
+	a.NoError(err)
+
+	// Text 保持合并输出，Result 中分开记录
+	a.Equal("out\nerr\nchild\n", output.Text)
+	a.Equal("out\nchild\n", output.Result.Stdout)
+	a.Equal("err\n", output.Result.Stderr)
+	a.True(output.Result.Success())
+	a.False(output.Result.EndTime.Before(output.Result.StartTime))
+	a.Greater(output.Result.MaxRSS, int64(0))
+}
+
+func TestRunGoshCommand_exitError(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下 sh 不可用")
+	}
+
+	_, err := RunGoshCommand(nil, "", "echo partial; exit 4", nil)
+	exitErr := GetExitError(err)
+	a.NotNil(exitErr)
+	a.Equal(4, exitErr.ExitCode())
+	a.Equal("partial\n", exitErr.Result.Stdout)
+
+	_, err = RunGoshCommand(nil, "", "sh -c 'kill -KILL $$'", nil)
+	exitErr = GetExitError(err)
+	a.NotNil(exitErr)
+	a.Equal(128+int(syscall.SIGKILL), exitErr.ExitCode())
+	a.Equal(syscall.SIGKILL, exitErr.Result.Signal)
+}
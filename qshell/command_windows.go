@@ -3,12 +3,16 @@
 
 package qshell
 
-import "github.com/pkg/errors"
+import (
+	"context"
 
-func RunSudoCommand(vars map[string]string, dir string, cmd string, passwordInput FnInput) (CommandOutput, error) {
+	"github.com/pkg/errors"
+)
+
+func RunSudoCommandContext(ctx context.Context, vars map[string]string, dir string, cmd string, passwordInput FnInput) (CommandOutput, error) {
 	return nil, errors.New("todo")
 }
 
-func RunUserCommand(vars map[string]string, dir string, cmd string) (CommandOutput, error) {
-	return RunCommandNoInput(vars, dir, "sh", cmd)
+func RunUserCommandContext(ctx context.Context, vars map[string]string, dir string, cmd string) (CommandOutput, error) {
+	return RunCommandNoInputContext(ctx, vars, dir, "sh", cmd)
 }
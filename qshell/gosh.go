@@ -4,8 +4,11 @@ import (
 	"context"
 	"io"
 	"strings"
+	"time"
 
+	"github.com/pkg/errors"
 	"github.com/qiangyt/go-comm/v3/qerr"
+	"mvdan.cc/sh/v3/interp"
 )
 
 // RunGoshCommandP 执行 shell 命令（失败时 panic）
@@ -19,6 +22,25 @@ func RunGoshCommandP(vars map[string]string, dir string, cmd string, passwordInp
 
 // RunGoshCommand 执行 shell 命令
 func RunGoshCommand(vars map[string]string, dir string, cmd string, passwordInput FnInput) (CommandOutput, error) {
+	return RunGoshCommandContext(context.Background(), vars, dir, cmd, passwordInput)
+}
+
+// RunGoshCommandContextP 执行受 ctx 控制的 shell 命令（失败时 panic）
+func RunGoshCommandContextP(ctx context.Context, vars map[string]string, dir string, cmd string, passwordInput FnInput) CommandOutput {
+	r, err := RunGoshCommandContext(ctx, vars, dir, cmd, passwordInput)
+	if err != nil {
+		panic(qerr.NewSystemError(err.Error(), err))
+	}
+	return r
+}
+
+// RunGoshCommandContext 执行受 ctx 控制的 shell 命令
+// ctx 取消或超时时返回 CommandContextError，其中包含已收集到的部分输出
+func RunGoshCommandContext(ctx context.Context, vars map[string]string, dir string, cmd string, passwordInput FnInput) (CommandOutput, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
 	var stdin io.Reader
 	if IsSudoCommand(cmd) {
 		password := InputSudoPassword(passwordInput)
@@ -34,11 +56,38 @@ func RunGoshCommand(vars map[string]string, dir string, cmd string, passwordInpu
 
 	executor := NewGoshExecutor(config)
 
-	out := strings.Builder{}
-	err := executor.RunWithVars(context.TODO(), vars, dir, cmd, stdin, &out, &out)
+	// 合并输出用于解析 CommandOutput，分开的 stdout/stderr 和资源使用记录在 Result 中
+	out := &outputRecorder{}
+	usage := &resourceUsage{}
+	result := newCommandResult(cmd)
+
+	err := executor.RunWithVars(withResourceUsage(ctx, usage), vars, dir, cmd, stdin,
+		out.writer(StreamStdout), out.writer(StreamStderr))
+
+	result.EndTime = time.Now()
+	combined, stdout, stderr := out.output()
+	result.Stdout = stdout
+	result.Stderr = stderr
+
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, newCommandContextError(cmd, combined, ctx.Err())
+		}
+
+		var status interp.ExitStatus
+		if errors.As(err, &status) {
+			result.ExitCode = int(status)
+			usage.apply(result)
+			return nil, newExitError(result)
+		}
 		return nil, err
 	}
+	usage.apply(result)
 
-	return ParseCommandOutput(out.String())
+	r, err := ParseCommandOutput(combined)
+	if err != nil {
+		return nil, err
+	}
+	r.Result = result
+	return r, nil
 }
@@ -0,0 +1,287 @@
+package qshell
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/qiangyt/go-comm/v3/qerr"
+	"github.com/qiangyt/go-comm/v3/qlang"
+	"mvdan.cc/sh/v3/interp"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// ============================================================
+// AuditDecision 审计决策
+// ============================================================
+
+// AuditDecision GoshExecutor 对一条命令做出的决策
+type AuditDecision int
+
+const (
+	// AuditAllowed 允许并以外部进程执行
+	AuditAllowed AuditDecision = iota + 1
+	// AuditGoHandler 由 Go 处理器执行
+	AuditGoHandler
+	// AuditBlocked 被黑名单或白名单模式拒绝
+	AuditBlocked
+)
+
+// String 返回决策的字符串表示
+func (d AuditDecision) String() string {
+	switch d {
+	case AuditAllowed:
+		return "allowed"
+	case AuditGoHandler:
+		return "go_handler"
+	case AuditBlocked:
+		return "blocked"
+	default:
+		return "unknown"
+	}
+}
+
+// ============================================================
+// AuditEvent 审计事件
+// ============================================================
+
+// AuditEventT 一条命令的审计事件
+type AuditEventT struct {
+	// Time 开始处理的时间
+	Time time.Time
+	// Name 命令名
+	Name string
+	// Args 参数（已按 GoshConfig.AuditRedactions 脱敏）
+	Args []string
+	// Source 根据脚本推断的命令来源，条件分支等情况下可能不准确
+	Source CommandSource
+	// Dir 执行目录
+	Dir string
+	// Rule 命中的规则（黑名单、白名单规则或 Go 处理器的模式），未命中时为空
+	Rule string
+	// Decision 决策
+	Decision AuditDecision
+	// Duration 执行耗时
+	Duration time.Duration
+	// ExitCode 退出码，命令未执行或没有退出码（被拒绝、ctx 取消等）时为 -1
+	ExitCode int
+	// Error 错误信息，成功时为空
+	Error string
+}
+
+// AuditEvent 是 AuditEventT 的指针别名
+type AuditEvent = *AuditEventT
+
+// finish 记录执行耗时和结果
+func (me AuditEvent) finish(err error) {
+	me.Duration = time.Since(me.Time)
+	me.ExitCode = -1
+
+	if err == nil {
+		me.ExitCode = 0
+		return
+	}
+
+	var status interp.ExitStatus
+	if errors.As(err, &status) {
+		me.ExitCode = int(status)
+	}
+	me.Error = err.Error()
+}
+
+// ============================================================
+// AuditSink 审计输出
+// ============================================================
+
+// AuditSink 接收审计事件，实现需要并发安全
+type AuditSink interface {
+	Audit(event AuditEvent)
+}
+
+// AuditSinkFunc 函数形式的 AuditSink
+type AuditSinkFunc func(event AuditEvent)
+
+// Audit 实现 AuditSink
+func (f AuditSinkFunc) Audit(event AuditEvent) {
+	f(event)
+}
+
+// AuditFileSinkT 以 JSON Lines 格式把审计事件写入文件，基于 qlang.Logger（支持滚动）
+type AuditFileSinkT struct {
+	logger qlang.Logger
+}
+
+// AuditFileSink 是 AuditFileSinkT 的指针别名
+type AuditFileSink = *AuditFileSinkT
+
+// NewAuditFileSinkP 创建文件审计输出（失败时 panic）
+func NewAuditFileSinkP(fileName string, config qlang.LoggerConfig) AuditFileSink {
+	r, err := NewAuditFileSink(fileName, config)
+	if err != nil {
+		panic(qerr.NewSystemError(err.Error(), err))
+	}
+	return r
+}
+
+// NewAuditFileSink 创建文件审计输出，config 为 nil 时使用 lumberjack 的默认滚动配置
+func NewAuditFileSink(fileName string, config qlang.LoggerConfig) (AuditFileSink, error) {
+	if config == nil {
+		config = &qlang.LoggerConfigT{}
+	}
+
+	logger, err := qlang.NewLogger(nil, config, fileName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "create audit log: %s", fileName)
+	}
+	return NewAuditLoggerSink(logger), nil
+}
+
+// NewAuditLoggerSink 使用已有的 qlang.Logger 输出审计事件
+func NewAuditLoggerSink(logger qlang.Logger) AuditFileSink {
+	return &AuditFileSinkT{logger: logger}
+}
+
+// Audit 实现 AuditSink，每个事件写一行 JSON
+func (me AuditFileSink) Audit(event AuditEvent) {
+	entry := me.logger.Info()
+	if event.Decision == AuditBlocked {
+		entry = me.logger.Warn()
+	}
+
+	entry = entry.
+		Time("start", event.Time).
+		Str("name", event.Name).
+		Strs("args", event.Args).
+		Str("source", event.Source.String()).
+		Str("dir", event.Dir).
+		Str("decision", event.Decision.String()).
+		Int64("durationMs", event.Duration.Milliseconds()).
+		Int("exitCode", event.ExitCode)
+	if len(event.Rule) > 0 {
+		entry = entry.Str("rule", event.Rule)
+	}
+	if len(event.Error) > 0 {
+		entry = entry.Str("error", event.Error)
+	}
+	entry.Msg("gosh audit")
+}
+
+// Close 关闭审计文件
+func (me AuditFileSink) Close() {
+	me.logger.Close()
+}
+
+// ============================================================
+// 脱敏
+// ============================================================
+
+// auditRedacted 脱敏后的替换文本
+const auditRedacted = "***"
+
+// redactArgs 按正则脱敏参数：正则含分组时只替换分组内容，否则替换整个匹配
+func redactArgs(args []string, redactions []*regexp.Regexp) []string {
+	if len(args) == 0 {
+		return []string{}
+	}
+
+	r := make([]string, len(args))
+	for i, arg := range args {
+		for _, re := range redactions {
+			arg = redactArg(arg, re)
+		}
+		r[i] = arg
+	}
+	return r
+}
+
+// redactArg 脱敏单个参数
+func redactArg(arg string, re *regexp.Regexp) string {
+	matches := re.FindAllStringSubmatchIndex(arg, -1)
+	if len(matches) == 0 {
+		return arg
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		spans := [][2]int{{m[0], m[1]}}
+		if re.NumSubexp() > 0 {
+			spans = spans[:0]
+			for g := 1; g <= re.NumSubexp(); g++ {
+				if m[2*g] >= 0 {
+					spans = append(spans, [2]int{m[2*g], m[2*g+1]})
+				}
+			}
+		}
+
+		for _, span := range spans {
+			if span[0] < last {
+				continue
+			}
+			b.WriteString(arg[last:span[0]])
+			b.WriteString(auditRedacted)
+			last = span[1]
+		}
+	}
+	b.WriteString(arg[last:])
+	return b.String()
+}
+
+// ============================================================
+// 命令来源
+// ============================================================
+
+// commandSources 根据 AST 推断运行时命令的来源
+// exec 处理器只能拿到展开后的参数，这里按命令名依次对应 AST 中出现的命令，
+// 循环等重复执行时沿用最后一次的来源。
+// 条件分支没有执行时对应关系会错位，所以推断结果只写入审计记录，不参与规则匹配
+type commandSources struct {
+	mu      sync.Mutex
+	pending map[string][]CommandSource
+	last    map[string]CommandSource
+}
+
+type commandSourcesKey struct{}
+
+// newCommandSources 从解析后的脚本提取命令来源
+func newCommandSources(file *syntax.File) *commandSources {
+	var commands []ExtractedCommand
+	NewCommandExtractor().extractFromFile(file, SourceDirect, &commands)
+
+	r := &commandSources{
+		pending: map[string][]CommandSource{},
+		last:    map[string]CommandSource{},
+	}
+	for _, cmd := range commands {
+		r.pending[cmd.Name] = append(r.pending[cmd.Name], cmd.Source)
+	}
+	return r
+}
+
+func withCommandSources(ctx context.Context, sources *commandSources) context.Context {
+	return context.WithValue(ctx, commandSourcesKey{}, sources)
+}
+
+// lookupCommandSource 返回命令的来源，无法推断时为 SourceDirect
+func lookupCommandSource(ctx context.Context, name string) CommandSource {
+	sources, _ := ctx.Value(commandSourcesKey{}).(*commandSources)
+	if sources == nil {
+		return SourceDirect
+	}
+
+	sources.mu.Lock()
+	defer sources.mu.Unlock()
+
+	if queue := sources.pending[name]; len(queue) > 0 {
+		sources.pending[name] = queue[1:]
+		sources.last[name] = queue[0]
+		return queue[0]
+	}
+	if source, ok := sources.last[name]; ok {
+		return source
+	}
+	return SourceDirect
+}
@@ -0,0 +1,281 @@
+package qshell
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"mvdan.cc/sh/v3/interp"
+)
+
+// auditRecorder 记录审计事件
+type auditRecorder struct {
+	mu     sync.Mutex
+	events []AuditEvent
+}
+
+func (me *auditRecorder) Audit(event AuditEvent) {
+	me.mu.Lock()
+	me.events = append(me.events, event)
+	me.mu.Unlock()
+}
+
+func (me *auditRecorder) snapshot() []AuditEvent {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return append([]AuditEvent{}, me.events...)
+}
+
+func TestGoshExecutor_audit(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下 ls 不可用")
+	}
+
+	recorder := &auditRecorder{}
+	dir := t.TempDir()
+	config := DefaultGoshConfig().
+		WithAuditSink(recorder).
+		WithBlacklist(NewCommandRule("head", MatchExact).WithName("no-head")).
+		WithGoHandler("greet", func(ctx context.Context, hc interp.HandlerContext, args []string) error {
+			_, err := hc.Stdout.Write([]byte("hi\n"))
+			return err
+		})
+	executor := NewGoshExecutor(config)
+
+	var stdout, stderr bytes.Buffer
+	a.NoError(executor.Run(context.Background(), dir, "ls -d /; greet", nil, &stdout, &stderr))
+
+	err := executor.Run(context.Background(), dir, "head /dev/null", nil, &stdout, &stderr)
+	a.Error(err)
+
+	err = executor.Run(context.Background(), dir, "ls /nonexistent-dir-xyz", nil, &stdout, &stderr)
+	a.Error(err)
+
+	events := recorder.snapshot()
+	a.Len(events, 4)
+
+	ls := events[0]
+	a.Equal("ls", ls.Name)
+	a.Equal([]string{"-d", "/"}, ls.Args)
+	a.Equal(SourceDirect, ls.Source)
+	a.Equal(dir, ls.Dir)
+	a.Equal(AuditAllowed, ls.Decision)
+	a.Empty(ls.Rule)
+	a.Equal(0, ls.ExitCode)
+	a.Empty(ls.Error)
+	a.False(ls.Time.IsZero())
+
+	greet := events[1]
+	a.Equal(AuditGoHandler, greet.Decision)
+	a.Equal("greet", greet.Rule)
+	a.Equal(0, greet.ExitCode)
+
+	head := events[2]
+	a.Equal(AuditBlocked, head.Decision)
+	a.Equal("no-head", head.Rule)
+	a.Equal(-1, head.ExitCode)
+	a.Contains(head.Error, "blocked by blacklist rule: no-head")
+
+	failed := events[3]
+	a.Equal(AuditAllowed, failed.Decision)
+	a.NotEqual(0, failed.ExitCode)
+	a.NotEqual(-1, failed.ExitCode)
+}
+
+func TestGoshExecutor_auditWhitelist(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下 ls 不可用")
+	}
+
+	recorder := &auditRecorder{}
+	executor := NewGoshExecutor(DefaultGoshConfig().
+		WithAuditSink(recorder).
+		WithWhitelistMode(true).
+		WithWhitelist(NewCommandRule("ls", MatchExact).WithName("allow-ls")))
+
+	var stdout, stderr bytes.Buffer
+	a.NoError(executor.Run(context.Background(), "", "ls -d /", nil, &stdout, &stderr))
+	a.Error(executor.Run(context.Background(), "", "head /dev/null", nil, &stdout, &stderr))
+
+	events := recorder.snapshot()
+	a.Len(events, 2)
+	a.Equal(AuditAllowed, events[0].Decision)
+	a.Equal("allow-ls", events[0].Rule)
+	a.Equal(AuditBlocked, events[1].Decision)
+	a.Empty(events[1].Rule)
+	a.Contains(events[1].Error, "not in whitelist")
+}
+
+func TestGoshExecutor_auditSource(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下 ls 不可用")
+	}
+
+	recorder := &auditRecorder{}
+	executor := NewGoshExecutor(DefaultGoshConfig().WithAuditSink(recorder))
+
+	var stdout, stderr bytes.Buffer
+	a.NoError(executor.Run(context.Background(), "", "echo $(ls -d /); (ls -d /); head -n 0 /dev/null", nil, &stdout, &stderr))
+
+	events := recorder.snapshot()
+	a.Len(events, 3)
+	a.Equal(SourceCmdSubst, events[0].Source)
+	a.Equal(SourceSubshell, events[1].Source)
+	a.Equal(SourceDirect, events[2].Source)
+	a.Equal(AuditAllowed, events[2].Decision)
+}
+
+// 来源只用于审计：运行时规则按 SourceDirect 匹配，推断的来源不影响拦截结果
+func TestGoshExecutor_sourceFilterRuntime(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下 date 不可用")
+	}
+
+	// 只限命令替换的黑名单规则不拦截运行时的命令，直接调用也不受影响
+	recorder := &auditRecorder{}
+	executor := NewGoshExecutor(DefaultGoshConfig().
+		WithAuditSink(recorder).
+		WithBlacklist(NewCommandRule("date", MatchExact).WithSourceFilter(SourceCmdSubst)))
+
+	var stdout, stderr bytes.Buffer
+	a.NoError(executor.Run(context.Background(), "", "date +%Y >/dev/null; if false; then date; fi; x=$(date +%Y)", nil, &stdout, &stderr))
+
+	events := recorder.snapshot()
+	a.Len(events, 2)
+	a.Equal(SourceDirect, events[0].Source)
+	a.Equal(AuditAllowed, events[0].Decision)
+	a.Equal(AuditAllowed, events[1].Decision)
+
+	// 限定直接调用的规则对所有运行时命令生效，与推断的来源无关
+	executor = NewGoshExecutor(DefaultGoshConfig().
+		WithBlacklist(NewCommandRule("date", MatchExact).WithSourceFilter(SourceDirect)))
+	err := executor.Run(context.Background(), "", "x=$(date +%Y)", nil, &stdout, &stderr)
+	a.Error(err)
+	a.Contains(err.Error(), "blocked by blacklist")
+
+	// 白名单中只限命令替换的规则不放行
+	executor = NewGoshExecutor(DefaultGoshConfig().
+		WithWhitelistMode(true).
+		WithWhitelist(NewCommandRule("date", MatchExact).WithSourceFilter(SourceCmdSubst)))
+	a.Error(executor.Run(context.Background(), "", "x=$(date +%Y)", nil, &stdout, &stderr))
+}
+
+func TestGoshExecutor_auditRedaction(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下 sh 不可用")
+	}
+
+	recorder := &auditRecorder{}
+	executor := NewGoshExecutor(DefaultGoshConfig().
+		WithAuditSink(recorder).
+		WithAuditRedactions(regexp.MustCompile(`--password=(.*)`), regexp.MustCompile(`^sk-[a-z0-9]+$`)))
+
+	var stdout, stderr bytes.Buffer
+	a.NoError(executor.Run(context.Background(), "", "sh -c 'exit 0' --password=hunter2 sk-abc123 plain", nil, &stdout, &stderr))
+
+	events := recorder.snapshot()
+	a.Len(events, 1)
+	a.Equal([]string{"-c", "exit 0", "--password=***", "***", "plain"}, events[0].Args)
+}
+
+func TestGoshExecutor_auditSinkPanic(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下 ls 不可用")
+	}
+
+	executor := NewGoshExecutor(DefaultGoshConfig().
+		WithAuditSink(AuditSinkFunc(func(event AuditEvent) {
+			panic("sink failure")
+		})))
+
+	var stdout, stderr bytes.Buffer
+	a.NoError(executor.Run(context.Background(), "", "ls -d /", nil, &stdout, &stderr))
+	a.Equal("/\n", stdout.String())
+}
+
+func TestAuditFileSink(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下 ls 不可用")
+	}
+
+	fileName := filepath.Join(t.TempDir(), "audit", "gosh.jsonl")
+	sink := NewAuditFileSinkP(fileName, nil)
+
+	executor := NewGoshExecutor(DefaultGoshConfig().
+		WithAuditSink(sink).
+		WithBlacklistSimple("head"))
+
+	var stdout, stderr bytes.Buffer
+	a.NoError(executor.Run(context.Background(), "", "ls -d /", nil, &stdout, &stderr))
+	a.Error(executor.Run(context.Background(), "", "head /dev/null", nil, &stdout, &stderr))
+	sink.Close()
+
+	data, err := os.ReadFile(fileName)
+	a.NoError(err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	a.Len(lines, 2)
+
+	var allowed map[string]any
+	a.NoError(json.Unmarshal([]byte(lines[0]), &allowed))
+	a.Equal("info", allowed["level"])
+	a.Equal("gosh audit", allowed["message"])
+	a.Equal("ls", allowed["name"])
+	a.Equal([]any{"-d", "/"}, allowed["args"])
+	a.Equal("direct", allowed["source"])
+	a.Equal("allowed", allowed["decision"])
+	a.Equal(float64(0), allowed["exitCode"])
+	a.Contains(allowed, "durationMs")
+	a.NotContains(allowed, "rule")
+
+	var blocked map[string]any
+	a.NoError(json.Unmarshal([]byte(lines[1]), &blocked))
+	a.Equal("warn", blocked["level"])
+	a.Equal("blocked", blocked["decision"])
+	a.Equal("head", blocked["rule"])
+	a.Equal(float64(-1), blocked["exitCode"])
+	a.Contains(blocked["error"], "blocked by blacklist")
+}
+
+func TestRedactArgs(t *testing.T) {
+	a := require.New(t)
+
+	whole := regexp.MustCompile(`secret`)
+	group := regexp.MustCompile(`token=(\w+)`)
+
+	a.Equal([]string{}, redactArgs(nil, []*regexp.Regexp{whole}))
+	a.Equal([]string{"a", "b"}, redactArgs([]string{"a", "b"}, nil))
+	a.Equal([]string{"my-***-x-***"}, redactArgs([]string{"my-secret-x-secret"}, []*regexp.Regexp{whole}))
+	a.Equal([]string{"token=***&token=***"}, redactArgs([]string{"token=abc&token=def"}, []*regexp.Regexp{group}))
+	a.Equal([]string{"token=***", "***"}, redactArgs([]string{"token=abc", "secret"}, []*regexp.Regexp{whole, group}))
+}
+
+func TestAuditDecision_String(t *testing.T) {
+	a := require.New(t)
+
+	a.Equal("allowed", AuditAllowed.String())
+	a.Equal("go_handler", AuditGoHandler.String())
+	a.Equal("blocked", AuditBlocked.String())
+	a.Equal("unknown", AuditDecision(0).String())
+}
@@ -24,6 +24,33 @@ const (
 	MatchRegex
 )
 
+// String 返回匹配模式的字符串表示
+func (m MatchMode) String() string {
+	switch m {
+	case MatchGlob:
+		return "glob"
+	case MatchExact:
+		return "exact"
+	case MatchRegex:
+		return "regex"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseMatchMode 解析匹配模式，空字符串表示默认的 glob
+func ParseMatchMode(s string) (MatchMode, error) {
+	switch s {
+	case "", "glob":
+		return MatchGlob, nil
+	case "exact":
+		return MatchExact, nil
+	case "regex":
+		return MatchRegex, nil
+	}
+	return MatchGlob, errors.Errorf("unknown match mode '%s', expected one of: glob, exact, regex", s)
+}
+
 // ============================================================
 // ArgMatcher 参数匹配器
 // ============================================================
@@ -46,6 +73,10 @@ type ArgMatcher struct {
 
 // CommandRuleT 命令规则
 type CommandRuleT struct {
+	// Name 规则名称（可选，用于错误信息和审计）
+	Name string
+	// Reason 规则原因（可选，命中时附加到错误信息中）
+	Reason string
 	// Pattern 命令模式
 	Pattern string
 	// ArgsMatchers 参数匹配器列表
@@ -81,6 +112,26 @@ func (me CommandRule) WithSourceFilter(sources ...CommandSource) CommandRule {
 	return me
 }
 
+// WithName 设置规则名称
+func (me CommandRule) WithName(name string) CommandRule {
+	me.Name = name
+	return me
+}
+
+// WithReason 设置规则原因
+func (me CommandRule) WithReason(reason string) CommandRule {
+	me.Reason = reason
+	return me
+}
+
+// String 返回规则的描述：有名称时使用名称，否则使用命令模式
+func (me CommandRule) String() string {
+	if len(me.Name) > 0 {
+		return me.Name
+	}
+	return me.Pattern
+}
+
 // ============================================================
 // SecurityCheckError 安全检查错误
 // ============================================================
@@ -103,7 +154,10 @@ type SecurityCheckError = *SecurityCheckErrorT
 // Error 实现 error 接口
 func (e SecurityCheckError) Error() string {
 	if e.Rule != nil {
-		return fmt.Sprintf("command '%s' %s (rule: %s)", e.Command.Name, e.Message, e.Rule.Pattern)
+		if len(e.Rule.Reason) > 0 {
+			return fmt.Sprintf("command '%s' %s (rule: %s): %s", e.Command.Name, e.Message, e.Rule, e.Rule.Reason)
+		}
+		return fmt.Sprintf("command '%s' %s (rule: %s)", e.Command.Name, e.Message, e.Rule)
 	}
 	return fmt.Sprintf("command '%s' %s", e.Command.Name, e.Message)
 }
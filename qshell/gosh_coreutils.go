@@ -0,0 +1,1157 @@
+package qshell
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/qiangyt/go-comm/v3/qio"
+	"github.com/spf13/afero"
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/interp"
+)
+
+// ============================================================
+// Coreutils 纯 Go 实现的常用命令
+// ============================================================
+//
+// 通过 GoshConfig.WithCoreutils 启用后，以下命令不再依赖宿主机的可执行文件，
+// 文件操作全部经过 GoshConfig.Fs（未配置时为真实文件系统），并遵守 AllowedReadDirs / AllowedWriteDirs：
+//
+//	cat [-n] [file...]
+//	ls [-a] [-l] [-d] [-1] [path...]
+//	cp [-r] [-f] src... dst
+//	mv [-f] src... dst
+//	rm [-r] [-f] path...
+//	mkdir [-p] dir...
+//	touch [-c] file...
+//	head [-n N | -N] [file...]
+//	tail [-n N | -n +N] [file...]
+//	grep [-i] [-v] [-n] [-c] [-l] [-q] [-F] [-E] [-e pattern] pattern [file...]
+//	sed [-i] [-E] [-e 's/re/replacement/flags'...] [file...]
+//	wc [-l] [-w] [-c] [file...]
+//	env [NAME=VALUE...]
+//	which name...
+//
+// 只实现脚本中最常用的选项。grep 和 sed 的正则使用 Go regexp 语法（接近 ERE），
+// sed 只支持 s 命令（flags: g、i），替换文本中 & 和 \1..\9 引用匹配内容。
+// wc 的计数以空格分隔输出。env 不支持执行命令。
+
+// CoreutilsT 纯 Go 实现的常用命令集合
+type CoreutilsT struct {
+	config GoshConfig
+}
+
+// Coreutils 是 CoreutilsT 的指针别名
+type Coreutils = *CoreutilsT
+
+// NewCoreutils 创建命令集合，afs 为 nil 时使用真实文件系统，不限制访问目录
+func NewCoreutils(afs afero.Fs) Coreutils {
+	return &CoreutilsT{config: DefaultGoshConfig().WithFs(afs)}
+}
+
+// WithCoreutils 注册全部 Go 实现的常用命令
+// 命令在运行时读取本配置的 Fs 和目录限制，因此与 WithFs 等的调用顺序无关
+func (me GoshConfig) WithCoreutils() GoshConfig {
+	for name, handler := range (&CoreutilsT{config: me}).Handlers() {
+		me.GoHandlers[name] = handler
+	}
+	return me
+}
+
+// Handlers 返回命令名到处理器的映射
+func (me Coreutils) Handlers() map[string]GoCommandHandler {
+	return map[string]GoCommandHandler{
+		"cat":   me.Cat,
+		"ls":    me.Ls,
+		"cp":    me.Cp,
+		"mv":    me.Mv,
+		"rm":    me.Rm,
+		"mkdir": me.Mkdir,
+		"touch": me.Touch,
+		"head":  me.Head,
+		"tail":  me.Tail,
+		"grep":  me.Grep,
+		"sed":   me.Sed,
+		"wc":    me.Wc,
+		"env":   me.Env,
+		"which": me.Which,
+	}
+}
+
+// ============================================================
+// 公共辅助
+// ============================================================
+
+// coreutilsFail 输出错误信息并返回退出码
+func coreutilsFail(hc interp.HandlerContext, code uint8, name string, format string, args ...any) error {
+	fmt.Fprintf(hc.Stderr, "%s: %s\n", name, fmt.Sprintf(format, args...))
+	return interp.ExitStatus(code)
+}
+
+// coreutilsFlags 解析短选项：valueFlags 中的选项带参数（如 "-n 5"、"-n5"），"--" 结束选项
+// 返回选项到参数列表的映射（无参数选项对应空字符串）和剩余操作数
+func coreutilsFlags(args []string, boolFlags string, valueFlags string) (map[byte][]string, []string, error) {
+	flags := map[byte][]string{}
+	i := 0
+	for ; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			i++
+			break
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			break
+		}
+
+		for j := 1; j < len(arg); j++ {
+			c := arg[j]
+			switch {
+			case strings.IndexByte(valueFlags, c) >= 0:
+				value := arg[j+1:]
+				if len(value) == 0 {
+					if i+1 >= len(args) {
+						return nil, nil, errors.Errorf("option requires an argument -- '%c'", c)
+					}
+					i++
+					value = args[i]
+				}
+				flags[c] = append(flags[c], value)
+				j = len(arg)
+			case strings.IndexByte(boolFlags, c) >= 0:
+				flags[c] = append(flags[c], "")
+			default:
+				return nil, nil, errors.Errorf("invalid option -- '%c'", c)
+			}
+		}
+	}
+	return flags, args[i:], nil
+}
+
+// path 解析相对于解释器当前目录的路径
+func (me Coreutils) path(hc interp.HandlerContext, name string) string {
+	return goshAbsPath(hc.Dir, name)
+}
+
+// checkRead 检查读权限，不允许时返回致命错误，与重定向的行为一致
+func (me Coreutils) checkRead(name string, path string) error {
+	if !me.config.readAllowed(path) {
+		return errors.Wrapf(ErrPathNotAllowed, "%s '%s'", name, path)
+	}
+	return nil
+}
+
+// checkWrite 检查写权限，不允许时返回致命错误
+func (me Coreutils) checkWrite(name string, path string) error {
+	if !me.config.writeAllowed(path) {
+		return errors.Wrapf(ErrPathNotAllowed, "%s '%s'", name, path)
+	}
+	return nil
+}
+
+// pathErrorMessage 返回不含路径的错误描述，用于已经输出了路径的错误信息
+func pathErrorMessage(err error) string {
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		return pathErr.Err.Error()
+	}
+	return err.Error()
+}
+
+// open 打开输入，"-" 表示标准输入
+func (me Coreutils) open(hc interp.HandlerContext, name string, file string) (io.ReadCloser, error) {
+	if file == "-" {
+		if hc.Stdin == nil {
+			return io.NopCloser(strings.NewReader("")), nil
+		}
+		return io.NopCloser(hc.Stdin), nil
+	}
+
+	path := me.path(hc, file)
+	if err := me.checkRead(name, path); err != nil {
+		return nil, err
+	}
+	info, err := me.config.fileSystem().Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, errors.Errorf("%s: Is a directory", file)
+	}
+	return me.config.fileSystem().Open(path)
+}
+
+// eachInput 依次处理输入文件（无文件时为标准输入），出错的文件输出错误后继续，最终返回退出码 1
+func (me Coreutils) eachInput(hc interp.HandlerContext, name string, files []string, fn func(file string, r io.Reader) error) error {
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	failed := false
+	for _, file := range files {
+		r, err := me.open(hc, name, file)
+		if err != nil {
+			if errors.Is(err, ErrPathNotAllowed) {
+				return err
+			}
+			fmt.Fprintf(hc.Stderr, "%s: %v\n", name, err)
+			failed = true
+			continue
+		}
+		err = fn(file, r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if failed {
+		return interp.ExitStatus(1)
+	}
+	return nil
+}
+
+// readLines 按行读取，保留行尾换行符
+func readLines(r io.Reader, fn func(line string) bool) error {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if len(line) > 0 && !fn(line) {
+			return nil
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ============================================================
+// cat / head / tail / wc
+// ============================================================
+
+// Cat 输出文件内容，-n 输出行号
+func (me Coreutils) Cat(ctx context.Context, hc interp.HandlerContext, args []string) error {
+	flags, files, err := coreutilsFlags(args, "n", "")
+	if err != nil {
+		return coreutilsFail(hc, 1, "cat", "%v", err)
+	}
+	_, number := flags['n']
+
+	lineNo := 0
+	return me.eachInput(hc, "cat", files, func(file string, r io.Reader) error {
+		if !number {
+			_, err := io.Copy(hc.Stdout, r)
+			return err
+		}
+		return readLines(r, func(line string) bool {
+			lineNo++
+			fmt.Fprintf(hc.Stdout, "%6d\t%s", lineNo, line)
+			return true
+		})
+	})
+}
+
+// headTailCount 解析 head / tail 的行数，支持 -N 简写；fromStart 表示 tail 的 +N 形式
+func headTailCount(args []string) (count int, fromStart bool, files []string, err error) {
+	if len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		if n, convErr := strconv.Atoi(args[0][1:]); convErr == nil {
+			return n, false, args[1:], nil
+		}
+	}
+
+	flags, files, err := coreutilsFlags(args, "", "n")
+	if err != nil {
+		return 0, false, nil, err
+	}
+	count = 10
+	if values := flags['n']; len(values) > 0 {
+		value := values[len(values)-1]
+		if strings.HasPrefix(value, "+") {
+			fromStart = true
+			value = value[1:]
+		}
+		count, err = strconv.Atoi(value)
+		if err != nil || count < 0 {
+			return 0, false, nil, errors.Errorf("invalid number of lines: '%s'", values[len(values)-1])
+		}
+	}
+	return count, fromStart, files, nil
+}
+
+// Head 输出文件的前 N 行（默认 10 行）
+func (me Coreutils) Head(ctx context.Context, hc interp.HandlerContext, args []string) error {
+	count, _, files, err := headTailCount(args)
+	if err != nil {
+		return coreutilsFail(hc, 1, "head", "%v", err)
+	}
+
+	first := true
+	return me.eachInput(hc, "head", files, func(file string, r io.Reader) error {
+		writeFileHeader(hc, files, file, &first)
+		n := 0
+		return readLines(r, func(line string) bool {
+			if n >= count {
+				return false
+			}
+			io.WriteString(hc.Stdout, line)
+			n++
+			return true
+		})
+	})
+}
+
+// Tail 输出文件的最后 N 行（默认 10 行），-n +N 表示从第 N 行开始输出
+func (me Coreutils) Tail(ctx context.Context, hc interp.HandlerContext, args []string) error {
+	count, fromStart, files, err := headTailCount(args)
+	if err != nil {
+		return coreutilsFail(hc, 1, "tail", "%v", err)
+	}
+
+	first := true
+	return me.eachInput(hc, "tail", files, func(file string, r io.Reader) error {
+		writeFileHeader(hc, files, file, &first)
+
+		n := 0
+		var last []string
+		err := readLines(r, func(line string) bool {
+			n++
+			if fromStart {
+				if n >= count {
+					io.WriteString(hc.Stdout, line)
+				}
+				return true
+			}
+			if count > 0 {
+				if len(last) == count {
+					last = last[1:]
+				}
+				last = append(last, line)
+			}
+			return true
+		})
+		for _, line := range last {
+			io.WriteString(hc.Stdout, line)
+		}
+		return err
+	})
+}
+
+// writeFileHeader 多个文件时输出 "==> file <==" 分隔
+func writeFileHeader(hc interp.HandlerContext, files []string, file string, first *bool) {
+	if len(files) < 2 {
+		return
+	}
+	if !*first {
+		fmt.Fprintln(hc.Stdout)
+	}
+	*first = false
+	fmt.Fprintf(hc.Stdout, "==> %s <==\n", file)
+}
+
+// Wc 统计行数、单词数和字节数
+func (me Coreutils) Wc(ctx context.Context, hc interp.HandlerContext, args []string) error {
+	flags, files, err := coreutilsFlags(args, "lwc", "")
+	if err != nil {
+		return coreutilsFail(hc, 1, "wc", "%v", err)
+	}
+	_, lines := flags['l']
+	_, words := flags['w']
+	_, bytesCount := flags['c']
+	if !lines && !words && !bytesCount {
+		lines, words, bytesCount = true, true, true
+	}
+
+	format := func(l, w, c int64, name string) string {
+		var fields []string
+		if lines {
+			fields = append(fields, strconv.FormatInt(l, 10))
+		}
+		if words {
+			fields = append(fields, strconv.FormatInt(w, 10))
+		}
+		if bytesCount {
+			fields = append(fields, strconv.FormatInt(c, 10))
+		}
+		if len(name) > 0 {
+			fields = append(fields, name)
+		}
+		return strings.Join(fields, " ")
+	}
+
+	var totalL, totalW, totalC int64
+	err = me.eachInput(hc, "wc", files, func(file string, r io.Reader) error {
+		var l, w, c int64
+		inWord := false
+		br := bufio.NewReader(r)
+		for {
+			b, err := br.ReadByte()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			c++
+			if b == '\n' {
+				l++
+			}
+			if b == ' ' || b == '\n' || b == '\t' || b == '\r' || b == '\v' || b == '\f' {
+				inWord = false
+			} else if !inWord {
+				inWord = true
+				w++
+			}
+		}
+		totalL, totalW, totalC = totalL+l, totalW+w, totalC+c
+
+		name := file
+		if name == "-" {
+			name = ""
+		}
+		fmt.Fprintln(hc.Stdout, format(l, w, c, name))
+		return nil
+	})
+	if len(files) > 1 {
+		fmt.Fprintln(hc.Stdout, format(totalL, totalW, totalC, "total"))
+	}
+	return err
+}
+
+// ============================================================
+// grep / sed
+// ============================================================
+
+// Grep 按正则搜索文件，有匹配时退出码为 0，无匹配为 1，出错为 2
+func (me Coreutils) Grep(ctx context.Context, hc interp.HandlerContext, args []string) error {
+	flags, operands, err := coreutilsFlags(args, "ivnclqFE", "e")
+	if err != nil {
+		return coreutilsFail(hc, 2, "grep", "%v", err)
+	}
+
+	patterns := flags['e']
+	if len(patterns) == 0 {
+		if len(operands) == 0 {
+			return coreutilsFail(hc, 2, "grep", "missing pattern")
+		}
+		patterns = []string{operands[0]}
+		operands = operands[1:]
+	}
+
+	_, ignoreCase := flags['i']
+	_, invert := flags['v']
+	_, lineNumber := flags['n']
+	_, countOnly := flags['c']
+	_, filesOnly := flags['l']
+	_, quiet := flags['q']
+	_, fixed := flags['F']
+
+	exprs := make([]string, len(patterns))
+	for i, p := range patterns {
+		if fixed {
+			p = regexp.QuoteMeta(p)
+		}
+		exprs[i] = "(?:" + p + ")"
+	}
+	expr := strings.Join(exprs, "|")
+	if ignoreCase {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return coreutilsFail(hc, 2, "grep", "invalid pattern: %v", err)
+	}
+
+	matched := false
+	err = me.eachInput(hc, "grep", operands, func(file string, r io.Reader) error {
+		prefix := ""
+		if len(operands) > 1 {
+			prefix = file + ":"
+		}
+
+		n, count := 0, 0
+		err := readLines(r, func(line string) bool {
+			n++
+			if re.MatchString(strings.TrimSuffix(line, "\n")) == invert {
+				return true
+			}
+			count++
+			matched = true
+			if quiet || filesOnly {
+				return false
+			}
+			if countOnly {
+				return true
+			}
+
+			fmt.Fprint(hc.Stdout, prefix)
+			if lineNumber {
+				fmt.Fprintf(hc.Stdout, "%d:", n)
+			}
+			io.WriteString(hc.Stdout, line)
+			if !strings.HasSuffix(line, "\n") {
+				fmt.Fprintln(hc.Stdout)
+			}
+			return true
+		})
+
+		switch {
+		case quiet:
+		case filesOnly:
+			if count > 0 {
+				fmt.Fprintln(hc.Stdout, file)
+			}
+		case countOnly:
+			fmt.Fprintf(hc.Stdout, "%s%d\n", prefix, count)
+		}
+		return err
+	})
+
+	var status interp.ExitStatus
+	switch {
+	case quiet && matched:
+		return nil
+	case errors.As(err, &status):
+		return interp.ExitStatus(2)
+	case err != nil:
+		return err
+	case !matched:
+		return interp.ExitStatus(1)
+	}
+	return nil
+}
+
+// sedSubst sed 的 s 命令
+type sedSubst struct {
+	re          *regexp.Regexp
+	replacement string
+	global      bool
+}
+
+// apply 对一行执行替换
+func (me *sedSubst) apply(line string) string {
+	if me.global {
+		return me.re.ReplaceAllString(line, me.replacement)
+	}
+	m := me.re.FindStringSubmatchIndex(line)
+	if m == nil {
+		return line
+	}
+	dst := me.re.ExpandString(nil, me.replacement, line, m)
+	return line[:m[0]] + string(dst) + line[m[1]:]
+}
+
+// parseSedScript 解析由 ; 或换行分隔的 s 命令
+func parseSedScript(script string) ([]*sedSubst, error) {
+	var r []*sedSubst
+	rest := script
+	for {
+		rest = strings.TrimLeft(rest, " \t\n;")
+		if len(rest) == 0 {
+			return r, nil
+		}
+		if rest[0] != 's' || len(rest) < 2 {
+			return nil, errors.Errorf("unsupported command: %s", rest)
+		}
+
+		delim := rest[1]
+		parts, remain, err := splitSedParts(rest[2:], delim)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse '%s'", script)
+		}
+
+		end := strings.IndexAny(remain, ";\n")
+		if end < 0 {
+			end = len(remain)
+		}
+		subst := &sedSubst{replacement: sedReplacement(parts[1])}
+		expr := parts[0]
+		for _, flag := range strings.TrimSpace(remain[:end]) {
+			switch flag {
+			case 'g':
+				subst.global = true
+			case 'i', 'I':
+				expr = "(?i)" + expr
+			default:
+				return nil, errors.Errorf("unknown option to 's': %c", flag)
+			}
+		}
+		if subst.re, err = regexp.Compile(expr); err != nil {
+			return nil, errors.Wrapf(err, "parse '%s'", script)
+		}
+		r = append(r, subst)
+		rest = remain[end:]
+	}
+}
+
+// splitSedParts 按分隔符拆分出正则和替换文本，"\分隔符" 表示分隔符本身
+func splitSedParts(s string, delim byte) ([2]string, string, error) {
+	var parts [2]string
+	var b strings.Builder
+	part := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && s[i+1] == delim:
+			b.WriteByte(delim)
+			i++
+		case c == '\\' && i+1 < len(s):
+			b.WriteByte(c)
+			b.WriteByte(s[i+1])
+			i++
+		case c == delim:
+			parts[part] = b.String()
+			b.Reset()
+			part++
+			if part == 2 {
+				return parts, s[i+1:], nil
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return parts, "", errors.New("unterminated 's' command")
+}
+
+// sedReplacement 把 sed 的替换文本转换为 regexp.Expand 的模板：& → ${0}，\N → ${N}
+func sedReplacement(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '$':
+			b.WriteString("$$")
+		case c == '&':
+			b.WriteString("${0}")
+		case c == '\\' && i+1 < len(s):
+			i++
+			next := s[i]
+			switch {
+			case next >= '0' && next <= '9':
+				b.WriteString("${" + string(next) + "}")
+			case next == 'n':
+				b.WriteByte('\n')
+			case next == 't':
+				b.WriteByte('\t')
+			case next == '$':
+				b.WriteString("$$")
+			default:
+				b.WriteByte(next)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// Sed 按 s/re/replacement/flags 逐行替换，-i 原地修改文件
+func (me Coreutils) Sed(ctx context.Context, hc interp.HandlerContext, args []string) error {
+	flags, operands, err := coreutilsFlags(args, "iEr", "e")
+	if err != nil {
+		return coreutilsFail(hc, 1, "sed", "%v", err)
+	}
+
+	scripts := flags['e']
+	if len(scripts) == 0 {
+		if len(operands) == 0 {
+			return coreutilsFail(hc, 1, "sed", "missing script")
+		}
+		scripts = []string{operands[0]}
+		operands = operands[1:]
+	}
+
+	var substs []*sedSubst
+	for _, script := range scripts {
+		parsed, err := parseSedScript(script)
+		if err != nil {
+			return coreutilsFail(hc, 1, "sed", "%v", err)
+		}
+		substs = append(substs, parsed...)
+	}
+
+	transform := func(r io.Reader, w io.Writer) error {
+		return readLines(r, func(line string) bool {
+			content := strings.TrimSuffix(line, "\n")
+			for _, subst := range substs {
+				content = subst.apply(content)
+			}
+			io.WriteString(w, content)
+			if strings.HasSuffix(line, "\n") {
+				io.WriteString(w, "\n")
+			}
+			return true
+		})
+	}
+
+	if _, inPlace := flags['i']; !inPlace {
+		return me.eachInput(hc, "sed", operands, func(file string, r io.Reader) error {
+			return transform(r, hc.Stdout)
+		})
+	}
+
+	if len(operands) == 0 {
+		return coreutilsFail(hc, 1, "sed", "no input files")
+	}
+	for _, file := range operands {
+		path := me.path(hc, file)
+		if err := me.checkWrite("sed", path); err != nil {
+			return err
+		}
+		data, err := afero.ReadFile(me.config.fileSystem(), path)
+		if err != nil {
+			return coreutilsFail(hc, 1, "sed", "%v", err)
+		}
+		var out bytes.Buffer
+		if err := transform(bytes.NewReader(data), &out); err != nil {
+			return err
+		}
+		if err := afero.WriteFile(me.config.fileSystem(), path, out.Bytes(), 0o644); err != nil {
+			return coreutilsFail(hc, 1, "sed", "%v", err)
+		}
+	}
+	return nil
+}
+
+// ============================================================
+// ls
+// ============================================================
+
+// Ls 列出目录内容，-a 包含隐藏文件，-l 长格式，-d 列出目录本身
+func (me Coreutils) Ls(ctx context.Context, hc interp.HandlerContext, args []string) error {
+	flags, paths, err := coreutilsFlags(args, "ald1", "")
+	if err != nil {
+		return coreutilsFail(hc, 2, "ls", "%v", err)
+	}
+	_, all := flags['a']
+	_, long := flags['l']
+	_, dirOnly := flags['d']
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	afs := me.config.fileSystem()
+	list := func(info os.FileInfo, name string) {
+		if long {
+			fmt.Fprintf(hc.Stdout, "%s %8d %s %s\n", info.Mode(), info.Size(), info.ModTime().Format("Jan _2 15:04"), name)
+		} else {
+			fmt.Fprintln(hc.Stdout, name)
+		}
+	}
+
+	var files []string
+	var dirs []string
+	infos := map[string]os.FileInfo{}
+	failed := false
+	for _, p := range paths {
+		path := me.path(hc, p)
+		if err := me.checkRead("ls", path); err != nil {
+			return err
+		}
+		info, err := afs.Stat(path)
+		if err != nil {
+			fmt.Fprintf(hc.Stderr, "ls: cannot access '%s': %v\n", p, pathErrorMessage(err))
+			failed = true
+			continue
+		}
+		infos[p] = info
+		if info.IsDir() && !dirOnly {
+			dirs = append(dirs, p)
+		} else {
+			files = append(files, p)
+		}
+	}
+	sort.Strings(files)
+	sort.Strings(dirs)
+
+	for _, f := range files {
+		list(infos[f], f)
+	}
+	for i, d := range dirs {
+		if len(paths) > 1 {
+			if i > 0 || len(files) > 0 {
+				fmt.Fprintln(hc.Stdout)
+			}
+			fmt.Fprintf(hc.Stdout, "%s:\n", d)
+		}
+		entries, err := afero.ReadDir(afs, me.path(hc, d))
+		if err != nil {
+			fmt.Fprintf(hc.Stderr, "ls: cannot open directory '%s': %v\n", d, pathErrorMessage(err))
+			failed = true
+			continue
+		}
+		for _, entry := range entries {
+			if !all && strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			list(entry, entry.Name())
+		}
+	}
+
+	if failed {
+		return interp.ExitStatus(2)
+	}
+	return nil
+}
+
+// ============================================================
+// cp / mv / rm / mkdir / touch
+// ============================================================
+
+// copyTarget 计算复制或移动的目标路径：dst 是已存在的目录时放到目录下
+func (me Coreutils) copyTarget(src string, dst string, multi bool) (string, error) {
+	info, err := me.config.fileSystem().Stat(dst)
+	if err == nil && info.IsDir() {
+		return filepath.Join(dst, filepath.Base(src)), nil
+	}
+	if multi {
+		return "", errors.Errorf("target '%s' is not a directory", dst)
+	}
+	return dst, nil
+}
+
+// Cp 复制文件，-r 递归复制目录
+func (me Coreutils) Cp(ctx context.Context, hc interp.HandlerContext, args []string) error {
+	flags, operands, err := coreutilsFlags(args, "rRf", "")
+	if err != nil {
+		return coreutilsFail(hc, 1, "cp", "%v", err)
+	}
+	_, r := flags['r']
+	_, R := flags['R']
+	recursive := r || R
+	if len(operands) < 2 {
+		return coreutilsFail(hc, 1, "cp", "missing destination file operand")
+	}
+
+	afs := me.config.fileSystem()
+	dst := me.path(hc, operands[len(operands)-1])
+	srcs := operands[:len(operands)-1]
+
+	failed := false
+	for _, s := range srcs {
+		src := me.path(hc, s)
+		if err := me.checkRead("cp", src); err != nil {
+			return err
+		}
+		target, err := me.copyTarget(src, dst, len(srcs) > 1)
+		if err != nil {
+			return coreutilsFail(hc, 1, "cp", "%v", err)
+		}
+		if err := me.checkWrite("cp", target); err != nil {
+			return err
+		}
+
+		info, err := afs.Stat(src)
+		if err != nil {
+			fmt.Fprintf(hc.Stderr, "cp: %v\n", err)
+			failed = true
+			continue
+		}
+		if info.IsDir() {
+			if !recursive {
+				fmt.Fprintf(hc.Stderr, "cp: -r not specified; omitting directory '%s'\n", s)
+				failed = true
+				continue
+			}
+			// 目标在源目录之内时 Walk 会遍历正在创建的目标，无限递归下去
+			if qio.IsPathAllowed(target, []string{src}) {
+				fmt.Fprintf(hc.Stderr, "cp: cannot copy a directory, '%s', into itself, '%s'\n", s, target)
+				failed = true
+				continue
+			}
+			err = copyDir(afs, src, target)
+		} else {
+			err = copyFile(afs, src, target, info.Mode())
+		}
+		if err != nil {
+			fmt.Fprintf(hc.Stderr, "cp: %v\n", err)
+			failed = true
+		}
+	}
+	if failed {
+		return interp.ExitStatus(1)
+	}
+	return nil
+}
+
+// copyFile 复制单个文件
+func copyFile(afs afero.Fs, src string, dst string, mode os.FileMode) error {
+	in, err := afs.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := afs.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// copyDir 递归复制目录
+func copyDir(afs afero.Fs, src string, dst string) error {
+	return afero.Walk(afs, src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return afs.MkdirAll(target, info.Mode().Perm())
+		}
+		return copyFile(afs, path, target, info.Mode())
+	})
+}
+
+// Mv 移动或重命名文件
+func (me Coreutils) Mv(ctx context.Context, hc interp.HandlerContext, args []string) error {
+	_, operands, err := coreutilsFlags(args, "f", "")
+	if err != nil {
+		return coreutilsFail(hc, 1, "mv", "%v", err)
+	}
+	if len(operands) < 2 {
+		return coreutilsFail(hc, 1, "mv", "missing destination file operand")
+	}
+
+	dst := me.path(hc, operands[len(operands)-1])
+	srcs := operands[:len(operands)-1]
+
+	failed := false
+	for _, s := range srcs {
+		src := me.path(hc, s)
+		if err := me.checkWrite("mv", src); err != nil {
+			return err
+		}
+		target, err := me.copyTarget(src, dst, len(srcs) > 1)
+		if err != nil {
+			return coreutilsFail(hc, 1, "mv", "%v", err)
+		}
+		if err := me.checkWrite("mv", target); err != nil {
+			return err
+		}
+		if err := me.config.fileSystem().Rename(src, target); err != nil {
+			fmt.Fprintf(hc.Stderr, "mv: %v\n", err)
+			failed = true
+		}
+	}
+	if failed {
+		return interp.ExitStatus(1)
+	}
+	return nil
+}
+
+// Rm 删除文件，-r 递归删除目录，-f 忽略不存在的文件
+func (me Coreutils) Rm(ctx context.Context, hc interp.HandlerContext, args []string) error {
+	flags, operands, err := coreutilsFlags(args, "rRf", "")
+	if err != nil {
+		return coreutilsFail(hc, 1, "rm", "%v", err)
+	}
+	_, r := flags['r']
+	_, R := flags['R']
+	_, force := flags['f']
+	recursive := r || R
+	if len(operands) == 0 && !force {
+		return coreutilsFail(hc, 1, "rm", "missing operand")
+	}
+
+	afs := me.config.fileSystem()
+	failed := false
+	for _, p := range operands {
+		path := me.path(hc, p)
+		if err := me.checkWrite("rm", path); err != nil {
+			return err
+		}
+		info, err := afs.Stat(path)
+		if err != nil {
+			if !force {
+				fmt.Fprintf(hc.Stderr, "rm: cannot remove '%s': %v\n", p, pathErrorMessage(err))
+				failed = true
+			}
+			continue
+		}
+		if info.IsDir() {
+			if !recursive {
+				fmt.Fprintf(hc.Stderr, "rm: cannot remove '%s': Is a directory\n", p)
+				failed = true
+				continue
+			}
+			// 与 GNU rm 的 --preserve-root 一致，不递归删除根目录及 "."、".."
+			if filepath.Clean(path) == string(filepath.Separator) {
+				fmt.Fprintf(hc.Stderr, "rm: it is dangerous to operate recursively on '%s'\n", p)
+				fmt.Fprintf(hc.Stderr, "rm: use --no-preserve-root to override this failsafe\n")
+				failed = true
+				continue
+			}
+			if base := filepath.Base(p); base == "." || base == ".." {
+				fmt.Fprintf(hc.Stderr, "rm: refusing to remove '.' or '..' directory: skipping '%s'\n", p)
+				failed = true
+				continue
+			}
+			err = afs.RemoveAll(path)
+		} else {
+			err = afs.Remove(path)
+		}
+		if err != nil {
+			fmt.Fprintf(hc.Stderr, "rm: %v\n", err)
+			failed = true
+		}
+	}
+	if failed {
+		return interp.ExitStatus(1)
+	}
+	return nil
+}
+
+// Mkdir 创建目录，-p 创建父目录且目录已存在时不报错
+func (me Coreutils) Mkdir(ctx context.Context, hc interp.HandlerContext, args []string) error {
+	flags, operands, err := coreutilsFlags(args, "p", "")
+	if err != nil {
+		return coreutilsFail(hc, 1, "mkdir", "%v", err)
+	}
+	_, parents := flags['p']
+	if len(operands) == 0 {
+		return coreutilsFail(hc, 1, "mkdir", "missing operand")
+	}
+
+	afs := me.config.fileSystem()
+	failed := false
+	for _, p := range operands {
+		path := me.path(hc, p)
+		if err := me.checkWrite("mkdir", path); err != nil {
+			return err
+		}
+		if parents {
+			err = afs.MkdirAll(path, 0o755)
+		} else {
+			err = afs.Mkdir(path, 0o755)
+		}
+		if err != nil {
+			fmt.Fprintf(hc.Stderr, "mkdir: cannot create directory '%s': %v\n", p, pathErrorMessage(err))
+			failed = true
+		}
+	}
+	if failed {
+		return interp.ExitStatus(1)
+	}
+	return nil
+}
+
+// Touch 创建空文件或更新文件的修改时间，-c 不创建不存在的文件
+func (me Coreutils) Touch(ctx context.Context, hc interp.HandlerContext, args []string) error {
+	flags, operands, err := coreutilsFlags(args, "c", "")
+	if err != nil {
+		return coreutilsFail(hc, 1, "touch", "%v", err)
+	}
+	_, noCreate := flags['c']
+	if len(operands) == 0 {
+		return coreutilsFail(hc, 1, "touch", "missing file operand")
+	}
+
+	afs := me.config.fileSystem()
+	now := time.Now()
+	failed := false
+	for _, p := range operands {
+		path := me.path(hc, p)
+		if err := me.checkWrite("touch", path); err != nil {
+			return err
+		}
+
+		if _, err := afs.Stat(path); err != nil {
+			if noCreate {
+				continue
+			}
+			f, err := afs.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0o644)
+			if err == nil {
+				err = f.Close()
+			}
+			if err != nil {
+				fmt.Fprintf(hc.Stderr, "touch: cannot touch '%s': %v\n", p, pathErrorMessage(err))
+				failed = true
+			}
+			continue
+		}
+		if err := afs.Chtimes(path, now, now); err != nil {
+			fmt.Fprintf(hc.Stderr, "touch: %v\n", err)
+			failed = true
+		}
+	}
+	if failed {
+		return interp.ExitStatus(1)
+	}
+	return nil
+}
+
+// ============================================================
+// env / which
+// ============================================================
+
+// Env 输出导出的环境变量，NAME=VALUE 参数覆盖对应的变量；不支持执行命令
+func (me Coreutils) Env(ctx context.Context, hc interp.HandlerContext, args []string) error {
+	vars := map[string]string{}
+	hc.Env.Each(func(name string, vr expand.Variable) bool {
+		if vr.IsSet() && vr.Exported && vr.Kind == expand.String {
+			vars[name] = vr.String()
+		} else {
+			delete(vars, name)
+		}
+		return true
+	})
+
+	for _, arg := range args {
+		pos := strings.IndexByte(arg, '=')
+		if pos <= 0 {
+			return coreutilsFail(hc, 125, "env", "running commands is not supported: %s", arg)
+		}
+		vars[arg[:pos]] = arg[pos+1:]
+	}
+
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(hc.Stdout, "%s=%s\n", name, vars[name])
+	}
+	return nil
+}
+
+// Which 在 PATH 中查找命令的可执行文件
+func (me Coreutils) Which(ctx context.Context, hc interp.HandlerContext, args []string) error {
+	if len(args) == 0 {
+		return interp.ExitStatus(1)
+	}
+
+	failed := false
+	for _, name := range args {
+		path, err := interp.LookPathDir(hc.Dir, hc.Env, name)
+		if err != nil {
+			failed = true
+			continue
+		}
+		fmt.Fprintln(hc.Stdout, path)
+	}
+	if failed {
+		return interp.ExitStatus(1)
+	}
+	return nil
+}
@@ -0,0 +1,281 @@
+package qshell
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/qiangyt/go-comm/v3/qio"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+	"mvdan.cc/sh/v3/interp"
+)
+
+// runCoreutils 在内存文件系统中以 /work 为当前目录运行脚本
+func runCoreutils(t *testing.T, fs afero.Fs, script string) (string, string, error) {
+	t.Helper()
+
+	if exists, _ := afero.DirExists(fs, "/work"); !exists {
+		require.NoError(t, fs.MkdirAll("/work", 0o755))
+	}
+	executor := NewGoshExecutor(DefaultGoshConfig().WithFs(fs).WithCoreutils())
+
+	var stdout, stderr bytes.Buffer
+	err := executor.Run(context.Background(), "/work", script, nil, &stdout, &stderr)
+	return stdout.String(), stderr.String(), err
+}
+
+// exitCode 返回脚本错误中的退出码
+func exitCode(err error) int {
+	var status interp.ExitStatus
+	if errors.As(err, &status) {
+		return int(status)
+	}
+	return -1
+}
+
+func TestCoreutils_files(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下路径格式不同")
+	}
+
+	fs := afero.NewMemMapFs()
+	stdout, stderr, err := runCoreutils(t, fs, `
+mkdir -p a/b/c
+touch a/b/c/x.txt a/one.txt
+echo hello > a/two.txt
+cp a/two.txt a/b/
+cp -r a/b copy
+mv a/one.txt a/renamed.txt
+rm a/two.txt
+ls a
+ls -d copy copy/c
+ls copy/c
+cat copy/two.txt
+`)
+	a.NoError(err, stderr)
+	a.Equal("b\nrenamed.txt\ncopy\ncopy/c\nx.txt\nhello\n", stdout)
+
+	a.Equal("hello\n", qio.ReadFileTextP(fs, "/work/a/b/two.txt"))
+	exists, _ := afero.Exists(fs, "/work/a/two.txt")
+	a.False(exists)
+
+	// 出错的文件输出错误信息，其它文件继续处理
+	stdout, stderr, err = runCoreutils(t, fs, "cat missing.txt copy/two.txt")
+	a.Equal(1, exitCode(err))
+	a.Equal("hello\n", stdout)
+	a.Contains(stderr, "cat: ")
+
+	_, stderr, err = runCoreutils(t, fs, "rm copy")
+	a.Equal(1, exitCode(err))
+	a.Contains(stderr, "rm: cannot remove 'copy': Is a directory")
+
+	_, _, err = runCoreutils(t, fs, "rm -rf copy missing")
+	a.NoError(err)
+	exists, _ = afero.Exists(fs, "/work/copy")
+	a.False(exists)
+
+	// 不递归删除根目录及 "."、".."
+	for _, operand := range []string{"/", "//", ".", "..", "a/..", "a/./"} {
+		_, stderr, err = runCoreutils(t, fs, "rm -rf "+operand)
+		a.Equal(1, exitCode(err), operand)
+		a.NotEmpty(stderr, operand)
+	}
+	_, stderr, err = runCoreutils(t, fs, "rm -rf /")
+	a.Contains(stderr, "rm: it is dangerous to operate recursively on '/'")
+	_, stderr, err = runCoreutils(t, fs, "rm -rf a/..")
+	a.Contains(stderr, "rm: refusing to remove '.' or '..' directory: skipping 'a/..'")
+	exists, _ = afero.Exists(fs, "/work/a/renamed.txt")
+	a.True(exists)
+
+	// 不能把目录复制到它自己之内
+	_, stderr, err = runCoreutils(t, fs, "cp -r a a/sub")
+	a.Equal(1, exitCode(err))
+	a.Contains(stderr, "cp: cannot copy a directory, 'a', into itself")
+	exists, _ = afero.Exists(fs, "/work/a/sub")
+	a.False(exists)
+	_, stderr, err = runCoreutils(t, fs, "cp -r a a")
+	a.Equal(1, exitCode(err))
+	a.Contains(stderr, "into itself, '/work/a/a'")
+
+	_, stderr, err = runCoreutils(t, fs, "cp a x")
+	a.Equal(1, exitCode(err))
+	a.Contains(stderr, "-r not specified")
+
+	_, stderr, err = runCoreutils(t, fs, "mkdir a")
+	a.Equal(1, exitCode(err))
+	a.Contains(stderr, "mkdir: cannot create directory 'a'")
+}
+
+func TestCoreutils_text(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下路径格式不同")
+	}
+
+	fs := afero.NewMemMapFs()
+	a.NoError(fs.MkdirAll("/work", 0o755))
+	qio.WriteFileTextP(fs, "/work/lines.txt", "one\ntwo\nthree\nfour\nfive\n")
+	qio.WriteFileTextP(fs, "/work/words.txt", "hello world\nHello Go\n")
+
+	cases := []struct {
+		script string
+		stdout string
+	}{
+		{"head -n 2 lines.txt", "one\ntwo\n"},
+		{"head -3 lines.txt", "one\ntwo\nthree\n"},
+		{"tail -n 2 lines.txt", "four\nfive\n"},
+		{"tail -n +4 lines.txt", "four\nfive\n"},
+		{"head -n 1 lines.txt words.txt", "==> lines.txt <==\none\n\n==> words.txt <==\nhello world\n"},
+		{"cat -n words.txt", "     1\thello world\n     2\tHello Go\n"},
+		{"cat lines.txt | head -n 1", "one\n"},
+		{"grep hello words.txt", "hello world\n"},
+		{"grep -i hello words.txt", "hello world\nHello Go\n"},
+		{"grep -n -v o lines.txt", "3:three\n5:five\n"},
+		{"grep -c e lines.txt", "3\n"},
+		{"grep -l Go lines.txt words.txt", "words.txt\n"},
+		{"grep t lines.txt words.txt", "lines.txt:two\nlines.txt:three\n"},
+		{"grep -F 'o.' words.txt || echo none", "none\n"},
+		{"grep -e one -e five lines.txt", "one\nfive\n"},
+		{"grep -q two lines.txt && echo found", "found\n"},
+		{"sed 's/o/0/' words.txt", "hell0 world\nHell0 Go\n"},
+		{"sed -e 's/o/0/g' -e 's|world|there|' words.txt", "hell0 w0rld\nHell0 G0\n"},
+		{"sed 's/hello/[&]/I; s/(G)o/\\1O/' words.txt", "[hello] world\n[Hello] GO\n"},
+		{"sed -E 's/(l+)/<\\1>/g' words.txt", "he<ll>o wor<l>d\nHe<ll>o Go\n"},
+		{"echo abc | sed 's/b/$x/'", "a$xc\n"},
+		{"wc -l lines.txt", "5 lines.txt\n"},
+		{"wc < words.txt", "2 4 21\n"},
+		{"wc -w lines.txt words.txt", "5 lines.txt\n4 words.txt\n9 total\n"},
+	}
+	for _, c := range cases {
+		stdout, stderr, err := runCoreutils(t, fs, c.script)
+		a.NoError(err, "%s: %s", c.script, stderr)
+		a.Equal(c.stdout, stdout, c.script)
+	}
+
+	_, _, err := runCoreutils(t, fs, "grep missing lines.txt")
+	a.Equal(1, exitCode(err))
+
+	_, stderr, err := runCoreutils(t, fs, "grep one nonexistent.txt")
+	a.Equal(2, exitCode(err))
+	a.Contains(stderr, "grep: ")
+
+	_, stderr, err = runCoreutils(t, fs, "grep '(' lines.txt")
+	a.Equal(2, exitCode(err))
+	a.Contains(stderr, "invalid pattern")
+
+	_, stderr, err = runCoreutils(t, fs, "sed 'y/a/b/' lines.txt")
+	a.Equal(1, exitCode(err))
+	a.Contains(stderr, "unsupported command")
+
+	_, stderr, err = runCoreutils(t, fs, "head -x lines.txt")
+	a.Equal(1, exitCode(err))
+	a.Contains(stderr, "invalid option -- 'x'")
+
+	// sed -i 原地修改
+	_, _, err = runCoreutils(t, fs, "sed -i -e 's/five/5/' lines.txt")
+	a.NoError(err)
+	a.Equal("one\ntwo\nthree\nfour\n5\n", qio.ReadFileTextP(fs, "/work/lines.txt"))
+}
+
+func TestCoreutils_envAndWhich(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下 sh 不可用")
+	}
+
+	fs := afero.NewMemMapFs()
+	stdout, _, err := runCoreutils(t, fs, "export GOSH_TEST_A=1; GOSH_TEST_B=2; env GOSH_TEST_C=3 | grep GOSH_TEST_")
+	a.NoError(err)
+	a.Equal("GOSH_TEST_A=1\nGOSH_TEST_C=3\n", stdout)
+
+	_, stderr, err := runCoreutils(t, fs, "env ls")
+	a.Equal(125, exitCode(err))
+	a.Contains(stderr, "running commands is not supported")
+
+	stdout, _, err = runCoreutils(t, fs, "which sh")
+	a.NoError(err)
+	a.Contains(stdout, "/sh\n")
+
+	_, _, err = runCoreutils(t, fs, "which nonexistent_command_xyz")
+	a.Equal(1, exitCode(err))
+}
+
+func TestCoreutils_allowedDirs(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下路径格式不同")
+	}
+
+	fs := afero.NewMemMapFs()
+	a.NoError(fs.MkdirAll("/work/out", 0o755))
+	a.NoError(fs.MkdirAll("/etc", 0o755))
+	qio.WriteFileTextP(fs, "/work/in.txt", "data\n")
+	qio.WriteFileTextP(fs, "/etc/passwd", "root\n")
+
+	// 注册顺序与 WithFs、目录限制无关
+	executor := NewGoshExecutor(DefaultGoshConfig().
+		WithCoreutils().
+		WithFs(fs).
+		WithAllowedReadDirs("/work").
+		WithAllowedWriteDirs("/work/out"))
+
+	var stdout, stderr bytes.Buffer
+	a.NoError(executor.Run(context.Background(), "/work", "cp in.txt out/ && cat out/in.txt", nil, &stdout, &stderr))
+	a.Equal("data\n", stdout.String())
+
+	for _, script := range []string{
+		"cat /etc/passwd",
+		"ls /etc",
+		"rm in.txt",
+		"touch new.txt",
+		"cp in.txt /etc/",
+		"mv out/in.txt .",
+		"sed -i 's/a/b/' in.txt",
+	} {
+		err := executor.Run(context.Background(), "/work", script, nil, &stdout, &stderr)
+		a.True(errors.Is(err, ErrPathNotAllowed), script)
+	}
+	a.Equal("root\n", qio.ReadFileTextP(fs, "/etc/passwd"))
+	a.Equal("data\n", qio.ReadFileTextP(fs, "/work/in.txt"))
+}
+
+func TestCoreutilsFlags(t *testing.T) {
+	a := require.New(t)
+
+	flags, operands, err := coreutilsFlags([]string{"-rf", "-n5", "-n", "6", "--", "-x", "y"}, "rf", "n")
+	a.NoError(err)
+	a.Equal([]string{""}, flags['r'])
+	a.Equal([]string{""}, flags['f'])
+	a.Equal([]string{"5", "6"}, flags['n'])
+	a.Equal([]string{"-x", "y"}, operands)
+
+	_, operands, err = coreutilsFlags([]string{"-", "a"}, "", "")
+	a.NoError(err)
+	a.Equal([]string{"-", "a"}, operands)
+
+	_, _, err = coreutilsFlags([]string{"-n"}, "", "n")
+	a.Error(err)
+}
+
+func TestNewCoreutils(t *testing.T) {
+	a := require.New(t)
+
+	fs := afero.NewMemMapFs()
+	qio.WriteFileTextP(fs, "/a.txt", "x\n")
+
+	handlers := NewCoreutils(fs).Handlers()
+	a.Len(handlers, 14)
+
+	var stdout bytes.Buffer
+	err := handlers["cat"](context.Background(), interp.HandlerContext{Dir: "/", Stdout: &stdout}, []string{"a.txt"})
+	a.NoError(err)
+	a.Equal("x\n", stdout.String())
+}
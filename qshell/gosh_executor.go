@@ -5,12 +5,18 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/qiangyt/go-comm/v3/qlang"
 	"github.com/qiangyt/go-comm/v3/qsys"
+	"github.com/spf13/afero"
 	"mvdan.cc/sh/v3/expand"
 	"mvdan.cc/sh/v3/interp"
 	"mvdan.cc/sh/v3/syntax"
@@ -30,7 +36,12 @@ import (
 //	│  ├── Blacklist        []CommandRule   // 黑名单规则         │
 //	│  ├── WhitelistMode    bool            // 白名单模式开关     │
 //	│  ├── Whitelist        []CommandRule   // 白名单规则         │
-//	│  └── GoHandlers       map[string]GoCommandHandler           │
+//	│  ├── GoHandlers       map[string]GoCommandHandler           │
+//	│  ├── AuditSink        AuditSink       // 审计输出           │
+//	│  ├── AllowedReadDirs  []string        // 允许读的目录       │
+//	│  ├── AllowedWriteDirs []string        // 允许写的目录       │
+//	│  ├── Fs               afero.Fs        // 脚本文件 I/O       │
+//	│  └── Limits           Limits          // 外部命令资源限制   │
 //	├─────────────────────────────────────────────────────────────┤
 //	│  执行流程:                                                   │
 //	│  1. 解析命令 (mvdan/sh syntax)                              │
@@ -38,6 +49,8 @@ import (
 //	│  3. 检查白名单模式 → 仅允许白名单命令                         │
 //	│  4. 检查 GoHandlers → 用 Go 实现执行                         │
 //	│  5. 其他命令 → exec 真实执行                                 │
+//	│  6. 每条命令的决策和结果 → AuditSink                         │
+//	│  7. 重定向、cd、内置命令的文件访问 → 目录限制 / Fs           │
 //	└─────────────────────────────────────────────────────────────┘
 //
 // CommandRule 支持通配符和参数匹配：
@@ -62,6 +75,11 @@ import (
 // config := qshell.DefaultGoshConfig().
 //     WithGoHandler("curl", myCurlHandler)
 //
+// 纯 Go 实现的常用命令，脚本不依赖宿主机的 cat、grep 等
+// config := qshell.DefaultGoshConfig().
+//     WithFs(afero.NewMemMapFs()).
+//     WithCoreutils()
+//
 // /白名单模式
 // config := qshell.DefaultGoshConfig().
 //     WithWhitelistMode(true).
@@ -97,6 +115,27 @@ type GoshConfigT struct {
 	// GoHandlers Go 实现的命令处理器
 	// key 支持通配符，如 "curl*" 匹配 curl 和 curlconfig
 	GoHandlers map[string]GoCommandHandler
+
+	// AuditSink 审计输出，每条命令（执行、Go 处理器或被拒绝）都会产生一个事件，nil 表示不审计
+	AuditSink AuditSink
+
+	// AuditRedactions 审计时需要脱敏的参数模式
+	// 正则含分组时只替换分组内容，如 `--password=(.*)`
+	AuditRedactions []*regexp.Regexp
+
+	// AllowedReadDirs 重定向、cd 和内置命令允许读取的目录，空表示不限制
+	// AllowedWriteDirs 中的目录同样允许读取
+	AllowedReadDirs []string
+
+	// AllowedWriteDirs 重定向和内置命令允许写入的目录，空表示不限制
+	AllowedWriteDirs []string
+
+	// Fs 脚本的文件 I/O（重定向、内置命令、通配符展开）使用的文件系统，nil 表示真实文件系统
+	// 外部命令不受影响
+	Fs afero.Fs
+
+	// Limits 每个外部命令进程的资源限制，nil 表示不限制；超出时脚本以 LimitError 终止
+	Limits Limits
 }
 
 type GoshConfig = *GoshConfigT
@@ -152,6 +191,42 @@ func (me GoshConfig) WithWhitelistSimple(cmds ...string) GoshConfig {
 	return me
 }
 
+// WithAuditSink 设置审计输出
+func (me GoshConfig) WithAuditSink(sink AuditSink) GoshConfig {
+	me.AuditSink = sink
+	return me
+}
+
+// WithAuditRedactions 添加审计脱敏模式
+func (me GoshConfig) WithAuditRedactions(patterns ...*regexp.Regexp) GoshConfig {
+	me.AuditRedactions = append(me.AuditRedactions, patterns...)
+	return me
+}
+
+// WithAllowedReadDirs 添加允许读取的目录
+func (me GoshConfig) WithAllowedReadDirs(dirs ...string) GoshConfig {
+	me.AllowedReadDirs = append(me.AllowedReadDirs, dirs...)
+	return me
+}
+
+// WithAllowedWriteDirs 添加允许写入的目录
+func (me GoshConfig) WithAllowedWriteDirs(dirs ...string) GoshConfig {
+	me.AllowedWriteDirs = append(me.AllowedWriteDirs, dirs...)
+	return me
+}
+
+// WithFs 设置脚本文件 I/O 使用的文件系统，如测试中使用 afero.NewMemMapFs()
+func (me GoshConfig) WithFs(fs afero.Fs) GoshConfig {
+	me.Fs = fs
+	return me
+}
+
+// WithLimits 设置外部命令的资源限制
+func (me GoshConfig) WithLimits(limits Limits) GoshConfig {
+	me.Limits = limits
+	return me
+}
+
 // WithGoHandler 注册 Go 命令处理器
 // pattern 支持通配符，如 "curl*" 匹配 curl 和 curlconfig
 func (me GoshConfig) WithGoHandler(pattern string, handler GoCommandHandler) GoshConfig {
@@ -210,10 +285,18 @@ func (me GoshExecutor) RunWithVars(ctx context.Context, vars map[string]string,
 		interp.Params("-e"),
 		interp.Env(expand.ListEnviron(environ...)),
 		interp.ExecHandler(me.createExecHandler()), //nolint:staticcheck // deprecated but no replacement
+		interp.CallHandler(me.createCallHandler()),
+		interp.OpenHandler(me.createOpenHandler()),
+		interp.StatHandler(me.createStatHandler()),
+		interp.ReadDirHandler2(me.createReadDirHandler()),
 		interp.StdIO(stdin, stdout, stderr),
 	}
 	if dir != "" {
-		opts = append(opts, interp.Dir(dir))
+		if me.config.Fs != nil {
+			opts = append(opts, goshFsDir(me.config.Fs, dir))
+		} else {
+			opts = append(opts, interp.Dir(dir))
+		}
 	}
 
 	// 创建并运行
@@ -222,6 +305,9 @@ func (me GoshExecutor) RunWithVars(ctx context.Context, vars map[string]string,
 		return errors.Wrapf(err, "create runner for command: %s", cmd)
 	}
 
+	// 记录 AST 中的命令来源，只用于审计
+	ctx = withCommandSources(ctx, newCommandSources(sf))
+
 	if err = runner.Run(ctx, sf); err != nil {
 		return errors.Wrapf(err, "run command: %s", cmd)
 	}
@@ -234,32 +320,165 @@ func (me GoshExecutor) createExecHandler() interp.ExecHandlerFunc {
 	return func(ctx context.Context, args []string) error {
 		cmd := args[0]
 		cmdArgs := args[1:]
+		source := lookupCommandSource(ctx, cmd)
 
-		// 1. 检查黑名单
-		if matched, rule := me.matchesRules(cmd, cmdArgs, me.config.Blacklist); matched {
-			return fmt.Errorf("command '%s' is blocked by blacklist rule: %s", cmd, rule.Pattern)
+		event := &AuditEventT{
+			Time:   time.Now(),
+			Name:   cmd,
+			Args:   cmdArgs,
+			Source: source,
+			Dir:    interp.HandlerCtx(ctx).Dir,
 		}
+		err := me.execCommand(ctx, args, event)
+		me.audit(event, err)
+		return err
+	}
+}
 
-		// 2. 检查白名单模式
-		if me.config.WhitelistMode {
-			if matched, _ := me.matchesRules(cmd, cmdArgs, me.config.Whitelist); !matched {
-				return fmt.Errorf("command '%s' is not in whitelist", cmd)
-			}
+// execCommand 按黑名单、白名单、Go 处理器、exec 的顺序处理命令，并记录审计决策
+func (me GoshExecutor) execCommand(ctx context.Context, args []string, event AuditEvent) error {
+	cmd := args[0]
+	cmdArgs := args[1:]
+
+	// 1. 检查黑名单
+	if matched, rule := me.matchesRules(cmd, cmdArgs, me.config.Blacklist); matched {
+		event.Decision = AuditBlocked
+		event.Rule = rule.String()
+		if len(rule.Reason) > 0 {
+			return fmt.Errorf("command '%s' is blocked by blacklist rule: %s: %s", cmd, rule, rule.Reason)
 		}
+		return fmt.Errorf("command '%s' is blocked by blacklist rule: %s", cmd, rule)
+	}
 
-		// 3. 检查 Go 处理器
-		for pattern, handler := range me.config.GoHandlers {
-			if me.matchPattern(pattern, cmd) {
-				return handler(ctx, interp.HandlerCtx(ctx), cmdArgs)
-			}
+	// 2. 检查白名单模式
+	if me.config.WhitelistMode {
+		matched, rule := me.matchesRules(cmd, cmdArgs, me.config.Whitelist)
+		if !matched {
+			event.Decision = AuditBlocked
+			return fmt.Errorf("command '%s' is not in whitelist", cmd)
+		}
+		event.Rule = rule.String()
+	}
+
+	// 3. 检查 Go 处理器
+	if pattern, handler := me.findGoHandler(cmd); handler != nil {
+		event.Decision = AuditGoHandler
+		event.Rule = pattern
+		return handler(ctx, interp.HandlerCtx(ctx), cmdArgs)
+	}
+
+	// 4. 默认 exec 执行
+	event.Decision = AuditAllowed
+	return goshExec(ctx, args, me.config.KillTimeout, me.config.Limits)
+}
+
+// audit 把审计事件发送给 AuditSink，审计输出的 panic 不影响命令执行
+func (me GoshExecutor) audit(event AuditEvent, err error) {
+	sink := me.config.AuditSink
+	if sink == nil {
+		return
+	}
+
+	event.finish(err)
+	event.Args = redactArgs(event.Args, me.config.AuditRedactions)
+
+	defer func() {
+		qlang.RecoverAndLog(recover(), nil, "gosh audit sink")
+	}()
+	sink.Audit(event)
+}
+
+// goshExec 执行外部命令，行为与 interp.DefaultExecHandler 一致，
+// 区别在于命令运行在独立进程组中，ctx 取消时先向整个进程组发送 SIGTERM，
+// killTimeout 后仍未退出则发送 SIGKILL，避免遗留孙进程。
+// limits 不为 nil 时对进程应用资源限制，超出限制时返回 LimitError
+func goshExec(ctx context.Context, args []string, killTimeout time.Duration, limits Limits) error {
+	hc := interp.HandlerCtx(ctx)
+	path, err := interp.LookPathDir(hc.Dir, hc.Env, args[0])
+	if err != nil {
+		fmt.Fprintln(hc.Stderr, err)
+		return interp.ExitStatus(127)
+	}
+
+	limiter, procCtx := newLimitedProcess(ctx, limits, args[0])
+	cmd := &exec.Cmd{
+		Path:   path,
+		Args:   args,
+		Env:    goshExecEnv(hc.Env),
+		Dir:    hc.Dir,
+		Stdin:  hc.Stdin,
+		Stdout: limiter.writer(hc.Stdout),
+		Stderr: limiter.writer(hc.Stderr),
+	}
+	setProcessGroup(cmd)
+	if err := limiter.prepare(cmd); err != nil {
+		return err
+	}
+
+	err = cmd.Start()
+	if err == nil {
+		exited := make(chan struct{})
+		stopf := context.AfterFunc(procCtx, func() {
+			_ = terminateProcessGroup(cmd, killTimeout, exited)
+		})
+		err = cmd.Wait()
+		close(exited)
+		stopf()
+
+		if usage := getResourceUsage(ctx); usage != nil {
+			usage.add(cmd.ProcessState)
+		}
+		if limitErr := limiter.finish(cmd.ProcessState); limitErr != nil {
+			return limitErr
 		}
+	} else {
+		limiter.finish(nil)
+	}
 
-		// 4. 默认 exec 执行
-		return interp.DefaultExecHandler(me.config.KillTimeout)(ctx, args)
+	var exitErr *exec.ExitError
+	var execErr *exec.Error
+	switch {
+	case errors.As(err, &exitErr):
+		if sig := exitSignal(exitErr.ProcessState); sig != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if s, ok := sig.(syscall.Signal); ok {
+				return interp.ExitStatus(128 + int(s))
+			}
+		}
+		return interp.ExitStatus(exitErr.ExitCode())
+	case errors.As(err, &execErr):
+		// 未能启动
+		fmt.Fprintf(hc.Stderr, "%v\n", err)
+		return interp.ExitStatus(127)
+	default:
+		return err
 	}
 }
 
+// goshExecEnv 将解释器环境转换为子进程环境变量列表（仅导出的字符串变量）
+func goshExecEnv(env expand.Environ) []string {
+	r := make([]string, 0, 64)
+	env.Each(func(name string, vr expand.Variable) bool {
+		if !vr.IsSet() {
+			// 全局设置但在当前作用域被 unset 的变量不应传给子进程
+			for i, kv := range r {
+				if strings.HasPrefix(kv, name+"=") {
+					r[i] = ""
+				}
+			}
+		}
+		if vr.Exported && vr.Kind == expand.String {
+			r = append(r, name+"="+vr.String())
+		}
+		return true
+	})
+	return r
+}
+
 // matchesRules 检查命令是否匹配规则列表
+// 运行时无法可靠地得知命令在脚本中的来源，按 SourceDirect 匹配
 func (me GoshExecutor) matchesRules(cmd string, args []string, rules []CommandRule) (bool, CommandRule) {
 	checker := NewSecurityChecker()
 
@@ -278,6 +497,27 @@ func (me GoshExecutor) matchesRules(cmd string, args []string, rules []CommandRu
 	return false, nil
 }
 
+// findGoHandler 查找拦截命令的 Go 处理器
+// 精确匹配优先，其次按模式的字典序取第一个匹配，保证多个通配符都匹配时结果确定
+func (me GoshExecutor) findGoHandler(cmd string) (string, GoCommandHandler) {
+	if handler, ok := me.config.GoHandlers[cmd]; ok {
+		return cmd, handler
+	}
+
+	patterns := make([]string, 0, len(me.config.GoHandlers))
+	for pattern := range me.config.GoHandlers {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+
+	for _, pattern := range patterns {
+		if me.matchPattern(pattern, cmd) {
+			return pattern, me.config.GoHandlers[pattern]
+		}
+	}
+	return "", nil
+}
+
 // matchPattern 通配符匹配（支持 * 和 ?）
 func (me GoshExecutor) matchPattern(pattern, s string) bool {
 	// 简单实现：使用 filepath.Match
@@ -0,0 +1,245 @@
+package qshell
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+	"mvdan.cc/sh/v3/interp"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// ============================================================
+// ExplainDecision 预演决策
+// ============================================================
+
+// ExplainDecision 预演时对一条命令给出的决策
+type ExplainDecision int
+
+const (
+	// ExplainExec 允许，以外部进程执行
+	ExplainExec ExplainDecision = iota + 1
+	// ExplainGoHandler 允许，由 Go 处理器执行
+	ExplainGoHandler
+	// ExplainBuiltin 允许，由解释器内置命令执行（不经过黑白名单）
+	ExplainBuiltin
+	// ExplainFunction 允许，调用脚本中定义的函数（函数体内的命令单独列出）
+	ExplainFunction
+	// ExplainBlocked 被黑名单或白名单模式拒绝
+	ExplainBlocked
+)
+
+// String 返回决策的字符串表示
+func (d ExplainDecision) String() string {
+	switch d {
+	case ExplainExec:
+		return "exec"
+	case ExplainGoHandler:
+		return "go_handler"
+	case ExplainBuiltin:
+		return "builtin"
+	case ExplainFunction:
+		return "function"
+	case ExplainBlocked:
+		return "blocked"
+	default:
+		return "unknown"
+	}
+}
+
+// Allowed 是否允许执行
+func (d ExplainDecision) Allowed() bool {
+	return d != ExplainBlocked
+}
+
+// ============================================================
+// ExplainEntry / ExplainReport 预演报告
+// ============================================================
+
+// ExplainEntryT 一条命令的预演结果
+type ExplainEntryT struct {
+	// Name 命令名（未展开的原文）
+	Name string
+	// Args 参数（未展开的原文）
+	Args []string
+	// Envs 内联环境变量
+	Envs []string
+	// Source 命令来源
+	Source CommandSource
+	// Position 在脚本中的位置
+	Position syntax.Pos
+	// Dynamic 命令名包含变量或命令替换，运行时的实际命令无法静态确定
+	Dynamic bool
+	// Decision 决策
+	Decision ExplainDecision
+	// Rule 命中的规则：黑名单/白名单规则，或 Go 处理器的模式
+	Rule string
+	// Reason 被拒绝的原因
+	Reason string
+	// Path exec 决策时命令的可执行文件路径，未找到时为空
+	Path string
+}
+
+// ExplainEntry 是 ExplainEntryT 的指针别名
+type ExplainEntry = *ExplainEntryT
+
+// String 返回单条预演结果的描述
+func (me ExplainEntry) String() string {
+	cmdline := strings.Join(append([]string{me.Name}, me.Args...), " ")
+
+	var detail string
+	switch me.Decision {
+	case ExplainBlocked:
+		detail = me.Reason
+		if len(me.Rule) > 0 {
+			detail = fmt.Sprintf("%s (rule: %s)", me.Reason, me.Rule)
+		}
+	case ExplainGoHandler:
+		detail = "handler: " + me.Rule
+	case ExplainExec:
+		if len(me.Path) > 0 {
+			detail = me.Path
+		} else if !me.Dynamic {
+			detail = "command not found"
+		}
+	}
+	if me.Dynamic {
+		detail = strings.TrimSpace(detail + " (dynamic)")
+	}
+
+	r := fmt.Sprintf("%d:%d\t%s\t%s\t%s", me.Position.Line(), me.Position.Col(), me.Source, me.Decision, cmdline)
+	if len(detail) > 0 {
+		r += "\t" + detail
+	}
+	return r
+}
+
+// ExplainReportT 预演报告
+type ExplainReportT struct {
+	// Command 预演的脚本
+	Command string
+	// Entries 按脚本中出现顺序排列的命令
+	Entries []ExplainEntry
+}
+
+// ExplainReport 是 ExplainReportT 的指针别名
+type ExplainReport = *ExplainReportT
+
+// Allowed 是否所有命令都允许执行
+func (me ExplainReport) Allowed() bool {
+	return len(me.Blocked()) == 0
+}
+
+// Blocked 返回被拒绝的命令
+func (me ExplainReport) Blocked() []ExplainEntry {
+	var r []ExplainEntry
+	for _, entry := range me.Entries {
+		if entry.Decision == ExplainBlocked {
+			r = append(r, entry)
+		}
+	}
+	return r
+}
+
+// String 返回报告的文本形式，每条命令一行
+func (me ExplainReport) String() string {
+	lines := make([]string, 0, len(me.Entries))
+	for _, entry := range me.Entries {
+		lines = append(lines, entry.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ============================================================
+// Explain
+// ============================================================
+
+// Explain 预演命令：不执行任何命令，给出每条命令（包括命令替换、子 shell、循环和函数中的命令）
+// 会被如何处理。参数按原文匹配规则，变量要到运行时才展开，因此依赖变量的参数规则只能尽力判断
+func (me GoshExecutor) Explain(cmd string) (ExplainReport, error) {
+	file, err := syntax.NewParser().Parse(strings.NewReader(cmd), "")
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse command: %s", cmd)
+	}
+
+	var commands []ExtractedCommand
+	NewCommandExtractor().extractFromFile(file, SourceDirect, &commands)
+
+	functions := map[string]bool{}
+	syntax.Walk(file, func(node syntax.Node) bool {
+		if fn, ok := node.(*syntax.FuncDecl); ok {
+			functions[fn.Name.Value] = true
+		}
+		return true
+	})
+
+	checker := NewSecurityChecker().
+		WithBlacklist(me.config.Blacklist...).
+		WithWhitelist(me.config.Whitelist...).
+		WithWhitelistMode(me.config.WhitelistMode)
+
+	r := &ExplainReportT{
+		Command: cmd,
+		Entries: make([]ExplainEntry, 0, len(commands)),
+	}
+	for _, c := range commands {
+		r.Entries = append(r.Entries, me.explainCommand(c, checker, functions))
+	}
+	return r, nil
+}
+
+// explainCommand 按运行时的顺序判断单条命令：函数 → 内置命令 → 黑名单 → 白名单 → Go 处理器 → exec
+func (me GoshExecutor) explainCommand(cmd ExtractedCommand, checker SecurityChecker, functions map[string]bool) ExplainEntry {
+	r := &ExplainEntryT{
+		Name:     cmd.Name,
+		Args:     cmd.Args,
+		Envs:     cmd.Envs,
+		Source:   cmd.Source,
+		Position: cmd.Position,
+		Dynamic:  strings.ContainsAny(cmd.Name, "$`"),
+	}
+
+	if !r.Dynamic {
+		if functions[cmd.Name] {
+			r.Decision = ExplainFunction
+			return r
+		}
+		if interp.IsBuiltin(cmd.Name) {
+			r.Decision = ExplainBuiltin
+			return r
+		}
+	}
+
+	if checkErr := GetSecurityCheckError(checker.Check([]ExtractedCommand{cmd})); checkErr != nil {
+		r.Decision = ExplainBlocked
+		r.Reason = checkErr.Message
+		if checkErr.Rule != nil {
+			r.Rule = checkErr.Rule.String()
+			if len(checkErr.Rule.Reason) > 0 {
+				r.Reason = checkErr.Rule.Reason
+			}
+		}
+		return r
+	}
+
+	if me.config.WhitelistMode {
+		if rule, matched := checker.matchesWhitelist(cmd); matched {
+			r.Rule = rule.String()
+		}
+	}
+
+	if pattern, handler := me.findGoHandler(cmd.Name); handler != nil {
+		r.Decision = ExplainGoHandler
+		r.Rule = pattern
+		return r
+	}
+
+	r.Decision = ExplainExec
+	if !r.Dynamic {
+		if path, err := exec.LookPath(cmd.Name); err == nil {
+			r.Path = path
+		}
+	}
+	return r
+}
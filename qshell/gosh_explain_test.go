@@ -0,0 +1,180 @@
+package qshell
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"mvdan.cc/sh/v3/interp"
+)
+
+// explainSummary 将报告简化为 "名称 来源 决策" 列表便于断言
+func explainSummary(report ExplainReport) []string {
+	r := make([]string, 0, len(report.Entries))
+	for _, e := range report.Entries {
+		r = append(r, e.Name+" "+e.Source.String()+" "+e.Decision.String())
+	}
+	return r
+}
+
+func TestGoshExecutor_Explain(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下 ls 不可用")
+	}
+
+	noop := func(ctx context.Context, hc interp.HandlerContext, args []string) error { return nil }
+	executor := NewGoshExecutor(DefaultGoshConfig().
+		WithBlacklist(
+			NewCommandRule("rm", MatchExact).WithName("no-rm").WithReason("deleting is not allowed"),
+			NewCommandRule("curl", MatchExact).WithSourceFilter(SourceCmdSubst),
+		).
+		WithGoHandler("zenity*", noop).
+		WithGoHandler("zenity", noop))
+
+	marker := filepath.Join(t.TempDir(), "marker")
+	script := `
+cleanup() {
+	rm -rf /tmp/x
+}
+echo $(curl http://x) > ` + marker + `
+(ls -d /)
+for f in $(ls /); do zenity --info "$f"; done
+while false; do zenity-ext; done
+if true; then cleanup; fi
+X=$(date) $CMD arg
+`
+	report, err := executor.Explain(script)
+	a.NoError(err)
+	a.Equal(script, report.Command)
+
+	a.Equal([]string{
+		"rm direct blocked",
+		"echo direct builtin",
+		"curl command_substitution blocked",
+		"ls subshell exec",
+		"ls command_substitution exec",
+		"zenity direct go_handler",
+		"false direct builtin",
+		"zenity-ext direct go_handler",
+		"true direct builtin",
+		"cleanup direct function",
+		"$CMD direct exec",
+		"date command_substitution exec",
+	}, explainSummary(report))
+
+	rm := report.Entries[0]
+	a.Equal("no-rm", rm.Rule)
+	a.Equal("deleting is not allowed", rm.Reason)
+	a.Equal([]string{"-rf", "/tmp/x"}, rm.Args)
+	a.Equal(uint(3), rm.Position.Line())
+
+	curl := report.Entries[2]
+	a.Equal("curl", curl.Rule)
+	a.Equal("is blocked by blacklist", curl.Reason)
+
+	ls := report.Entries[3]
+	a.NotEmpty(ls.Path)
+
+	// 精确匹配优先于通配符
+	a.Equal("zenity", report.Entries[5].Rule)
+	a.Equal("zenity*", report.Entries[7].Rule)
+
+	dynamic := report.Entries[10]
+	a.True(dynamic.Dynamic)
+	a.Empty(dynamic.Path)
+	a.Equal([]string{"X=$(date)"}, dynamic.Envs)
+
+	a.False(report.Allowed())
+	a.Len(report.Blocked(), 2)
+
+	// 预演不执行任何命令
+	_, err = os.Stat(marker)
+	a.True(os.IsNotExist(err))
+}
+
+func TestGoshExecutor_Explain_whitelist(t *testing.T) {
+	a := require.New(t)
+
+	executor := NewGoshExecutor(DefaultGoshConfig().
+		WithWhitelistMode(true).
+		WithWhitelist(NewCommandRule("git", MatchExact).WithName("allow-git")))
+
+	report, err := executor.Explain("git status && make build && echo done")
+	a.NoError(err)
+	a.Equal([]string{
+		"git direct exec",
+		"make direct blocked",
+		"echo direct builtin",
+	}, explainSummary(report))
+
+	a.Equal("allow-git", report.Entries[0].Rule)
+	a.Equal("is not in whitelist", report.Entries[1].Reason)
+	a.Empty(report.Entries[1].Rule)
+}
+
+func TestGoshExecutor_Explain_declarations(t *testing.T) {
+	a := require.New(t)
+
+	executor := NewGoshExecutor(DefaultGoshConfig().
+		WithBlacklist(NewCommandRule("rm", MatchExact), NewCommandRule("curl", MatchExact)))
+
+	report, err := executor.Explain("export X=$(rm -rf /tmp/zz); f() { local y=$(curl evil); }; [[ $(id) ]]")
+	a.NoError(err)
+	a.Equal([]string{
+		"rm command_substitution blocked",
+		"curl command_substitution blocked",
+		"id command_substitution exec",
+	}, explainSummary(report))
+	a.False(report.Allowed())
+}
+
+func TestGoshExecutor_Explain_allowed(t *testing.T) {
+	a := require.New(t)
+
+	report, err := NewGoshExecutor(nil).Explain("nonexistent_command_xyz a b")
+	a.NoError(err)
+	a.True(report.Allowed())
+	a.Len(report.Entries, 1)
+	a.Equal(ExplainExec, report.Entries[0].Decision)
+	a.Empty(report.Entries[0].Path)
+	a.Equal("1:1\tdirect\texec\tnonexistent_command_xyz a b\tcommand not found", report.String())
+
+	report, err = NewGoshExecutor(nil).Explain("")
+	a.NoError(err)
+	a.Empty(report.Entries)
+
+	_, err = NewGoshExecutor(nil).Explain("echo $(")
+	a.Error(err)
+}
+
+func TestExplainEntry_String(t *testing.T) {
+	a := require.New(t)
+
+	report, err := NewGoshExecutor(DefaultGoshConfig().
+		WithBlacklist(NewCommandRule("rm", MatchExact).WithReason("no deleting")).
+		WithGoHandler("zenity", nil)).
+		Explain("rm -rf x; $X")
+	a.NoError(err)
+
+	a.Equal("1:1\tdirect\tblocked\trm -rf x\tno deleting (rule: rm)", report.Entries[0].String())
+	a.Equal("1:11\tdirect\texec\t$X\t(dynamic)", report.Entries[1].String())
+}
+
+func TestExplainDecision_String(t *testing.T) {
+	a := require.New(t)
+
+	a.Equal("exec", ExplainExec.String())
+	a.Equal("go_handler", ExplainGoHandler.String())
+	a.Equal("builtin", ExplainBuiltin.String())
+	a.Equal("function", ExplainFunction.String())
+	a.Equal("blocked", ExplainBlocked.String())
+	a.Equal("unknown", ExplainDecision(0).String())
+
+	a.True(ExplainExec.Allowed())
+	a.False(ExplainBlocked.Allowed())
+}
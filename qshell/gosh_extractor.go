@@ -42,6 +42,21 @@ func (s CommandSource) String() string {
 	}
 }
 
+// ParseCommandSource 解析命令来源，与 String 互逆
+func ParseCommandSource(s string) (CommandSource, error) {
+	switch s {
+	case "direct":
+		return SourceDirect, nil
+	case "command_substitution":
+		return SourceCmdSubst, nil
+	case "process_substitution":
+		return SourceProcSubst, nil
+	case "subshell":
+		return SourceSubshell, nil
+	}
+	return SourceDirect, errors.Errorf("unknown command source '%s', expected one of: direct, command_substitution, process_substitution, subshell", s)
+}
+
 // ============================================================
 // ExtractedCommand 提取的命令信息
 // ============================================================
@@ -122,24 +137,57 @@ func (me CommandExtractor) extractFromStmt(stmt *syntax.Stmt, source CommandSour
 	case *syntax.Subshell:
 		me.extractFromSubshell(cmd, commands)
 	case *syntax.IfClause:
-		me.extractFromIfClause(cmd, commands)
+		me.extractFromIfClause(cmd, source, commands)
 	case *syntax.WhileClause:
-		me.extractFromWhileClause(cmd, commands)
+		me.extractFromWhileClause(cmd, source, commands)
 	case *syntax.ForClause:
-		me.extractFromForClause(cmd, commands)
+		me.extractFromForClause(cmd, source, commands)
 	case *syntax.CaseClause:
-		me.extractFromCaseClause(cmd, commands)
+		me.extractFromCaseClause(cmd, source, commands)
 	case *syntax.Block:
 		me.extractFromBlock(cmd, source, commands)
 	case *syntax.FuncDecl:
 		// 函数声明中的命令
 		me.extractFromStmt(cmd.Body, source, commands)
+	case *syntax.DeclClause:
+		// 声明中的命令替换: export X=$(cmd)、local y=$(cmd)
+		for _, assign := range cmd.Args {
+			me.extractNodeSubcommands(assign, commands)
+		}
+	case *syntax.TestClause:
+		// [[ $(cmd) ]]
+		me.extractNodeSubcommands(cmd.X, commands)
+	case *syntax.ArithmCmd:
+		// (( $(cmd) ))
+		me.extractNodeSubcommands(cmd.X, commands)
+	case *syntax.LetClause:
+		// let x=$(cmd)
+		for _, expr := range cmd.Exprs {
+			me.extractNodeSubcommands(expr, commands)
+		}
+	case *syntax.TimeClause:
+		// time cmd
+		if cmd.Stmt != nil {
+			me.extractFromStmt(cmd.Stmt, source, commands)
+		}
+	case *syntax.CoprocClause:
+		// coproc cmd
+		me.extractFromStmt(cmd.Stmt, source, commands)
+	}
+
+	// 重定向目标中的命令替换: cmd > $(other)
+	for _, redir := range stmt.Redirs {
+		me.extractWordSubcommands(redir.Word, commands)
 	}
 }
 
 // extractFromCallExpr 从 CallExpr 节点提取命令（直接命令调用）
 func (me CommandExtractor) extractFromCallExpr(call *syntax.CallExpr, source CommandSource, commands *[]ExtractedCommand) {
 	if len(call.Args) == 0 {
+		// 仅有赋值的语句: X=$(cmd)、X=($(cmd))
+		for _, assign := range call.Assigns {
+			me.extractNodeSubcommands(assign, commands)
+		}
 		return
 	}
 
@@ -177,7 +225,10 @@ func (me CommandExtractor) extractFromCallExpr(call *syntax.CallExpr, source Com
 		Envs:     envs,
 	})
 
-	// 检查参数中是否有命令替换
+	// 检查内联环境变量和参数中是否有命令替换
+	for _, assign := range call.Assigns {
+		me.extractNodeSubcommands(assign, commands)
+	}
 	for _, arg := range args {
 		me.extractWordSubcommands(arg, commands)
 	}
@@ -199,46 +250,50 @@ func (me CommandExtractor) extractFromSubshell(subshell *syntax.Subshell, comman
 }
 
 // extractFromIfClause 从 IfClause 节点提取命令
-func (me CommandExtractor) extractFromIfClause(ifClause *syntax.IfClause, commands *[]ExtractedCommand) {
+func (me CommandExtractor) extractFromIfClause(ifClause *syntax.IfClause, source CommandSource, commands *[]ExtractedCommand) {
 	// 条件部分
 	for _, stmt := range ifClause.Cond {
-		me.extractFromStmt(stmt, SourceDirect, commands)
+		me.extractFromStmt(stmt, source, commands)
 	}
 	// then 部分
 	for _, stmt := range ifClause.Then {
-		me.extractFromStmt(stmt, SourceDirect, commands)
+		me.extractFromStmt(stmt, source, commands)
 	}
 	// else 部分 (Else 是 *IfClause 类型，可以是 elif 或 else)
 	if ifClause.Else != nil {
-		me.extractFromIfClause(ifClause.Else, commands)
+		me.extractFromIfClause(ifClause.Else, source, commands)
 	}
 }
 
 // extractFromWhileClause 从 WhileClause 节点提取命令
-func (me CommandExtractor) extractFromWhileClause(whileClause *syntax.WhileClause, commands *[]ExtractedCommand) {
+func (me CommandExtractor) extractFromWhileClause(whileClause *syntax.WhileClause, source CommandSource, commands *[]ExtractedCommand) {
 	// 条件部分
 	for _, stmt := range whileClause.Cond {
-		me.extractFromStmt(stmt, SourceDirect, commands)
+		me.extractFromStmt(stmt, source, commands)
 	}
 	// 循环体
 	for _, stmt := range whileClause.Do {
-		me.extractFromStmt(stmt, SourceDirect, commands)
+		me.extractFromStmt(stmt, source, commands)
 	}
 }
 
 // extractFromForClause 从 ForClause 节点提取命令
-func (me CommandExtractor) extractFromForClause(forClause *syntax.ForClause, commands *[]ExtractedCommand) {
+func (me CommandExtractor) extractFromForClause(forClause *syntax.ForClause, source CommandSource, commands *[]ExtractedCommand) {
+	// 迭代列表或 C 风格循环中的命令替换: for f in $(ls)、for ((i = $(cmd); ...))
+	me.extractNodeSubcommands(forClause.Loop, commands)
 	// 循环体
 	for _, stmt := range forClause.Do {
-		me.extractFromStmt(stmt, SourceDirect, commands)
+		me.extractFromStmt(stmt, source, commands)
 	}
 }
 
 // extractFromCaseClause 从 CaseClause 节点提取命令
-func (me CommandExtractor) extractFromCaseClause(caseClause *syntax.CaseClause, commands *[]ExtractedCommand) {
+func (me CommandExtractor) extractFromCaseClause(caseClause *syntax.CaseClause, source CommandSource, commands *[]ExtractedCommand) {
+	// case 的判断词中的命令替换: case $(uname) in
+	me.extractWordSubcommands(caseClause.Word, commands)
 	for _, item := range caseClause.Items {
 		for _, stmt := range item.Stmts {
-			me.extractFromStmt(stmt, SourceDirect, commands)
+			me.extractFromStmt(stmt, source, commands)
 		}
 	}
 }
@@ -255,36 +310,33 @@ func (me CommandExtractor) extractWordSubcommands(word *syntax.Word, commands *[
 	if word == nil {
 		return
 	}
+	me.extractNodeSubcommands(word, commands)
+}
+
+// extractNodeSubcommands 从词、赋值、测试表达式、算术表达式等节点中提取命令替换和进程替换，
+// 包括嵌套在双引号、参数展开、数组和算术运算中的
+func (me CommandExtractor) extractNodeSubcommands(node syntax.Node, commands *[]ExtractedCommand) {
+	if node == nil {
+		return
+	}
 
-	for _, part := range word.Parts {
-		switch p := part.(type) {
+	syntax.Walk(node, func(n syntax.Node) bool {
+		switch p := n.(type) {
 		case *syntax.CmdSubst:
 			// 命令替换 $(cmd) 或 `cmd`
 			for _, stmt := range p.Stmts {
 				me.extractFromStmt(stmt, SourceCmdSubst, commands)
 			}
-		case *syntax.DblQuoted:
-			// 双引号中可能有命令替换
-			for _, qp := range p.Parts {
-				if cs, ok := qp.(*syntax.CmdSubst); ok {
-					for _, stmt := range cs.Stmts {
-						me.extractFromStmt(stmt, SourceCmdSubst, commands)
-					}
-				}
-			}
-		case *syntax.ParamExp:
-			// 参数展开中可能有命令替换 ${...}
-			// Exp.Word 可能包含命令替换
-			if p.Exp != nil && p.Exp.Word != nil {
-				me.extractWordSubcommands(p.Exp.Word, commands)
-			}
+			return false
 		case *syntax.ProcSubst:
 			// 进程替换 <(cmd) 或 >(cmd)
 			for _, stmt := range p.Stmts {
 				me.extractFromStmt(stmt, SourceProcSubst, commands)
 			}
+			return false
 		}
-	}
+		return true
+	})
 }
 
 // wordToString 将 Word 转换为字符串（保留变量、引号等）
@@ -186,6 +186,32 @@ func TestExtractCommands_NestedCommandSubstitution(t *testing.T) {
 	a.Equal([]string{"-rf", "/"}, cmds[2].Args)
 }
 
+func TestExtractCommands_LoopsAndAssignments(t *testing.T) {
+	a := require.New(t)
+
+	extractor := NewCommandExtractor()
+
+	// 循环列表、纯赋值、重定向和 case 判断词中的命令替换，以及命令替换中的循环体
+	cmds, err := extractor.Extract(`for f in $(ls); do cat "$f"; done
+X=$(whoami)
+echo hi > $(mktemp)
+case $(uname) in *) true;; esac
+echo $(while read l; do wc -l; done)`)
+	a.NoError(err)
+
+	names := []string{}
+	sources := []CommandSource{}
+	for _, cmd := range cmds {
+		names = append(names, cmd.Name)
+		sources = append(sources, cmd.Source)
+	}
+	a.Equal([]string{"ls", "cat", "whoami", "echo", "mktemp", "uname", "true", "echo", "read", "wc"}, names)
+	a.Equal([]CommandSource{
+		SourceCmdSubst, SourceDirect, SourceCmdSubst, SourceDirect, SourceCmdSubst,
+		SourceCmdSubst, SourceDirect, SourceDirect, SourceCmdSubst, SourceCmdSubst,
+	}, sources)
+}
+
 func TestExtractCommands_EnvironmentVariables(t *testing.T) {
 	a := require.New(t)
 
@@ -249,3 +275,33 @@ func TestExtractCommands_ComplexPipeline(t *testing.T) {
 	a.Equal("grep", cmds[1].Name)
 	a.Equal("wc", cmds[2].Name)
 }
+
+func TestExtractCommands_DeclarationsAndExpressions(t *testing.T) {
+	a := require.New(t)
+
+	extractor := NewCommandExtractor()
+
+	// 声明、测试、算术、let、time 和 coproc 中的命令替换和进程替换
+	cmds, err := extractor.Extract(`export X=$(rm -rf /tmp/zz)
+f() { local y=$(curl evil); }
+[[ $(id) == root && -f <(cat /etc/passwd) ]]
+(( $(nproc) > 1 ))
+let "n = $(wc -l)"
+time sleep 1
+coproc tail -f /dev/null
+declare -a arr=($(ls) "$(pwd)")`)
+	a.NoError(err)
+
+	names := []string{}
+	sources := []CommandSource{}
+	for _, cmd := range cmds {
+		names = append(names, cmd.Name)
+		sources = append(sources, cmd.Source)
+	}
+	a.Equal([]string{"rm", "curl", "id", "cat", "nproc", "wc", "sleep", "tail", "ls", "pwd"}, names)
+	a.Equal([]CommandSource{
+		SourceCmdSubst, SourceCmdSubst, SourceCmdSubst, SourceProcSubst, SourceCmdSubst,
+		SourceCmdSubst, SourceDirect, SourceDirect, SourceCmdSubst, SourceCmdSubst,
+	}, sources)
+	a.Equal([]string{"-rf", "/tmp/zz"}, cmds[0].Args)
+}
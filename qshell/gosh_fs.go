@@ -0,0 +1,191 @@
+package qshell
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/qiangyt/go-comm/v3/qio"
+	"github.com/spf13/afero"
+	"mvdan.cc/sh/v3/interp"
+)
+
+// ============================================================
+// 文件系统沙箱
+// ============================================================
+//
+// GoshExecutor 通过解释器的 open / stat / readdir / call 处理器限制脚本本身的文件访问：
+//   - 重定向（> >> < <>）、source、$(<file) 等按打开方式检查读写权限
+//   - test/[ 等内置命令的 stat 和通配符展开的 readdir 按读权限检查
+//   - cd / pushd 的目标目录按读权限检查
+//
+// 外部命令在独立进程中运行，不受这里的目录限制，需要配合黑名单/白名单控制。
+//
+// 配置 GoshConfig.Fs 后，上述文件操作都转发给 afero.Fs，脚本可以完全在内存中运行。
+// 注意解释器的 cd 还会在真实文件系统上检查目录的执行权限，因此内存文件系统中只能 cd 到真实存在的目录。
+
+// ErrPathNotAllowed 路径不在允许的目录内
+var ErrPathNotAllowed = errors.New("path is not allowed")
+
+// readAllowed 是否允许读取路径：未配置 AllowedReadDirs 时不限制，允许写的目录也允许读
+func (me GoshConfig) readAllowed(path string) bool {
+	if len(me.AllowedReadDirs) == 0 {
+		return true
+	}
+	return qio.IsPathAllowed(path, me.AllowedReadDirs) ||
+		qio.IsPathAllowed(path, me.AllowedWriteDirs)
+}
+
+// writeAllowed 是否允许写入路径：未配置 AllowedWriteDirs 时不限制
+func (me GoshConfig) writeAllowed(path string) bool {
+	if len(me.AllowedWriteDirs) == 0 {
+		return true
+	}
+	return qio.IsPathAllowed(path, me.AllowedWriteDirs)
+}
+
+// fileSystem 返回脚本文件 I/O 使用的文件系统，未配置 Fs 时为真实文件系统
+func (me GoshConfig) fileSystem() afero.Fs {
+	if me.Fs == nil {
+		return afero.NewOsFs()
+	}
+	return me.Fs
+}
+
+// createOpenHandler 创建文件打开处理器
+// 目录限制被拒绝时返回致命错误终止脚本，与黑名单拒绝命令的行为一致
+func (me GoshExecutor) createOpenHandler() interp.OpenHandlerFunc {
+	return func(ctx context.Context, path string, flag int, perm os.FileMode) (io.ReadWriteCloser, error) {
+		if path == "/dev/null" {
+			return qio.DevNull{}, nil
+		}
+		path = goshAbsPath(interp.HandlerCtx(ctx).Dir, path)
+
+		if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+			if !me.config.writeAllowed(path) {
+				return nil, errors.Wrapf(ErrPathNotAllowed, "open '%s' for writing", path)
+			}
+		} else if !me.config.readAllowed(path) {
+			return nil, errors.Wrapf(ErrPathNotAllowed, "open '%s' for reading", path)
+		}
+
+		if me.config.Fs == nil {
+			return os.OpenFile(path, flag, perm)
+		}
+		return me.config.Fs.OpenFile(path, flag, perm)
+	}
+}
+
+// createStatHandler 创建 stat 处理器，被拒绝的路径表现为无权限
+func (me GoshExecutor) createStatHandler() interp.StatHandlerFunc {
+	return func(ctx context.Context, path string, followSymlinks bool) (fs.FileInfo, error) {
+		if !me.config.readAllowed(path) {
+			return nil, &os.PathError{Op: "stat", Path: path, Err: ErrPathNotAllowed}
+		}
+
+		afs := me.config.Fs
+		if afs == nil {
+			return interp.DefaultStatHandler()(ctx, path, followSymlinks)
+		}
+		if lstater, ok := afs.(afero.Lstater); ok && !followSymlinks {
+			info, _, err := lstater.LstatIfPossible(path)
+			return info, err
+		}
+		return afs.Stat(path)
+	}
+}
+
+// createReadDirHandler 创建通配符展开使用的 readdir 处理器
+func (me GoshExecutor) createReadDirHandler() interp.ReadDirHandlerFunc2 {
+	return func(ctx context.Context, path string) ([]fs.DirEntry, error) {
+		if !me.config.readAllowed(path) {
+			return nil, &os.PathError{Op: "readdir", Path: path, Err: ErrPathNotAllowed}
+		}
+
+		if me.config.Fs == nil {
+			return interp.DefaultReadDirHandler2()(ctx, path)
+		}
+		infos, err := afero.ReadDir(me.config.Fs, path)
+		if err != nil {
+			return nil, err
+		}
+		r := make([]fs.DirEntry, 0, len(infos))
+		for _, info := range infos {
+			r = append(r, fs.FileInfoToDirEntry(info))
+		}
+		return r, nil
+	}
+}
+
+// createCallHandler 创建调用处理器，在 cd / pushd 执行前检查目标目录
+func (me GoshExecutor) createCallHandler() interp.CallHandlerFunc {
+	return func(ctx context.Context, args []string) ([]string, error) {
+		hc := interp.HandlerCtx(ctx)
+		target := goshDirTarget(hc, args)
+		if len(target) == 0 {
+			return args, nil
+		}
+
+		target = goshAbsPath(hc.Dir, target)
+		if !me.config.readAllowed(target) {
+			return nil, errors.Wrapf(ErrPathNotAllowed, "%s '%s'", args[0], target)
+		}
+		return args, nil
+	}
+}
+
+// goshDirTarget 返回 cd / pushd 将要进入的目录，不是切换目录的调用时返回空
+func goshDirTarget(hc interp.HandlerContext, args []string) string {
+	switch args[0] {
+	case "cd":
+		switch len(args) {
+		case 1:
+			return hc.Env.Get("HOME").String()
+		case 2:
+			if args[1] == "-" {
+				return hc.Env.Get("OLDPWD").String()
+			}
+			return args[1]
+		}
+	case "pushd":
+		// 无参数或 +N/-N 只是在已进入过的目录间轮换，-n 等选项跳过
+		for _, arg := range args[1:] {
+			if strings.HasPrefix(arg, "+") || strings.HasPrefix(arg, "-") {
+				continue
+			}
+			return arg
+		}
+	}
+	return ""
+}
+
+// goshAbsPath 将相对路径解析为相对于解释器当前目录的绝对路径
+func goshAbsPath(dir string, path string) string {
+	if len(path) == 0 || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// goshFsDir 在 afero.Fs 中校验并设置初始工作目录，替代只检查真实文件系统的 interp.Dir
+func goshFsDir(afs afero.Fs, dir string) interp.RunnerOption {
+	return func(r *interp.Runner) error {
+		path, err := filepath.Abs(dir)
+		if err != nil {
+			return errors.Wrapf(err, "get absolute dir: %s", dir)
+		}
+		info, err := afs.Stat(path)
+		if err != nil {
+			return errors.Wrapf(err, "stat dir: %s", path)
+		}
+		if !info.IsDir() {
+			return errors.Errorf("%s is not a directory", path)
+		}
+		r.Dir = path
+		return nil
+	}
+}
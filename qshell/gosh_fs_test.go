@@ -0,0 +1,150 @@
+package qshell
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/qiangyt/go-comm/v3/qio"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGoshExecutor_fs(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下路径格式不同")
+	}
+
+	fs := afero.NewMemMapFs()
+	a.NoError(fs.MkdirAll("/work/sub", 0o755))
+	qio.WriteFileTextP(fs, "/work/in.txt", "from memory\n")
+
+	executor := NewGoshExecutor(DefaultGoshConfig().WithFs(fs))
+
+	script := `
+echo hello > /work/a.txt
+echo world >> a.txt
+read line < in.txt
+echo "$line" > out.txt
+echo $(< /work/a.txt)
+for f in /work/*.txt; do echo "$f"; done
+[ -d /work/sub ] && echo dir
+[ -f /work/missing ] || echo missing
+`
+	var stdout, stderr bytes.Buffer
+	a.NoError(executor.Run(context.Background(), "/work", script, nil, &stdout, &stderr))
+	a.Equal("hello world\n/work/a.txt\n/work/in.txt\n/work/out.txt\ndir\nmissing\n", stdout.String())
+
+	a.Equal("hello\nworld\n", qio.ReadFileTextP(fs, "/work/a.txt"))
+	a.Equal("from memory\n", qio.ReadFileTextP(fs, "/work/out.txt"))
+
+	// 真实文件系统不受影响
+	_, err := os.Stat("/work/a.txt")
+	a.True(os.IsNotExist(err))
+
+	// 初始目录在 Fs 中校验
+	err = executor.Run(context.Background(), "/nonexistent", "true", nil, &stdout, &stderr)
+	a.Error(err)
+	qio.WriteFileTextP(fs, "/file", "")
+	err = executor.Run(context.Background(), "/file", "true", nil, &stdout, &stderr)
+	a.Error(err)
+	a.Contains(err.Error(), "is not a directory")
+}
+
+func TestGoshExecutor_allowedWriteDirs(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下路径格式不同")
+	}
+
+	allowed := t.TempDir()
+	other := t.TempDir()
+	a.NoError(os.WriteFile(filepath.Join(other, "in.txt"), []byte("x\n"), 0o644))
+
+	executor := NewGoshExecutor(DefaultGoshConfig().WithAllowedWriteDirs(allowed))
+
+	var stdout, stderr bytes.Buffer
+	a.NoError(executor.Run(context.Background(), allowed, "echo ok > out.txt; read x < "+other+"/in.txt; echo $x", nil, &stdout, &stderr))
+	a.Equal("x\n", stdout.String())
+	a.FileExists(filepath.Join(allowed, "out.txt"))
+
+	target := filepath.Join(other, "out.txt")
+	for _, script := range []string{
+		"echo x > " + target,
+		"echo x >> " + target,
+		"echo x 2> " + target,
+		"cd " + other + " && echo x > out.txt",
+		"echo x > ../" + filepath.Base(other) + "/out.txt",
+	} {
+		err := executor.Run(context.Background(), allowed, script, nil, &stdout, &stderr)
+		a.Error(err, script)
+		a.True(errors.Is(err, ErrPathNotAllowed), script)
+		a.Contains(err.Error(), "open '"+target+"' for writing", script)
+	}
+	a.NoFileExists(target)
+
+	// /dev/null 总是允许
+	a.NoError(executor.Run(context.Background(), allowed, "echo x > /dev/null", nil, &stdout, &stderr))
+}
+
+func TestGoshExecutor_allowedReadDirs(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下路径格式不同")
+	}
+
+	readable := t.TempDir()
+	writable := t.TempDir()
+	other := t.TempDir()
+	a.NoError(os.WriteFile(filepath.Join(readable, "in.txt"), []byte("r\n"), 0o644))
+	a.NoError(os.WriteFile(filepath.Join(other, "secret.txt"), []byte("s\n"), 0o644))
+
+	executor := NewGoshExecutor(DefaultGoshConfig().
+		WithAllowedReadDirs(readable).
+		WithAllowedWriteDirs(writable))
+
+	var stdout, stderr bytes.Buffer
+	script := "read x < in.txt; echo $x > " + writable + "/copy.txt; cd " + writable + " && read y < copy.txt; echo $y"
+	a.NoError(executor.Run(context.Background(), readable, script, nil, &stdout, &stderr))
+	a.Equal("r\n", stdout.String())
+
+	secret := filepath.Join(other, "secret.txt")
+	err := executor.Run(context.Background(), readable, "read x < "+secret, nil, &stdout, &stderr)
+	a.True(errors.Is(err, ErrPathNotAllowed))
+	a.Contains(err.Error(), "open '"+secret+"' for reading")
+
+	// source 把打开失败作为退出码 1 处理
+	stderr.Reset()
+	a.Error(executor.Run(context.Background(), readable, ". "+secret, nil, &stdout, &stderr))
+	a.Contains(stderr.String(), "open '"+secret+"' for reading: path is not allowed")
+
+	err = executor.Run(context.Background(), readable, "cd "+other, nil, &stdout, &stderr)
+	a.True(errors.Is(err, ErrPathNotAllowed))
+	a.Contains(err.Error(), "cd '"+other+"'")
+
+	err = executor.Run(context.Background(), readable, "pushd ../"+filepath.Base(other), nil, &stdout, &stderr)
+	a.True(errors.Is(err, ErrPathNotAllowed))
+	a.Contains(err.Error(), "pushd '"+other+"'")
+
+	// stat 被拒绝时文件表现为不存在
+	stdout.Reset()
+	a.NoError(executor.Run(context.Background(), readable, "[ -f "+secret+" ] || echo hidden", nil, &stdout, &stderr))
+	a.Equal("hidden\n", stdout.String())
+}
+
+func TestGoshAbsPath(t *testing.T) {
+	a := require.New(t)
+
+	a.Equal("", goshAbsPath("/work", ""))
+	a.Equal("/abs", goshAbsPath("/work", "/abs"))
+	a.Equal("/work/rel", goshAbsPath("/work", "rel"))
+	a.Equal("/other", goshAbsPath("/work", "../other"))
+}
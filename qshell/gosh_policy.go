@@ -0,0 +1,475 @@
+package qshell
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/qiangyt/go-comm/v3/qconfig"
+	"github.com/qiangyt/go-comm/v3/qerr"
+	"github.com/qiangyt/go-comm/v3/qio"
+	"github.com/qiangyt/go-comm/v3/qjson"
+	"github.com/qiangyt/go-comm/v3/qlang"
+	"github.com/spf13/afero"
+)
+
+// ============================================================
+// 策略文件格式
+// ============================================================
+//
+// GoshConfig 的安全规则可以用 YAML 或 JSON 文件描述（.json 后缀按 JSON 解析，其它按 YAML 解析）：
+//
+//	kill_timeout: 10s
+//	whitelist_mode: false
+//	blacklist:
+//	  - name: no-recursive-rm
+//	    pattern: rm
+//	    match: exact            # glob（默认）/ exact / regex
+//	    args:
+//	      - pattern: "-*r*"     # position 省略表示任意位置
+//	    sources: [direct]       # direct / command_substitution / process_substitution / subshell
+//	    reason: recursive delete is not allowed
+//	audit_redactions:
+//	  - "--password=(.*)"
+//	allowed_read_dirs: [/data]
+//	allowed_write_dirs: [/tmp/work]
+//
+// GoHandlers、AuditSink 和 Fs 无法序列化，加载后需要在代码中通过 WithGoHandler、WithAuditSink、WithFs 设置。
+
+// GoshPolicyArg 策略文件中的参数匹配器
+type GoshPolicyArg struct {
+	// Position 参数位置，省略表示任意位置
+	Position *int `mapstructure:"position" yaml:"position,omitempty" json:"position,omitempty"`
+	// Pattern 匹配模式
+	Pattern string `mapstructure:"pattern" yaml:"pattern" json:"pattern"`
+	// Match 匹配类型：glob（默认）、exact、regex
+	Match string `mapstructure:"match" yaml:"match,omitempty" json:"match,omitempty"`
+	// Required 是否必需
+	Required bool `mapstructure:"required" yaml:"required,omitempty" json:"required,omitempty"`
+}
+
+// GoshPolicyRuleT 策略文件中的命令规则
+type GoshPolicyRuleT struct {
+	// Name 规则名称
+	Name string `mapstructure:"name" yaml:"name,omitempty" json:"name,omitempty"`
+	// Pattern 命令模式
+	Pattern string `mapstructure:"pattern" yaml:"pattern" json:"pattern"`
+	// Match 匹配类型：glob（默认）、exact、regex
+	Match string `mapstructure:"match" yaml:"match,omitempty" json:"match,omitempty"`
+	// Args 参数匹配器
+	Args []GoshPolicyArg `mapstructure:"args" yaml:"args,omitempty" json:"args,omitempty"`
+	// Sources 命令来源过滤，空表示所有来源
+	Sources []string `mapstructure:"sources" yaml:"sources,omitempty" json:"sources,omitempty"`
+	// Reason 规则原因
+	Reason string `mapstructure:"reason" yaml:"reason,omitempty" json:"reason,omitempty"`
+}
+
+// GoshPolicyRule 是 GoshPolicyRuleT 的指针别名
+type GoshPolicyRule = *GoshPolicyRuleT
+
+// GoshPolicyT 策略文件内容
+type GoshPolicyT struct {
+	// KillTimeout 命令超时时间，如 "6s"，省略时使用默认值
+	KillTimeout string `mapstructure:"kill_timeout" yaml:"kill_timeout,omitempty" json:"kill_timeout,omitempty"`
+	// WhitelistMode 是否启用白名单模式
+	WhitelistMode bool `mapstructure:"whitelist_mode" yaml:"whitelist_mode" json:"whitelist_mode"`
+	// Blacklist 黑名单规则
+	Blacklist []GoshPolicyRule `mapstructure:"blacklist" yaml:"blacklist,omitempty" json:"blacklist,omitempty"`
+	// Whitelist 白名单规则
+	Whitelist []GoshPolicyRule `mapstructure:"whitelist" yaml:"whitelist,omitempty" json:"whitelist,omitempty"`
+	// AuditRedactions 审计时需要脱敏的参数正则
+	AuditRedactions []string `mapstructure:"audit_redactions" yaml:"audit_redactions,omitempty" json:"audit_redactions,omitempty"`
+	// AllowedReadDirs 允许读取的目录，省略表示不限制
+	AllowedReadDirs []string `mapstructure:"allowed_read_dirs" yaml:"allowed_read_dirs,omitempty" json:"allowed_read_dirs,omitempty"`
+	// AllowedWriteDirs 允许写入的目录，省略表示不限制
+	AllowedWriteDirs []string `mapstructure:"allowed_write_dirs" yaml:"allowed_write_dirs,omitempty" json:"allowed_write_dirs,omitempty"`
+}
+
+// GoshPolicy 是 GoshPolicyT 的指针别名
+type GoshPolicy = *GoshPolicyT
+
+// ============================================================
+// GoshPolicyError 策略校验错误
+// ============================================================
+
+// GoshPolicyIssue 策略中的一处错误
+type GoshPolicyIssue struct {
+	// Field 出错的字段路径，如 "blacklist[2].args[0].match"
+	Field string
+	// Rule 出错规则的名称（规则未命名时为空）
+	Rule string
+	// Message 错误描述
+	Message string
+}
+
+// String 返回错误描述
+func (me GoshPolicyIssue) String() string {
+	if len(me.Rule) > 0 {
+		return fmt.Sprintf("%s (rule '%s'): %s", me.Field, me.Rule, me.Message)
+	}
+	return fmt.Sprintf("%s: %s", me.Field, me.Message)
+}
+
+// GoshPolicyErrorT 策略校验错误，包含所有出错的规则
+type GoshPolicyErrorT struct {
+	// File 策略文件路径（从内存构造时为空）
+	File string
+	// Issues 所有错误
+	Issues []GoshPolicyIssue
+}
+
+// GoshPolicyError 是 GoshPolicyErrorT 的指针别名
+type GoshPolicyError = *GoshPolicyErrorT
+
+// Error 实现 error 接口
+func (me GoshPolicyError) Error() string {
+	prefix := "invalid gosh policy"
+	if len(me.File) > 0 {
+		prefix += " " + me.File
+	}
+
+	if len(me.Issues) == 1 {
+		return prefix + ": " + me.Issues[0].String()
+	}
+
+	lines := make([]string, 0, len(me.Issues)+1)
+	lines = append(lines, fmt.Sprintf("%s: %d issues", prefix, len(me.Issues)))
+	for _, issue := range me.Issues {
+		lines = append(lines, "  - "+issue.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// GetGoshPolicyError 获取策略校验错误详情
+func GetGoshPolicyError(err error) GoshPolicyError {
+	var r GoshPolicyError
+	if errors.As(err, &r) {
+		return r
+	}
+	return nil
+}
+
+// ============================================================
+// 加载
+// ============================================================
+
+// LoadGoshPolicyP 从策略文件加载 GoshConfig（失败时 panic）
+func LoadGoshPolicyP(fs afero.Fs, path string) GoshConfig {
+	r, err := LoadGoshPolicy(fs, path)
+	if err != nil {
+		panic(qerr.NewSystemError(err.Error(), err))
+	}
+	return r
+}
+
+// LoadGoshPolicy 从策略文件加载 GoshConfig
+// 校验失败时返回 GoshPolicyError，指出每条出错规则的位置
+func LoadGoshPolicy(fs afero.Fs, path string) (GoshConfig, error) {
+	policy, err := ReadGoshPolicy(fs, path)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := policy.ToConfig()
+	if err != nil {
+		if policyErr := GetGoshPolicyError(err); policyErr != nil {
+			policyErr.File = path
+		}
+		return nil, err
+	}
+	return r, nil
+}
+
+// ReadGoshPolicy 读取策略文件，不做语义校验
+func ReadGoshPolicy(fs afero.Fs, path string) (GoshPolicy, error) {
+	var input map[string]any
+	var err error
+	if isJsonPolicyFile(path) {
+		input, err = qio.MapFromJsonFile(fs, path, false)
+	} else {
+		input, err = qio.MapFromYamlFile(fs, path, false)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "read gosh policy: %s", path)
+	}
+
+	r, err := GoshPolicyWithMap(input)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read gosh policy: %s", path)
+	}
+	return r, nil
+}
+
+// GoshPolicyWithMap 从 map 解码策略，不允许未知字段
+func GoshPolicyWithMap(input map[string]any) (GoshPolicy, error) {
+	r, _, err := qconfig.DecodeWithMap(input, &qconfig.ConfigConfig{
+		ErrorUnused:          true,
+		ErrorUnset:           false,
+		ZeroFields:           false,
+		WeaklyTypedInput:     true,
+		Squash:               true,
+		IgnoreUntaggedFields: true,
+	}, &GoshPolicyT{}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ToConfig 校验策略并转换为 GoshConfig
+func (me GoshPolicy) ToConfig() (GoshConfig, error) {
+	r := DefaultGoshConfig()
+	var issues []GoshPolicyIssue
+
+	if len(me.KillTimeout) > 0 {
+		d, err := time.ParseDuration(me.KillTimeout)
+		if err != nil {
+			issues = append(issues, GoshPolicyIssue{Field: "kill_timeout", Message: err.Error()})
+		} else {
+			r.KillTimeout = d
+		}
+	}
+	r.WhitelistMode = me.WhitelistMode
+
+	var listIssues []GoshPolicyIssue
+	r.Blacklist, listIssues = policyRulesToConfig("blacklist", me.Blacklist)
+	issues = append(issues, listIssues...)
+
+	r.Whitelist, listIssues = policyRulesToConfig("whitelist", me.Whitelist)
+	issues = append(issues, listIssues...)
+
+	for i, pattern := range me.AuditRedactions {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			issues = append(issues, GoshPolicyIssue{
+				Field:   fmt.Sprintf("audit_redactions[%d]", i),
+				Message: fmt.Sprintf("invalid regex '%s': %v", pattern, err),
+			})
+			continue
+		}
+		r.AuditRedactions = append(r.AuditRedactions, re)
+	}
+
+	var dirIssues []GoshPolicyIssue
+	r.AllowedReadDirs, dirIssues = policyDirsToConfig("allowed_read_dirs", me.AllowedReadDirs)
+	issues = append(issues, dirIssues...)
+
+	r.AllowedWriteDirs, dirIssues = policyDirsToConfig("allowed_write_dirs", me.AllowedWriteDirs)
+	issues = append(issues, dirIssues...)
+
+	if len(issues) > 0 {
+		return nil, &GoshPolicyErrorT{Issues: issues}
+	}
+	return r, nil
+}
+
+// policyDirsToConfig 校验目录列表，目录必须是非空路径
+func policyDirsToConfig(list string, dirs []string) ([]string, []GoshPolicyIssue) {
+	var r []string
+	var issues []GoshPolicyIssue
+	for i, dir := range dirs {
+		if len(strings.TrimSpace(dir)) == 0 {
+			issues = append(issues, GoshPolicyIssue{
+				Field:   fmt.Sprintf("%s[%d]", list, i),
+				Message: "must not be empty",
+			})
+			continue
+		}
+		r = append(r, dir)
+	}
+	return r, issues
+}
+
+// policyRulesToConfig 校验并转换一个规则列表
+func policyRulesToConfig(list string, rules []GoshPolicyRule) ([]CommandRule, []GoshPolicyIssue) {
+	r := make([]CommandRule, 0, len(rules))
+	var issues []GoshPolicyIssue
+	names := map[string]bool{}
+
+	for i, rule := range rules {
+		field := fmt.Sprintf("%s[%d]", list, i)
+		if rule == nil {
+			issues = append(issues, GoshPolicyIssue{Field: field, Message: "rule must not be empty"})
+			continue
+		}
+
+		issue := func(subField string, format string, args ...any) {
+			issues = append(issues, GoshPolicyIssue{
+				Field:   field + "." + subField,
+				Rule:    rule.Name,
+				Message: fmt.Sprintf(format, args...),
+			})
+		}
+
+		if len(rule.Name) > 0 {
+			if names[rule.Name] {
+				issue("name", "duplicate rule name '%s'", rule.Name)
+			}
+			names[rule.Name] = true
+		}
+
+		mode, err := ParseMatchMode(rule.Match)
+		if err != nil {
+			issue("match", "%v", err)
+		} else if msg := validatePolicyPattern(mode, rule.Pattern); len(msg) > 0 {
+			issue("pattern", "%s", msg)
+		}
+
+		cmdRule := NewCommandRule(rule.Pattern, mode).WithName(rule.Name).WithReason(rule.Reason)
+
+		for j, arg := range rule.Args {
+			argField := fmt.Sprintf("args[%d]", j)
+
+			position := -1
+			if arg.Position != nil {
+				position = *arg.Position
+				if position < -1 {
+					issue(argField+".position", "must be >= 0, or -1 for any position")
+				}
+			}
+
+			argMode, err := ParseMatchMode(arg.Match)
+			if err != nil {
+				issue(argField+".match", "%v", err)
+			} else if msg := validatePolicyPattern(argMode, arg.Pattern); len(msg) > 0 {
+				issue(argField+".pattern", "%s", msg)
+			}
+
+			cmdRule.WithArgsFilter(ArgMatcher{
+				Position: position,
+				Pattern:  arg.Pattern,
+				Mode:     argMode,
+				Required: arg.Required,
+			})
+		}
+
+		for j, s := range rule.Sources {
+			source, err := ParseCommandSource(s)
+			if err != nil {
+				issue(fmt.Sprintf("sources[%d]", j), "%v", err)
+				continue
+			}
+			cmdRule.WithSourceFilter(source)
+		}
+
+		r = append(r, cmdRule)
+	}
+
+	return r, issues
+}
+
+// validatePolicyPattern 校验模式，返回错误描述，合法时返回空字符串
+func validatePolicyPattern(mode MatchMode, pattern string) string {
+	if len(pattern) == 0 {
+		return "must not be empty"
+	}
+
+	switch mode {
+	case MatchGlob:
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Sprintf("invalid glob '%s': %v", pattern, err)
+		}
+	case MatchRegex:
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Sprintf("invalid regex '%s': %v", pattern, err)
+		}
+	}
+	return ""
+}
+
+// ============================================================
+// 序列化
+// ============================================================
+
+// GoshPolicyFromConfig 将 GoshConfig 转换为策略（GoHandlers、AuditSink 和 Fs 不会被保留）
+func GoshPolicyFromConfig(config GoshConfig) GoshPolicy {
+	r := &GoshPolicyT{
+		KillTimeout:      config.KillTimeout.String(),
+		WhitelistMode:    config.WhitelistMode,
+		Blacklist:        configRulesToPolicy(config.Blacklist),
+		Whitelist:        configRulesToPolicy(config.Whitelist),
+		AllowedReadDirs:  config.AllowedReadDirs,
+		AllowedWriteDirs: config.AllowedWriteDirs,
+	}
+	for _, re := range config.AuditRedactions {
+		r.AuditRedactions = append(r.AuditRedactions, re.String())
+	}
+	return r
+}
+
+// configRulesToPolicy 将规则列表转换为策略格式
+func configRulesToPolicy(rules []CommandRule) []GoshPolicyRule {
+	if len(rules) == 0 {
+		return nil
+	}
+
+	r := make([]GoshPolicyRule, 0, len(rules))
+	for _, rule := range rules {
+		policyRule := &GoshPolicyRuleT{
+			Name:    rule.Name,
+			Pattern: rule.Pattern,
+			Match:   rule.MatchMode.String(),
+			Reason:  rule.Reason,
+		}
+
+		for _, m := range rule.ArgsMatchers {
+			arg := GoshPolicyArg{
+				Pattern:  m.Pattern,
+				Match:    m.Mode.String(),
+				Required: m.Required,
+			}
+			if m.Position != -1 {
+				position := m.Position
+				arg.Position = &position
+			}
+			policyRule.Args = append(policyRule.Args, arg)
+		}
+
+		for _, s := range rule.SourceFilter {
+			policyRule.Sources = append(policyRule.Sources, s.String())
+		}
+
+		r = append(r, policyRule)
+	}
+	return r
+}
+
+// ToYaml 序列化为 YAML
+func (me GoshPolicy) ToYaml() (string, error) {
+	return qlang.ToYaml("gosh policy", me)
+}
+
+// ToJson 序列化为带缩进的 JSON
+func (me GoshPolicy) ToJson() (string, error) {
+	r, err := qjson.MarshalJSONIndent(me, "", "  ")
+	if err != nil {
+		return "", errors.Wrap(err, "marshal gosh policy to json")
+	}
+	return string(r), nil
+}
+
+// SaveGoshPolicy 将 GoshConfig 保存为策略文件，.json 后缀保存为 JSON，其它保存为 YAML
+func SaveGoshPolicy(fs afero.Fs, path string, config GoshConfig) error {
+	policy := GoshPolicyFromConfig(config)
+
+	var text string
+	var err error
+	if isJsonPolicyFile(path) {
+		text, err = policy.ToJson()
+	} else {
+		text, err = policy.ToYaml()
+	}
+	if err != nil {
+		return err
+	}
+
+	return qio.WriteFileText(fs, path, text)
+}
+
+// isJsonPolicyFile 是否按 JSON 处理策略文件
+func isJsonPolicyFile(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".json")
+}
@@ -0,0 +1,307 @@
+package qshell
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/qiangyt/go-comm/v3/qio"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+const testGoshPolicyYaml = `
+kill_timeout: 10s
+whitelist_mode: false
+blacklist:
+  - name: no-recursive-rm
+    pattern: rm
+    match: exact
+    args:
+      - pattern: "-*r*"
+      - position: 0
+        pattern: "^/$"
+        match: regex
+        required: true
+    sources: [direct, subshell]
+    reason: recursive delete is not allowed
+  - pattern: "shutdown*"
+whitelist:
+  - pattern: echo
+    match: exact
+`
+
+func TestLoadGoshPolicy_yaml(t *testing.T) {
+	a := require.New(t)
+
+	fs := afero.NewMemMapFs()
+	qio.WriteFileTextP(fs, "/policy.yaml", testGoshPolicyYaml)
+
+	config, err := LoadGoshPolicy(fs, "/policy.yaml")
+	a.NoError(err)
+	a.Equal(10*time.Second, config.KillTimeout)
+	a.False(config.WhitelistMode)
+	a.NotNil(config.GoHandlers)
+
+	a.Len(config.Blacklist, 2)
+	rule := config.Blacklist[0]
+	a.Equal("no-recursive-rm", rule.Name)
+	a.Equal("recursive delete is not allowed", rule.Reason)
+	a.Equal("rm", rule.Pattern)
+	a.Equal(MatchExact, rule.MatchMode)
+	a.Equal([]ArgMatcher{
+		{Position: -1, Pattern: "-*r*", Mode: MatchGlob},
+		{Position: 0, Pattern: "^/$", Mode: MatchRegex, Required: true},
+	}, rule.ArgsMatchers)
+	a.Equal([]CommandSource{SourceDirect, SourceSubshell}, rule.SourceFilter)
+
+	a.Equal("shutdown*", config.Blacklist[1].Pattern)
+	a.Equal(MatchGlob, config.Blacklist[1].MatchMode)
+	a.Empty(config.Blacklist[1].Name)
+
+	a.Len(config.Whitelist, 1)
+	a.Equal("echo", config.Whitelist[0].Pattern)
+}
+
+func TestLoadGoshPolicy_json(t *testing.T) {
+	a := require.New(t)
+
+	fs := afero.NewMemMapFs()
+	qio.WriteFileTextP(fs, "/policy.json", `{
+		"whitelist_mode": true,
+		"whitelist": [{"name": "git", "pattern": "git", "match": "exact"}]
+	}`)
+
+	config := LoadGoshPolicyP(fs, "/policy.json")
+	a.Equal(6*time.Second, config.KillTimeout)
+	a.True(config.WhitelistMode)
+	a.Len(config.Whitelist, 1)
+	a.Equal("git", config.Whitelist[0].Name)
+	a.Empty(config.Blacklist)
+}
+
+func TestLoadGoshPolicy_enforced(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下 ls 不可用")
+	}
+
+	fs := afero.NewMemMapFs()
+	qio.WriteFileTextP(fs, "/policy.yaml", `
+blacklist:
+  - name: no-secret
+    pattern: ls
+    match: exact
+    args:
+      - pattern: "secret*"
+    reason: secrets must not be listed
+  - pattern: "head*"
+`)
+
+	executor := NewGoshExecutor(LoadGoshPolicyP(fs, "/policy.yaml"))
+
+	var stdout, stderr bytes.Buffer
+	err := executor.Run(context.Background(), "", "ls secret-dir", nil, &stdout, &stderr)
+	a.Error(err)
+	a.Contains(err.Error(), "blocked by blacklist rule: no-secret: secrets must not be listed")
+
+	err = executor.Run(context.Background(), "", "head -n 1 /dev/null", nil, &stdout, &stderr)
+	a.Error(err)
+	a.Contains(err.Error(), "blocked by blacklist rule: head*")
+
+	a.NoError(executor.Run(context.Background(), "", "ls -d /", nil, &stdout, &stderr))
+	a.Equal("/\n", stdout.String())
+}
+
+func TestLoadGoshPolicy_validation(t *testing.T) {
+	a := require.New(t)
+
+	fs := afero.NewMemMapFs()
+	qio.WriteFileTextP(fs, "/policy.yaml", `
+kill_timeout: soon
+blacklist:
+  - name: ok
+    pattern: rm
+  - name: bad-match
+    pattern: rm
+    match: fuzzy
+  - name: ok
+    pattern: "[a-"
+    args:
+      - position: -2
+        pattern: "(x"
+        match: regex
+    sources: [pipe]
+whitelist:
+  - match: exact
+audit_redactions: ["(bad"]
+`)
+
+	_, err := LoadGoshPolicy(fs, "/policy.yaml")
+	a.Error(err)
+
+	policyErr := GetGoshPolicyError(err)
+	a.NotNil(policyErr)
+	a.Equal("/policy.yaml", policyErr.File)
+
+	fields := []string{}
+	for _, issue := range policyErr.Issues {
+		fields = append(fields, issue.Field)
+	}
+	a.Equal([]string{
+		"kill_timeout",
+		"blacklist[1].match",
+		"blacklist[2].name",
+		"blacklist[2].pattern",
+		"blacklist[2].args[0].position",
+		"blacklist[2].args[0].pattern",
+		"blacklist[2].sources[0]",
+		"whitelist[0].pattern",
+		"audit_redactions[0]",
+	}, fields)
+
+	a.Equal("bad-match", policyErr.Issues[1].Rule)
+	a.Contains(policyErr.Issues[1].Message, "unknown match mode 'fuzzy'")
+	a.Contains(err.Error(), "invalid gosh policy /policy.yaml: 9 issues")
+	a.Contains(err.Error(), "blacklist[1].match (rule 'bad-match'): unknown match mode 'fuzzy'")
+	a.Contains(err.Error(), "whitelist[0].pattern: must not be empty")
+
+	a.Panics(func() {
+		LoadGoshPolicyP(fs, "/policy.yaml")
+	})
+}
+
+func TestLoadGoshPolicy_unknownField(t *testing.T) {
+	a := require.New(t)
+
+	fs := afero.NewMemMapFs()
+	qio.WriteFileTextP(fs, "/policy.yaml", "blacklist:\n  - pattern: rm\n    patern: typo\n")
+
+	_, err := LoadGoshPolicy(fs, "/policy.yaml")
+	a.Error(err)
+	a.Contains(err.Error(), "patern")
+	a.Nil(GetGoshPolicyError(err))
+
+	_, err = LoadGoshPolicy(fs, "/missing.yaml")
+	a.Error(err)
+}
+
+func TestSaveGoshPolicy_roundTrip(t *testing.T) {
+	a := require.New(t)
+
+	original := DefaultGoshConfig().
+		WithKillTimeout(1500*time.Millisecond).
+		WithWhitelistMode(true).
+		WithBlacklist(NewCommandRule("rm", MatchExact).
+			WithName("no-rm").
+			WithReason("dangerous").
+			WithArgsFilter(
+				ArgMatcher{Position: -1, Pattern: "-rf", Mode: MatchExact},
+				ArgMatcher{Position: 0, Pattern: "/", Mode: MatchGlob, Required: true},
+			).
+			WithSourceFilter(SourceCmdSubst, SourceProcSubst)).
+		WithWhitelistSimple("echo", "git").
+		WithAuditRedactions(regexp.MustCompile(`--password=(.*)`)).
+		WithAllowedReadDirs("/data").
+		WithAllowedWriteDirs("/tmp/work", "/var/out")
+
+	for _, path := range []string{"/policy.yaml", "/policy.json"} {
+		fs := afero.NewMemMapFs()
+		a.NoError(SaveGoshPolicy(fs, path, original))
+
+		loaded, err := LoadGoshPolicy(fs, path)
+		a.NoError(err, path)
+		a.Equal(original.KillTimeout, loaded.KillTimeout, path)
+		a.Equal(original.WhitelistMode, loaded.WhitelistMode, path)
+		a.Equal(original.Blacklist, loaded.Blacklist, path)
+		a.Equal(original.Whitelist, loaded.Whitelist, path)
+		a.Equal(original.AuditRedactions, loaded.AuditRedactions, path)
+		a.Equal(original.AllowedReadDirs, loaded.AllowedReadDirs, path)
+		a.Equal(original.AllowedWriteDirs, loaded.AllowedWriteDirs, path)
+	}
+}
+
+func TestLoadGoshPolicy_allowedDirs(t *testing.T) {
+	a := require.New(t)
+
+	fs := afero.NewMemMapFs()
+	qio.WriteFileTextP(fs, "/policy.yaml", "allowed_read_dirs: [/data]\nallowed_write_dirs: [/tmp/work, \"\"]\n")
+
+	_, err := LoadGoshPolicy(fs, "/policy.yaml")
+	policyErr := GetGoshPolicyError(err)
+	a.NotNil(policyErr)
+	a.Len(policyErr.Issues, 1)
+	a.Equal("allowed_write_dirs[1]", policyErr.Issues[0].Field)
+
+	qio.WriteFileTextP(fs, "/policy.yaml", "allowed_read_dirs: [/data]\nallowed_write_dirs: [/tmp/work]\n")
+	config := LoadGoshPolicyP(fs, "/policy.yaml")
+	a.Equal([]string{"/data"}, config.AllowedReadDirs)
+	a.Equal([]string{"/tmp/work"}, config.AllowedWriteDirs)
+}
+
+func TestGoshPolicy_ToYaml(t *testing.T) {
+	a := require.New(t)
+
+	policy := GoshPolicyFromConfig(DefaultGoshConfig().
+		WithBlacklist(NewCommandRule("rm", MatchExact).WithName("no-rm")))
+
+	text, err := policy.ToYaml()
+	a.NoError(err)
+	a.Contains(text, "kill_timeout: 6s")
+	a.Contains(text, "name: no-rm")
+	a.Contains(text, "match: exact")
+	a.NotContains(text, "whitelist:")
+
+	text, err = policy.ToJson()
+	a.NoError(err)
+	a.Contains(text, `"kill_timeout": "6s"`)
+}
+
+func TestParseMatchMode(t *testing.T) {
+	a := require.New(t)
+
+	for _, mode := range []MatchMode{MatchGlob, MatchExact, MatchRegex} {
+		parsed, err := ParseMatchMode(mode.String())
+		a.NoError(err)
+		a.Equal(mode, parsed)
+	}
+
+	parsed, err := ParseMatchMode("")
+	a.NoError(err)
+	a.Equal(MatchGlob, parsed)
+
+	_, err = ParseMatchMode("fuzzy")
+	a.Error(err)
+	a.Equal("unknown", MatchMode(99).String())
+}
+
+func TestParseCommandSource(t *testing.T) {
+	a := require.New(t)
+
+	for _, source := range []CommandSource{SourceDirect, SourceCmdSubst, SourceProcSubst, SourceSubshell} {
+		parsed, err := ParseCommandSource(source.String())
+		a.NoError(err)
+		a.Equal(source, parsed)
+	}
+
+	_, err := ParseCommandSource("pipe")
+	a.Error(err)
+}
+
+func TestSecurityCheckError_reason(t *testing.T) {
+	a := require.New(t)
+
+	err := &SecurityCheckErrorT{
+		Command: &ExtractedCommandT{Name: "rm"},
+		Rule:    NewCommandRule("rm", MatchExact).WithName("no-rm").WithReason("dangerous"),
+		Message: "is blocked by blacklist",
+	}
+	a.Equal("command 'rm' is blocked by blacklist (rule: no-rm): dangerous", err.Error())
+
+	err.Rule = NewCommandRule("rm", MatchExact)
+	a.Equal("command 'rm' is blocked by blacklist (rule: rm)", err.Error())
+}
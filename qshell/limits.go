@@ -0,0 +1,287 @@
+package qshell
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ============================================================
+// Limits 资源限制
+// ============================================================
+
+// LimitsT 子进程的资源限制，零值字段表示不限制
+// rlimit 和 cgroup 仅在 Linux 上生效，输出和运行时间限制在所有平台生效。
+// rlimit 由 /bin/sh 的 ulimit 在 exec 命令之前设置，命令的 argv[0] 因此变为它的完整路径
+type LimitsT struct {
+	// CPUTime CPU 时间上限（RLIMIT_CPU，向上取整到秒），超出时进程收到 SIGXCPU，1 秒后 SIGKILL
+	CPUTime time.Duration
+
+	// AddressSpace 虚拟内存上限（RLIMIT_AS，字节，向下取整到 KiB），超出时内存分配失败，由进程自行报错
+	AddressSpace uint64
+
+	// OpenFiles 打开文件数上限（RLIMIT_NOFILE）
+	OpenFiles uint64
+
+	// MaxOutputBytes stdout 和 stderr 的总输出上限（字节），超出时丢弃后续输出并终止进程组
+	MaxOutputBytes int64
+
+	// WallTime 最长运行时间，超出时终止进程组
+	WallTime time.Duration
+
+	// Cgroup 进程创建时直接放入的 cgroup v2 目录（需已存在且可写），相对路径相对于 /sys/fs/cgroup
+	// 内存、CPU 配额等由该 cgroup 自身的配置决定；系统未启用 cgroup v2 时忽略
+	Cgroup string
+}
+
+// Limits 是 LimitsT 的指针别名
+type Limits = *LimitsT
+
+// NewLimits 创建不做任何限制的 Limits
+func NewLimits() Limits {
+	return &LimitsT{}
+}
+
+// WithCPUTime 设置 CPU 时间上限
+func (me Limits) WithCPUTime(d time.Duration) Limits {
+	me.CPUTime = d
+	return me
+}
+
+// WithAddressSpace 设置虚拟内存上限（字节）
+func (me Limits) WithAddressSpace(bytes uint64) Limits {
+	me.AddressSpace = bytes
+	return me
+}
+
+// WithOpenFiles 设置打开文件数上限
+func (me Limits) WithOpenFiles(n uint64) Limits {
+	me.OpenFiles = n
+	return me
+}
+
+// WithMaxOutputBytes 设置输出上限（字节）
+func (me Limits) WithMaxOutputBytes(n int64) Limits {
+	me.MaxOutputBytes = n
+	return me
+}
+
+// WithWallTime 设置最长运行时间
+func (me Limits) WithWallTime(d time.Duration) Limits {
+	me.WallTime = d
+	return me
+}
+
+// WithCgroup 设置 cgroup v2 目录
+func (me Limits) WithCgroup(path string) Limits {
+	me.Cgroup = path
+	return me
+}
+
+// ============================================================
+// LimitError 超出限制的错误
+// ============================================================
+
+// LimitKind 被超出的限制类型
+type LimitKind string
+
+const (
+	// LimitCPUTime 超出 CPU 时间
+	LimitCPUTime LimitKind = "cpu_time"
+	// LimitOutput 超出输出上限
+	LimitOutput LimitKind = "output"
+	// LimitWallTime 超出最长运行时间
+	LimitWallTime LimitKind = "wall_time"
+	// LimitMemory 被 cgroup 的内存限制 OOM 终止
+	LimitMemory LimitKind = "memory"
+)
+
+// LimitErrorT 命令因超出资源限制被终止
+// RLIMIT_AS 和 RLIMIT_NOFILE 超出时只是系统调用失败，由进程自行处理，不会产生 LimitError
+type LimitErrorT struct {
+	// Command 命令
+	Command string
+	// Kind 被超出的限制
+	Kind LimitKind
+	// Limit 限制值的描述，如 "2s"、"1024 bytes"
+	Limit string
+}
+
+// LimitError 是 LimitErrorT 的指针别名
+type LimitError = *LimitErrorT
+
+// Error 实现 error 接口
+func (me LimitError) Error() string {
+	return fmt.Sprintf("command '%s' exceeded %s limit (%s)", me.Command, me.Kind, me.Limit)
+}
+
+// IsLimitError 检查错误是否为 LimitError
+func IsLimitError(err error) bool {
+	return GetLimitError(err) != nil
+}
+
+// GetLimitError 从错误链中提取 LimitError，不存在时返回 nil
+func GetLimitError(err error) LimitError {
+	var r LimitError
+	if errors.As(err, &r) {
+		return r
+	}
+	return nil
+}
+
+// ============================================================
+// limitedProcess 对单个进程应用限制
+// ============================================================
+
+// errWallTimeExceeded 运行时间超限时 ctx 的取消原因
+var errWallTimeExceeded = errors.New("wall time limit exceeded")
+
+// limitedProcess 对单个进程应用资源限制并判断进程是否因超限结束
+// 所有方法对 nil 接收者都是空操作，未配置限制时无需判断
+type limitedProcess struct {
+	limits  Limits
+	command string
+	ctx     context.Context
+	cancel  context.CancelFunc
+	cmd     *exec.Cmd
+	cgroup  *cgroupPlacement
+
+	mu       sync.Mutex
+	written  int64
+	exceeded LimitError
+}
+
+// newLimitedProcess 创建限制器，配置了 WallTime 时返回带超时的 ctx，命令应使用该 ctx 运行
+func newLimitedProcess(ctx context.Context, limits Limits, command string) (*limitedProcess, context.Context) {
+	if limits == nil {
+		return nil, ctx
+	}
+
+	r := &limitedProcess{limits: limits, command: command}
+	if limits.WallTime > 0 {
+		ctx, r.cancel = context.WithTimeoutCause(ctx, limits.WallTime, errWallTimeExceeded)
+	}
+	r.ctx = ctx
+	return r, ctx
+}
+
+// prepare 在进程启动前调用，配置 rlimit 和 cgroup 放置，两者在 exec 之前生效
+func (me *limitedProcess) prepare(cmd *exec.Cmd) error {
+	if me == nil {
+		return nil
+	}
+	me.cmd = cmd
+
+	if err := wrapRlimits(cmd, me.limits); err != nil {
+		me.release()
+		return errors.Wrapf(err, "set resource limits for command '%s'", me.command)
+	}
+
+	cgroup, err := placeInCgroup(cmd, me.limits.Cgroup)
+	if err != nil {
+		me.release()
+		return errors.Wrapf(err, "place command '%s' in cgroup", me.command)
+	}
+	me.cgroup = cgroup
+	return nil
+}
+
+// allowOutput 记录 n 字节输出，返回允许保留的字节数；超出上限时终止进程组
+func (me *limitedProcess) allowOutput(n int) int {
+	if me == nil || me.limits.MaxOutputBytes <= 0 {
+		return n
+	}
+
+	me.mu.Lock()
+	remain := me.limits.MaxOutputBytes - me.written
+	allowed := n
+	if int64(n) > remain {
+		allowed = int(max(remain, 0))
+	}
+	me.written += int64(allowed)
+	me.mu.Unlock()
+
+	if allowed < n {
+		me.exceed(LimitOutput, fmt.Sprintf("%d bytes", me.limits.MaxOutputBytes))
+	}
+	return allowed
+}
+
+// writer 包装输出，未配置输出上限时原样返回
+func (me *limitedProcess) writer(w io.Writer) io.Writer {
+	if me == nil || me.limits.MaxOutputBytes <= 0 || w == nil {
+		return w
+	}
+	return &limitedWriter{process: me, w: w}
+}
+
+// exceed 记录第一个被超出的限制并终止进程组
+func (me *limitedProcess) exceed(kind LimitKind, limit string) {
+	me.mu.Lock()
+	first := me.exceeded == nil
+	if first {
+		me.exceeded = &LimitErrorT{Command: me.command, Kind: kind, Limit: limit}
+	}
+	me.mu.Unlock()
+
+	if first && me.cmd != nil && me.cmd.Process != nil {
+		_ = killProcessGroup(me.cmd)
+	}
+}
+
+// finish 在进程结束后调用，释放资源并返回超出的限制，未超限时返回 nil
+func (me *limitedProcess) finish(state *os.ProcessState) LimitError {
+	if me == nil {
+		return nil
+	}
+	defer me.release()
+
+	failed := state == nil || !state.Success()
+	if failed {
+		switch {
+		case errors.Is(context.Cause(me.ctx), errWallTimeExceeded):
+			me.exceed(LimitWallTime, me.limits.WallTime.String())
+		case cpuLimitExceeded(state, me.limits):
+			me.exceed(LimitCPUTime, me.limits.CPUTime.String())
+		case me.cgroup != nil && me.cgroup.oomKilled():
+			me.exceed(LimitMemory, "cgroup "+me.cgroup.path)
+		}
+	}
+
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.exceeded
+}
+
+// release 取消运行时间计时并关闭 cgroup 目录
+func (me *limitedProcess) release() {
+	if me.cancel != nil {
+		me.cancel()
+	}
+	if me.cgroup != nil {
+		me.cgroup.close()
+	}
+}
+
+// limitedWriter 超出输出上限后丢弃后续输出
+type limitedWriter struct {
+	process *limitedProcess
+	w       io.Writer
+}
+
+// Write 实现 io.Writer，超出部分被丢弃但不返回错误，进程由 limitedProcess 终止
+func (me *limitedWriter) Write(p []byte) (int, error) {
+	n := me.process.allowOutput(len(p))
+	if n > 0 {
+		if _, err := me.w.Write(p[:n]); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,170 @@
+//go:build linux
+// +build linux
+
+package qshell
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// cgroupRoot cgroup v2 的挂载点
+const cgroupRoot = "/sys/fs/cgroup"
+
+// rlimitShell 在 exec 目标程序之前设置 rlimit 的 shell
+const rlimitShell = "/bin/sh"
+
+// rlimitT 一项 rlimit 及对应的 ulimit 选项，ulimit 的值为 Max / unit
+type rlimitT struct {
+	resource int
+	option   string
+	unit     uint64
+	name     string
+	unix.Rlimit
+}
+
+// rlimitsOf 返回需要设置的 rlimit
+func rlimitsOf(limits Limits) []rlimitT {
+	r := []rlimitT{}
+	if limits.CPUTime > 0 {
+		secs := uint64(math.Ceil(limits.CPUTime.Seconds()))
+		// 软限制触发 SIGXCPU，忽略该信号的进程在硬限制时被 SIGKILL
+		r = append(r, rlimitT{unix.RLIMIT_CPU, "t", 1, "RLIMIT_CPU", unix.Rlimit{Cur: secs, Max: secs + 1}})
+	}
+	if limits.AddressSpace > 0 {
+		// ulimit -v 以 KiB 为单位，向下取整
+		kb := limits.AddressSpace / 1024 * 1024
+		r = append(r, rlimitT{unix.RLIMIT_AS, "v", 1024, "RLIMIT_AS", unix.Rlimit{Cur: kb, Max: kb}})
+	}
+	if limits.OpenFiles > 0 {
+		r = append(r, rlimitT{unix.RLIMIT_NOFILE, "n", 1, "RLIMIT_NOFILE", unix.Rlimit{Cur: limits.OpenFiles, Max: limits.OpenFiles}})
+	}
+	return r
+}
+
+// wrapRlimits 改为由 /bin/sh 先用 ulimit 设置 rlimit 再 exec 原来的程序，
+// 程序及其所有后代从第一条指令起就受限制，不存在启动后才设置的窗口。
+// sh 用 exec 替换自身，pid 和进程组不变；sh 支持 exec -a（如 bash）时程序的 argv[0] 保持不变，
+// 否则（如 dash）变为程序的路径。调用方应在包装之前保存 cmd.Args 用于展示
+func wrapRlimits(cmd *exec.Cmd, limits Limits) error {
+	rlimits := rlimitsOf(limits)
+	if len(rlimits) == 0 || cmd.Err != nil {
+		return nil
+	}
+
+	// 非 root 不能提高硬限制，启动前检查，避免 ulimit 在子进程中失败
+	statements := make([]string, 0, len(rlimits)*2)
+	for _, l := range rlimits {
+		var current unix.Rlimit
+		if err := unix.Getrlimit(l.resource, &current); err != nil {
+			return errors.Wrapf(err, "get %s", l.name)
+		}
+		if l.Max > current.Max && os.Geteuid() != 0 {
+			return errors.Errorf("set %s: %d exceeds the hard limit %d", l.name, l.Max, current.Max)
+		}
+		// 软限制不能高于硬限制：提高硬限制时先设置硬限制，否则先设置软限制
+		soft := fmt.Sprintf("ulimit -S -%s %d", l.option, l.Cur/l.unit)
+		hard := fmt.Sprintf("ulimit -H -%s %d", l.option, l.Max/l.unit)
+		if l.Max > current.Max {
+			statements = append(statements, hard, soft)
+		} else {
+			statements = append(statements, soft, hard)
+		}
+	}
+	script := strings.Join(statements, " && ") + ` || exit 126; a0=$1; shift; ` +
+		`if (exec -a "$a0" true) 2>/dev/null; then exec -a "$a0" "$@"; fi; exec "$@"`
+
+	cmd.Args = append([]string{rlimitShell, "-c", script, rlimitShell, cmd.Args[0], cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = rlimitShell
+	return nil
+}
+
+// cpuLimitExceeded 进程是否因 RLIMIT_CPU 被终止
+func cpuLimitExceeded(state *os.ProcessState, limits Limits) bool {
+	if state == nil || limits.CPUTime <= 0 {
+		return false
+	}
+	switch exitSignal(state) {
+	case syscall.SIGXCPU:
+		return true
+	case syscall.SIGKILL:
+		secs := math.Ceil(limits.CPUTime.Seconds())
+		return (state.UserTime() + state.SystemTime()).Seconds() >= secs
+	}
+	return false
+}
+
+// cgroupV2Available 系统是否挂载了 cgroup v2
+func cgroupV2Available() bool {
+	_, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers"))
+	return err == nil
+}
+
+// cgroupPlacement 进程所在的 cgroup
+type cgroupPlacement struct {
+	path     string
+	dir      *os.File
+	oomKills int64
+}
+
+// placeInCgroup 让进程在创建时直接进入 cgroup（clone3 CLONE_INTO_CGROUP），不存在迁移前的窗口
+func placeInCgroup(cmd *exec.Cmd, path string) (*cgroupPlacement, error) {
+	if len(path) == 0 || !cgroupV2Available() {
+		return nil, nil
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(cgroupRoot, path)
+	}
+
+	dir, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(dir.Fd())
+
+	return &cgroupPlacement{path: path, dir: dir, oomKills: readOOMKills(path)}, nil
+}
+
+// oomKilled 进程运行期间 cgroup 是否发生过 OOM kill
+func (me *cgroupPlacement) oomKilled() bool {
+	return readOOMKills(me.path) > me.oomKills
+}
+
+// close 关闭 cgroup 目录
+func (me *cgroupPlacement) close() {
+	me.dir.Close()
+}
+
+// readOOMKills 读取 memory.events 中的 oom_kill 计数，无法读取时返回 0
+func readOOMKills(path string) int64 {
+	f, err := os.Open(filepath.Join(path, "memory.events"))
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "oom_kill" {
+			n, _ := strconv.ParseInt(fields[1], 10, 64)
+			return n
+		}
+	}
+	return 0
+}
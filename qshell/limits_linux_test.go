@@ -0,0 +1,199 @@
+//go:build linux
+// +build linux
+
+package qshell
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestRunningCommand_Limits_output(t *testing.T) {
+	a := require.New(t)
+
+	rc := NewRunningCommand(&CommandOptionsT{
+		Command: "yes",
+		Limits:  NewLimits().WithMaxOutputBytes(1000),
+	})
+
+	limitErr := rc.LimitError()
+	a.NotNil(limitErr)
+	a.Equal(LimitOutput, limitErr.Kind)
+	a.Equal("command 'yes' exceeded output limit (1000 bytes)", limitErr.Error())
+	a.Len(rc.GetOutput(), 1000)
+	a.True(rc.WaitStatus().Signaled())
+}
+
+func TestRunningCommand_Limits_wallTime(t *testing.T) {
+	a := require.New(t)
+
+	start := time.Now()
+	rc := NewRunningCommand(&CommandOptionsT{
+		Command: "sleep",
+		Args:    []string{"10"},
+		Limits:  NewLimits().WithWallTime(200 * time.Millisecond),
+	})
+
+	limitErr := rc.LimitError()
+	a.NotNil(limitErr)
+	a.Equal(LimitWallTime, limitErr.Kind)
+	a.Equal("200ms", limitErr.Limit)
+	a.Less(time.Since(start), 5*time.Second)
+}
+
+func TestRunningCommand_Limits_cpuTime(t *testing.T) {
+	a := require.New(t)
+
+	if testing.Short() {
+		t.Skip("需要消耗 1 秒 CPU 时间")
+	}
+
+	rc := NewRunningCommand(&CommandOptionsT{
+		Command: "sh",
+		Args:    []string{"-c", "while :; do :; done"},
+		Limits:  NewLimits().WithCPUTime(time.Second).WithWallTime(30 * time.Second),
+	})
+
+	limitErr := rc.LimitError()
+	a.NotNil(limitErr)
+	a.Equal(LimitCPUTime, limitErr.Kind)
+}
+
+func TestRunningCommand_Limits_rlimits(t *testing.T) {
+	a := require.New(t)
+
+	// rlimit 在 exec 之前设置，命令和它立即创建的子进程都受限制
+	rc := NewRunningCommand(&CommandOptionsT{
+		Command: "sh",
+		Args:    []string{"-c", `ulimit -n; ulimit -v; sh -c 'ulimit -H -n; ulimit -S -t; ulimit -H -t'`},
+		Limits:  NewLimits().WithOpenFiles(64).WithAddressSpace(1 << 30).WithCPUTime(5 * time.Second),
+	})
+
+	a.Equal(0, rc.Wait())
+	a.Nil(rc.LimitError())
+	a.Equal("64\n1048576\n64\n5\n6\n", rc.GetStdoutString())
+}
+
+func TestRunningCommand_Limits_args(t *testing.T) {
+	a := require.New(t)
+
+	// 设置 rlimit 的包装不出现在命令行中
+	rc := NewRunningCommand(&CommandOptionsT{
+		Command: "sh",
+		Args:    []string{"-c", "echo $0"},
+		Limits:  NewLimits().WithOpenFiles(64),
+	})
+	a.Equal(0, rc.Wait())
+	a.Equal([]string{"sh", "-c", "echo $0"}, rc.Args())
+
+	// 支持 exec -a 的 sh 保持 argv[0] 不变
+	if exec.Command(rlimitShell, "-c", "(exec -a x true) 2>/dev/null").Run() == nil {
+		a.Equal("sh\n", rc.GetStdoutString())
+	} else {
+		path, err := exec.LookPath("sh")
+		a.NoError(err)
+		a.Equal(path+"\n", rc.GetStdoutString())
+	}
+}
+
+func TestRunningCommand_Limits_rlimitAboveHardLimit(t *testing.T) {
+	a := require.New(t)
+
+	if os.Geteuid() == 0 {
+		t.Skip("root 可以提高硬限制")
+	}
+	var current unix.Rlimit
+	a.NoError(unix.Getrlimit(unix.RLIMIT_NOFILE, &current))
+	if current.Max == unix.RLIM_INFINITY {
+		t.Skip("没有硬限制")
+	}
+
+	a.Panics(func() {
+		NewRunningCommand(&CommandOptionsT{
+			Command: "true",
+			Limits:  NewLimits().WithOpenFiles(current.Max + 1),
+		})
+	})
+}
+
+func TestRunningCommand_Limits_none(t *testing.T) {
+	a := require.New(t)
+
+	rc := NewRunningCommand(&CommandOptionsT{
+		Command: "sh",
+		Args:    []string{"-c", "echo hello; exit 3"},
+		Limits:  NewLimits().WithMaxOutputBytes(100).WithWallTime(10 * time.Second),
+	})
+
+	a.Equal(3, rc.Wait())
+	a.Nil(rc.LimitError())
+	a.Equal("hello\n", rc.GetOutputString())
+}
+
+func TestRunningCommand_Limits_invalidCgroup(t *testing.T) {
+	a := require.New(t)
+
+	if !cgroupV2Available() {
+		t.Skip("系统未启用 cgroup v2")
+	}
+
+	a.Panics(func() {
+		NewRunningCommand(&CommandOptionsT{
+			Command: "true",
+			Limits:  NewLimits().WithCgroup("qshell-nonexistent-cgroup"),
+		})
+	})
+}
+
+func TestGoshExecutor_Limits(t *testing.T) {
+	a := require.New(t)
+
+	executor := NewGoshExecutor(DefaultGoshConfig().
+		WithLimits(NewLimits().
+			WithMaxOutputBytes(500).
+			WithWallTime(300 * time.Millisecond).
+			WithOpenFiles(32)))
+
+	var stdout, stderr bytes.Buffer
+	err := executor.Run(context.Background(), "", "yes", nil, &stdout, &stderr)
+	limitErr := GetLimitError(err)
+	a.NotNil(limitErr, "%v", err)
+	a.Equal(LimitOutput, limitErr.Kind)
+	a.Equal(500, stdout.Len())
+
+	start := time.Now()
+	err = executor.Run(context.Background(), "", "sleep 10; echo unreachable", nil, &stdout, &stderr)
+	limitErr = GetLimitError(err)
+	a.NotNil(limitErr, "%v", err)
+	a.Equal(LimitWallTime, limitErr.Kind)
+	a.Contains(err.Error(), "command 'sleep' exceeded wall_time limit (300ms)")
+	a.Less(time.Since(start), 5*time.Second)
+
+	// 限制作用于每个进程，而不是整个脚本
+	stdout.Reset()
+	a.NoError(executor.Run(context.Background(), "", "sleep 0.2; sh -c 'sleep 0.2; ulimit -n'", nil, &stdout, &stderr))
+	a.Equal("32", strings.TrimSpace(stdout.String()))
+
+	// Go 内置命令不受限制
+	stdout.Reset()
+	a.NoError(executor.Run(context.Background(), "", "echo "+strings.Repeat("x", 1000), nil, &stdout, &stderr))
+	a.Equal(1001, stdout.Len())
+}
+
+func TestLimitError(t *testing.T) {
+	a := require.New(t)
+
+	err := &LimitErrorT{Command: "x", Kind: LimitMemory, Limit: "cgroup /sys/fs/cgroup/a"}
+	a.True(IsLimitError(err))
+	a.False(IsLimitError(context.Canceled))
+	a.Nil(GetLimitError(nil))
+	a.Equal("command 'x' exceeded memory limit (cgroup /sys/fs/cgroup/a)", err.Error())
+}
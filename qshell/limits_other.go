@@ -0,0 +1,35 @@
+//go:build !linux
+// +build !linux
+
+package qshell
+
+import (
+	"os"
+	"os/exec"
+)
+
+// wrapRlimits 非 Linux 平台不设置 rlimit
+func wrapRlimits(cmd *exec.Cmd, limits Limits) error {
+	return nil
+}
+
+// cpuLimitExceeded 非 Linux 平台不设置 RLIMIT_CPU
+func cpuLimitExceeded(state *os.ProcessState, limits Limits) bool {
+	return false
+}
+
+// cgroupPlacement 非 Linux 平台没有 cgroup
+type cgroupPlacement struct {
+	path string
+}
+
+// placeInCgroup 非 Linux 平台忽略 cgroup 配置
+func placeInCgroup(cmd *exec.Cmd, path string) (*cgroupPlacement, error) {
+	return nil, nil
+}
+
+func (me *cgroupPlacement) oomKilled() bool {
+	return false
+}
+
+func (me *cgroupPlacement) close() {}
@@ -0,0 +1,69 @@
+package qshell
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// ============================================================
+// ExitStatus 退出状态
+// ============================================================
+
+// ExitStatus 命令退出状态
+type ExitStatus struct {
+	// Code 退出码，被信号终止时为 -1
+	Code int
+	// Signal 终止进程的信号，正常退出时为 nil
+	Signal os.Signal
+}
+
+// Signaled 是否被信号终止
+func (me ExitStatus) Signaled() bool {
+	return me.Signal != nil
+}
+
+// String 返回退出状态的字符串表示
+func (me ExitStatus) String() string {
+	if me.Signaled() {
+		return fmt.Sprintf("signal: %v", me.Signal)
+	}
+	return fmt.Sprintf("exit status %d", me.Code)
+}
+
+// newExitStatus 从进程状态构造退出状态
+func newExitStatus(state *os.ProcessState) ExitStatus {
+	if state == nil {
+		return ExitStatus{Code: -1}
+	}
+	return ExitStatus{
+		Code:   state.ExitCode(),
+		Signal: exitSignal(state),
+	}
+}
+
+// ============================================================
+// 进程组终止
+// ============================================================
+
+// terminateProcessGroup 先向进程组发送 SIGTERM，grace 内 exited 未关闭则发送 SIGKILL
+// grace <= 0 或平台不支持 SIGTERM 时直接发送 SIGKILL
+func terminateProcessGroup(cmd *exec.Cmd, grace time.Duration, exited <-chan struct{}) error {
+	if grace <= 0 {
+		return killProcessGroup(cmd)
+	}
+	if err := termProcessGroup(cmd); err != nil {
+		return killProcessGroup(cmd)
+	}
+
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+
+	select {
+	case <-exited:
+		return nil
+	case <-timer.C:
+		return killProcessGroup(cmd)
+	}
+}
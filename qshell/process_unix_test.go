@@ -0,0 +1,138 @@
+//go:build !windows
+// +build !windows
+
+package qshell
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// readPidFile 等待脚本写出的 pid 文件并读取其中的进程号
+func readPidFile(a *require.Assertions, path string) int {
+	var pid int
+	a.Eventually(func() bool {
+		data, err := os.ReadFile(path)
+		if err != nil || !bytes.HasSuffix(data, []byte("\n")) {
+			return false
+		}
+		pid, err = strconv.Atoi(strings.TrimSpace(string(data)))
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	return pid
+}
+
+// processAlive 判断进程是否仍在运行（僵尸进程视为已结束）
+func processAlive(pid int) bool {
+	if err := syscall.Kill(pid, 0); err != nil {
+		return false
+	}
+	stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		// 没有 /proc 的平台上只能依据 kill 的结果
+		return true
+	}
+	fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+	return len(fields) == 0 || fields[0] != "Z"
+}
+
+func TestRunningCommand_Terminate_graceful(t *testing.T) {
+	a := require.New(t)
+
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	rc := NewRunningCommand(&CommandOptionsT{
+		Command: "sh",
+		Args:    []string{"-c", "sleep 100 & echo $! > " + pidFile + "; wait"},
+	})
+	grandchild := readPidFile(a, pidFile)
+	a.True(processAlive(grandchild))
+
+	begin := time.Now()
+	status := rc.Terminate(5 * time.Second)
+	a.Less(time.Since(begin), 5*time.Second)
+
+	a.True(status.Signaled())
+	a.Equal(syscall.SIGTERM, status.Signal)
+	a.Equal(-1, status.Code)
+	a.Equal("signal: terminated", status.String())
+	a.Equal(status, rc.WaitStatus())
+	a.Eventually(func() bool { return !processAlive(grandchild) }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestRunningCommand_Terminate_escalate(t *testing.T) {
+	a := require.New(t)
+
+	readyFile := filepath.Join(t.TempDir(), "ready")
+	rc := NewRunningCommand(&CommandOptionsT{
+		Command: "sh",
+		Args:    []string{"-c", "trap '' TERM; echo $$ > " + readyFile + "; while true; do sleep 0.05; done"},
+	})
+	readPidFile(a, readyFile)
+
+	begin := time.Now()
+	status := rc.Terminate(300 * time.Millisecond)
+	a.GreaterOrEqual(time.Since(begin), 300*time.Millisecond)
+
+	a.Equal(syscall.SIGKILL, status.Signal)
+	a.Equal("signal: killed", status.String())
+}
+
+func TestRunningCommand_Terminate_exited(t *testing.T) {
+	a := require.New(t)
+
+	rc := NewRunningCommand(&CommandOptionsT{Command: "sh", Args: []string{"-c", "exit 7"}})
+	a.Equal(7, rc.Wait())
+
+	status := rc.Terminate(time.Second)
+	a.False(status.Signaled())
+	a.Equal(7, status.Code)
+	a.Equal("exit status 7", status.String())
+}
+
+func TestRunningCommand_Kill_processGroup(t *testing.T) {
+	a := require.New(t)
+
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	rc := NewRunningCommand(&CommandOptionsT{
+		Command: "sh",
+		Args:    []string{"-c", "sleep 100 & echo $! > " + pidFile + "; wait"},
+	})
+	grandchild := readPidFile(a, pidFile)
+
+	rc.Kill()
+	a.Equal(syscall.SIGKILL, rc.WaitStatus().Signal)
+	a.Eventually(func() bool { return !processAlive(grandchild) }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestGoshExecutor_cancelKillsProcessGroup(t *testing.T) {
+	a := require.New(t)
+
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	executor := NewGoshExecutor(DefaultGoshConfig().WithKillTimeout(300 * time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		readPidFile(a, pidFile)
+		cancel()
+	}()
+
+	// sh 忽略 SIGTERM，需要升级为 SIGKILL；后台 sleep 是孙进程，需要随进程组一起结束
+	var stdout, stderr bytes.Buffer
+	begin := time.Now()
+	err := executor.Run(ctx, "", "sh -c 'trap \"\" TERM; sleep 100 & echo $! > "+pidFile+"; wait'", nil, &stdout, &stderr)
+	a.Error(err)
+	a.Less(time.Since(begin), 5*time.Second)
+	a.ErrorIs(err, context.Canceled)
+
+	grandchild := readPidFile(a, pidFile)
+	a.Eventually(func() bool { return !processAlive(grandchild) }, 5*time.Second, 10*time.Millisecond)
+}
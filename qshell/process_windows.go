@@ -0,0 +1,49 @@
+//go:build windows
+// +build windows
+
+package qshell
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// setProcessGroup 让命令运行在独立的进程组中，便于整体终止其进程树
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= syscall.CREATE_NEW_PROCESS_GROUP
+}
+
+// killProcessGroup 使用 taskkill /T 终止命令及其所有子进程
+func killProcessGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+
+	pid := strconv.Itoa(cmd.Process.Pid)
+	if err := exec.Command("taskkill", "/T", "/F", "/PID", pid).Run(); err != nil {
+		return cmd.Process.Kill()
+	}
+	return nil
+}
+
+// termProcessGroup Windows 不支持 SIGTERM
+func termProcessGroup(cmd *exec.Cmd) error {
+	return errors.New("SIGTERM is not supported on windows")
+}
+
+// exitSignal Windows 没有信号退出状态
+func exitSignal(state *os.ProcessState) os.Signal {
+	return nil
+}
+
+// maxRSS Windows 上进程结束后无法获取峰值内存，返回 0
+func maxRSS(state *os.ProcessState) int64 {
+	return 0
+}
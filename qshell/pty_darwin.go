@@ -0,0 +1,49 @@
+//go:build darwin
+// +build darwin
+
+package qshell
+
+import (
+	"bytes"
+	"os"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// openPty 打开一对伪终端，master 以非阻塞方式打开以便交给 netpoller
+func openPty() (master *os.File, slave *os.File, err error) {
+	fd, err := unix.Open("/dev/ptmx", unix.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "open /dev/ptmx")
+	}
+
+	if err := unix.IoctlSetInt(fd, unix.TIOCPTYGRANT, 0); err != nil {
+		unix.Close(fd)
+		return nil, nil, errors.Wrap(err, "grant pty")
+	}
+	if err := unix.IoctlSetInt(fd, unix.TIOCPTYUNLK, 0); err != nil {
+		unix.Close(fd)
+		return nil, nil, errors.Wrap(err, "unlock pty")
+	}
+
+	name := make([]byte, 128)
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), uintptr(unix.TIOCPTYGNAME), uintptr(unsafe.Pointer(&name[0])))
+	if errno != 0 {
+		unix.Close(fd)
+		return nil, nil, errors.Wrap(errno, "get pty name")
+	}
+	if i := bytes.IndexByte(name, 0); i >= 0 {
+		name = name[:i]
+	}
+
+	slaveName := string(name)
+	slave, err = os.OpenFile(slaveName, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		unix.Close(fd)
+		return nil, nil, errors.Wrapf(err, "open %s", slaveName)
+	}
+
+	return os.NewFile(uintptr(fd), "/dev/ptmx"), slave, nil
+}
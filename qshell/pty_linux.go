@@ -0,0 +1,40 @@
+//go:build linux
+// +build linux
+
+package qshell
+
+import (
+	"os"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// openPty 打开一对伪终端，master 以非阻塞方式打开以便交给 netpoller
+func openPty() (master *os.File, slave *os.File, err error) {
+	fd, err := unix.Open("/dev/ptmx", unix.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "open /dev/ptmx")
+	}
+
+	n, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	if err != nil {
+		unix.Close(fd)
+		return nil, nil, errors.Wrap(err, "get pty number")
+	}
+
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		unix.Close(fd)
+		return nil, nil, errors.Wrap(err, "unlock pty")
+	}
+
+	slaveName := "/dev/pts/" + strconv.Itoa(n)
+	slave, err = os.OpenFile(slaveName, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		unix.Close(fd)
+		return nil, nil, errors.Wrapf(err, "open %s", slaveName)
+	}
+
+	return os.NewFile(uintptr(fd), "/dev/ptmx"), slave, nil
+}
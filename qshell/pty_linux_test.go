@@ -0,0 +1,146 @@
+//go:build linux
+// +build linux
+
+package qshell
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// waitForOutput 等待输出中出现指定内容
+func waitForOutput(t *testing.T, runningCmd RunningCommand, s string) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		return strings.Contains(runningCmd.GetOutputString(), s)
+	}, 5*time.Second, 20*time.Millisecond, "output: %q", runningCmd.GetOutputString())
+}
+
+func TestNewRunningCommand_pty(t *testing.T) {
+	a := require.New(t)
+
+	runningCmd := NewRunningCommand(&CommandOptionsT{
+		Command: "sh",
+		Args:    []string{"-c", "test -t 0 && test -t 1 && echo is-a-tty; echo err 1>&2"},
+		Pty:     true,
+	})
+	a.True(runningCmd.IsPty())
+	a.Equal(0, runningCmd.Wait())
+
+	a.Contains(runningCmd.GetStdoutString(), "is-a-tty\r\n")
+	// 伪终端下 stderr 也进入同一个输出流
+	a.Contains(runningCmd.GetStdoutString(), "err\r\n")
+	a.Empty(runningCmd.GetStderr())
+
+	var texts []string
+	for _, line := range runningCmd.GetLines() {
+		a.Equal(StreamStdout, line.Stream)
+		texts = append(texts, line.Text)
+	}
+	a.Equal([]string{"is-a-tty", "err"}, texts)
+}
+
+func TestRunningCommand_pty_interactive(t *testing.T) {
+	a := require.New(t)
+
+	var mu sync.Mutex
+	var raw strings.Builder
+	runningCmd := NewRunningCommand(&CommandOptionsT{
+		Command: "sh",
+		Pty:     true,
+		OnRawOutput: func(stream OutputStream, data []byte) {
+			mu.Lock()
+			raw.Write(data)
+			mu.Unlock()
+		},
+	})
+
+	_, err := runningCmd.WriteString("echo hello-$((1+2))\n")
+	a.NoError(err)
+	waitForOutput(t, runningCmd, "hello-3")
+
+	_, err = runningCmd.WriteString("exit 7\n")
+	a.NoError(err)
+	a.Equal(7, runningCmd.Wait())
+
+	mu.Lock()
+	defer mu.Unlock()
+	a.Contains(raw.String(), "hello-3")
+	a.Equal(runningCmd.GetOutputString(), raw.String())
+}
+
+func TestRunningCommand_pty_resize(t *testing.T) {
+	a := require.New(t)
+
+	runningCmd := NewRunningCommand(&CommandOptionsT{
+		Command: "sh",
+		Pty:     true,
+		PtySize: WindowSize{Rows: 30, Cols: 90},
+	})
+	defer runningCmd.Kill()
+
+	_, err := runningCmd.WriteString("stty size\n")
+	a.NoError(err)
+	waitForOutput(t, runningCmd, "30 90")
+
+	a.NoError(runningCmd.Resize(40, 100))
+	_, err = runningCmd.WriteString("stty size\n")
+	a.NoError(err)
+	waitForOutput(t, runningCmd, "40 100")
+
+	_, err = runningCmd.WriteString("exit\n")
+	a.NoError(err)
+	runningCmd.Wait()
+
+	// 结束后伪终端已关闭
+	_, err = runningCmd.WriteString("echo\n")
+	a.Error(err)
+}
+
+func TestRunningCommand_pty_SubscribeRaw_replay(t *testing.T) {
+	a := require.New(t)
+
+	runningCmd := NewRunningCommand(&CommandOptionsT{
+		Command: "sh",
+		Args:    []string{"-c", "printf 'prompt> '"},
+		Pty:     true,
+	})
+	runningCmd.Wait()
+
+	var replayed []byte
+	unsubscribe := runningCmd.SubscribeRaw(func(stream OutputStream, data []byte) {
+		replayed = append(replayed, data...)
+	})
+	unsubscribe()
+	a.Equal("prompt> ", string(replayed))
+
+	// 不以换行结尾的内容在结束时作为最后一行
+	lines := runningCmd.GetLines()
+	a.Len(lines, 1)
+	a.Equal("prompt> ", lines[0].Text)
+}
+
+func TestRunningCommand_notPty(t *testing.T) {
+	a := require.New(t)
+
+	runningCmd := NewRunningCommand(&CommandOptionsT{
+		Command: "sh",
+		Args:    []string{"-c", "echo a; echo b 1>&2"},
+	})
+	runningCmd.Wait()
+
+	a.False(runningCmd.IsPty())
+	_, err := runningCmd.WriteString("x")
+	a.Error(err)
+	a.Error(runningCmd.Resize(10, 10))
+
+	var replayed []string
+	runningCmd.SubscribeRaw(func(stream OutputStream, data []byte) {
+		replayed = append(replayed, stream.String()+":"+string(data))
+	})()
+	a.ElementsMatch([]string{"stdout:a\n", "stderr:b\n"}, replayed)
+}
@@ -0,0 +1,21 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package qshell
+
+import (
+	"os"
+	"os/exec"
+
+	"github.com/pkg/errors"
+)
+
+// startPty 当前平台不支持伪终端
+func startPty(cmd *exec.Cmd, size WindowSize) (*os.File, error) {
+	return nil, errors.New("pty is not supported on this platform")
+}
+
+// resizePty 当前平台不支持伪终端
+func resizePty(master *os.File, size WindowSize) error {
+	return errors.New("pty is not supported on this platform")
+}
@@ -0,0 +1,64 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package qshell
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// startPty 在伪终端中启动命令，返回 master 端
+func startPty(cmd *exec.Cmd, size WindowSize) (*os.File, error) {
+	master, slave, err := openPty()
+	if err != nil {
+		return nil, err
+	}
+	defer slave.Close()
+
+	if err := resizePty(master, size); err != nil {
+		master.Close()
+		return nil, err
+	}
+
+	cmd.Stdin = slave
+	cmd.Stdout = slave
+	cmd.Stderr = slave
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	// 新建会话并把 slave（子进程的 fd 0）设为控制终端
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	cmd.SysProcAttr.Ctty = 0
+
+	if err := cmd.Start(); err != nil {
+		master.Close()
+		return nil, err
+	}
+	return master, nil
+}
+
+// resizePty 设置伪终端窗口大小
+func resizePty(master *os.File, size WindowSize) error {
+	conn, err := master.SyscallConn()
+	if err != nil {
+		return errors.Wrap(err, "resize pty")
+	}
+
+	var ioctlErr error
+	err = conn.Control(func(fd uintptr) {
+		ioctlErr = unix.IoctlSetWinsize(int(fd), unix.TIOCSWINSZ, &unix.Winsize{
+			Row: size.Rows,
+			Col: size.Cols,
+		})
+	})
+	if err == nil {
+		err = ioctlErr
+	}
+	return errors.Wrap(err, "resize pty")
+}
@@ -0,0 +1,552 @@
+package qshell
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/qiangyt/go-comm/v3/qerr"
+	"github.com/qiangyt/go-comm/v3/qio"
+	"github.com/qiangyt/go-comm/v3/qnet"
+	"github.com/spf13/afero"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// ============================================================
+// CommandRunner 命令执行器
+// ============================================================
+
+// CommandRunner 在本地或远程执行 shell 命令，调用方无需关心命令在哪里执行
+type CommandRunner interface {
+	// RunContext 执行命令并解析 stdout，非零退出时返回 ExitError，ctx 取消或超时时返回 CommandContextError
+	RunContext(ctx context.Context, vars map[string]string, dir string, cmd string) (CommandOutput, error)
+}
+
+// LocalRunnerT 在本机执行命令的 CommandRunner
+type LocalRunnerT struct {
+	// Shell 使用的 shell，为空或 "gosh" 时使用内置的 gosh 解释器
+	Shell string
+	// PasswordInput sudo 命令的密码输入
+	PasswordInput FnInput
+}
+
+// LocalRunner 是 LocalRunnerT 的指针别名
+type LocalRunner = *LocalRunnerT
+
+// NewLocalRunner 创建本地执行器
+func NewLocalRunner(sh string, passwordInput FnInput) LocalRunner {
+	return &LocalRunnerT{Shell: sh, PasswordInput: passwordInput}
+}
+
+// RunContext 实现 CommandRunner
+func (me LocalRunner) RunContext(ctx context.Context, vars map[string]string, dir string, cmd string) (CommandOutput, error) {
+	return RunShellCommandContext(ctx, vars, dir, me.Shell, cmd, me.PasswordInput)
+}
+
+// ============================================================
+// SSHRunner 通过 SSH 远程执行命令
+// ============================================================
+
+// SSHRunnerT 按 ~/.ssh/config 中的主机别名建立 SSH 连接
+//
+// 连接参数来自 qnet.SSHConfig.Resolve：HostName、User、Port、IdentityFile、ProxyJump、
+// ConnectTimeout，主机密钥按 StrictHostKeyChecking、UserKnownHostsFile、GlobalKnownHostsFile、
+// HashKnownHosts 和 HostKeyAlias 用 qnet.KnownHosts 校验。
+// 认证依次尝试 WithAuthMethods 追加的方式、SSH_AUTH_SOCK 指向的 agent 和 IdentityFile 中的私钥
+type SSHRunnerT struct {
+	config          *qnet.SSHConfig
+	hostKeyCallback ssh.HostKeyCallback
+	authMethods     []ssh.AuthMethod
+}
+
+// SSHRunner 是 SSHRunnerT 的指针别名
+type SSHRunner = *SSHRunnerT
+
+// NewSSHRunner 创建 SSH 执行器，config 为 nil 时主机名按字面解析
+func NewSSHRunner(config *qnet.SSHConfig) SSHRunner {
+	if config == nil {
+		config = qnet.NewSSHConfig()
+	}
+	return &SSHRunnerT{config: config}
+}
+
+// WithHostKeyCallback 设置主机密钥校验，覆盖 known_hosts 校验
+func (me SSHRunner) WithHostKeyCallback(callback ssh.HostKeyCallback) SSHRunner {
+	me.hostKeyCallback = callback
+	return me
+}
+
+// WithAuthMethods 追加认证方式，优先于 agent 和私钥文件
+func (me SSHRunner) WithAuthMethods(methods ...ssh.AuthMethod) SSHRunner {
+	me.authMethods = append(me.authMethods, methods...)
+	return me
+}
+
+// sshEndpoint 解析后的 SSH 连接目标
+type sshEndpoint struct {
+	alias  string
+	addr   string
+	user   string
+	config *qnet.SSHHostConfig
+	// knownHostsName 在 known_hosts 中查找主机密钥使用的名称
+	knownHostsName string
+}
+
+// resolve 解析 [user@]host[:port] 形式的目标，host 可以是 ssh config 中的别名
+func (me SSHRunner) resolve(dest string) (*sshEndpoint, error) {
+	_, username, alias, _, port, err := qnet.ParseSSHURL(dest)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse ssh destination '%s'", dest)
+	}
+
+	config, err := me.config.Resolve(alias)
+	if err != nil {
+		return nil, err
+	}
+
+	hostname := alias
+	if len(config.HostName) > 0 {
+		hostname = config.HostName
+	}
+	if len(username) == 0 {
+		username = config.User
+	}
+	// ParseSSHURL 无法区分显式的 22 端口，此时以配置为准
+	if port == 22 && config.Port > 0 {
+		port = config.Port
+	}
+	if len(username) == 0 {
+		username = sshLocalUsername()
+	}
+
+	// 与 ssh 相同，HostKeyAlias 不带端口
+	knownHostsName := qnet.KnownHostsName(hostname, port)
+	if len(config.HostKeyAlias) > 0 {
+		knownHostsName = config.HostKeyAlias
+	}
+
+	return &sshEndpoint{
+		alias:          alias,
+		addr:           net.JoinHostPort(hostname, strconv.Itoa(port)),
+		user:           username,
+		config:         config,
+		knownHostsName: knownHostsName,
+	}, nil
+}
+
+// sshLocalUsername 未配置 User 时使用的本地用户名
+func sshLocalUsername() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// clientConfig 生成连接 endpoint 的 ssh.ClientConfig
+func (me SSHRunner) clientConfig(endpoint *sshEndpoint, signers []ssh.Signer) (*ssh.ClientConfig, error) {
+	hostKeyCallback, hostKeyAlgorithms, err := me.hostKeyCallbackFor(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	auth := append([]ssh.AuthMethod{}, me.authMethods...)
+	keys := append(append([]ssh.Signer{}, signers...), sshIdentitySigners(endpoint.config)...)
+	if len(keys) > 0 {
+		auth = append(auth, ssh.PublicKeys(keys...))
+	}
+
+	r := &ssh.ClientConfig{
+		User:              endpoint.user,
+		Auth:              auth,
+		HostKeyCallback:   hostKeyCallback,
+		HostKeyAlgorithms: hostKeyAlgorithms,
+	}
+	if endpoint.config.ConnectTimeout > 0 {
+		r.Timeout = time.Duration(endpoint.config.ConnectTimeout) * time.Second
+	}
+	return r, nil
+}
+
+// hostKeyCallbackFor 按 StrictHostKeyChecking 校验主机密钥，accept-new 和 no 会把新主机的密钥
+// 写入第一个 UserKnownHostsFile；同时返回与 ssh 一致的主机密钥算法顺序，优先协商已记录的密钥类型
+func (me SSHRunner) hostKeyCallbackFor(endpoint *sshEndpoint) (ssh.HostKeyCallback, []string, error) {
+	if me.hostKeyCallback != nil {
+		return me.hostKeyCallback, nil, nil
+	}
+
+	config := endpoint.config
+	knownHosts, err := qnet.LoadKnownHostsForConfig(afero.NewOsFs(), config)
+	if err != nil {
+		return nil, nil, err
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		return knownHosts.CheckHostKey(endpoint.knownHostsName, key, config.StrictHostKeyCheck, config.HashKnownHosts)
+	}, knownHosts.HostKeyAlgorithms(endpoint.knownHostsName), nil
+}
+
+// sshIdentitySigners 读取 IdentityFile 中的私钥，无法读取或带口令的私钥被忽略
+func sshIdentitySigners(config *qnet.SSHHostConfig) []ssh.Signer {
+	r := make([]ssh.Signer, 0, len(config.IdentityFiles))
+	for _, f := range config.IdentityFiles {
+		path, err := qio.ExpandHomePath(f)
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			continue
+		}
+		r = append(r, signer)
+	}
+	return r
+}
+
+// sshAgentSigners 连接 SSH_AUTH_SOCK 指向的 agent，返回 agent 中的密钥和需要关闭的连接
+func sshAgentSigners() ([]ssh.Signer, net.Conn) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if len(sock) == 0 {
+		return nil, nil
+	}
+
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, nil
+	}
+	signers, err := agent.NewClient(conn).Signers()
+	if err != nil {
+		conn.Close()
+		return nil, nil
+	}
+	return signers, conn
+}
+
+// jumpHosts 返回 endpoint 的 ProxyJump 跳板列表，"none" 表示直连
+func (me *sshEndpoint) jumpHosts() []string {
+	if len(me.config.ProxyJump) == 0 || strings.EqualFold(me.config.ProxyJump, "none") {
+		return nil
+	}
+
+	r := []string{}
+	for _, jump := range strings.Split(me.config.ProxyJump, ",") {
+		if jump = strings.TrimSpace(jump); len(jump) > 0 {
+			r = append(r, jump)
+		}
+	}
+	return r
+}
+
+// DialP 是 Dial 的 panic 版本
+func (me SSHRunner) DialP(ctx context.Context, host string) SSHClient {
+	r, err := me.Dial(ctx, host)
+	if err != nil {
+		panic(qerr.NewSystemError(err.Error(), err))
+	}
+	return r
+}
+
+// Dial 连接主机，依次经过 ProxyJump 中的跳板
+// 跳板按各自的 ssh config 连接，但不再展开跳板自身的 ProxyJump
+func (me SSHRunner) Dial(ctx context.Context, host string) (SSHClient, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	target, err := me.resolve(host)
+	if err != nil {
+		return nil, err
+	}
+
+	hops := []*sshEndpoint{}
+	for _, jump := range target.jumpHosts() {
+		endpoint, err := me.resolve(jump)
+		if err != nil {
+			return nil, errors.Wrapf(err, "resolve ProxyJump of '%s'", host)
+		}
+		hops = append(hops, endpoint)
+	}
+	hops = append(hops, target)
+
+	signers, agentConn := sshAgentSigners()
+
+	r := &SSHClientT{Host: host, agentConn: agentConn, config: target.config}
+	for _, hop := range hops {
+		config, err := me.clientConfig(hop, signers)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+
+		client, err := r.dialHop(ctx, hop.addr, config)
+		if err != nil {
+			r.Close()
+			return nil, errors.Wrapf(err, "ssh connect to '%s' (%s@%s)", hop.alias, hop.user, hop.addr)
+		}
+		r.clients = append(r.clients, client)
+	}
+	return r, nil
+}
+
+// RunContextP 是 RunContext 的 panic 版本
+func (me SSHRunner) RunContextP(ctx context.Context, host string, vars map[string]string, dir string, cmd string) CommandOutput {
+	r, err := me.RunContext(ctx, host, vars, dir, cmd)
+	if err != nil {
+		panic(qerr.NewSystemError(err.Error(), err))
+	}
+	return r
+}
+
+// RunContext 连接主机执行一条命令后断开，多条命令应复用 Dial 返回的 SSHClient
+func (me SSHRunner) RunContext(ctx context.Context, host string, vars map[string]string, dir string, cmd string) (CommandOutput, error) {
+	client, err := me.Dial(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	return client.RunContext(ctx, vars, dir, cmd)
+}
+
+// Runner 返回在指定主机上执行命令的 CommandRunner，每次执行建立新连接
+func (me SSHRunner) Runner(host string) CommandRunner {
+	return sshHostRunner{runner: me, host: host}
+}
+
+// sshHostRunner 绑定主机的 CommandRunner
+type sshHostRunner struct {
+	runner SSHRunner
+	host   string
+}
+
+func (me sshHostRunner) RunContext(ctx context.Context, vars map[string]string, dir string, cmd string) (CommandOutput, error) {
+	return me.runner.RunContext(ctx, me.host, vars, dir, cmd)
+}
+
+// ============================================================
+// SSHClient 已建立的 SSH 连接
+// ============================================================
+
+// SSHClientT 到远程主机的连接，实现 CommandRunner，可以执行多条命令
+type SSHClientT struct {
+	// Host 连接时使用的主机名或别名
+	Host string
+
+	// clients 依次为各跳板和目标主机的连接，最后一个是目标主机
+	clients   []*ssh.Client
+	agentConn net.Conn
+	// config 目标主机的配置
+	config *qnet.SSHHostConfig
+}
+
+// SSHClient 是 SSHClientT 的指针别名
+type SSHClient = *SSHClientT
+
+// Client 返回到目标主机的底层连接
+func (me SSHClient) Client() *ssh.Client {
+	return me.clients[len(me.clients)-1]
+}
+
+// dialHop 建立一跳连接：第一跳直连，之后经由上一跳转发
+func (me SSHClient) dialHop(ctx context.Context, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	var conn net.Conn
+	var err error
+	if len(me.clients) == 0 {
+		dialer := net.Dialer{Timeout: config.Timeout}
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = me.clients[len(me.clients)-1].DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 握手不支持 ctx，取消时关闭连接使握手失败
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	if config.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(config.Timeout))
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if !stop() {
+		if err == nil {
+			c.Close()
+		}
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// KeepAlive 发送 keepalive@openssh.com 确认连接存活，服务器拒绝该请求同样说明连接正常
+func (me SSHClient) KeepAlive(ctx context.Context) error {
+	return sshKeepAlive(ctx, me.Client())
+}
+
+func sshKeepAlive(ctx context.Context, conn *ssh.Client) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := conn.SendRequest("keepalive@openssh.com", true, nil)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close 关闭目标主机和所有跳板的连接
+func (me SSHClient) Close() error {
+	var r error
+	for i := len(me.clients) - 1; i >= 0; i-- {
+		if err := me.clients[i].Close(); err != nil && r == nil {
+			r = err
+		}
+	}
+	me.clients = nil
+
+	if me.agentConn != nil {
+		me.agentConn.Close()
+		me.agentConn = nil
+	}
+	return r
+}
+
+// RunContextP 是 RunContext 的 panic 版本
+func (me SSHClient) RunContextP(ctx context.Context, vars map[string]string, dir string, cmd string) CommandOutput {
+	r, err := me.RunContext(ctx, vars, dir, cmd)
+	if err != nil {
+		panic(qerr.NewSystemError(err.Error(), err))
+	}
+	return r
+}
+
+// RunContext 在远程主机的登录 shell 中执行命令，实现 CommandRunner
+// vars 以 export 语句传递（多数 sshd 拒绝 env 请求），dir 非空时先切换目录
+func (me SSHClient) RunContext(ctx context.Context, vars map[string]string, dir string, cmd string) (CommandOutput, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	commandLine, err := sshCommandLine(vars, dir, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := me.Client().NewSession()
+	if err != nil {
+		return nil, errors.Wrapf(err, "open ssh session to '%s'", me.Host)
+	}
+	defer session.Close()
+
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+
+	result := newCommandResult(cmd)
+	err = session.Start(commandLine)
+	if err != nil {
+		return nil, errors.Wrapf(err, "start command '%s' on '%s'", cmd, me.Host)
+	}
+
+	// 远程进程收不到本地的 SIGKILL，先发送信号，再关闭会话让 Wait 返回
+	stop := context.AfterFunc(ctx, func() {
+		_ = session.Signal(ssh.SIGKILL)
+		session.Close()
+	})
+	err = session.Wait()
+	stop()
+
+	result.EndTime = time.Now()
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+
+	if ctx.Err() != nil {
+		return nil, newCommandContextError(cmd, result.Stdout, ctx.Err())
+	}
+	if err != nil {
+		var exitErr *ssh.ExitError
+		if errors.As(err, &exitErr) {
+			result.ExitCode = exitErr.ExitStatus()
+			if len(exitErr.Signal()) > 0 {
+				result.ExitCode = -1
+				result.Signal = SSHSignal(exitErr.Signal())
+			}
+			return nil, newExitError(result)
+		}
+		return nil, errors.Wrapf(err, "get output for command '%s' on '%s'", cmd, me.Host)
+	}
+
+	r, err := ParseCommandOutput(result.Stdout)
+	if err != nil {
+		return nil, err
+	}
+	r.Result = result
+	return r, nil
+}
+
+// sshVarNamePattern 合法的 shell 变量名
+var sshVarNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// sshCommandLine 生成远程执行的命令行，变量名不合法时返回错误，避免变量名被远程 shell 当作命令执行
+func sshCommandLine(vars map[string]string, dir string, cmd string) (string, error) {
+	var b strings.Builder
+
+	names := make([]string, 0, len(vars))
+	for k := range vars {
+		if !sshVarNamePattern.MatchString(k) {
+			return "", errors.Errorf("invalid environment variable name: '%s'", k)
+		}
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		fmt.Fprintf(&b, "export %s=%s; ", k, sshQuote(vars[k]))
+	}
+
+	if len(dir) > 0 {
+		// cmd 可能由多条语句组成，cd 失败时整个命令都不能执行
+		fmt.Fprintf(&b, "cd %s || exit 1; ", sshQuote(filepath.ToSlash(dir)))
+	}
+	b.WriteString(cmd)
+	return b.String(), nil
+}
+
+// sshQuote 用单引号转义 POSIX shell 参数
+func sshQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// SSHSignal 远程进程的终止信号，名称不含 SIG 前缀，如 "KILL"
+type SSHSignal string
+
+// Signal 实现 os.Signal
+func (me SSHSignal) Signal() {}
+
+// String 实现 os.Signal
+func (me SSHSignal) String() string {
+	return "SIG" + string(me)
+}
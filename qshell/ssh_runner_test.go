@@ -0,0 +1,629 @@
+package qshell
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/qiangyt/go-comm/v3/qnet"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// ============================================================
+// testSSHServer 测试用的 sshd 替身：exec 请求交给本机 sh 执行，支持 direct-tcpip 和 tcpip-forward 转发
+// ============================================================
+
+type testSSHServer struct {
+	t        *testing.T
+	listener net.Listener
+	hostKey  ssh.Signer
+	forwards atomic.Int32
+	wg       sync.WaitGroup
+}
+
+// startTestSSHServer 启动测试 sshd，主机密钥为 ed25519，extraHostKeys 为额外提供的主机密钥
+func startTestSSHServer(t *testing.T, authorized ssh.PublicKey, extraHostKeys ...ssh.Signer) *testSSHServer {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	hostKey, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == "tester" && string(key.Marshal()) == string(authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unauthorized key for %s", conn.User())
+		},
+	}
+	config.AddHostKey(hostKey)
+	for _, k := range extraHostKeys {
+		config.AddHostKey(k)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	r := &testSSHServer{t: t, listener: listener, hostKey: hostKey}
+	t.Cleanup(func() {
+		listener.Close()
+		r.wg.Wait()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			r.wg.Add(1)
+			go func() {
+				defer r.wg.Done()
+				r.serve(conn, config)
+			}()
+		}
+	}()
+	return r
+}
+
+func (me *testSSHServer) addr() string {
+	return me.listener.Addr().String()
+}
+
+func (me *testSSHServer) port() int {
+	return me.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (me *testSSHServer) serve(conn net.Conn, config *ssh.ServerConfig) {
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer sconn.Close()
+	go me.serveGlobalRequests(sconn, reqs)
+
+	for ch := range chans {
+		switch ch.ChannelType() {
+		case "session":
+			go me.serveSession(ch)
+		case "direct-tcpip":
+			go me.serveForward(ch)
+		default:
+			ch.Reject(ssh.UnknownChannelType, "unsupported")
+		}
+	}
+}
+
+func (me *testSSHServer) serveSession(newCh ssh.NewChannel) {
+	ch, reqs, err := newCh.Accept()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+
+	var cmd *exec.Cmd
+	done := make(chan error, 1)
+	for {
+		select {
+		case req, ok := <-reqs:
+			if !ok {
+				if cmd != nil {
+					killProcessGroup(cmd)
+				}
+				return
+			}
+			switch req.Type {
+			case "exec":
+				var payload struct{ Command string }
+				ssh.Unmarshal(req.Payload, &payload)
+				cmd = exec.Command("sh", "-c", payload.Command)
+				setProcessGroup(cmd)
+				cmd.Stdout = ch
+				cmd.Stderr = ch.Stderr()
+				if err := cmd.Start(); err != nil {
+					req.Reply(false, nil)
+					return
+				}
+				req.Reply(true, nil)
+				go func() { done <- cmd.Wait() }()
+			case "signal":
+				if cmd != nil {
+					killProcessGroup(cmd)
+				}
+			default:
+				req.Reply(false, nil)
+			}
+		case <-done:
+			status := cmd.ProcessState.Sys().(syscall.WaitStatus)
+			if status.Signaled() {
+				ch.SendRequest("exit-signal", false, ssh.Marshal(struct {
+					Signal     string
+					CoreDumped bool
+					Error      string
+					Lang       string
+				}{Signal: "KILL"}))
+			} else {
+				ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status.ExitStatus())}))
+			}
+			return
+		}
+	}
+}
+
+// serveGlobalRequests 处理 tcpip-forward：在本机监听，把连接以 forwarded-tcpip 通道交给客户端
+func (me *testSSHServer) serveGlobalRequests(sconn *ssh.ServerConn, reqs <-chan *ssh.Request) {
+	listeners := map[string]net.Listener{}
+	defer func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}()
+
+	for req := range reqs {
+		var payload struct {
+			Addr string
+			Port uint32
+		}
+		switch req.Type {
+		case "tcpip-forward":
+			ssh.Unmarshal(req.Payload, &payload)
+			l, err := net.Listen("tcp", net.JoinHostPort(payload.Addr, strconv.Itoa(int(payload.Port))))
+			if err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			port := uint32(l.Addr().(*net.TCPAddr).Port)
+			listeners[net.JoinHostPort(payload.Addr, strconv.Itoa(int(port)))] = l
+			req.Reply(true, ssh.Marshal(struct{ Port uint32 }{port}))
+			go me.acceptForwarded(sconn, l, payload.Addr, port)
+		case "cancel-tcpip-forward":
+			ssh.Unmarshal(req.Payload, &payload)
+			key := net.JoinHostPort(payload.Addr, strconv.Itoa(int(payload.Port)))
+			if l, ok := listeners[key]; ok {
+				l.Close()
+				delete(listeners, key)
+			}
+			req.Reply(true, nil)
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+func (me *testSSHServer) acceptForwarded(sconn *ssh.ServerConn, l net.Listener, addr string, port uint32) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		origin := conn.RemoteAddr().(*net.TCPAddr)
+		ch, reqs, err := sconn.OpenChannel("forwarded-tcpip", ssh.Marshal(struct {
+			Addr       string
+			Port       uint32
+			OriginAddr string
+			OriginPort uint32
+		}{addr, port, origin.IP.String(), uint32(origin.Port)}))
+		if err != nil {
+			conn.Close()
+			continue
+		}
+		go ssh.DiscardRequests(reqs)
+		go func() {
+			io.Copy(ch, conn)
+			ch.CloseWrite()
+		}()
+		go func() {
+			io.Copy(conn, ch)
+			conn.Close()
+			ch.Close()
+		}()
+	}
+}
+
+func (me *testSSHServer) serveForward(newCh ssh.NewChannel) {
+	var payload struct {
+		Host     string
+		Port     uint32
+		OrigHost string
+		OrigPort uint32
+	}
+	ssh.Unmarshal(newCh.ExtraData(), &payload)
+
+	target, err := net.Dial("tcp", net.JoinHostPort(payload.Host, strconv.Itoa(int(payload.Port))))
+	if err != nil {
+		newCh.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	ch, reqs, err := newCh.Accept()
+	if err != nil {
+		target.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	me.forwards.Add(1)
+
+	go func() {
+		io.Copy(ch, target)
+		ch.CloseWrite()
+	}()
+	io.Copy(target, ch)
+	target.Close()
+	ch.Close()
+}
+
+// ============================================================
+// 测试辅助
+// ============================================================
+
+// sshTestKey 生成客户端密钥并写入私钥文件
+func sshTestKey(t *testing.T, dir string) (ed25519.PrivateKey, ssh.PublicKey, string) {
+	t.Helper()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	sshPub, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	require.NoError(t, err)
+	keyFile := filepath.Join(dir, "id_test")
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(block), 0o600))
+	return priv, sshPub, keyFile
+}
+
+// sshTestKnownHosts 写入包含各服务器主机密钥的 known_hosts
+func sshTestKnownHosts(t *testing.T, dir string, servers ...*testSSHServer) string {
+	t.Helper()
+
+	var content string
+	for _, s := range servers {
+		entry := &qnet.KnownHostsEntry{Hosts: []string{qnet.KnownHostsName("127.0.0.1", s.port())}, Key: s.hostKey.PublicKey()}
+		content += entry.String() + "\n"
+	}
+	r := filepath.Join(dir, "known_hosts")
+	require.NoError(t, os.WriteFile(r, []byte(content), 0o600))
+	return r
+}
+
+// sshTestConfig 写入并加载 ssh config
+func sshTestConfig(t *testing.T, dir string, content string) *qnet.SSHConfig {
+	t.Helper()
+
+	path := filepath.Join(dir, "config")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	r, err := qnet.LoadSSHConfigFromFile(path)
+	require.NoError(t, err)
+	return r
+}
+
+func skipSSHTestOnWindows(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("测试 sshd 使用 sh 执行命令")
+	}
+}
+
+// ============================================================
+// 测试
+// ============================================================
+
+func TestSSHRunner_proxyJump(t *testing.T) {
+	a := require.New(t)
+	skipSSHTestOnWindows(t)
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	dir := t.TempDir()
+	_, pub, keyFile := sshTestKey(t, dir)
+	jump := startTestSSHServer(t, pub)
+	target := startTestSSHServer(t, pub)
+	knownHosts := sshTestKnownHosts(t, dir, jump, target)
+
+	config := sshTestConfig(t, dir, fmt.Sprintf(`
+Host app
+  HostName 127.0.0.1
+  Port %d
+  User tester
+  IdentityFile %s
+  UserKnownHostsFile %s
+  ProxyJump bastion
+
+Host bastion
+  HostName 127.0.0.1
+  Port %d
+  User tester
+  IdentityFile %s
+  UserKnownHostsFile %s
+`, target.port(), keyFile, knownHosts, jump.port(), keyFile, knownHosts))
+
+	runner := NewSSHRunner(config)
+	output, err := runner.RunContext(context.Background(), "app", map[string]string{"GREETING": "it's ok"}, dir, "echo \"$GREETING\"; pwd")
+	a.NoError(err)
+	a.Equal(COMMAND_OUTPUT_KIND_TEXT, output.Kind)
+	realDir, _ := filepath.EvalSymlinks(dir)
+	a.Equal("it's ok\n"+realDir+"\n", output.Text)
+	a.Equal(0, output.Result.ExitCode)
+	a.Equal(int32(1), jump.forwards.Load())
+
+	// 与本地执行相同的输出解析
+	client := runner.DialP(context.Background(), "app")
+	defer client.Close()
+
+	var cr CommandRunner = client
+	output, err = cr.RunContext(context.Background(), nil, "", "printf '$vars$\\n\\nA=1\\nB=2\\n'")
+	a.NoError(err)
+	a.Equal(COMMAND_OUTPUT_KIND_VARS, output.Kind)
+	a.Equal(map[string]string{"A": "1", "B": "2"}, output.Vars)
+
+	_, err = client.RunContext(context.Background(), nil, "", "echo failed >&2; exit 3")
+	exitErr := GetExitError(err)
+	a.NotNil(exitErr, "%v", err)
+	a.Equal(3, exitErr.ExitCode())
+	a.Equal("failed\n", exitErr.Result.Stderr)
+	a.Contains(err.Error(), "exited with status 3: failed")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = client.RunContext(ctx, nil, "", "echo started; sleep 10")
+	ctxErr := GetCommandContextError(err)
+	a.NotNil(ctxErr, "%v", err)
+	a.True(ctxErr.IsDeadlineExceeded())
+	a.Less(time.Since(start), 5*time.Second)
+}
+
+func TestSSHRunner_agentAuth(t *testing.T) {
+	a := require.New(t)
+	skipSSHTestOnWindows(t)
+
+	dir := t.TempDir()
+	priv, pub, _ := sshTestKey(t, dir)
+	server := startTestSSHServer(t, pub)
+
+	keyring := agent.NewKeyring()
+	a.NoError(keyring.Add(agent.AddedKey{PrivateKey: priv}))
+	sock := filepath.Join(dir, "agent.sock")
+	listener, err := net.Listen("unix", sock)
+	a.NoError(err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				agent.ServeAgent(keyring, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", sock)
+
+	// 不在 ssh config 中的主机按 user@host:port 解析
+	runner := NewSSHRunner(nil).WithHostKeyCallback(ssh.FixedHostKey(server.hostKey.PublicKey()))
+	output, err := runner.Runner("tester@"+server.addr()).RunContext(context.Background(), nil, "", "echo hello")
+	a.NoError(err)
+	a.Equal("hello\n", output.Text)
+
+	_, err = runner.RunContext(context.Background(), "nobody@"+server.addr(), nil, "", "echo hello")
+	a.Error(err)
+	a.Contains(err.Error(), "unable to authenticate")
+}
+
+func TestSSHRunner_hostKey(t *testing.T) {
+	a := require.New(t)
+	skipSSHTestOnWindows(t)
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	dir := t.TempDir()
+	_, pub, keyFile := sshTestKey(t, dir)
+	server := startTestSSHServer(t, pub)
+	other := startTestSSHServer(t, pub)
+	emptyKnownHosts := sshTestKnownHosts(t, t.TempDir())
+
+	config := sshTestConfig(t, dir, fmt.Sprintf(`
+Host unknown
+  HostName 127.0.0.1
+  Port %d
+  User tester
+  IdentityFile %s
+  UserKnownHostsFile %s
+
+Host insecure
+  HostName 127.0.0.1
+  Port %d
+  User tester
+  IdentityFile %s
+  UserKnownHostsFile %s
+  StrictHostKeyChecking no
+`, server.port(), keyFile, emptyKnownHosts, other.port(), keyFile, emptyKnownHosts))
+
+	runner := NewSSHRunner(config)
+	_, err := runner.RunContext(context.Background(), "unknown", nil, "", "echo hello")
+	a.Error(err)
+	a.Contains(err.Error(), "is unknown")
+
+	output, err := runner.RunContext(context.Background(), "insecure", nil, "", "echo hello")
+	a.NoError(err)
+	a.Equal("hello\n", output.Text)
+}
+
+func TestSSHRunner_acceptNewHostKey(t *testing.T) {
+	a := require.New(t)
+	skipSSHTestOnWindows(t)
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	dir := t.TempDir()
+	_, pub, keyFile := sshTestKey(t, dir)
+	server := startTestSSHServer(t, pub)
+	knownHosts := filepath.Join(dir, "hosts", "known_hosts")
+
+	config := sshTestConfig(t, dir, fmt.Sprintf(`
+Host app
+  HostName 127.0.0.1
+  Port %d
+  User tester
+  IdentityFile %s
+  UserKnownHostsFile %s
+  StrictHostKeyChecking accept-new
+  HostKeyAlias app-alias
+  HashKnownHosts yes
+`, server.port(), keyFile, knownHosts))
+
+	// 首次连接记录主机密钥，再次连接按记录校验
+	runner := NewSSHRunner(config)
+	_, err := runner.RunContext(context.Background(), "app", nil, "", "true")
+	a.NoError(err)
+
+	recorded, err := qnet.LoadKnownHosts(afero.NewOsFs(), knownHosts)
+	a.NoError(err)
+	entries := recorded.Lookup("app-alias")
+	a.Len(entries, 1)
+	a.True(strings.HasPrefix(entries[0].Hosts[0], "|1|"))
+
+	_, err = runner.RunContext(context.Background(), "app", nil, "", "true")
+	a.NoError(err)
+
+	// 主机密钥变化时拒绝连接
+	other := startTestSSHServer(t, pub)
+	config = sshTestConfig(t, dir, fmt.Sprintf(`
+Host app
+  HostName 127.0.0.1
+  Port %d
+  User tester
+  IdentityFile %s
+  UserKnownHostsFile %s
+  StrictHostKeyChecking accept-new
+  HostKeyAlias app-alias
+`, other.port(), keyFile, knownHosts))
+
+	_, err = NewSSHRunner(config).RunContext(context.Background(), "app", nil, "", "true")
+	a.Error(err)
+	a.Contains(err.Error(), "has changed")
+}
+
+func TestSSHRunner_hostKeyOfOtherType(t *testing.T) {
+	a := require.New(t)
+	skipSSHTestOnWindows(t)
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	dir := t.TempDir()
+	_, pub, keyFile := sshTestKey(t, dir)
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	a.NoError(err)
+	rsaHostKey, err := ssh.NewSignerFromKey(priv)
+	a.NoError(err)
+	both := startTestSSHServer(t, pub, rsaHostKey)
+	ed25519Only := startTestSSHServer(t, pub)
+
+	run := func(server *testSSHServer, known ssh.PublicKey, strict string) error {
+		knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+		entry := &qnet.KnownHostsEntry{Hosts: []string{qnet.KnownHostsName("127.0.0.1", server.port())}, Key: known}
+		a.NoError(os.WriteFile(knownHosts, []byte(entry.String()+"\n"), 0o600))
+
+		config := sshTestConfig(t, dir, fmt.Sprintf(`
+Host app
+  HostName 127.0.0.1
+  Port %d
+  User tester
+  IdentityFile %s
+  UserKnownHostsFile %s
+  StrictHostKeyChecking %s
+`, server.port(), keyFile, knownHosts, strict))
+		_, err := NewSSHRunner(config).RunContext(context.Background(), "app", nil, "", "true")
+		return err
+	}
+
+	// 服务器同时提供 ed25519 和 ssh-rsa 密钥时，协商 known_hosts 中已记录的类型
+	a.NoError(run(both, rsaHostKey.PublicKey(), "yes"))
+	a.NoError(run(both, both.hostKey.PublicKey(), "yes"))
+
+	// 只记录了 ssh-rsa 密钥而服务器只提供 ed25519 密钥时，视为未知主机而不是密钥变化
+	err = run(ed25519Only, rsaHostKey.PublicKey(), "yes")
+	a.Error(err)
+	a.Contains(err.Error(), "is unknown")
+	a.NoError(run(ed25519Only, rsaHostKey.PublicKey(), "accept-new"))
+}
+
+func TestSSHRunner_invalidVarName(t *testing.T) {
+	a := require.New(t)
+	skipSSHTestOnWindows(t)
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	dir := t.TempDir()
+	_, pub, keyFile := sshTestKey(t, dir)
+	server := startTestSSHServer(t, pub)
+	knownHosts := sshTestKnownHosts(t, dir, server)
+	config := sshTestConfig(t, dir, fmt.Sprintf(`
+Host app
+  HostName 127.0.0.1
+  Port %d
+  User tester
+  IdentityFile %s
+  UserKnownHostsFile %s
+`, server.port(), keyFile, knownHosts))
+
+	// 变量名中的命令不会在远程主机执行
+	marker := filepath.Join(dir, "injected")
+	vars := map[string]string{"X=1; touch " + marker + "; Y": "v"}
+	_, err := NewSSHRunner(config).RunContext(context.Background(), "app", vars, "", "true")
+	a.Error(err)
+	a.Contains(err.Error(), "invalid environment variable name")
+	a.NoFileExists(marker)
+}
+
+func TestSSHCommandLine(t *testing.T) {
+	a := require.New(t)
+
+	line, err := sshCommandLine(nil, "", "ls")
+	a.NoError(err)
+	a.Equal("ls", line)
+	line, err = sshCommandLine(map[string]string{"B": "it's", "A_1": "1"}, "/my dir", "ls")
+	a.NoError(err)
+	a.Equal(`export A_1='1'; export B='it'\''s'; cd '/my dir' || exit 1; ls`, line)
+
+	// 目录不存在时多条语句都不执行
+	if runtime.GOOS != "windows" {
+		marker := filepath.Join(t.TempDir(), "marker")
+		line, err = sshCommandLine(nil, "/no/such/dir", "true; touch "+sshQuote(marker))
+		a.NoError(err)
+		err = exec.Command("/bin/sh", "-c", line).Run()
+		a.Error(err)
+		a.NoFileExists(marker)
+	}
+
+	for _, name := range []string{"X=1; rm -rf ~; Y", "1A", "A-B", "", "A B", "$(id)"} {
+		_, err = sshCommandLine(map[string]string{name: "v"}, "", "ls")
+		a.Error(err, name)
+	}
+}
+
+func TestLocalRunner(t *testing.T) {
+	a := require.New(t)
+
+	var runner CommandRunner = NewLocalRunner("gosh", nil)
+	output, err := runner.RunContext(context.Background(), map[string]string{"X": "1"}, "", "echo $X")
+	a.NoError(err)
+	a.Equal("1\n", output.Text)
+}
@@ -0,0 +1,670 @@
+package qshell
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/qiangyt/go-comm/v3/qerr"
+	"github.com/qiangyt/go-comm/v3/qnet"
+	"golang.org/x/crypto/ssh"
+)
+
+// ============================================================
+// SSHForward 端口转发规格
+// ============================================================
+
+// SSHForwardKind 转发类型
+type SSHForwardKind string
+
+const (
+	// SSH_FORWARD_LOCAL 本地监听，经 SSH 连接到远端可达的目标，对应 LocalForward
+	SSH_FORWARD_LOCAL SSHForwardKind = "local"
+	// SSH_FORWARD_REMOTE 远端监听，连接回本地可达的目标，对应 RemoteForward
+	SSH_FORWARD_REMOTE SSHForwardKind = "remote"
+	// SSH_FORWARD_DYNAMIC 本地 SOCKS5 代理，经 SSH 连接请求的目标，对应 DynamicForward
+	SSH_FORWARD_DYNAMIC SSHForwardKind = "dynamic"
+	// SSH_FORWARD_REMOTE_DYNAMIC 远端 SOCKS5 代理，从本地连接请求的目标，对应不带目标的 RemoteForward
+	SSH_FORWARD_REMOTE_DYNAMIC SSHForwardKind = "remote-dynamic"
+)
+
+// SSHForwardT 一个转发：监听地址和目标地址，network 为 "tcp" 或 "unix"
+type SSHForwardT struct {
+	Kind SSHForwardKind
+
+	ListenNetwork string
+	ListenAddress string
+
+	// Target* 对 SOCKS 转发为空，目标由客户端请求
+	TargetNetwork string
+	TargetAddress string
+}
+
+// SSHForward 是 SSHForwardT 的指针别名
+type SSHForward = *SSHForwardT
+
+// String 返回 ssh 风格的描述，如 "local 127.0.0.1:8080 -> db:5432"
+func (me SSHForward) String() string {
+	if len(me.TargetAddress) == 0 {
+		return fmt.Sprintf("%s %s", me.Kind, me.ListenAddress)
+	}
+	return fmt.Sprintf("%s %s -> %s", me.Kind, me.ListenAddress, me.TargetAddress)
+}
+
+// ParseSSHForwardP 是 ParseSSHForward 的 panic 版本
+func ParseSSHForwardP(kind SSHForwardKind, args ...string) SSHForward {
+	r, err := ParseSSHForward(kind, args...)
+	if err != nil {
+		panic(qerr.NewSystemError(err.Error(), err))
+	}
+	return r
+}
+
+// ParseSSHForward 按 ssh config 的语法解析转发，如 ParseSSHForward(SSH_FORWARD_LOCAL, "5432", "db:5432")，
+// SSH_FORWARD_REMOTE 只有一个参数时是远端 SOCKS 代理
+func ParseSSHForward(kind SSHForwardKind, args ...string) (SSHForward, error) {
+	keyword := map[SSHForwardKind]string{
+		SSH_FORWARD_LOCAL:          "LocalForward",
+		SSH_FORWARD_REMOTE:         "RemoteForward",
+		SSH_FORWARD_REMOTE_DYNAMIC: "RemoteForward",
+		SSH_FORWARD_DYNAMIC:        "DynamicForward",
+	}[kind]
+	if len(keyword) == 0 {
+		return nil, errors.Errorf("unknown forward kind: %s", kind)
+	}
+
+	spec, err := qnet.NormalizeSSHForward(keyword, args...)
+	if err != nil {
+		return nil, err
+	}
+	if kind == SSH_FORWARD_REMOTE_DYNAMIC {
+		kind = SSH_FORWARD_REMOTE
+	}
+	return parseSSHForwardSpec(kind, spec)
+}
+
+// SSHForwardsOf 返回主机配置中的 LocalForward、RemoteForward 和 DynamicForward
+func SSHForwardsOf(config *qnet.SSHHostConfig) ([]SSHForward, error) {
+	r := []SSHForward{}
+	add := func(kind SSHForwardKind, specs []string) error {
+		for _, spec := range specs {
+			f, err := parseSSHForwardSpec(kind, spec)
+			if err != nil {
+				return err
+			}
+			r = append(r, f)
+		}
+		return nil
+	}
+
+	if err := add(SSH_FORWARD_LOCAL, config.LocalForward); err != nil {
+		return nil, err
+	}
+	if err := add(SSH_FORWARD_REMOTE, config.RemoteForward); err != nil {
+		return nil, err
+	}
+	if err := add(SSH_FORWARD_DYNAMIC, config.DynamicForward); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// parseSSHForwardSpec 解析 qnet.SSHHostConfig 中规范化的转发，如 "8080 [localhost]:80"、
+// "/tmp/db.sock [db]:5432" 或 "[127.0.0.1]:1080"，远端 SOCKS 代理的目标是 "[socks]:0"
+func parseSSHForwardSpec(kind SSHForwardKind, spec string) (SSHForward, error) {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 || len(fields) > 2 || (kind == SSH_FORWARD_DYNAMIC) != (len(fields) == 1) {
+		return nil, errors.Errorf("bad %s forward specification '%s'", kind, spec)
+	}
+
+	r := &SSHForwardT{Kind: kind}
+	var err error
+	r.ListenNetwork, r.ListenAddress, err = parseSSHForwardAddress(fields[0], true)
+	if err != nil {
+		return nil, errors.Wrapf(err, "bad %s forward specification '%s'", kind, spec)
+	}
+
+	if len(fields) == 2 {
+		if kind == SSH_FORWARD_REMOTE && fields[1] == "[socks]:0" {
+			r.Kind = SSH_FORWARD_REMOTE_DYNAMIC
+			return r, nil
+		}
+		r.TargetNetwork, r.TargetAddress, err = parseSSHForwardAddress(fields[1], false)
+		if err != nil {
+			return nil, errors.Wrapf(err, "bad %s forward specification '%s'", kind, spec)
+		}
+	}
+	return r, nil
+}
+
+// parseSSHForwardAddress 解析 "port"、"[host]:port" 或 socket 路径；
+// 只有端口的监听地址绑定到回环地址，"*" 表示所有地址
+func parseSSHForwardAddress(s string, listen bool) (string, string, error) {
+	if !strings.HasPrefix(s, "[") {
+		if _, err := strconv.Atoi(s); err == nil && listen {
+			return "tcp", net.JoinHostPort("127.0.0.1", s), nil
+		}
+		if strings.Contains(s, "/") {
+			return "unix", s, nil
+		}
+		return "", "", errors.Errorf("bad address '%s'", s)
+	}
+
+	end := strings.LastIndex(s, "]:")
+	if end < 0 {
+		return "", "", errors.Errorf("bad address '%s'", s)
+	}
+	host, port := s[1:end], s[end+2:]
+	if listen && host == "*" {
+		host = ""
+	}
+	return "tcp", net.JoinHostPort(host, port), nil
+}
+
+// ============================================================
+// SSHTunnel 一个已打开的转发
+// ============================================================
+
+// SSHTunnelState 转发状态
+type SSHTunnelState string
+
+const (
+	SSH_TUNNEL_OPEN   SSHTunnelState = "open"
+	SSH_TUNNEL_FAILED SSHTunnelState = "failed"
+	SSH_TUNNEL_CLOSED SSHTunnelState = "closed"
+)
+
+// SSHTunnelStatus 转发的健康状况
+type SSHTunnelStatus struct {
+	Forward SSHForward
+	State   SSHTunnelState
+	// Addr 实际监听的地址，监听端口为 0 时可以从这里得到分配的端口
+	Addr string
+	// Err 转发无法打开或被关闭的原因
+	Err error
+
+	// Active 当前连接数，Total 已接受的连接数，Failed 无法连接到目标的连接数
+	Active int64
+	Total  int64
+	Failed int64
+	// LastError 最近一次连接目标失败的原因
+	LastError error
+
+	BytesSent     int64
+	BytesReceived int64
+}
+
+// SSHTunnelT 一个转发的监听器和计数
+type SSHTunnelT struct {
+	Forward SSHForward
+
+	listener net.Listener
+	dial     func(network, addr string) (net.Conn, error)
+
+	mutex     sync.Mutex
+	state     SSHTunnelState
+	err       error
+	lastError error
+
+	active, total, failed atomic.Int64
+	sent, received        atomic.Int64
+	conns                 sync.WaitGroup
+	// open 正在转发的连接，关闭转发时一并关闭
+	open map[net.Conn]struct{}
+}
+
+// SSHTunnel 是 SSHTunnelT 的指针别名
+type SSHTunnel = *SSHTunnelT
+
+// Addr 实际监听的地址，转发未打开时为空
+func (me SSHTunnel) Addr() string {
+	if me.listener == nil {
+		return ""
+	}
+	return me.listener.Addr().String()
+}
+
+// Status 返回转发的健康状况
+func (me SSHTunnel) Status() SSHTunnelStatus {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+
+	return SSHTunnelStatus{
+		Forward:       me.Forward,
+		State:         me.state,
+		Addr:          me.Addr(),
+		Err:           me.err,
+		Active:        me.active.Load(),
+		Total:         me.total.Load(),
+		Failed:        me.failed.Load(),
+		LastError:     me.lastError,
+		BytesSent:     me.sent.Load(),
+		BytesReceived: me.received.Load(),
+	}
+}
+
+// serve 接受连接直到监听器关闭，调用前 conns 已为 serve 自身计数
+func (me SSHTunnel) serve() {
+	defer me.conns.Done()
+	for {
+		conn, err := me.listener.Accept()
+		if err != nil {
+			me.close(nil)
+			return
+		}
+
+		me.total.Add(1)
+		me.active.Add(1)
+		me.conns.Add(1)
+		go func() {
+			defer me.conns.Done()
+			defer me.active.Add(-1)
+			me.handle(conn)
+		}()
+	}
+}
+
+func (me SSHTunnel) handle(conn net.Conn) {
+	if !me.track(conn) {
+		return
+	}
+	defer me.untrack(conn)
+
+	var target net.Conn
+	var err error
+	if len(me.Forward.TargetAddress) > 0 {
+		target, err = me.dial(me.Forward.TargetNetwork, me.Forward.TargetAddress)
+	} else {
+		target, err = serveSOCKS5(conn, me.dial)
+	}
+	if err != nil {
+		me.failed.Add(1)
+		me.mutex.Lock()
+		me.lastError = err
+		me.mutex.Unlock()
+		return
+	}
+	if !me.track(target) {
+		return
+	}
+	defer me.untrack(target)
+
+	sent, received := pipeSSHTunnel(conn, target)
+	me.sent.Add(sent)
+	me.received.Add(received)
+}
+
+// track 登记正在转发的连接，转发已关闭时关闭连接并返回 false
+func (me SSHTunnel) track(conn net.Conn) bool {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+
+	if me.state != SSH_TUNNEL_OPEN {
+		conn.Close()
+		return false
+	}
+	if me.open == nil {
+		me.open = map[net.Conn]struct{}{}
+	}
+	me.open[conn] = struct{}{}
+	return true
+}
+
+func (me SSHTunnel) untrack(conn net.Conn) {
+	conn.Close()
+
+	me.mutex.Lock()
+	delete(me.open, conn)
+	me.mutex.Unlock()
+}
+
+// close 关闭监听器和正在转发的连接
+func (me SSHTunnel) close(err error) {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+
+	if me.state == SSH_TUNNEL_OPEN {
+		me.state = SSH_TUNNEL_CLOSED
+		if me.err == nil {
+			me.err = err
+		}
+	}
+	if me.listener != nil {
+		me.listener.Close()
+	}
+	for conn := range me.open {
+		conn.Close()
+	}
+}
+
+// pipeSSHTunnel 双向复制数据直到两个方向都结束，返回 conn 发给 target 和 target 发回的字节数
+func pipeSSHTunnel(conn net.Conn, target net.Conn) (int64, int64) {
+	var received int64
+	done := make(chan struct{})
+	go func() {
+		received, _ = io.Copy(conn, target)
+		closeWrite(conn)
+		close(done)
+	}()
+
+	sent, _ := io.Copy(target, conn)
+	closeWrite(target)
+	<-done
+	return sent, received
+}
+
+// closeWrite 半关闭连接，让对端读到 EOF
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	} else {
+		conn.Close()
+	}
+}
+
+// ============================================================
+// SSHTunnels 一个主机上的所有转发
+// ============================================================
+
+// SSHTunnelsT 经同一个 SSH 连接打开的转发，ctx 取消、调用 Close 或 SSH 连接断开时全部关闭
+type SSHTunnelsT struct {
+	Host    string
+	Tunnels []SSHTunnel
+
+	client     SSHClient
+	ownsClient bool
+	// conn 打开转发时的底层连接，client 关闭后仍可用于检测
+	conn *ssh.Client
+
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+	stop      func() bool
+}
+
+// SSHTunnels 是 SSHTunnelsT 的指针别名
+type SSHTunnels = *SSHTunnelsT
+
+// OpenTunnelsP 是 OpenTunnels 的 panic 版本
+func (me SSHRunner) OpenTunnelsP(ctx context.Context, host string, extra ...SSHForward) SSHTunnels {
+	r, err := me.OpenTunnels(ctx, host, extra...)
+	if err != nil {
+		panic(qerr.NewSystemError(err.Error(), err))
+	}
+	return r
+}
+
+// OpenTunnels 连接主机，打开 ssh config 中该主机的 LocalForward、RemoteForward、DynamicForward
+// 和 extra 中的转发，ctx 取消时关闭转发和连接
+func (me SSHRunner) OpenTunnels(ctx context.Context, host string, extra ...SSHForward) (SSHTunnels, error) {
+	client, err := me.Dial(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	forwards, err := SSHForwardsOf(client.config)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	r, err := client.OpenTunnels(ctx, append(forwards, extra...)...)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	r.ownsClient = true
+	return r, nil
+}
+
+// OpenTunnelsP 是 OpenTunnels 的 panic 版本
+func (me SSHClient) OpenTunnelsP(ctx context.Context, forwards ...SSHForward) SSHTunnels {
+	r, err := me.OpenTunnels(ctx, forwards...)
+	if err != nil {
+		panic(qerr.NewSystemError(err.Error(), err))
+	}
+	return r
+}
+
+// OpenTunnels 在已有连接上打开转发，关闭转发不会关闭连接。
+// 与 ssh 相同，无法打开的转发只标记为失败，除非主机配置了 ExitOnForwardFailure；
+// 配置了 ServerAliveInterval 时按 ServerAliveCountMax 检测连接是否存活
+func (me SSHClient) OpenTunnels(ctx context.Context, forwards ...SSHForward) (SSHTunnels, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	r := &SSHTunnelsT{Host: me.Host, client: me, conn: me.Client(), done: make(chan struct{})}
+	for _, f := range forwards {
+		t := &SSHTunnelT{Forward: f, state: SSH_TUNNEL_OPEN}
+		r.Tunnels = append(r.Tunnels, t)
+
+		if err := me.listen(t); err != nil {
+			t.state = SSH_TUNNEL_FAILED
+			t.err = err
+			if me.config.ExitOnForwardFailure {
+				r.closeWithError(err)
+				return nil, errors.Wrapf(err, "open forward '%s' on '%s'", f, me.Host)
+			}
+			continue
+		}
+		t.conns.Add(1)
+		go t.serve()
+	}
+
+	r.stop = context.AfterFunc(ctx, func() { r.closeWithError(ctx.Err()) })
+	go func() {
+		err := r.conn.Wait()
+		if err == nil {
+			err = errors.Errorf("ssh connection to '%s' closed", me.Host)
+		}
+		r.closeWithError(err)
+	}()
+	if interval := me.config.ServerAliveInterval; interval > 0 {
+		go r.keepAlive(time.Duration(interval)*time.Second, me.config.ServerAliveCountMax)
+	}
+	return r, nil
+}
+
+// listen 打开转发的监听器
+func (me SSHClient) listen(t SSHTunnel) error {
+	var err error
+	f := t.Forward
+	switch f.Kind {
+	case SSH_FORWARD_LOCAL, SSH_FORWARD_DYNAMIC:
+		t.listener, err = net.Listen(f.ListenNetwork, f.ListenAddress)
+		t.dial = me.Client().Dial
+	case SSH_FORWARD_REMOTE, SSH_FORWARD_REMOTE_DYNAMIC:
+		if f.ListenNetwork == "unix" {
+			t.listener, err = me.Client().ListenUnix(f.ListenAddress)
+		} else {
+			t.listener, err = me.Client().Listen(f.ListenNetwork, f.ListenAddress)
+		}
+		dialer := &net.Dialer{}
+		t.dial = dialer.Dial
+	default:
+		err = errors.Errorf("unknown forward kind: %s", f.Kind)
+	}
+	return err
+}
+
+// keepAlive 每隔 interval 发送 keepalive@openssh.com，连续 countMax 次无响应时断开
+func (me SSHTunnels) keepAlive(interval time.Duration, countMax int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	missed := 0
+	for {
+		select {
+		case <-me.done:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		err := sshKeepAlive(ctx, me.conn)
+		cancel()
+		if err == nil {
+			missed = 0
+			continue
+		}
+		if missed++; missed >= countMax {
+			me.closeWithError(errors.Wrapf(err, "ssh server '%s' not responding", me.Host))
+			return
+		}
+	}
+}
+
+// Status 返回各转发的健康状况
+func (me SSHTunnels) Status() []SSHTunnelStatus {
+	r := make([]SSHTunnelStatus, 0, len(me.Tunnels))
+	for _, t := range me.Tunnels {
+		r = append(r, t.Status())
+	}
+	return r
+}
+
+// Healthy 连接存活且所有转发都处于打开状态
+func (me SSHTunnels) Healthy() bool {
+	select {
+	case <-me.done:
+		return false
+	default:
+	}
+
+	for _, t := range me.Tunnels {
+		if t.Status().State != SSH_TUNNEL_OPEN {
+			return false
+		}
+	}
+	return true
+}
+
+// Done 所有转发关闭后关闭的 channel
+func (me SSHTunnels) Done() <-chan struct{} {
+	return me.done
+}
+
+// Err 返回关闭的原因，未关闭或调用 Close 关闭时为 nil
+func (me SSHTunnels) Err() error {
+	select {
+	case <-me.done:
+		return me.err
+	default:
+		return nil
+	}
+}
+
+// Close 关闭所有转发，由 SSHRunner.OpenTunnels 建立的连接一并关闭
+func (me SSHTunnels) Close() error {
+	me.closeWithError(nil)
+	return nil
+}
+
+func (me SSHTunnels) closeWithError(err error) {
+	me.closeOnce.Do(func() {
+		me.err = err
+		if me.stop != nil {
+			me.stop()
+		}
+		for _, t := range me.Tunnels {
+			t.close(err)
+		}
+		if me.ownsClient {
+			me.client.Close()
+		}
+		for _, t := range me.Tunnels {
+			t.conns.Wait()
+		}
+		close(me.done)
+	})
+}
+
+// ============================================================
+// SOCKS5
+// ============================================================
+
+// serveSOCKS5 完成 SOCKS5 握手（无认证、CONNECT），用 dial 连接请求的目标
+func serveSOCKS5(conn net.Conn, dial func(network, addr string) (net.Conn, error)) (net.Conn, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return nil, errors.Wrap(err, "read socks greeting")
+	}
+	if header[0] != 5 {
+		return nil, errors.Errorf("unsupported socks version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return nil, errors.Wrap(err, "read socks greeting")
+	}
+	if !strings.ContainsRune(string(methods), 0) {
+		conn.Write([]byte{5, 0xff})
+		return nil, errors.New("socks client does not offer 'no authentication'")
+	}
+	if _, err := conn.Write([]byte{5, 0}); err != nil {
+		return nil, err
+	}
+
+	var req [4]byte
+	if _, err := io.ReadFull(conn, req[:]); err != nil {
+		return nil, errors.Wrap(err, "read socks request")
+	}
+
+	var host string
+	switch req[3] {
+	case 1, 4:
+		ip := make([]byte, map[byte]int{1: net.IPv4len, 4: net.IPv6len}[req[3]])
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return nil, errors.Wrap(err, "read socks request")
+		}
+		host = net.IP(ip).String()
+	case 3:
+		var n [1]byte
+		if _, err := io.ReadFull(conn, n[:]); err != nil {
+			return nil, errors.Wrap(err, "read socks request")
+		}
+		name := make([]byte, n[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return nil, errors.Wrap(err, "read socks request")
+		}
+		host = string(name)
+	default:
+		replySOCKS5(conn, 8)
+		return nil, errors.Errorf("unsupported socks address type %d", req[3])
+	}
+
+	var port [2]byte
+	if _, err := io.ReadFull(conn, port[:]); err != nil {
+		return nil, errors.Wrap(err, "read socks request")
+	}
+	if req[1] != 1 {
+		replySOCKS5(conn, 7)
+		return nil, errors.Errorf("unsupported socks command %d", req[1])
+	}
+
+	addr := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:]))))
+	target, err := dial("tcp", addr)
+	if err != nil {
+		replySOCKS5(conn, 5)
+		return nil, errors.Wrapf(err, "socks connect to '%s'", addr)
+	}
+	if err := replySOCKS5(conn, 0); err != nil {
+		target.Close()
+		return nil, err
+	}
+	return target, nil
+}
+
+// replySOCKS5 发送 SOCKS5 应答，绑定地址固定为 0.0.0.0:0
+func replySOCKS5(conn net.Conn, status byte) error {
+	_, err := conn.Write([]byte{5, status, 0, 1, 0, 0, 0, 0, 0, 0})
+	return err
+}
@@ -0,0 +1,303 @@
+package qshell
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/qiangyt/go-comm/v3/qnet"
+	"github.com/stretchr/testify/require"
+)
+
+// startTestEchoServer 启动逐行回显的 TCP 服务，作为转发的目标
+func startTestEchoServer(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// sshTestEcho 经 conn 发送一行并读取回显
+func sshTestEcho(t *testing.T, conn net.Conn, line string) {
+	t.Helper()
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err := conn.Write([]byte(line + "\n"))
+	require.NoError(t, err)
+	got, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, line+"\n", got)
+}
+
+// sshTestSOCKS5Connect 通过 SOCKS5 代理连接 target，返回连接和代理的应答状态
+func sshTestSOCKS5Connect(t *testing.T, proxy string, target string) (net.Conn, byte) {
+	t.Helper()
+	a := require.New(t)
+
+	conn, err := net.Dial("tcp", proxy)
+	a.NoError(err)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = conn.Write([]byte{5, 1, 0})
+	a.NoError(err)
+	reply := make([]byte, 2)
+	_, err = io.ReadFull(conn, reply)
+	a.NoError(err)
+	a.Equal([]byte{5, 0}, reply)
+
+	host, portText, _ := net.SplitHostPort(target)
+	port, _ := strconv.Atoi(portText)
+	req := []byte{5, 1, 0, 3, byte(len(host))}
+	req = append(req, host...)
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	_, err = conn.Write(req)
+	a.NoError(err)
+
+	reply = make([]byte, 10)
+	_, err = io.ReadFull(conn, reply)
+	a.NoError(err)
+	conn.SetDeadline(time.Time{})
+	return conn, reply[1]
+}
+
+func TestParseSSHForward(t *testing.T) {
+	a := require.New(t)
+
+	f, err := ParseSSHForward(SSH_FORWARD_LOCAL, "8080", "db.internal:5432")
+	a.NoError(err)
+	a.Equal(&SSHForwardT{Kind: SSH_FORWARD_LOCAL, ListenNetwork: "tcp", ListenAddress: "127.0.0.1:8080",
+		TargetNetwork: "tcp", TargetAddress: "db.internal:5432"}, f)
+	a.Equal("local 127.0.0.1:8080 -> db.internal:5432", f.String())
+
+	f, err = ParseSSHForward(SSH_FORWARD_LOCAL, "*:8443", "[::1]:443")
+	a.NoError(err)
+	a.Equal(":8443", f.ListenAddress)
+	a.Equal("[::1]:443", f.TargetAddress)
+
+	f, err = ParseSSHForward(SSH_FORWARD_LOCAL, "/tmp/db.sock", "db:5432")
+	a.NoError(err)
+	a.Equal("unix", f.ListenNetwork)
+	a.Equal("/tmp/db.sock", f.ListenAddress)
+
+	f, err = ParseSSHForward(SSH_FORWARD_REMOTE, "0", "/run/app.sock")
+	a.NoError(err)
+	a.Equal("127.0.0.1:0", f.ListenAddress)
+	a.Equal("unix", f.TargetNetwork)
+
+	f, err = ParseSSHForward(SSH_FORWARD_REMOTE, "9091")
+	a.NoError(err)
+	a.Equal(SSH_FORWARD_REMOTE_DYNAMIC, f.Kind)
+	a.Empty(f.TargetAddress)
+
+	f, err = ParseSSHForward(SSH_FORWARD_DYNAMIC, "localhost:1080")
+	a.NoError(err)
+	a.Equal("localhost:1080", f.ListenAddress)
+
+	_, err = ParseSSHForward(SSH_FORWARD_LOCAL, "8080")
+	a.Error(err)
+	_, err = ParseSSHForward("tunnel", "8080")
+	a.Error(err)
+
+	forwards, err := SSHForwardsOf(&qnet.SSHHostConfig{
+		LocalForward:   []string{"8080 [localhost]:80"},
+		RemoteForward:  []string{"9090 [localhost]:90", "9091 [socks]:0"},
+		DynamicForward: []string{"[127.0.0.1]:1080"},
+	})
+	a.NoError(err)
+	a.Len(forwards, 4)
+	a.Equal(SSH_FORWARD_LOCAL, forwards[0].Kind)
+	a.Equal(SSH_FORWARD_REMOTE, forwards[1].Kind)
+	a.Equal(SSH_FORWARD_REMOTE_DYNAMIC, forwards[2].Kind)
+	a.Equal(SSH_FORWARD_DYNAMIC, forwards[3].Kind)
+	a.Equal("127.0.0.1:1080", forwards[3].ListenAddress)
+}
+
+func TestSSHRunner_OpenTunnels(t *testing.T) {
+	a := require.New(t)
+	skipSSHTestOnWindows(t)
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	dir := t.TempDir()
+	_, pub, keyFile := sshTestKey(t, dir)
+	server := startTestSSHServer(t, pub)
+	echo := startTestEchoServer(t)
+	sock := filepath.Join(dir, "db.sock")
+
+	config := sshTestConfig(t, dir, fmt.Sprintf(`
+Host app
+  HostName 127.0.0.1
+  Port %d
+  User tester
+  IdentityFile %s
+  StrictHostKeyChecking no
+  UserKnownHostsFile /dev/null
+  ServerAliveInterval 1
+  LocalForward %s %s
+  RemoteForward 0 %s
+  RemoteForward 0
+`, server.port(), keyFile, sock, echo, echo))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dynamic := &SSHForwardT{Kind: SSH_FORWARD_DYNAMIC, ListenNetwork: "tcp", ListenAddress: "127.0.0.1:0"}
+	tunnels, err := NewSSHRunner(config).OpenTunnels(ctx, "app", dynamic)
+	a.NoError(err)
+	defer tunnels.Close()
+
+	a.Len(tunnels.Tunnels, 4)
+	a.True(tunnels.Healthy())
+	local, remote, remoteDynamic, localDynamic := tunnels.Tunnels[0], tunnels.Tunnels[1], tunnels.Tunnels[2], tunnels.Tunnels[3]
+	a.Equal(SSH_FORWARD_REMOTE_DYNAMIC, remoteDynamic.Forward.Kind)
+
+	// LocalForward：本地 unix socket 经 SSH 连接到目标
+	conn, err := net.Dial("unix", sock)
+	a.NoError(err)
+	sshTestEcho(t, conn, "via local forward")
+
+	// RemoteForward：服务器上监听的端口连接回本地目标
+	conn, err = net.Dial("tcp", remote.Addr())
+	a.NoError(err)
+	sshTestEcho(t, conn, "via remote forward")
+
+	// DynamicForward：本地 SOCKS5 代理经 SSH 连接目标
+	conn, status := sshTestSOCKS5Connect(t, localDynamic.Addr(), echo)
+	a.Equal(byte(0), status)
+	sshTestEcho(t, conn, "via dynamic forward")
+
+	// 远端 SOCKS5 代理从本地连接目标
+	conn, status = sshTestSOCKS5Connect(t, remoteDynamic.Addr(), echo)
+	a.Equal(byte(0), status)
+	sshTestEcho(t, conn, "via remote dynamic forward")
+
+	// 目标无法连接时 SOCKS 返回失败，并计入转发的健康状况
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	closedAddr := closed.Addr().String()
+	closed.Close()
+	conn, status = sshTestSOCKS5Connect(t, localDynamic.Addr(), closedAddr)
+	a.NotEqual(byte(0), status)
+	conn.Close()
+
+	a.Eventually(func() bool {
+		s := localDynamic.Status()
+		return s.Active == 0 && s.Total == 2 && s.Failed == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	status1 := local.Status()
+	a.Equal(SSH_TUNNEL_OPEN, status1.State)
+	a.Equal(sock, status1.Addr)
+	a.Equal(int64(1), status1.Total)
+	a.Eventually(func() bool {
+		return local.Status().BytesSent == int64(len("via local forward\n"))
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// 保活请求被拒绝也说明连接正常
+	time.Sleep(1500 * time.Millisecond)
+	a.True(tunnels.Healthy())
+	a.NoError(tunnels.Err())
+
+	// ctx 取消后关闭所有转发
+	cancel()
+	select {
+	case <-tunnels.Done():
+	case <-time.After(5 * time.Second):
+		a.Fail("tunnels are not closed")
+	}
+	a.True(errors.Is(tunnels.Err(), context.Canceled))
+	a.False(tunnels.Healthy())
+	for _, s := range tunnels.Status() {
+		a.Equal(SSH_TUNNEL_CLOSED, s.State, s.Forward.String())
+	}
+	_, err = net.Dial("unix", sock)
+	a.Error(err)
+}
+
+func TestSSHClient_OpenTunnels_failure(t *testing.T) {
+	a := require.New(t)
+	skipSSHTestOnWindows(t)
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	dir := t.TempDir()
+	_, pub, keyFile := sshTestKey(t, dir)
+	server := startTestSSHServer(t, pub)
+	echo := startTestEchoServer(t)
+
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	defer busy.Close()
+
+	config := sshTestConfig(t, dir, fmt.Sprintf(`
+Host app
+  HostName 127.0.0.1
+  Port %d
+  User tester
+  IdentityFile %s
+  StrictHostKeyChecking no
+  UserKnownHostsFile /dev/null
+
+Host strict
+  HostName 127.0.0.1
+  Port %d
+  User tester
+  IdentityFile %s
+  StrictHostKeyChecking no
+  UserKnownHostsFile /dev/null
+  ExitOnForwardFailure yes
+`, server.port(), keyFile, server.port(), keyFile))
+	runner := NewSSHRunner(config)
+
+	forwards := []SSHForward{
+		{Kind: SSH_FORWARD_LOCAL, ListenNetwork: "tcp", ListenAddress: busy.Addr().String(), TargetNetwork: "tcp", TargetAddress: echo},
+		{Kind: SSH_FORWARD_LOCAL, ListenNetwork: "tcp", ListenAddress: "127.0.0.1:0", TargetNetwork: "tcp", TargetAddress: echo},
+	}
+
+	// 无法打开的转发标记为失败，其余转发照常工作，关闭转发不关闭连接
+	client := runner.DialP(context.Background(), "app")
+	defer client.Close()
+
+	tunnels, err := client.OpenTunnels(context.Background(), forwards...)
+	a.NoError(err)
+	a.False(tunnels.Healthy())
+	a.Equal(SSH_TUNNEL_FAILED, tunnels.Tunnels[0].Status().State)
+	a.Error(tunnels.Tunnels[0].Status().Err)
+
+	conn, err := net.Dial("tcp", tunnels.Tunnels[1].Addr())
+	a.NoError(err)
+	sshTestEcho(t, conn, "still working")
+
+	a.NoError(tunnels.Close())
+	<-tunnels.Done()
+	a.NoError(tunnels.Err())
+	_, err = client.RunContext(context.Background(), nil, "", "true")
+	a.NoError(err)
+
+	// ExitOnForwardFailure 时任一转发失败则整体失败
+	_, err = runner.OpenTunnels(context.Background(), "strict", forwards...)
+	a.Error(err)
+	a.Contains(err.Error(), "open forward")
+}
@@ -1,16 +1,19 @@
 package qshell
 
 import (
-	"bufio"
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/qiangyt/go-comm/v3/qlang"
+	"go.uber.org/atomic"
 )
 
 // CommandOptions 创建命令的选项
@@ -29,20 +32,59 @@ type CommandOptionsT struct {
 
 	// Logger 日志记录器（可选）
 	Logger qlang.Logger
+
+	// OnOutput 输出行监听器（可选），在命令启动前注册，不会错过任何输出
+	OnOutput OutputListener
+
+	// OnRawOutput 原始输出监听器（可选），在命令启动前注册，不会错过任何输出
+	OnRawOutput RawOutputListener
+
+	// Pty 是否在伪终端中运行（仅 Linux/macOS）
+	// 启用后 stdout 和 stderr 合并为一个流（标记为 StreamStdout），支持 Write 和 Resize
+	Pty bool
+
+	// PtySize 伪终端初始窗口大小，默认 24x80
+	PtySize WindowSize
+
+	// Limits 资源限制（可选），超出时可通过 RunningCommand.LimitError 获取原因
+	Limits Limits
 }
 
 type CommandOptions = *CommandOptionsT
 
+// WindowSize 终端窗口大小
+type WindowSize struct {
+	Rows uint16
+	Cols uint16
+}
+
 // RunningCommand 正在运行的命令
 type RunningCommandT struct {
-	cmd        *exec.Cmd
-	output     []byte
-	mu         sync.Mutex
-	done       chan struct{}
-	maxBuffer  int
-	logger     qlang.Logger
-	terminalID string
-	exitCode   *int
+	cmd          *exec.Cmd
+	args         []string // 调用方给出的命令行，不含设置资源限制的包装
+	pty          *os.File
+	output       []byte
+	stdout       []byte
+	stderr       []byte
+	lines        []OutputLine
+	linesSize    int
+	seq          uint64
+	listeners    map[uint64]OutputListener
+	rawListeners map[uint64]RawOutputListener
+	listenerID   uint64
+	pendingLine  map[OutputStream][]byte
+	mu           sync.Mutex
+	notifyMu     sync.Mutex // 保证监听器按 Seq 顺序收到输出
+	done         chan struct{}
+	maxBuffer    int
+	logger       qlang.Logger
+	terminalID   string
+	exitStatus   ExitStatus
+	limiter      *limitedProcess
+	limitErr     LimitError
+	waitOnce     sync.Once
+	startedAt    time.Time
+	lastActivity atomic.Int64 // 最近一次输入或输出的时间（UnixNano）
 }
 
 type RunningCommand = *RunningCommandT
@@ -64,65 +106,365 @@ func NewRunningCommand(options CommandOptions) RunningCommand {
 
 	terminalID := fmt.Sprintf("terminal-%d-%d", time.Now().UnixNano(), time.Now().Nanosecond())
 
-	cmd := exec.CommandContext(ctx, options.Command, options.Args...)
+	limiter, ctx := newLimitedProcess(ctx, options.Limits, options.Command)
 
-	// 创建管道捕获输出
-	stdoutPipe, err := cmd.StdoutPipe()
-	if err != nil {
-		panic(fmt.Errorf("创建 stdout 管道失败: %w", err))
-	}
-	stderrPipe, err := cmd.StderrPipe()
-	if err != nil {
-		panic(fmt.Errorf("创建 stderr 管道失败: %w", err))
+	cmd := exec.CommandContext(ctx, options.Command, options.Args...)
+	// ctx 取消时终止整个进程组，而不只是直接子进程
+	cmd.Cancel = func() error {
+		return killProcessGroup(cmd)
 	}
 
 	result := &RunningCommandT{
-		cmd:        cmd,
-		done:       make(chan struct{}),
-		maxBuffer:  maxBuffer,
-		logger:     options.Logger,
-		terminalID: terminalID,
+		cmd:          cmd,
+		args:         slices.Clone(cmd.Args),
+		listeners:    map[uint64]OutputListener{},
+		rawListeners: map[uint64]RawOutputListener{},
+		pendingLine:  map[OutputStream][]byte{},
+		done:         make(chan struct{}),
+		maxBuffer:    maxBuffer,
+		logger:       options.Logger,
+		terminalID:   terminalID,
+		limiter:      limiter,
+	}
+	if err := limiter.prepare(cmd); err != nil {
+		panic(fmt.Errorf("设置资源限制失败: %w", err))
+	}
+	if options.OnOutput != nil {
+		result.Subscribe(options.OnOutput)
 	}
+	if options.OnRawOutput != nil {
+		result.SubscribeRaw(options.OnRawOutput)
+	}
+
+	var collectors sync.WaitGroup
+	if options.Pty {
+		size := options.PtySize
+		if size.Rows == 0 || size.Cols == 0 {
+			size = WindowSize{Rows: 24, Cols: 80}
+		}
+
+		// 启动命令
+		pty, err := startPty(cmd, size)
+		if err != nil {
+			panic(fmt.Errorf("启动命令失败: %w", err))
+		}
+		result.pty = pty
+
+		// 伪终端只有一个输出流
+		collectors.Add(1)
+		go result.collect(&collectors, StreamStdout, pty)
+	} else {
+		// 创建管道捕获输出
+		stdoutPipe, err := cmd.StdoutPipe()
+		if err != nil {
+			panic(fmt.Errorf("创建 stdout 管道失败: %w", err))
+		}
+		stderrPipe, err := cmd.StderrPipe()
+		if err != nil {
+			panic(fmt.Errorf("创建 stderr 管道失败: %w", err))
+		}
+
+		// 启动命令，使用独立进程组以便终止时连同孙进程一起终止
+		// （伪终端模式下 Setsid 已经创建了新的进程组）
+		setProcessGroup(cmd)
+		if err := cmd.Start(); err != nil {
+			panic(fmt.Errorf("启动命令失败: %w", err))
+		}
 
-	// 启动命令
-	if err := cmd.Start(); err != nil {
-		panic(fmt.Errorf("启动命令失败: %w", err))
+		// 分别收集 stdout 和 stderr，按到达顺序合并
+		collectors.Add(2)
+		go result.collect(&collectors, StreamStdout, stdoutPipe)
+		go result.collect(&collectors, StreamStderr, stderrPipe)
 	}
 
+	result.startedAt = time.Now()
+	result.touch()
+
 	if result.logger != nil {
-		result.logger.Info().Str("terminalId", terminalID).Int("pid", result.Pid()).Msg("终端命令已启动")
+		result.logger.Info().Str("terminalId", terminalID).Int("pid", result.Pid()).Bool("pty", options.Pty).Msg("终端命令已启动")
 	}
 
-	// 收集输出
 	go func() {
-		defer func() {
-			qlang.RecoverAndLog(recover(), result.logger, "terminal output collector")
-		}()
-		defer close(result.done)
-
-		// 合并 stdout 和 stderr
-		reader := io.MultiReader(stdoutPipe, stderrPipe)
-		scanner := bufio.NewScanner(reader)
-		for scanner.Scan() {
-			line := append(scanner.Bytes(), '\n')
-			result.mu.Lock()
-			if len(result.output)+len(line) > result.maxBuffer {
-				if len(result.output) > result.maxBuffer/2 {
-					result.output = result.output[:result.maxBuffer/2]
-				}
-			}
-			result.output = append(result.output, line...)
-			result.mu.Unlock()
+		collectors.Wait()
+		if result.pty != nil {
+			result.pty.Close()
 		}
-
 		if result.logger != nil {
 			result.logger.Info().Str("terminalId", terminalID).Msg("终端命令输出收集完成")
 		}
+		close(result.done)
 	}()
 
 	return result
 }
 
+// collect 读取一个输出流直到结束
+func (me RunningCommand) collect(wg *sync.WaitGroup, stream OutputStream, reader io.Reader) {
+	defer wg.Done()
+	defer func() {
+		qlang.RecoverAndLog(recover(), me.logger, "terminal output collector")
+	}()
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := reader.Read(buf)
+		if n > 0 {
+			me.appendOutput(stream, bytes.Clone(buf[:n]))
+		}
+		if err != nil {
+			// 伪终端在子进程退出后返回 EIO，与 EOF 同样视为结束
+			me.flushPendingLine(stream)
+			return
+		}
+	}
+}
+
+// appendOutput 记录一段原始输出，拆分出其中完整的行，并通知监听器
+func (me RunningCommand) appendOutput(stream OutputStream, data []byte) {
+	me.touch()
+
+	data = data[:me.limiter.allowOutput(len(data))]
+	if len(data) == 0 {
+		return
+	}
+
+	me.notifyMu.Lock()
+	defer me.notifyMu.Unlock()
+
+	me.mu.Lock()
+	me.output = appendBoundedOutput(me.output, data, me.maxBuffer)
+	if stream == StreamStderr {
+		me.stderr = appendBoundedOutput(me.stderr, data, me.maxBuffer)
+	} else {
+		me.stdout = appendBoundedOutput(me.stdout, data, me.maxBuffer)
+	}
+
+	var lines []OutputLine
+	pending := append(me.pendingLine[stream], data...)
+	for {
+		i := bytes.IndexByte(pending, '\n')
+		if i < 0 {
+			break
+		}
+		lines = append(lines, me.addLine(stream, pending[:i]))
+		pending = pending[i+1:]
+	}
+	me.pendingLine[stream] = bytes.Clone(pending)
+
+	listeners, rawListeners := me.snapshotListeners()
+	me.mu.Unlock()
+
+	for _, listener := range rawListeners {
+		me.notifyRaw(listener, stream, data)
+	}
+	for _, line := range lines {
+		for _, listener := range listeners {
+			me.notify(listener, line)
+		}
+	}
+}
+
+// flushPendingLine 流结束时把末尾不以换行结尾的内容作为最后一行
+func (me RunningCommand) flushPendingLine(stream OutputStream) {
+	me.notifyMu.Lock()
+	defer me.notifyMu.Unlock()
+
+	me.mu.Lock()
+	pending := me.pendingLine[stream]
+	delete(me.pendingLine, stream)
+	if len(pending) == 0 {
+		me.mu.Unlock()
+		return
+	}
+	line := me.addLine(stream, pending)
+	listeners, _ := me.snapshotListeners()
+	me.mu.Unlock()
+
+	for _, listener := range listeners {
+		me.notify(listener, line)
+	}
+}
+
+// addLine 把一行追加到合并日志，调用方需持有 mu
+func (me RunningCommand) addLine(stream OutputStream, text []byte) OutputLine {
+	text = bytes.TrimSuffix(text, []byte{'\r'})
+
+	me.seq++
+	line := OutputLine{
+		Seq:    me.seq,
+		Stream: stream,
+		Time:   time.Now(),
+		Text:   string(text),
+	}
+	me.lines = append(me.lines, line)
+	me.linesSize += len(line.Text) + 1
+
+	// 合并日志只保留最近 maxBuffer 字节
+	for len(me.lines) > 1 && me.linesSize > me.maxBuffer {
+		me.linesSize -= len(me.lines[0].Text) + 1
+		me.lines = me.lines[1:]
+	}
+	return line
+}
+
+// snapshotListeners 复制当前监听器列表，调用方需持有 mu
+func (me RunningCommand) snapshotListeners() ([]OutputListener, []RawOutputListener) {
+	listeners := make([]OutputListener, 0, len(me.listeners))
+	for _, listener := range me.listeners {
+		listeners = append(listeners, listener)
+	}
+	rawListeners := make([]RawOutputListener, 0, len(me.rawListeners))
+	for _, listener := range me.rawListeners {
+		rawListeners = append(rawListeners, listener)
+	}
+	return listeners, rawListeners
+}
+
+// notify 调用监听器，监听器 panic 不影响输出收集
+func (me RunningCommand) notify(listener OutputListener, line OutputLine) {
+	defer func() {
+		qlang.RecoverAndLog(recover(), me.logger, "terminal output listener")
+	}()
+	listener(line)
+}
+
+// notifyRaw 调用原始输出监听器，监听器 panic 不影响输出收集
+func (me RunningCommand) notifyRaw(listener RawOutputListener, stream OutputStream, data []byte) {
+	defer func() {
+		qlang.RecoverAndLog(recover(), me.logger, "terminal raw output listener")
+	}()
+	listener(stream, data)
+}
+
+// Subscribe 订阅输出行，先按顺序回放已缓存的行，再推送新行
+// 返回取消订阅函数
+func (me RunningCommand) Subscribe(listener OutputListener) func() {
+	me.notifyMu.Lock()
+	defer me.notifyMu.Unlock()
+
+	me.mu.Lock()
+	me.listenerID++
+	id := me.listenerID
+	me.listeners[id] = listener
+	backlog := make([]OutputLine, len(me.lines))
+	copy(backlog, me.lines)
+	me.mu.Unlock()
+
+	for _, line := range backlog {
+		me.notify(listener, line)
+	}
+
+	return func() {
+		me.mu.Lock()
+		delete(me.listeners, id)
+		me.mu.Unlock()
+	}
+}
+
+// SubscribeChan 以 channel 形式订阅输出行，命令结束或取消订阅时关闭 channel
+// channel 缓冲区满时新行会被丢弃，以免阻塞输出收集
+func (me RunningCommand) SubscribeChan(bufferSize int) (<-chan OutputLine, func()) {
+	if bufferSize <= 0 {
+		bufferSize = 256
+	}
+
+	ch := make(chan OutputLine, bufferSize)
+	var chMu sync.Mutex
+	closed := false
+
+	unsubscribe := me.Subscribe(func(line OutputLine) {
+		chMu.Lock()
+		defer chMu.Unlock()
+		if closed {
+			return
+		}
+		select {
+		case ch <- line:
+		default:
+		}
+	})
+
+	var once sync.Once
+	closeFn := func() {
+		once.Do(func() {
+			unsubscribe()
+			chMu.Lock()
+			closed = true
+			close(ch)
+			chMu.Unlock()
+		})
+	}
+
+	go func() {
+		<-me.done
+		closeFn()
+	}()
+
+	return ch, closeFn
+}
+
+// SubscribeRaw 订阅原始输出，先回放已缓存的输出，再推送新的输出块
+// 伪终端模式下回放整个输出缓冲区，管道模式下按行回放
+// 返回取消订阅函数
+func (me RunningCommand) SubscribeRaw(listener RawOutputListener) func() {
+	me.notifyMu.Lock()
+	defer me.notifyMu.Unlock()
+
+	me.mu.Lock()
+	me.listenerID++
+	id := me.listenerID
+	me.rawListeners[id] = listener
+	var backlogPty []byte
+	var backlog []OutputLine
+	if me.pty != nil {
+		backlogPty = bytes.Clone(me.stdout)
+	} else {
+		backlog = make([]OutputLine, len(me.lines))
+		copy(backlog, me.lines)
+	}
+	me.mu.Unlock()
+
+	if len(backlogPty) > 0 {
+		me.notifyRaw(listener, StreamStdout, backlogPty)
+	}
+	for _, line := range backlog {
+		me.notifyRaw(listener, line.Stream, []byte(line.Text+"\n"))
+	}
+
+	return func() {
+		me.mu.Lock()
+		delete(me.rawListeners, id)
+		me.mu.Unlock()
+	}
+}
+
+// IsPty 是否运行在伪终端中
+func (me RunningCommand) IsPty() bool {
+	return me.pty != nil
+}
+
+// Write 向伪终端写入原始输入（如按键、控制字符），仅伪终端模式可用
+func (me RunningCommand) Write(p []byte) (int, error) {
+	if me.pty == nil {
+		return 0, errors.New("terminal is not running in pty mode")
+	}
+	me.touch()
+	return me.pty.Write(p)
+}
+
+// WriteString 向伪终端写入字符串，仅伪终端模式可用
+func (me RunningCommand) WriteString(s string) (int, error) {
+	return me.Write([]byte(s))
+}
+
+// Resize 调整伪终端窗口大小，仅伪终端模式可用
+func (me RunningCommand) Resize(rows, cols uint16) error {
+	if me.pty == nil {
+		return errors.New("terminal is not running in pty mode")
+	}
+	return resizePty(me.pty, WindowSize{Rows: rows, Cols: cols})
+}
+
 // Pid 返回进程 ID
 func (me RunningCommand) Pid() int {
 	if me.cmd.Process == nil {
@@ -131,6 +473,26 @@ func (me RunningCommand) Pid() int {
 	return me.cmd.Process.Pid
 }
 
+// touch 记录一次输入或输出活动
+func (me RunningCommand) touch() {
+	me.lastActivity.Store(time.Now().UnixNano())
+}
+
+// StartedAt 返回命令启动时间
+func (me RunningCommand) StartedAt() time.Time {
+	return me.startedAt
+}
+
+// LastActivity 返回最近一次输入或输出的时间
+func (me RunningCommand) LastActivity() time.Time {
+	return time.Unix(0, me.lastActivity.Load())
+}
+
+// Args 返回完整命令行（含命令名）
+func (me RunningCommand) Args() []string {
+	return slices.Clone(me.args)
+}
+
 // TerminalID 返回终端 ID
 func (me RunningCommand) TerminalID() string {
 	return me.terminalID
@@ -150,19 +512,58 @@ func (me RunningCommand) GetOutputString() string {
 	return string(me.GetOutput())
 }
 
+// GetStdout 获取当前 stdout 输出（副本）
+func (me RunningCommand) GetStdout() []byte {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return bytes.Clone(me.stdout)
+}
+
+// GetStdoutString 获取当前 stdout 输出的字符串形式
+func (me RunningCommand) GetStdoutString() string {
+	return string(me.GetStdout())
+}
+
+// GetStderr 获取当前 stderr 输出（副本）
+func (me RunningCommand) GetStderr() []byte {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return bytes.Clone(me.stderr)
+}
+
+// GetStderrString 获取当前 stderr 输出的字符串形式
+func (me RunningCommand) GetStderrString() string {
+	return string(me.GetStderr())
+}
+
+// GetLines 获取按到达顺序排列、带时间戳的合并输出（副本）
+func (me RunningCommand) GetLines() []OutputLine {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	r := make([]OutputLine, len(me.lines))
+	copy(r, me.lines)
+	return r
+}
+
 // Wait 等待命令完成并返回退出码
 func (me RunningCommand) Wait() int {
+	return me.WaitStatus().Code
+}
+
+// WaitStatus 等待命令完成并返回退出状态，包括终止进程的信号
+func (me RunningCommand) WaitStatus() ExitStatus {
 	<-me.done
 
-	// 使用缓存避免重复调用 cmd.Wait()
+	// 并发调用时也只调用一次 cmd.Wait()，其余调用使用缓存的退出状态
+	me.waitOnce.Do(me.waitProcess)
+
 	me.mu.Lock()
-	if me.exitCode != nil {
-		code := *me.exitCode
-		me.mu.Unlock()
-		return code
-	}
-	me.mu.Unlock()
+	defer me.mu.Unlock()
+	return me.exitStatus
+}
 
+// waitProcess 等待进程退出并缓存退出状态
+func (me RunningCommand) waitProcess() {
 	err := me.cmd.Wait()
 
 	var code int
@@ -183,17 +584,35 @@ func (me RunningCommand) Wait() int {
 		code = 0
 	}
 
+	status := ExitStatus{Code: code}
+	if me.cmd.ProcessState != nil {
+		status.Signal = exitSignal(me.cmd.ProcessState)
+	}
+
+	limitErr := me.limiter.finish(me.cmd.ProcessState)
+	if limitErr != nil && me.logger != nil {
+		me.logger.Warn().Str("terminalId", me.terminalID).Str("limit", string(limitErr.Kind)).Msg("终端命令超出资源限制")
+	}
+
 	me.mu.Lock()
-	me.exitCode = &code
+	me.exitStatus = status
+	me.limitErr = limitErr
 	me.mu.Unlock()
+}
+
+// LimitError 等待命令完成，返回命令因超出 CommandOptions.Limits 被终止的原因，未超限时返回 nil
+func (me RunningCommand) LimitError() LimitError {
+	me.WaitStatus()
 
-	return code
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.limitErr
 }
 
-// Kill 终止命令
+// Kill 立即终止命令所在的整个进程组（SIGKILL）
 func (me RunningCommand) Kill() {
 	if me.cmd.Process != nil {
-		if err := me.cmd.Process.Kill(); err != nil {
+		if err := killProcessGroup(me.cmd); err != nil && !errors.Is(err, os.ErrProcessDone) {
 			if me.logger != nil {
 				me.logger.Error(err).Str("terminalId", me.terminalID).Msg("终止终端进程失败")
 			}
@@ -205,6 +624,22 @@ func (me RunningCommand) Kill() {
 	}
 }
 
+// Terminate 优雅终止命令：先向整个进程组发送 SIGTERM，grace 内未结束则发送 SIGKILL
+// 等待命令结束后返回退出状态，可通过 ExitStatus.Signal 判断是哪个信号终止了进程
+func (me RunningCommand) Terminate(grace time.Duration) ExitStatus {
+	if me.cmd.Process != nil && !me.IsDone() {
+		if me.logger != nil {
+			me.logger.Info().Str("terminalId", me.terminalID).Dur("grace", grace).Msg("正在终止终端进程")
+		}
+		if err := terminateProcessGroup(me.cmd, grace, me.done); err != nil && !errors.Is(err, os.ErrProcessDone) {
+			if me.logger != nil {
+				me.logger.Error(err).Str("terminalId", me.terminalID).Msg("终止终端进程失败")
+			}
+		}
+	}
+	return me.WaitStatus()
+}
+
 // Done 返回完成信号 channel
 func (me RunningCommand) Done() <-chan struct{} {
 	return me.done
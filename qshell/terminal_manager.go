@@ -0,0 +1,560 @@
+package qshell
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/qiangyt/go-comm/v3/qerr"
+	"github.com/qiangyt/go-comm/v3/qlang"
+)
+
+// ============================================================
+// 错误
+// ============================================================
+
+var (
+	// ErrTerminalLimitExceeded 同时运行的终端数量达到上限
+	ErrTerminalLimitExceeded = errors.New("terminal limit exceeded")
+	// ErrOwnerQuotaExceeded 某个 owner 同时运行的终端数量达到上限
+	ErrOwnerQuotaExceeded = errors.New("terminal quota of owner exceeded")
+	// ErrTerminalNotFound 终端不存在
+	ErrTerminalNotFound = errors.New("terminal not found")
+	// ErrTerminalManagerClosed 终端管理器已关闭
+	ErrTerminalManagerClosed = errors.New("terminal manager closed")
+)
+
+// ============================================================
+// TerminalEvent 终端生命周期事件
+// ============================================================
+
+// TerminalEventKind 终端生命周期事件类型
+type TerminalEventKind int
+
+const (
+	// TerminalStarted 终端已启动
+	TerminalStarted TerminalEventKind = iota + 1
+	// TerminalKilled 终端被主动终止（Kill/KillAll/Close）
+	TerminalKilled
+	// TerminalReaped 终端因空闲或存活超时被回收
+	TerminalReaped
+	// TerminalExited 终端进程已退出
+	TerminalExited
+	// TerminalRemoved 终端已从管理器中移除
+	TerminalRemoved
+)
+
+// String 返回事件类型的字符串表示
+func (k TerminalEventKind) String() string {
+	switch k {
+	case TerminalStarted:
+		return "started"
+	case TerminalKilled:
+		return "killed"
+	case TerminalReaped:
+		return "reaped"
+	case TerminalExited:
+		return "exited"
+	case TerminalRemoved:
+		return "removed"
+	default:
+		return "unknown"
+	}
+}
+
+// TerminalEvent 终端生命周期事件
+type TerminalEvent struct {
+	// Kind 事件类型
+	Kind TerminalEventKind
+	// TerminalID 终端 ID
+	TerminalID string
+	// Owner 终端所有者
+	Owner string
+	// Time 事件时间
+	Time time.Time
+	// ExitCode 退出码（仅 TerminalExited 有效）
+	ExitCode int
+	// Reason 原因（TerminalReaped 时为 "idle" 或 "ttl"）
+	Reason string
+}
+
+// TerminalEventListener 终端生命周期事件监听器
+type TerminalEventListener func(event TerminalEvent)
+
+// ============================================================
+// TerminalInfo 终端快照
+// ============================================================
+
+// TerminalInfo 终端状态快照
+type TerminalInfo struct {
+	ID           string
+	Owner        string
+	Pid          int
+	Args         []string
+	Pty          bool
+	StartedAt    time.Time
+	LastActivity time.Time
+	Done         bool
+	FinishedAt   time.Time
+	ExitCode     int
+}
+
+// ============================================================
+// TerminalManagerOptions
+// ============================================================
+
+// TerminalManagerOptionsT 终端管理器选项
+type TerminalManagerOptionsT struct {
+	// MaxTerminals 同时运行的终端总数上限，0 表示不限
+	MaxTerminals int
+
+	// MaxPerOwner 每个 owner 同时运行的终端数上限，0 表示不限
+	MaxPerOwner int
+
+	// IdleTimeout 终端无输入输出超过该时间后被终止，0 表示不限
+	IdleTimeout time.Duration
+
+	// TTL 终端最长运行时间，超过后被终止，0 表示不限
+	TTL time.Duration
+
+	// RetainFinished 已结束的终端保留多久（供查询输出）后被移除，0 表示结束后立即移除
+	RetainFinished time.Duration
+
+	// ReapInterval 后台回收检查间隔，默认 1 秒
+	ReapInterval time.Duration
+
+	// Logger 日志记录器（可选）
+	Logger qlang.Logger
+}
+
+type TerminalManagerOptions = *TerminalManagerOptionsT
+
+// DefaultTerminalManagerOptions 返回默认选项
+func DefaultTerminalManagerOptions() TerminalManagerOptions {
+	return &TerminalManagerOptionsT{
+		RetainFinished: 5 * time.Minute,
+		ReapInterval:   time.Second,
+	}
+}
+
+// ============================================================
+// TerminalManager
+// ============================================================
+
+// managedTerminal 管理器中的终端条目
+type managedTerminal struct {
+	cmd        RunningCommand
+	owner      string
+	finishedAt time.Time
+	exitCode   int
+	reaping    bool
+}
+
+func (me *managedTerminal) done() bool {
+	return !me.finishedAt.IsZero()
+}
+
+// TerminalManagerT 终端管理器，按 TerminalID 管理正在运行和已结束的终端
+type TerminalManagerT struct {
+	options    TerminalManagerOptionsT
+	terminals  map[string]*managedTerminal
+	listeners  map[uint64]TerminalEventListener
+	listenerID uint64
+	mu         sync.Mutex
+	closed     bool
+	stop       chan struct{}
+	stopped    chan struct{}
+}
+
+type TerminalManager = *TerminalManagerT
+
+// NewTerminalManager 创建终端管理器并启动后台回收
+func NewTerminalManager(options TerminalManagerOptions) TerminalManager {
+	if options == nil {
+		options = DefaultTerminalManagerOptions()
+	}
+
+	opts := *options
+	if opts.ReapInterval <= 0 {
+		opts.ReapInterval = time.Second
+	}
+
+	r := &TerminalManagerT{
+		options:   opts,
+		terminals: map[string]*managedTerminal{},
+		listeners: map[uint64]TerminalEventListener{},
+		stop:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+
+	go r.reapLoop()
+
+	return r
+}
+
+// StartP 启动终端（失败时 panic）
+func (me TerminalManager) StartP(owner string, options CommandOptions) RunningCommand {
+	r, err := me.Start(owner, options)
+	if err != nil {
+		panic(qerr.NewSystemError(err.Error(), err))
+	}
+	return r
+}
+
+// Start 为 owner 启动终端，超出总数或 owner 配额时返回错误
+func (me TerminalManager) Start(owner string, options CommandOptions) (RunningCommand, error) {
+	entry, err := me.register(owner, options)
+	if err != nil {
+		return nil, err
+	}
+
+	me.emit(TerminalEvent{Kind: TerminalStarted, TerminalID: entry.cmd.TerminalID(), Owner: owner, Time: entry.cmd.StartedAt()})
+	go me.watch(entry)
+
+	return entry.cmd, nil
+}
+
+// register 检查配额，启动终端并登记
+func (me TerminalManager) register(owner string, options CommandOptions) (entry *managedTerminal, err error) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	if me.closed {
+		return nil, ErrTerminalManagerClosed
+	}
+
+	total, ofOwner := me.countRunning(owner)
+	if me.options.MaxTerminals > 0 && total >= me.options.MaxTerminals {
+		return nil, errors.Wrapf(ErrTerminalLimitExceeded, "max %d", me.options.MaxTerminals)
+	}
+	if me.options.MaxPerOwner > 0 && ofOwner >= me.options.MaxPerOwner {
+		return nil, errors.Wrapf(ErrOwnerQuotaExceeded, "owner '%s' max %d", owner, me.options.MaxPerOwner)
+	}
+
+	// NewRunningCommand 失败时 panic，这里转换为 error
+	defer func() {
+		if p := recover(); p != nil {
+			entry = nil
+			if e, ok := p.(error); ok {
+				err = e
+			} else {
+				err = fmt.Errorf("%v", p)
+			}
+		}
+	}()
+
+	opts := *options
+	if opts.Logger == nil {
+		opts.Logger = me.options.Logger
+	}
+	cmd := NewRunningCommand(&opts)
+
+	entry = &managedTerminal{cmd: cmd, owner: owner}
+	me.terminals[cmd.TerminalID()] = entry
+	return entry, nil
+}
+
+// countRunning 统计运行中的终端数，调用方需持有 mu
+func (me TerminalManager) countRunning(owner string) (total int, ofOwner int) {
+	for _, t := range me.terminals {
+		if t.done() {
+			continue
+		}
+		total++
+		if t.owner == owner {
+			ofOwner++
+		}
+	}
+	return
+}
+
+// watch 等待终端结束并记录退出码
+func (me TerminalManager) watch(entry *managedTerminal) {
+	code := entry.cmd.Wait()
+
+	me.mu.Lock()
+	entry.finishedAt = time.Now()
+	entry.exitCode = code
+	retain := me.options.RetainFinished
+	if retain <= 0 {
+		delete(me.terminals, entry.cmd.TerminalID())
+	}
+	me.mu.Unlock()
+
+	me.emit(TerminalEvent{
+		Kind:       TerminalExited,
+		TerminalID: entry.cmd.TerminalID(),
+		Owner:      entry.owner,
+		Time:       entry.finishedAt,
+		ExitCode:   code,
+	})
+	if retain <= 0 {
+		me.emit(TerminalEvent{Kind: TerminalRemoved, TerminalID: entry.cmd.TerminalID(), Owner: entry.owner, Time: time.Now()})
+	}
+}
+
+// Get 按 TerminalID 查找终端
+func (me TerminalManager) Get(terminalID string) (RunningCommand, bool) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	t, found := me.terminals[terminalID]
+	if !found {
+		return nil, false
+	}
+	return t.cmd, true
+}
+
+// Info 按 TerminalID 获取终端状态快照
+func (me TerminalManager) Info(terminalID string) (TerminalInfo, bool) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	t, found := me.terminals[terminalID]
+	if !found {
+		return TerminalInfo{}, false
+	}
+	return me.info(t), true
+}
+
+// info 生成终端快照，调用方需持有 mu
+func (me TerminalManager) info(t *managedTerminal) TerminalInfo {
+	return TerminalInfo{
+		ID:           t.cmd.TerminalID(),
+		Owner:        t.owner,
+		Pid:          t.cmd.Pid(),
+		Args:         t.cmd.Args(),
+		Pty:          t.cmd.IsPty(),
+		StartedAt:    t.cmd.StartedAt(),
+		LastActivity: t.cmd.LastActivity(),
+		Done:         t.done(),
+		FinishedAt:   t.finishedAt,
+		ExitCode:     t.exitCode,
+	}
+}
+
+// List 列出所有终端，按启动时间排序
+func (me TerminalManager) List() []TerminalInfo {
+	return me.list(func(t *managedTerminal) bool { return true })
+}
+
+// ListByOwner 列出 owner 的所有终端，按启动时间排序
+func (me TerminalManager) ListByOwner(owner string) []TerminalInfo {
+	return me.list(func(t *managedTerminal) bool { return t.owner == owner })
+}
+
+func (me TerminalManager) list(filter func(t *managedTerminal) bool) []TerminalInfo {
+	me.mu.Lock()
+	r := make([]TerminalInfo, 0, len(me.terminals))
+	for _, t := range me.terminals {
+		if filter(t) {
+			r = append(r, me.info(t))
+		}
+	}
+	me.mu.Unlock()
+
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].StartedAt.Equal(r[j].StartedAt) {
+			return r[i].ID < r[j].ID
+		}
+		return r[i].StartedAt.Before(r[j].StartedAt)
+	})
+	return r
+}
+
+// Count 返回运行中的终端总数
+func (me TerminalManager) Count() int {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	total, _ := me.countRunning("")
+	return total
+}
+
+// Kill 终止指定终端
+func (me TerminalManager) Kill(terminalID string) error {
+	me.mu.Lock()
+	t, found := me.terminals[terminalID]
+	me.mu.Unlock()
+
+	if !found {
+		return errors.Wrap(ErrTerminalNotFound, terminalID)
+	}
+
+	me.kill(t, TerminalKilled, "")
+	return nil
+}
+
+// KillOwner 终止 owner 的所有运行中终端，返回终止的数量
+func (me TerminalManager) KillOwner(owner string) int {
+	return me.killAll(func(t *managedTerminal) bool { return t.owner == owner })
+}
+
+// KillAll 终止所有运行中终端，返回终止的数量
+func (me TerminalManager) KillAll() int {
+	return me.killAll(func(t *managedTerminal) bool { return true })
+}
+
+func (me TerminalManager) killAll(filter func(t *managedTerminal) bool) int {
+	me.mu.Lock()
+	targets := make([]*managedTerminal, 0, len(me.terminals))
+	for _, t := range me.terminals {
+		if !t.done() && filter(t) {
+			targets = append(targets, t)
+		}
+	}
+	me.mu.Unlock()
+
+	for _, t := range targets {
+		me.kill(t, TerminalKilled, "")
+	}
+	return len(targets)
+}
+
+// kill 终止终端并发出事件
+func (me TerminalManager) kill(t *managedTerminal, kind TerminalEventKind, reason string) {
+	if t.cmd.IsDone() {
+		return
+	}
+
+	t.cmd.Kill()
+	me.emit(TerminalEvent{Kind: kind, TerminalID: t.cmd.TerminalID(), Owner: t.owner, Time: time.Now(), Reason: reason})
+}
+
+// Remove 移除指定终端，运行中的终端会先被终止
+func (me TerminalManager) Remove(terminalID string) error {
+	me.mu.Lock()
+	t, found := me.terminals[terminalID]
+	if found {
+		delete(me.terminals, terminalID)
+	}
+	me.mu.Unlock()
+
+	if !found {
+		return errors.Wrap(ErrTerminalNotFound, terminalID)
+	}
+
+	me.kill(t, TerminalKilled, "")
+	me.emit(TerminalEvent{Kind: TerminalRemoved, TerminalID: terminalID, Owner: t.owner, Time: time.Now()})
+	return nil
+}
+
+// Reap 执行一次回收：终止空闲或超时的终端，移除保留期已过的已结束终端
+func (me TerminalManager) Reap() {
+	now := time.Now()
+
+	type reapTarget struct {
+		t      *managedTerminal
+		reason string
+	}
+	var toKill []reapTarget
+	var removed []*managedTerminal
+
+	me.mu.Lock()
+	for id, t := range me.terminals {
+		if t.done() {
+			if now.Sub(t.finishedAt) >= me.options.RetainFinished {
+				delete(me.terminals, id)
+				removed = append(removed, t)
+			}
+			continue
+		}
+		if t.reaping {
+			continue
+		}
+
+		if me.options.TTL > 0 && now.Sub(t.cmd.StartedAt()) >= me.options.TTL {
+			t.reaping = true
+			toKill = append(toKill, reapTarget{t, "ttl"})
+		} else if me.options.IdleTimeout > 0 && now.Sub(t.cmd.LastActivity()) >= me.options.IdleTimeout {
+			t.reaping = true
+			toKill = append(toKill, reapTarget{t, "idle"})
+		}
+	}
+	me.mu.Unlock()
+
+	for _, target := range toKill {
+		if me.options.Logger != nil {
+			me.options.Logger.Info().Str("terminalId", target.t.cmd.TerminalID()).Str("reason", target.reason).Msg("回收终端")
+		}
+		me.kill(target.t, TerminalReaped, target.reason)
+	}
+	for _, t := range removed {
+		me.emit(TerminalEvent{Kind: TerminalRemoved, TerminalID: t.cmd.TerminalID(), Owner: t.owner, Time: now})
+	}
+}
+
+// reapLoop 后台定期回收
+func (me TerminalManager) reapLoop() {
+	defer close(me.stopped)
+
+	ticker := time.NewTicker(me.options.ReapInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-me.stop:
+			return
+		case <-ticker.C:
+			func() {
+				defer func() {
+					qlang.RecoverAndLog(recover(), me.options.Logger, "terminal reaper")
+				}()
+				me.Reap()
+			}()
+		}
+	}
+}
+
+// Close 停止后台回收并终止所有运行中终端，之后不能再启动新终端
+func (me TerminalManager) Close() {
+	me.mu.Lock()
+	if me.closed {
+		me.mu.Unlock()
+		return
+	}
+	me.closed = true
+	me.mu.Unlock()
+
+	close(me.stop)
+	<-me.stopped
+
+	me.KillAll()
+}
+
+// Subscribe 订阅终端生命周期事件，返回取消订阅函数
+func (me TerminalManager) Subscribe(listener TerminalEventListener) func() {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	me.listenerID++
+	id := me.listenerID
+	me.listeners[id] = listener
+
+	return func() {
+		me.mu.Lock()
+		delete(me.listeners, id)
+		me.mu.Unlock()
+	}
+}
+
+// emit 同步通知所有监听器，调用方不能持有 mu
+func (me TerminalManager) emit(event TerminalEvent) {
+	me.mu.Lock()
+	listeners := make([]TerminalEventListener, 0, len(me.listeners))
+	for _, listener := range me.listeners {
+		listeners = append(listeners, listener)
+	}
+	me.mu.Unlock()
+
+	for _, listener := range listeners {
+		func() {
+			defer func() {
+				qlang.RecoverAndLog(recover(), me.options.Logger, "terminal event listener")
+			}()
+			listener(event)
+		}()
+	}
+}
@@ -0,0 +1,371 @@
+package qshell
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// eventRecorder 记录终端生命周期事件
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []TerminalEvent
+}
+
+func (me *eventRecorder) listener(event TerminalEvent) {
+	me.mu.Lock()
+	me.events = append(me.events, event)
+	me.mu.Unlock()
+}
+
+func (me *eventRecorder) kinds(terminalID string) []TerminalEventKind {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	var r []TerminalEventKind
+	for _, e := range me.events {
+		if e.TerminalID == terminalID {
+			r = append(r, e.Kind)
+		}
+	}
+	return r
+}
+
+func (me *eventRecorder) find(terminalID string, kind TerminalEventKind) (TerminalEvent, bool) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	for _, e := range me.events {
+		if e.TerminalID == terminalID && e.Kind == kind {
+			return e, true
+		}
+	}
+	return TerminalEvent{}, false
+}
+
+func sleepCommand() CommandOptions {
+	return &CommandOptionsT{Command: "sleep", Args: []string{"30"}}
+}
+
+func TestTerminalManager_happy(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下行为不同")
+	}
+
+	mgr := NewTerminalManager(nil)
+	defer mgr.Close()
+
+	recorder := &eventRecorder{}
+	mgr.Subscribe(recorder.listener)
+
+	term, err := mgr.Start("alice", &CommandOptionsT{Command: "sh", Args: []string{"-c", "echo hi; exit 3"}})
+	a.NoError(err)
+	a.Equal(3, term.Wait())
+
+	found, ok := mgr.Get(term.TerminalID())
+	a.True(ok)
+	a.Same(term, found)
+
+	a.Eventually(func() bool {
+		info, _ := mgr.Info(term.TerminalID())
+		return info.Done
+	}, 5*time.Second, 10*time.Millisecond)
+
+	info, ok := mgr.Info(term.TerminalID())
+	a.True(ok)
+	a.Equal("alice", info.Owner)
+	a.Equal(3, info.ExitCode)
+	a.Equal([]string{"sh", "-c", "echo hi; exit 3"}, info.Args)
+	a.False(info.StartedAt.IsZero())
+	a.False(info.FinishedAt.Before(info.StartedAt))
+	a.Equal(0, mgr.Count())
+
+	a.Equal([]TerminalEventKind{TerminalStarted, TerminalExited}, recorder.kinds(term.TerminalID()))
+	exited, _ := recorder.find(term.TerminalID(), TerminalExited)
+	a.Equal(3, exited.ExitCode)
+
+	_, ok = mgr.Get("nope")
+	a.False(ok)
+	_, ok = mgr.Info("nope")
+	a.False(ok)
+}
+
+func TestTerminalManager_List(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下行为不同")
+	}
+
+	mgr := NewTerminalManager(nil)
+	defer mgr.Close()
+
+	t1 := mgr.StartP("alice", sleepCommand())
+	t2 := mgr.StartP("bob", sleepCommand())
+	t3 := mgr.StartP("alice", sleepCommand())
+
+	all := mgr.List()
+	a.Len(all, 3)
+	a.Equal(t1.TerminalID(), all[0].ID)
+	a.Equal(t2.TerminalID(), all[1].ID)
+	a.Equal(t3.TerminalID(), all[2].ID)
+
+	ofAlice := mgr.ListByOwner("alice")
+	a.Len(ofAlice, 2)
+	a.Equal(t1.TerminalID(), ofAlice[0].ID)
+	a.Equal(t3.TerminalID(), ofAlice[1].ID)
+
+	a.Equal(3, mgr.Count())
+	a.Equal(3, mgr.KillAll())
+	a.Eventually(func() bool { return mgr.Count() == 0 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestTerminalManager_limits(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下行为不同")
+	}
+
+	mgr := NewTerminalManager(&TerminalManagerOptionsT{MaxTerminals: 3, MaxPerOwner: 2, RetainFinished: time.Minute})
+	defer mgr.Close()
+
+	a1 := mgr.StartP("alice", sleepCommand())
+	mgr.StartP("alice", sleepCommand())
+
+	_, err := mgr.Start("alice", sleepCommand())
+	a.True(errors.Is(err, ErrOwnerQuotaExceeded))
+
+	mgr.StartP("bob", sleepCommand())
+	_, err = mgr.Start("carol", sleepCommand())
+	a.True(errors.Is(err, ErrTerminalLimitExceeded))
+
+	// 已结束的终端不占配额
+	a.NoError(mgr.Kill(a1.TerminalID()))
+	a.Eventually(func() bool { return mgr.Count() == 2 }, 5*time.Second, 10*time.Millisecond)
+
+	_, err = mgr.Start("alice", sleepCommand())
+	a.NoError(err)
+
+	a.Equal(2, mgr.KillOwner("alice"))
+	a.Eventually(func() bool { return mgr.Count() == 1 }, 5*time.Second, 10*time.Millisecond)
+	a.Equal(1, mgr.KillAll())
+}
+
+func TestTerminalManager_Kill(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下行为不同")
+	}
+
+	mgr := NewTerminalManager(nil)
+	defer mgr.Close()
+
+	recorder := &eventRecorder{}
+	mgr.Subscribe(recorder.listener)
+
+	term := mgr.StartP("alice", sleepCommand())
+	a.NoError(mgr.Kill(term.TerminalID()))
+	a.NotEqual(0, term.Wait())
+
+	a.Eventually(func() bool {
+		_, found := recorder.find(term.TerminalID(), TerminalExited)
+		return found
+	}, 5*time.Second, 10*time.Millisecond)
+	a.Equal([]TerminalEventKind{TerminalStarted, TerminalKilled, TerminalExited}, recorder.kinds(term.TerminalID()))
+
+	a.True(errors.Is(mgr.Kill("nope"), ErrTerminalNotFound))
+}
+
+func TestTerminalManager_Remove(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下行为不同")
+	}
+
+	mgr := NewTerminalManager(nil)
+	defer mgr.Close()
+
+	recorder := &eventRecorder{}
+	mgr.Subscribe(recorder.listener)
+
+	term := mgr.StartP("alice", sleepCommand())
+	a.NoError(mgr.Remove(term.TerminalID()))
+	term.Wait()
+
+	_, found := mgr.Get(term.TerminalID())
+	a.False(found)
+	_, removed := recorder.find(term.TerminalID(), TerminalRemoved)
+	a.True(removed)
+
+	a.True(errors.Is(mgr.Remove(term.TerminalID()), ErrTerminalNotFound))
+}
+
+func TestTerminalManager_reapIdle(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下行为不同")
+	}
+
+	mgr := NewTerminalManager(&TerminalManagerOptionsT{
+		IdleTimeout:    200 * time.Millisecond,
+		RetainFinished: time.Minute,
+		ReapInterval:   20 * time.Millisecond,
+	})
+	defer mgr.Close()
+
+	recorder := &eventRecorder{}
+	mgr.Subscribe(recorder.listener)
+
+	// 持续输出的终端不算空闲
+	busy := mgr.StartP("alice", &CommandOptionsT{Command: "sh", Args: []string{"-c", "while true; do echo tick; sleep 0.05; done"}})
+	idle := mgr.StartP("alice", sleepCommand())
+
+	idle.Wait()
+	a.Eventually(func() bool {
+		_, found := recorder.find(idle.TerminalID(), TerminalReaped)
+		return found
+	}, 5*time.Second, 10*time.Millisecond)
+	reaped, _ := recorder.find(idle.TerminalID(), TerminalReaped)
+	a.Equal("idle", reaped.Reason)
+
+	a.False(busy.IsDone())
+	busy.Kill()
+}
+
+func TestTerminalManager_reapTTL(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下行为不同")
+	}
+
+	mgr := NewTerminalManager(&TerminalManagerOptionsT{
+		TTL:            200 * time.Millisecond,
+		IdleTimeout:    time.Minute,
+		RetainFinished: time.Minute,
+		ReapInterval:   20 * time.Millisecond,
+	})
+	defer mgr.Close()
+
+	recorder := &eventRecorder{}
+	mgr.Subscribe(recorder.listener)
+
+	term := mgr.StartP("alice", &CommandOptionsT{Command: "sh", Args: []string{"-c", "while true; do echo tick; sleep 0.05; done"}})
+	term.Wait()
+
+	a.Eventually(func() bool {
+		_, found := recorder.find(term.TerminalID(), TerminalReaped)
+		return found
+	}, 5*time.Second, 10*time.Millisecond)
+	reaped, _ := recorder.find(term.TerminalID(), TerminalReaped)
+	a.Equal("ttl", reaped.Reason)
+	a.GreaterOrEqual(time.Since(term.StartedAt()), 200*time.Millisecond)
+}
+
+func TestTerminalManager_removeFinished(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下行为不同")
+	}
+
+	mgr := NewTerminalManager(&TerminalManagerOptionsT{
+		RetainFinished: 100 * time.Millisecond,
+		ReapInterval:   20 * time.Millisecond,
+	})
+	defer mgr.Close()
+
+	recorder := &eventRecorder{}
+	mgr.Subscribe(recorder.listener)
+
+	term := mgr.StartP("alice", &CommandOptionsT{Command: "echo", Args: []string{"hi"}})
+	term.Wait()
+
+	a.Eventually(func() bool {
+		_, found := mgr.Get(term.TerminalID())
+		return !found
+	}, 5*time.Second, 10*time.Millisecond)
+	a.Eventually(func() bool {
+		return len(recorder.kinds(term.TerminalID())) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+	a.Equal([]TerminalEventKind{TerminalStarted, TerminalExited, TerminalRemoved}, recorder.kinds(term.TerminalID()))
+}
+
+func TestTerminalManager_Close(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下行为不同")
+	}
+
+	mgr := NewTerminalManager(nil)
+	term := mgr.StartP("alice", sleepCommand())
+
+	mgr.Close()
+	mgr.Close()
+	term.Wait()
+
+	_, err := mgr.Start("alice", sleepCommand())
+	a.True(errors.Is(err, ErrTerminalManagerClosed))
+	a.Panics(func() {
+		mgr.StartP("alice", sleepCommand())
+	})
+}
+
+func TestTerminalManager_startFailure(t *testing.T) {
+	a := require.New(t)
+
+	mgr := NewTerminalManager(nil)
+	defer mgr.Close()
+
+	_, err := mgr.Start("alice", &CommandOptionsT{Command: "nonexistent_command_xyz"})
+	a.Error(err)
+	a.Empty(mgr.List())
+
+	_, err = mgr.Start("alice", &CommandOptionsT{Command: "rm", Args: []string{"-rf", "/"}})
+	a.Error(err)
+}
+
+func TestTerminalManager_unsubscribe(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下行为不同")
+	}
+
+	mgr := NewTerminalManager(nil)
+	defer mgr.Close()
+
+	recorder := &eventRecorder{}
+	unsubscribe := mgr.Subscribe(recorder.listener)
+	unsubscribe()
+
+	mgr.StartP("alice", &CommandOptionsT{Command: "echo", Args: []string{"hi"}}).Wait()
+	a.Empty(recorder.kinds(""))
+	mgr.Close()
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	a.Empty(recorder.events)
+}
+
+func TestTerminalEventKind_String(t *testing.T) {
+	a := require.New(t)
+
+	a.Equal("started", TerminalStarted.String())
+	a.Equal("killed", TerminalKilled.String())
+	a.Equal("reaped", TerminalReaped.String())
+	a.Equal("exited", TerminalExited.String())
+	a.Equal("removed", TerminalRemoved.String())
+	a.Equal("unknown", TerminalEventKind(0).String())
+}
@@ -0,0 +1,67 @@
+package qshell
+
+import "time"
+
+// ============================================================
+// OutputStream 输出流
+// ============================================================
+
+// OutputStream 表示一行输出来自哪个流
+type OutputStream int
+
+const (
+	// StreamStdout 标准输出
+	StreamStdout OutputStream = iota + 1
+	// StreamStderr 标准错误
+	StreamStderr
+)
+
+// String 返回输出流的字符串表示
+func (s OutputStream) String() string {
+	switch s {
+	case StreamStdout:
+		return "stdout"
+	case StreamStderr:
+		return "stderr"
+	default:
+		return "unknown"
+	}
+}
+
+// ============================================================
+// OutputLine 输出行
+// ============================================================
+
+// OutputLine 带时间戳和流标记的一行输出
+type OutputLine struct {
+	// Seq 行序号，stdout 和 stderr 共用，按到达顺序从 1 开始递增
+	Seq uint64
+	// Stream 来源流
+	Stream OutputStream
+	// Time 收到该行的时间
+	Time time.Time
+	// Text 行内容（不含换行符）
+	Text string
+}
+
+// OutputListener 输出行监听器
+// 在输出收集协程中同步调用，不应阻塞，也不应在其中订阅或取消订阅
+type OutputListener func(line OutputLine)
+
+// RawOutputListener 原始输出监听器，按读到的块推送，不做行拆分
+// 适合转发伪终端中的提示符、进度条、控制字符等不以换行结尾的输出
+type RawOutputListener func(stream OutputStream, data []byte)
+
+// appendBoundedOutput 追加输出，超出 max 时先把已有内容截断到一半（与原合并输出的截断规则一致），
+// 仍放不下时只保留 data 末尾部分，保证结果不超过 max
+func appendBoundedOutput(buf []byte, data []byte, max int) []byte {
+	if len(buf)+len(data) > max {
+		if len(buf) > max/2 {
+			buf = buf[:max/2]
+		}
+		if room := max - len(buf); len(data) > room {
+			data = data[len(data)-room:]
+		}
+	}
+	return append(buf, data...)
+}
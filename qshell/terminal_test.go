@@ -3,6 +3,7 @@ package qshell
 import (
 	"context"
 	"runtime"
+	"sync"
 	"testing"
 	"time"
 
@@ -310,3 +311,179 @@ func TestRunningCommand_Env(t *testing.T) {
 	// 这个测试确保 RunningCommand 可以被创建并正常工作
 	t.Skip("环境变量测试需要更复杂的设置")
 }
+
+func TestRunningCommand_separateStreams(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下行为不同")
+	}
+
+	runningCmd := NewRunningCommand(&CommandOptionsT{
+		Command: "sh",
+		Args:    []string{"-c", "echo out; echo err 1>&2"},
+	})
+	a.Equal(0, runningCmd.Wait())
+
+	a.Equal("out\n", runningCmd.GetStdoutString())
+	a.Equal("err\n", runningCmd.GetStderrString())
+	a.Contains(runningCmd.GetOutputString(), "out\n")
+	a.Contains(runningCmd.GetOutputString(), "err\n")
+}
+
+func TestRunningCommand_GetLines_ordered(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下行为不同")
+	}
+
+	runningCmd := NewRunningCommand(&CommandOptionsT{
+		Command: "sh",
+		Args:    []string{"-c", "echo a; sleep 0.1; echo b 1>&2; sleep 0.1; echo c"},
+	})
+	runningCmd.Wait()
+
+	lines := runningCmd.GetLines()
+	a.Len(lines, 3)
+
+	a.Equal("a", lines[0].Text)
+	a.Equal(StreamStdout, lines[0].Stream)
+	a.Equal("b", lines[1].Text)
+	a.Equal(StreamStderr, lines[1].Stream)
+	a.Equal("c", lines[2].Text)
+	a.Equal(StreamStdout, lines[2].Stream)
+
+	for i, line := range lines {
+		a.Equal(uint64(i+1), line.Seq)
+		if i > 0 {
+			a.False(line.Time.Before(lines[i-1].Time))
+		}
+	}
+
+	a.Equal("a\nb\nc\n", runningCmd.GetOutputString())
+}
+
+func TestRunningCommand_OnOutput(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下行为不同")
+	}
+
+	var mu sync.Mutex
+	var received []OutputLine
+	runningCmd := NewRunningCommand(&CommandOptionsT{
+		Command: "sh",
+		Args:    []string{"-c", "echo 1; echo 2 1>&2"},
+		OnOutput: func(line OutputLine) {
+			mu.Lock()
+			received = append(received, line)
+			mu.Unlock()
+		},
+	})
+	runningCmd.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	a.Len(received, 2)
+	a.Equal(uint64(1), received[0].Seq)
+	a.Equal(uint64(2), received[1].Seq)
+}
+
+func TestRunningCommand_SubscribeChan(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下行为不同")
+	}
+
+	runningCmd := NewRunningCommand(&CommandOptionsT{
+		Command: "sh",
+		Args:    []string{"-c", "sleep 0.1; echo x; sleep 0.1; echo y 1>&2; sleep 0.1; echo z"},
+	})
+
+	ch, _ := runningCmd.SubscribeChan(16)
+
+	var texts []string
+	for line := range ch {
+		texts = append(texts, line.Stream.String()+":"+line.Text)
+	}
+	a.Equal([]string{"stdout:x", "stderr:y", "stdout:z"}, texts)
+	a.True(runningCmd.IsDone())
+}
+
+func TestRunningCommand_Subscribe_replayAndUnsubscribe(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下行为不同")
+	}
+
+	runningCmd := NewRunningCommand(&CommandOptionsT{
+		Command: "sh",
+		Args:    []string{"-c", "echo first; echo second"},
+	})
+	runningCmd.Wait()
+
+	// 命令结束后订阅也能回放已缓存的输出
+	var replayed []string
+	unsubscribe := runningCmd.Subscribe(func(line OutputLine) {
+		replayed = append(replayed, line.Text)
+	})
+	unsubscribe()
+	a.Equal([]string{"first", "second"}, replayed)
+
+	ch, unsubscribeChan := runningCmd.SubscribeChan(0)
+	unsubscribeChan()
+	unsubscribeChan()
+	for range ch {
+	}
+}
+
+func TestRunningCommand_maxBufferSizePerStream(t *testing.T) {
+	a := require.New(t)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 下行为不同")
+	}
+
+	runningCmd := NewRunningCommand(&CommandOptionsT{
+		Command:       "sh",
+		Args:          []string{"-c", "for i in $(seq 1 500); do echo \"out $i\"; echo \"err $i\" 1>&2; done"},
+		MaxBufferSize: 100,
+	})
+	runningCmd.Wait()
+
+	a.LessOrEqual(len(runningCmd.GetStdout()), 100)
+	a.LessOrEqual(len(runningCmd.GetStderr()), 100)
+	a.LessOrEqual(len(runningCmd.GetOutput()), 100)
+
+	size := 0
+	lines := runningCmd.GetLines()
+	for _, line := range lines {
+		size += len(line.Text) + 1
+	}
+	a.LessOrEqual(size, 100)
+	// 合并日志保留最新的输出
+	a.Equal(uint64(1000), lines[len(lines)-1].Seq)
+}
+
+func TestOutputStream_String(t *testing.T) {
+	a := require.New(t)
+
+	a.Equal("stdout", StreamStdout.String())
+	a.Equal("stderr", StreamStderr.String())
+	a.Equal("unknown", OutputStream(0).String())
+}
+
+func TestAppendBoundedOutput(t *testing.T) {
+	a := require.New(t)
+
+	a.Equal("abc", string(appendBoundedOutput(nil, []byte("abc"), 10)))
+	a.Equal("abcdefgh", string(appendBoundedOutput([]byte("abcdef"), []byte("gh"), 10)))
+	// 超出时已有内容截断到一半
+	a.Equal("abcdexyz", string(appendBoundedOutput([]byte("abcdefgh"), []byte("xyz"), 10)))
+	// 单次写入超过上限时只保留末尾
+	a.Equal("abcde56789", string(appendBoundedOutput([]byte("abcdefgh"), []byte("0123456789"), 10)))
+}